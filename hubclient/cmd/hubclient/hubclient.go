@@ -31,7 +31,10 @@ func main() {
 	defer logger.Sync()
 
 	cfg := config.LoadConfig(logger)
-	s := server.NewServer(cfg, logger)
+	s, err := server.NewServer(cfg, logger)
+	if err != nil {
+		logger.Fatal("Failed to create server", zap.Error(err))
+	}
 
 	if err := s.Run(); err != nil {
 		logger.Fatal("Server run failed", zap.Error(err))
@@ -0,0 +1,245 @@
+// Package auth implements the optional login to the page: the OpenID Connect authorization code
+// flow against an identity provider, the signed cookies holding logins and sessions, and the hub
+// tokens minted for logged in users.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// discoveryPath is the path of the OpenID Provider's configuration relative to its issuer.
+const discoveryPath = "/.well-known/openid-configuration"
+
+// User is the identity of a user logged in with the identity provider.
+type User struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name,omitempty"`
+	Email   string `json:"email,omitempty"`
+}
+
+// DisplayName returns the name the page shows for the user: their name, email or subject.
+func (u User) DisplayName() string {
+	switch {
+	case u.Name != "":
+		return u.Name
+	case u.Email != "":
+		return u.Email
+	default:
+		return u.Subject
+	}
+}
+
+// Provider runs the authorization code flow with PKCE against an OpenID Provider, whose endpoints
+// it discovers from the issuer on first use.
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	mu        sync.Mutex
+	endpoints *endpoints
+}
+
+// endpoints holds the parts of the OpenID Provider's configuration the flow uses.
+type endpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// NewProvider returns a Provider for the issuer, authenticating to its token endpoint as the client.
+// Public clients have no secret and rely on PKCE alone.
+func NewProvider(issuer, clientID, clientSecret string) *Provider {
+	return &Provider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// discover returns the provider's endpoints, fetching its configuration until it succeeds once so
+// the page starts while the provider is unreachable.
+func (p *Provider) discover(ctx context.Context) (*endpoints, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+discoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	var e endpoints
+	if err := p.doJSON(req, &e); err != nil {
+		return nil, fmt.Errorf("failed to discover the OpenID Provider: %w", err)
+	}
+	if e.Issuer != p.issuer {
+		return nil, fmt.Errorf("OpenID Provider configuration is for issuer %q, not %q", e.Issuer, p.issuer)
+	}
+	if e.AuthorizationEndpoint == "" || e.TokenEndpoint == "" {
+		return nil, errors.New("OpenID Provider configuration lacks the authorization or token endpoint")
+	}
+	p.endpoints = &e
+	return p.endpoints, nil
+}
+
+// Login is a login in progress: the state binding the provider's redirect to the browser that
+// started it, the nonce binding the ID token to it and the PKCE code verifier.
+type Login struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// NewLogin returns a login with fresh random values.
+func NewLogin() Login {
+	return Login{State: randomString(), Nonce: randomString(), Verifier: randomString()}
+}
+
+// AuthCodeURL returns the URL of the provider's authorization endpoint the browser is redirected to
+// for the login, which sends it back to redirectURL.
+func (p *Provider) AuthCodeURL(ctx context.Context, login Login, redirectURL string) (string, error) {
+	e, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {"openid profile email"},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(e.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return e.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code the provider redirected the browser back with for an ID
+// token and returns the user it identifies.
+func (p *Provider) Exchange(ctx context.Context, login Login, code, redirectURL string) (User, error) {
+	e, err := p.discover(ctx)
+	if err != nil {
+		return User{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {login.Verifier},
+	}
+	if p.clientSecret == "" {
+		form.Set("client_id", p.clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return User{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	var resp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.doJSON(req, &resp); err != nil {
+		return User{}, fmt.Errorf("failed to redeem the authorization code: %w", err)
+	}
+	if resp.IDToken == "" {
+		return User{}, errors.New("token response carries no ID token")
+	}
+	return p.verifyIDToken(e, resp.IDToken, login.Nonce)
+}
+
+// verifyIDToken checks the claims of an ID token received from the token endpoint. Its signature is
+// not checked: the token came straight from the provider over the connection the client opened,
+// which OpenID Connect Core 1.0 (section 3.1.3.7) accepts in place of it.
+func (p *Provider) verifyIDToken(e *endpoints, idToken, nonce string) (User, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, claims); err != nil {
+		return User{}, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if issuer, _ := claims.GetIssuer(); issuer != e.Issuer {
+		return User{}, fmt.Errorf("ID token was issued by %q, not %q", issuer, e.Issuer)
+	}
+	if audience, _ := claims.GetAudience(); !slices.Contains(audience, p.clientID) {
+		return User{}, errors.New("ID token was not issued to this client")
+	}
+	if expires, _ := claims.GetExpirationTime(); expires == nil || !time.Now().Before(expires.Time) {
+		return User{}, errors.New("ID token expired")
+	}
+	if value, _ := claims["nonce"].(string); value != nonce {
+		return User{}, errors.New("ID token nonce does not match the login")
+	}
+
+	var user User
+	user.Subject, _ = claims.GetSubject()
+	if user.Subject == "" {
+		return User{}, errors.New("ID token has no subject")
+	}
+	user.Name, _ = claims["name"].(string)
+	user.Email, _ = claims["email"].(string)
+	return user, nil
+}
+
+// doJSON sends a request to the provider and decodes its JSON response into v, turning OAuth error
+// responses into errors.
+func (p *Provider) doJSON(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Error != "" {
+			return fmt.Errorf("%s: %s", oauthErr.Error, oauthErr.Description)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// randomString returns 32 random bytes, base64url encoded.
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
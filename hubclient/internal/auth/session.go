@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// errInvalidCookie is returned for cookies that are malformed, forged or expired.
+var errInvalidCookie = errors.New("invalid or expired cookie")
+
+// Cookies signs the values of the cookies holding logins in progress and the sessions of logged in
+// users, so the page keeps no server-side state and any replica sharing the secret accepts them.
+type Cookies struct {
+	key []byte
+}
+
+// NewCookies returns Cookies signing with the secret, or with a random key when it is empty, in
+// which case restarting the page logs its users out.
+func NewCookies(secret string) *Cookies {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		_, _ = rand.Read(key)
+	}
+	return &Cookies{key: key}
+}
+
+// signed is the content of a cookie: its value and the time it expires.
+type signed struct {
+	Value   json.RawMessage `json:"v"`
+	Expires int64           `json:"exp"`
+}
+
+// Encode returns the cookie value carrying v until ttl elapses.
+func (c *Cookies) Encode(v any, ttl time.Duration) (string, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(signed{Value: value, Expires: time.Now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + c.sign(encoded), nil
+}
+
+// Decode verifies a cookie value returned by Encode, decodes what it carries into v and returns
+// the time it expires.
+func (c *Cookies) Decode(cookie string, v any) (time.Time, error) {
+	encoded, signature, ok := strings.Cut(cookie, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(c.sign(encoded))) {
+		return time.Time{}, errInvalidCookie
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return time.Time{}, errInvalidCookie
+	}
+	var s signed
+	if err := json.Unmarshal(payload, &s); err != nil || time.Now().Unix() >= s.Expires {
+		return time.Time{}, errInvalidCookie
+	}
+	return time.Unix(s.Expires, 0), json.Unmarshal(s.Value, v)
+}
+
+func (c *Cookies) sign(encoded string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// MintToken returns a hub access token for the user, an HS256 JWT signed with the secret of the
+// hub's --auth-jwt-secret, valid until expires.
+func MintToken(secret string, user User, expires time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": user.Subject,
+		"iat": time.Now().Unix(),
+		"exp": expires.Unix(),
+	}
+	if user.Name != "" {
+		claims["name"] = user.Name
+	}
+	if user.Email != "" {
+		claims["email"] = user.Email
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
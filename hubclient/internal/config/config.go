@@ -4,6 +4,7 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 	"os"
+	"time"
 )
 
 const (
@@ -13,36 +14,84 @@ const (
 type Config struct {
 	Port    string
 	HubAddr string
+	// Standalone runs a hub with an in-memory broker inside the process and serves the page against
+	// it instead of a HubServer
+	Standalone bool
+
+	ProxyWebSocket   bool
+	HubTLS           bool
+	HubTLSSkipVerify bool
+	TLSCertFile      string
+	TLSKeyFile       string
+
+	// OIDCIssuer enables logging in to the page with the OpenID Provider, as OIDCClientID with the
+	// optional OIDCClientSecret; OIDCRedirectURL is the page's callback URL registered with it, and
+	// defaults to /auth/callback on the page's host
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	// SessionSecret signs the session cookies of logged in users, which expire after SessionTTL;
+	// without it a random key is used and restarting the page logs everyone out
+	SessionSecret string
+	SessionTTL    time.Duration
+	// HubJWTSecret signs the hub tokens minted for logged in users, matching the hub's --auth-jwt-secret
+	HubJWTSecret string
 }
 
+// LoadConfig resolves the configuration from flags, HUB_ prefixed environment variables and an
+// optional YAML or TOML config file, in that order of precedence, and validates it.
 func LoadConfig(logger *zap.Logger) *Config {
 	var cfg Config
+	var configFile string
+	loaded := false
 
 	rootCmd := &cobra.Command{
 		Use:   "hubclient",
 		Short: "hubClient is a simple web-server that serves an HTML page to communicate with the hubServer",
 		Run: func(cmd *cobra.Command, args []string) {
-			if cfg.HubAddr == "" {
-				logger.Error("hub-addr is required")
+			if configFile == "" {
+				configFile = os.Getenv(envName("config"))
+			}
+
+			if err := applyLayers(cmd.Flags(), configFile); err != nil {
+				logger.Error("Failed to load configuration", zap.Error(err))
+				os.Exit(1)
+			}
+
+			if err := cfg.Validate(); err != nil {
+				logger.Error("Invalid configuration", zap.Error(err))
 				_ = cmd.Help()
 				os.Exit(1)
 			}
+			loaded = true
 		},
 	}
 
+	rootCmd.Flags().StringVar(&configFile, "config", "", "Path to a YAML or TOML config file (flags and HUB_ environment variables take precedence)")
 	rootCmd.Flags().StringVar(&cfg.Port, "port", DefaultPort, "Port for serving the HTML page")
 	rootCmd.Flags().StringVar(&cfg.HubAddr, "hub-addr", "", "Address of the HubServer")
+	rootCmd.Flags().BoolVar(&cfg.Standalone, "standalone", false, "Run an in-process hub with an in-memory broker on this port and serve the page against it, with no HubServer or Redis needed")
+	rootCmd.Flags().BoolVar(&cfg.ProxyWebSocket, "proxy-ws", false, "Reverse-proxy /ws to the HubServer so the page only talks to this origin")
+	rootCmd.Flags().BoolVar(&cfg.HubTLS, "hub-tls", false, "Connect to the HubServer over TLS (wss)")
+	rootCmd.Flags().BoolVar(&cfg.HubTLSSkipVerify, "hub-tls-skip-verify", false, "Skip verification of the HubServer certificate when proxying")
+	rootCmd.Flags().StringVar(&cfg.TLSCertFile, "tls-cert-file", "", "Certificate file for serving the page over HTTPS")
+	rootCmd.Flags().StringVar(&cfg.TLSKeyFile, "tls-key-file", "", "Key file for serving the page over HTTPS")
+	rootCmd.Flags().StringVar(&cfg.OIDCIssuer, "oidc-issuer", "", "Issuer URL of the OpenID Provider users log in to the page with (empty serves the page without login)")
+	rootCmd.Flags().StringVar(&cfg.OIDCClientID, "oidc-client-id", "", "Client id of the page registered with the OpenID Provider")
+	rootCmd.Flags().StringVar(&cfg.OIDCClientSecret, "oidc-client-secret", "", "Client secret of the page registered with the OpenID Provider (empty for public clients)")
+	rootCmd.Flags().StringVar(&cfg.OIDCRedirectURL, "oidc-redirect-url", "", "Callback URL of the page registered with the OpenID Provider (empty is /auth/callback on the page's host)")
+	rootCmd.Flags().StringVar(&cfg.SessionSecret, "session-secret", "", "Secret signing the session cookies of logged in users (empty uses a random key, logging users out on restart)")
+	rootCmd.Flags().DurationVar(&cfg.SessionTTL, "session-ttl", 8*time.Hour, "Time a login to the page lasts, and the hub tokens minted for it with it")
+	rootCmd.Flags().StringVar(&cfg.HubJWTSecret, "hub-jwt-secret", "", "Secret the hub tokens of logged in users are signed with, the HubServer's --auth-jwt-secret")
 	if err := rootCmd.Execute(); err != nil {
 		logger.Fatal("Error parsing arguments", zap.Error(err))
 		os.Exit(1)
 	}
 
-	if port := os.Getenv("PORT"); port != "" {
-		cfg.Port = port
-	}
-
-	if hubAddr := os.Getenv("HUBADDR"); hubAddr != "" {
-		cfg.HubAddr = hubAddr
+	// Help was requested
+	if !loaded {
+		os.Exit(0)
 	}
 
 	return &cfg
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// envPrefix is the prefix of the environment variables overriding the config file, e.g. HUB_HUB_ADDR.
+const envPrefix = "HUB"
+
+// legacyEnv maps flags to the environment variables honoured before the HUB_ prefix was introduced.
+var legacyEnv = map[string]string{
+	"port":     "PORT",
+	"hub-addr": "HUBADDR",
+}
+
+// applyLayers fills every flag not set on the command line from the environment or, failing that,
+// the config file, so values are resolved as flags > environment > config file > defaults.
+func applyLayers(flags *pflag.FlagSet, configFile string) error {
+	v := viper.New()
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	for key, env := range legacyEnv {
+		if err := v.BindEnv(key, envName(key), env); err != nil {
+			return err
+		}
+	}
+
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read config file %s: %w", configFile, err)
+		}
+	}
+
+	var errs []error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed || f.Name == "config" || f.Name == "help" || !v.IsSet(f.Name) {
+			return
+		}
+
+		if err := f.Value.Set(flagValue(v.Get(f.Name))); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value for %s (from %s or config file): %w", f.Name, envName(f.Name), err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// envName returns the prefixed environment variable for a flag.
+func envName(flag string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// flagValue converts a value read from the environment or a config file to its flag string form.
+func flagValue(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Validate checks the configuration and reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Standalone {
+		if c.HubAddr != "" || c.ProxyWebSocket || c.HubTLS {
+			errs = append(errs, errors.New("standalone serves its own hub and cannot be combined with hub-addr, proxy-ws or hub-tls"))
+		}
+	} else if c.HubAddr == "" {
+		errs = append(errs, fmt.Errorf("hub-addr is required (set --hub-addr, %s/HUBADDR or hub-addr in the config file, or run with --standalone)", envName("hub-addr")))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be a number between 1 and 65535, got %q", c.Port))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls-cert-file and tls-key-file must be set together"))
+	}
+	if c.OIDCIssuer != "" {
+		if c.OIDCClientID == "" {
+			errs = append(errs, errors.New("oidc-client-id is required with oidc-issuer"))
+		}
+		if c.HubJWTSecret == "" {
+			errs = append(errs, errors.New("hub-jwt-secret is required with oidc-issuer to mint hub tokens for logged in users"))
+		}
+		if c.SessionTTL <= 0 {
+			errs = append(errs, fmt.Errorf("session-ttl must be positive, got %s", c.SessionTTL))
+		}
+	} else if c.OIDCClientID != "" || c.OIDCClientSecret != "" || c.OIDCRedirectURL != "" || c.HubJWTSecret != "" {
+		errs = append(errs, errors.New("oidc-client-id, oidc-client-secret, oidc-redirect-url and hub-jwt-secret require oidc-issuer"))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soumya-codes/realtime-hub/hubclient/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubclient/internal/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// loginCookie holds the login in progress while the browser visits the OpenID Provider, for
+	// at most loginTTL, and sessionCookie the user once logged in
+	loginCookie   = "hubclient_login"
+	loginTTL      = 10 * time.Minute
+	sessionCookie = "hubclient_session"
+
+	callbackPath = "/auth/callback"
+)
+
+// login logs users in to the page with an OpenID Provider and mints the hub tokens the page
+// connects with as them.
+type login struct {
+	provider     *auth.Provider
+	cookies      *auth.Cookies
+	redirectURL  string
+	sessionTTL   time.Duration
+	hubJWTSecret string
+	logger       *zap.Logger
+}
+
+// newLogin returns the login of the page, or nil when no OpenID Provider is configured.
+func newLogin(cfg *config.Config, logger *zap.Logger) *login {
+	if cfg.OIDCIssuer == "" {
+		return nil
+	}
+	return &login{
+		provider:     auth.NewProvider(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret),
+		cookies:      auth.NewCookies(cfg.SessionSecret),
+		redirectURL:  cfg.OIDCRedirectURL,
+		sessionTTL:   cfg.SessionTTL,
+		hubJWTSecret: cfg.HubJWTSecret,
+		logger:       logger,
+	}
+}
+
+// register adds the login, callback and logout endpoints to the router.
+func (l *login) register(router *gin.Engine) {
+	router.GET("/login", l.handleLogin)
+	router.GET(callbackPath, l.handleCallback)
+	router.POST("/logout", l.handleLogout)
+}
+
+// handleLogin starts a login, redirecting the browser to the OpenID Provider.
+func (l *login) handleLogin(c *gin.Context) {
+	pending := auth.NewLogin()
+	authURL, err := l.provider.AuthCodeURL(c.Request.Context(), pending, l.callbackURL(c.Request))
+	if err != nil {
+		l.logger.Error("Failed to start login", zap.Error(err))
+		c.String(http.StatusBadGateway, "The identity provider is unavailable")
+		return
+	}
+	value, err := l.cookies.Encode(pending, loginTTL)
+	if err != nil {
+		l.logger.Error("Failed to encode login cookie", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	setCookie(c, loginCookie, value, loginTTL)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// handleCallback completes a login once the OpenID Provider redirected the browser back, starting
+// the user's session.
+func (l *login) handleCallback(c *gin.Context) {
+	var pending auth.Login
+	cookie, err := c.Cookie(loginCookie)
+	if err == nil {
+		_, err = l.cookies.Decode(cookie, &pending)
+	}
+	clearCookie(c, loginCookie)
+	if err != nil || c.Query("state") != pending.State {
+		l.logger.Warn("Login callback does not match a login in progress", zap.Error(err))
+		c.String(http.StatusBadRequest, "The login expired or was not started here, please log in again")
+		return
+	}
+	if reason := c.Query("error"); reason != "" {
+		l.logger.Warn("Identity provider refused the login", zap.String("error", reason), zap.String("description", c.Query("error_description")))
+		c.String(http.StatusUnauthorized, "The identity provider refused the login: %s", reason)
+		return
+	}
+
+	user, err := l.provider.Exchange(c.Request.Context(), pending, c.Query("code"), l.callbackURL(c.Request))
+	if err != nil {
+		l.logger.Error("Failed to complete login", zap.Error(err))
+		c.String(http.StatusBadGateway, "The login could not be completed, please log in again")
+		return
+	}
+	value, err := l.cookies.Encode(user, l.sessionTTL)
+	if err != nil {
+		l.logger.Error("Failed to encode session cookie", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	setCookie(c, sessionCookie, value, l.sessionTTL)
+	l.logger.Info("User logged in", zap.String("user-id", user.Subject))
+	c.Redirect(http.StatusFound, "/")
+}
+
+// handleLogout ends the user's session.
+func (l *login) handleLogout(c *gin.Context) {
+	clearCookie(c, sessionCookie)
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+// session returns the user logged in with the request's session cookie and a hub token minted for
+// them, expiring with the session, or false when the request carries no valid session.
+func (l *login) session(c *gin.Context) (auth.User, string, bool) {
+	cookie, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return auth.User{}, "", false
+	}
+	var user auth.User
+	expires, err := l.cookies.Decode(cookie, &user)
+	if err != nil {
+		return auth.User{}, "", false
+	}
+
+	token, err := auth.MintToken(l.hubJWTSecret, user, expires)
+	if err != nil {
+		l.logger.Error("Failed to mint hub token", zap.String("user-id", user.Subject), zap.Error(err))
+		return auth.User{}, "", false
+	}
+	return user, token, true
+}
+
+// callbackURL returns the URL the OpenID Provider redirects the browser back to: the configured one
+// or the callback path on the host the browser reached the page at.
+func (l *login) callbackURL(r *http.Request) string {
+	if l.redirectURL != "" {
+		return l.redirectURL
+	}
+	callback := url.URL{Scheme: "http", Host: r.Host, Path: callbackPath}
+	if r.TLS != nil {
+		callback.Scheme = "https"
+	}
+	return callback.String()
+}
+
+// setCookie sets an HTTP-only cookie of the page lasting ttl. SameSite=Lax lets the browser send it
+// along the OpenID Provider's redirect back to the page.
+func setCookie(c *gin.Context, name, value string, ttl time.Duration) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl / time.Second),
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearCookie deletes a cookie of the page.
+func clearCookie(c *gin.Context, name string) {
+	http.SetCookie(c.Writer, &http.Cookie{Name: name, Path: "/", MaxAge: -1, HttpOnly: true})
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/soumya-codes/realtime-hub/hubclient/internal/config"
+	"go.uber.org/zap"
+)
+
+// newWebSocketProxy creates a reverse proxy forwarding WebSocket upgrades to the HubServer.
+// Client headers are passed through and X-Forwarded-* headers are added for the HubServer.
+func newWebSocketProxy(cfg *config.Config, logger *zap.Logger) http.Handler {
+	target := &url.URL{Scheme: "http", Host: cfg.HubAddr}
+	if cfg.HubTLS {
+		target.Scheme = "https"
+	}
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.HubTLSSkipVerify},
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logger.Error("Failed to proxy WebSocket request", zap.String("hub-addr", cfg.HubAddr), zap.Error(err))
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
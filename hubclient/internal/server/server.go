@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/soumya-codes/realtime-hub/hubclient/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/pkg/hub"
 	"go.uber.org/zap"
 	"net/http"
 	"os"
@@ -19,10 +20,12 @@ type Server struct {
 	httpServer *http.Server
 	cfg        *config.Config
 	logger     *zap.Logger
+	// hub is the in-process hub the page connects to in standalone mode
+	hub *hub.Hub
 }
 
 // NewServer creates a new Server instance.
-func NewServer(cfg *config.Config, logger *zap.Logger) *Server {
+func NewServer(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 	router := gin.Default()
 	server := &Server{
 		cfg:    cfg,
@@ -38,20 +41,69 @@ func NewServer(cfg *config.Config, logger *zap.Logger) *Server {
 		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
 	})
 
+	// When proxying, the page connects to /ws and reads room history on its own origin
+	hubAddr, wsScheme := cfg.HubAddr, "ws"
+	if cfg.HubTLS {
+		wsScheme = "wss"
+	}
+	if cfg.ProxyWebSocket {
+		hubAddr, wsScheme = "", ""
+		proxy := gin.WrapH(newWebSocketProxy(cfg, logger))
+		router.GET("/ws", proxy)
+		router.GET("/rooms/:room/messages", proxy)
+	}
+	pageLogin := newLogin(cfg, logger)
+	if pageLogin != nil {
+		pageLogin.register(router)
+	}
+	if cfg.Standalone {
+		options := []hub.Option{hub.WithName("hubclient-standalone"), hub.WithLogger(logger.Named("hub"))}
+		if pageLogin != nil {
+			// The standalone hub admits the users logged in to the page only
+			options = append(options, hub.WithAuth(cfg.HubJWTSecret, true))
+		}
+		h, err := hub.New(options...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start standalone hub: %w", err)
+		}
+		server.hub = h
+		hubAddr, wsScheme = "", ""
+		router.GET("/ws", gin.WrapH(h))
+		logger.Info("Serving the page against a standalone hub")
+	}
+
 	router.LoadHTMLFiles("internal/templates/index.html")
+	router.StaticFile("/js/hub-client.js", "js/hub-client.js")
 	router.GET("/", func(ctx *gin.Context) {
-		ctx.HTML(http.StatusOK, "index.html", gin.H{
-			"hubAddr": cfg.HubAddr,
-		})
+		data := gin.H{
+			"hubAddr":  hubAddr,
+			"wsScheme": wsScheme,
+		}
+		if pageLogin != nil {
+			user, token, ok := pageLogin.session(ctx)
+			if !ok {
+				ctx.Redirect(http.StatusFound, "/login")
+				return
+			}
+			data["user"] = user.DisplayName()
+			data["hubToken"] = token
+		}
+		ctx.HTML(http.StatusOK, "index.html", data)
 	})
 
-	return server
+	return server, nil
 }
 
 // Run starts the server and listens for incoming request
 func (s *Server) Run() error {
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if s.cfg.TLSCertFile != "" {
+			err = s.httpServer.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.logger.Error("HTTP server ListenAndServe error: ", zap.Error(err))
 		}
 	}()
@@ -72,6 +124,11 @@ func (s *Server) Run() error {
 		s.logger.Error("Server forced to shutdown", zap.Error(err))
 		return fmt.Errorf("server forced to shutdown %w", err)
 	}
+	if s.hub != nil {
+		if err := s.hub.Close(); err != nil {
+			s.logger.Error("Failed to close standalone hub", zap.Error(err))
+		}
+	}
 
 	s.logger.Info("Server exiting")
 	return nil
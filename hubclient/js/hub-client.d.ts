@@ -0,0 +1,176 @@
+// Type declarations for hub-client.js, following protocol/hub.v1.schema.json.
+
+export declare const SUBPROTOCOL: 'hub.v1';
+
+export declare const PROTOCOL_VERSION: 1;
+
+export declare const CAPABILITIES: readonly Capability[];
+
+export declare const ECHO_ROOM: '__echo__';
+
+export declare const CloseCodes: Readonly<{
+    SHUTDOWN: 4001;
+    EVICTED: 4002;
+    DRAIN: 4003;
+    UNAUTHORIZED: 4004;
+    IDLE: 4005;
+}>;
+
+export interface Frame {
+    type: 'message' | 'ack' | 'receipt' | 'nack' | 'chunk' | 'join' | 'leave' | 'credit' | 'error' | 'auth' | 'request' | 'reply' | 'deprecation' | 'whoami' | 'config';
+    id?: string;
+    origin_id?: string;
+    hub_id?: string;
+    room?: string;
+    payload?: unknown;
+    receipt?: boolean;
+    nack?: boolean;
+    ephemeral?: boolean;
+    local?: boolean;
+    echo?: EchoPolicy;
+    status?: 'delivered' | 'read' | 'accepted';
+    count?: number;
+    recipient_id?: string;
+    seq?: number;
+    total?: number;
+    data?: string;
+    deliver_at?: string;
+    nonce?: string;
+    ts?: number;
+    signature?: string;
+    token?: string;
+    content_type?: string;
+    reason?: 'content_type' | 'payload_too_large' | 'invalid_json' | 'payload_too_deep' | 'string_too_long' |
+        'invalid_utf8' | 'control_characters' | 'unauthenticated' |
+        'invalid_request' | 'duplicate_request' | 'timeout' | 'service_unavailable' | 'bandwidth_cap_exceeded' |
+        'no_recipients' | 'queues_full' | 'room_limit' | 'user_room_limit' | 'bad_filter';
+    cursor?: string;
+    room_seq?: number;
+    sequence?: number;
+    key?: string;
+    ingested_at?: number;
+    hlc?: string;
+    annotations?: Record<string, string>;
+    patch?: PatchOperation[];
+    service?: string;
+    correlation_id?: string;
+    protocol_version?: number;
+    min_protocol_version?: number;
+    cutoff?: string;
+}
+
+export interface PatchOperation {
+    op: 'add' | 'remove' | 'replace';
+    path: string;
+    value?: unknown;
+}
+
+export interface CloseHint {
+    reason: string;
+    retry_after_ms?: number;
+    alt_hub?: string;
+    handoff?: string;
+}
+
+export type Capability = 'acks' | 'compression' | 'binary' | 'batching' | 'replay';
+
+export type EchoPolicy = 'others' | 'all' | 'none';
+
+export interface HubClientOptions {
+    token?: string;
+    signedQuery?: string;
+    keepaliveClass?: string;
+    statePatches?: boolean;
+    capabilities?: Capability[] | null;
+    echo?: EchoPolicy | '';
+    session?: string;
+    authFrame?: boolean;
+    scheme?: 'ws' | 'wss';
+    reconnect?: boolean;
+    autoAck?: boolean;
+    maxFrameSize?: number;
+    uploadChunkSize?: number;
+    credit?: number;
+    autoCredit?: boolean;
+    replay?: boolean;
+    reorderWindow?: number;
+    offlineQueue?: number;
+    offlineOverflow?: 'oldest' | 'newest';
+    transport?: 'websocket' | 'auto';
+    webTransportAddr?: string;
+}
+
+export interface DroppedDetail {
+    frame: Frame;
+    reason: 'overflow' | 'closed';
+}
+
+export interface GapDetail {
+    room: string;
+    missed: number | null;
+    error: Error | null;
+}
+
+export interface SendOptions {
+    id?: string;
+    room?: string;
+    receipt?: boolean;
+    nack?: boolean;
+    ephemeral?: boolean;
+    local?: boolean;
+    deliverAt?: Date;
+    contentType?: string;
+    ttl?: number;
+    key?: string;
+    routingKey?: string;
+    echo?: EchoPolicy;
+}
+
+export interface Whoami {
+    connection_id: string;
+    session_id: string;
+    hub_id: string;
+    user_id?: string;
+    roles?: string[];
+    remote_ip?: string;
+    protocol_version: number;
+    keepalive_class: string;
+    capabilities: Capability[];
+    echo: EchoPolicy;
+    rooms: string[];
+    max_rooms?: number;
+    groups?: string[];
+    rate_limit?: {messages_per_second: number; burst: number; remaining: number};
+    throttled?: boolean;
+    connected_at: string;
+}
+
+export interface RequestOptions {
+    timeout?: number;
+    contentType?: string;
+}
+
+export declare class HubClient extends EventTarget {
+    constructor(hubAddr: string, options?: HubClientOptions);
+    hubAddr: string;
+    readonly session: string;
+    readonly connected: boolean;
+    readonly idle: boolean;
+    readonly ready: boolean;
+    readonly config: Record<string, unknown> | null;
+    connect(): void;
+    close(): void;
+    send(payload: unknown, options?: SendOptions): string;
+    join(room: string, options?: {filter?: string}): void;
+    leave(room: string): void;
+    grant(count: number): void;
+    ack(frame: Frame): void;
+    request(service: string, payload: unknown, options?: RequestOptions): Promise<Frame>;
+    ping(options?: {timeout?: number}): Promise<{rtt: number; serverTime: number}>;
+    whoami(): Promise<Whoami>;
+    onConfig(callback: (config: Record<string, unknown>) => void): () => void;
+    reply(request: Frame, payload: unknown, options?: {contentType?: string}): void;
+    sendFrame(frame: Frame): void;
+}
+
+export declare function reconnectHint(event: CloseEvent): CloseHint | null;
@@ -0,0 +1,943 @@
+// HubClient speaks the hub.v1 WebSocket protocol described in protocol/hub.v1.schema.json.
+// It is shared by the bundled HubClient page and external web apps.
+
+export const SUBPROTOCOL = 'hub.v1';
+
+// PROTOCOL_VERSION is the revision of the hub.v1 protocol the client speaks, announced when it
+// connects so hubs can warn about versions they will stop accepting.
+export const PROTOCOL_VERSION = 1;
+
+// CAPABILITIES are the features of the hub.v1 protocol the client supports, advertised to the hub
+// when it connects so the hub only uses those on the connection.
+export const CAPABILITIES = Object.freeze(['acks', 'compression', 'binary', 'batching', 'replay']);
+
+// ECHO_ROOM is the hub's diagnostic room, whose messages are reflected back to their sender alone.
+export const ECHO_ROOM = '__echo__';
+
+// Close codes sent by the hub; their reason carries a JSON reconnect hint.
+export const CloseCodes = Object.freeze({
+    SHUTDOWN: 4001,
+    EVICTED: 4002,
+    DRAIN: 4003,
+    UNAUTHORIZED: 4004,
+    IDLE: 4005,
+});
+
+const defaults = {
+    token: '',
+    // signedQuery is the query string of a connect URL signed by the backend (exp, sig and the
+    // parameters it covers), used instead of a token by visitors without an account.
+    signedQuery: '',
+    // keepaliveClass chooses how often the hub pings the connection, among the hub's
+    // --keepalive-classes, unless the token's keepalive_class claim chooses one. Signed connect
+    // URLs must include it in their signed query instead.
+    keepaliveClass: '',
+    // With statePatches, hubs running with --state-patches send the messages of state rooms as JSON
+    // Patches against the previous payload of their key, applied before message events are
+    // dispatched. Signed connect URLs must include state_patches=true in their signed query instead.
+    statePatches: false,
+    // capabilities narrows the features advertised to the hub to a subset of CAPABILITIES, for
+    // rolling them out gradually; null advertises them all. Signed connect URLs must include
+    // capabilities in their signed query instead.
+    capabilities: null,
+    // echo is the echo policy of the messages sent: 'others' (the hub's default) delivers them to
+    // the user's other connections, 'all' to this connection too, for clients rendering what the hub
+    // accepted from its echo, and 'none' to no connection of the user. Signed connect URLs must
+    // include it in their signed query instead.
+    echo: '',
+    // With authFrame, the token is sent in an auth frame once the connection opens instead of in
+    // the connect URL, for hubs started with --auth-grace-period.
+    authFrame: false,
+    reconnect: true,
+    autoAck: true,
+    // The hub limits inbound frames to 512 bytes; larger messages are uploaded in base64 chunks.
+    maxFrameSize: 512,
+    uploadChunkSize: 256,
+    // credit is the number of messages the hub may deliver ahead of the client; 0 disables flow control.
+    // With autoCredit, credit is replenished once message event handlers return; otherwise call grant.
+    credit: 0,
+    autoCredit: true,
+    // With replay, messages missed in a room, because the hub dropped them or the connection was
+    // lost, are fetched from the room's history; a gap event reports those that cannot be recovered.
+    replay: true,
+    // reorderWindow is the number of milliseconds messages of rooms sequenced with the hub's
+    // --sequenced-rooms are held back when they arrive ahead of a message with a lower sequence
+    // number, so they are dispatched in sequence order; 0 dispatches them as they arrive.
+    reorderWindow: 0,
+    // offlineQueue is the number of messages sent with send while disconnected that are held and
+    // sent in order once the client connects; offlineOverflow chooses whether a full queue drops
+    // the 'oldest' or the 'newest' message. 0 disables the queue.
+    offlineQueue: 100,
+    offlineOverflow: 'oldest',
+    // session is the id the hub logs the client's connections under across reconnects, a random
+    // one by default. Signed connect URLs must include session in their signed query instead.
+    session: '',
+    // transport is 'websocket', or 'auto' to connect over an experimental WebTransport (HTTP/3)
+    // session to webTransportAddr, the hub's --webtransport-addr (hubAddr by default), when the
+    // browser supports WebTransport, falling back to WebSockets once a session fails to open.
+    transport: 'websocket',
+    webTransportAddr: '',
+};
+
+// newSession returns a random session id, a version 4 UUID.
+function newSession() {
+    if (globalThis.crypto?.randomUUID) {
+        return crypto.randomUUID();
+    }
+    const bytes = crypto.getRandomValues(new Uint8Array(16));
+    bytes[6] = (bytes[6] & 0x0f) | 0x40;
+    bytes[8] = (bytes[8] & 0x3f) | 0x80;
+    const hex = Array.from(bytes, (b) => b.toString(16).padStart(2, '0')).join('');
+    return `${hex.slice(0, 8)}-${hex.slice(8, 12)}-${hex.slice(12, 16)}-${hex.slice(16, 20)}-${hex.slice(20)}`;
+}
+
+// historyPageSize is the number of messages requested per page of room history, the hub's maximum.
+const historyPageSize = 1000;
+
+const encoder = new TextEncoder();
+const decoder = new TextDecoder();
+
+// HubClient connects to a hub and dispatches the frames it receives as events:
+//   open        the connection is established
+//   message     a message frame, reassembled from chunks if needed (event.detail is the frame)
+//   receipt     a delivery or read receipt for a message sent with receipt (event.detail is the frame)
+//   nack        a message sent with nack reached no connection (event.detail is the nack frame, with
+//               the message's id and room and the reason)
+//   request     a request for the service the client is authenticated as (event.detail is the
+//               frame, to be answered with reply)
+//   error       the hub rejected a message sent with send (event.detail is the error frame, with
+//               the message's id and room and the reason)
+//   gap         messages of a room were missed and could not be replayed from its history
+//               (event.detail has room, missed, the number of messages or null when unknown, and error)
+//   deprecation the hub will stop accepting the client's protocol version (event.detail is the
+//               frame, with the min_protocol_version required from its cutoff on)
+//   config      the hub pushed a client configuration (event.detail is the configuration object,
+//               also kept in config); see onConfig
+//   close       the connection closed (event.detail has code, reason and the hub's reconnect hint)
+//   reconnect   a reconnect is scheduled (event.detail has delay in ms and the hub address)
+//   idle        the hub closed the connection for sending nothing for its idle timeout; it is
+//               reopened once the page becomes visible again, by sending or by calling connect
+//   dropped     a message sent while disconnected was dropped from the offline queue (event.detail
+//               has the frame and the reason: overflow when the queue was full, closed when the
+//               client was closed or will not reconnect)
+export class HubClient extends EventTarget {
+    constructor(hubAddr, options = {}) {
+        super();
+        this.hubAddr = hubAddr;
+        this.options = {...defaults, ...options};
+        this.session = this.options.session || newSession();
+        this.scheme = this.options.scheme || (location.protocol === 'https:' ? 'wss' : 'ws');
+        this.socket = null;
+        this.chunks = new Map();
+        this.counter = 0;
+        this.processed = 0;
+        this.closing = false;
+        this.opened = false;
+        // joined holds the rooms joined with join, with their filters, rejoined after a reconnect, and rooms the
+        // sequence number and history cursor of the last message received in each room, and the
+        // messages held back for reordering.
+        this.joined = new Map();
+        this.rooms = new Map();
+        // calls holds the requests awaiting a reply by correlation id, and echoes the pings awaiting
+        // their echo by message id.
+        this.calls = new Map();
+        this.echoes = new Map();
+        // handoff is the token a draining hub issued to resume the rooms on the next connection.
+        this.handoff = '';
+        // idle is set while the connection is closed for idleness, until it is reopened on demand.
+        this.idle = false;
+        // ready is set once a connection is open and authenticated, and offline holds the messages
+        // sent until then.
+        this.ready = false;
+        this.offline = [];
+        // statePayloads holds the payload of the last message of each key received in each room, by
+        // room and key, which the hub's state patches apply to.
+        this.statePayloads = new Map();
+        // config is the last client configuration the hub pushed, kept across reconnects.
+        this.config = null;
+        // webTransportFailed is set once a WebTransport session failed to open, after which the
+        // client connects over WebSockets.
+        this.webTransportFailed = false;
+    }
+
+    get connected() {
+        return this.socket !== null && this.socket.readyState === WebSocket.OPEN;
+    }
+
+    connect() {
+        this.closing = false;
+        this.idle = false;
+        let query;
+        if (this.options.signedQuery) {
+            // Signed connect URLs cover their whole query, so they cannot carry a handoff token;
+            // the backend signs the protocol_version parameter in
+            query = this.options.signedQuery.replace(/^\?/, '');
+        } else {
+            const params = new URLSearchParams({protocol_version: PROTOCOL_VERSION});
+            if (this.options.token && !this.options.authFrame) {
+                params.set('access_token', this.options.token);
+            }
+            if (this.options.keepaliveClass) {
+                params.set('keepalive_class', this.options.keepaliveClass);
+            }
+            if (this.options.statePatches) {
+                params.set('state_patches', 'true');
+            }
+            if (this.options.echo) {
+                params.set('echo', this.options.echo);
+            }
+            params.set('capabilities', (this.options.capabilities ?? CAPABILITIES).join(','));
+            params.set('session', this.session);
+            if (this.handoff) {
+                params.set('handoff', this.handoff);
+            }
+            query = params.toString();
+        }
+
+        const handoff = this.handoff;
+        this.handoff = '';
+        // The hub patches against the payloads its connection delivered, so a new one starts over
+        this.statePayloads.clear();
+        const webTransport = this.useWebTransport();
+        const socket = webTransport
+            ? new WebTransportSocket(`https://${this.options.webTransportAddr || this.hubAddr}/ws?${query}`)
+            : new WebSocket(`${this.scheme}://${this.hubAddr}/ws?${query}`, SUBPROTOCOL);
+        socket.addEventListener('open', () => {
+            if (this.options.authFrame && this.options.token) {
+                // The connection is usable once the hub accepted the auth frame
+                this.sendFrame({type: 'auth', token: this.options.token});
+                return;
+            }
+            this.handleOpen();
+        });
+        socket.addEventListener('message', (event) => this.handleData(event.data));
+        socket.addEventListener('close', (event) => {
+            if (webTransport && !socket.opened && !this.closing) {
+                // Networks blocking UDP or HTTP/3 fail WebTransport sessions before they open
+                this.webTransportFailed = true;
+                this.handoff = handoff;
+                this.connect();
+                return;
+            }
+            this.handleClose(event);
+        });
+        this.socket = socket;
+    }
+
+    // useWebTransport reports whether the next connection is opened over WebTransport.
+    useWebTransport() {
+        return this.options.transport === 'auto' && !this.webTransportFailed && this.scheme === 'wss' &&
+            typeof globalThis.WebTransport === 'function';
+    }
+
+    // handleOpen resumes the client's rooms and flow control on a new connection.
+    handleOpen() {
+        this.processed = 0;
+        if (this.options.credit > 0) {
+            this.grant(this.options.credit);
+        }
+        for (const [room, filter] of this.joined) {
+            this.sendFrame({type: 'join', room: room, filter: filter});
+        }
+        this.ready = true;
+        const queued = this.offline;
+        this.offline = [];
+        queued.forEach((frame) => this.sendFrame(frame));
+        // Sequence numbers restart with every connection; messages published while the client
+        // was away are recovered from the rooms' history.
+        const reconnected = this.opened;
+        this.opened = true;
+        for (const [room, state] of this.rooms) {
+            state.seq = 0;
+            if (reconnected) {
+                this.recover(room, state, null);
+            }
+        }
+        this.dispatchEvent(new CustomEvent('open'));
+    }
+
+    close() {
+        this.closing = true;
+        this.dropOffline('closed');
+        if (this.socket) {
+            this.socket.close();
+        }
+    }
+
+    // send publishes a payload and returns the message id. Options: id, room, receipt, nack (ask for
+    // a nack event when the message reaches no connection), ephemeral, local, deliverAt (a Date for
+    // scheduled delivery), contentType (the payload's media type), ttl (milliseconds after which the
+    // message is no longer delivered), key (the value the message sets in a state room; a null
+    // payload removes it), routingKey (routed to a room by the hub's routing rules, falling back
+    // to room when no rule matches) and echo (the message's echo policy, overriding the echo option).
+    // Messages sent while disconnected wait in the offline queue.
+    send(payload, options = {}) {
+        const frame = {
+            type: 'message',
+            id: options.id || `${Date.now()}-${++this.counter}`,
+            payload: payload,
+            room: options.room,
+            receipt: options.receipt,
+            nack: options.nack,
+            ephemeral: options.ephemeral,
+            local: options.local,
+            content_type: options.contentType,
+            ttl: options.ttl,
+            key: options.key,
+            routing_key: options.routingKey,
+            echo: options.echo,
+            deliver_at: options.deliverAt ? options.deliverAt.toISOString() : undefined,
+        };
+        if (this.ready || this.options.offlineQueue <= 0) {
+            this.sendFrame(frame);
+            return frame.id;
+        }
+
+        this.offline.push(frame);
+        if (this.offline.length > this.options.offlineQueue) {
+            const dropped = this.options.offlineOverflow === 'newest' ? this.offline.pop() : this.offline.shift();
+            this.dispatchEvent(new CustomEvent('dropped', {detail: {frame: dropped, reason: 'overflow'}}));
+        }
+        // A connection closed for idleness is reopened once there is something to send
+        if (this.idle && !this.closing) {
+            this.connect();
+        }
+        return frame.id;
+    }
+
+    // dropOffline empties the offline queue, reporting each message as dropped for the reason.
+    dropOffline(reason) {
+        const dropped = this.offline;
+        this.offline = [];
+        dropped.forEach((frame) => this.dispatchEvent(new CustomEvent('dropped', {detail: {frame: frame, reason: reason}})));
+    }
+
+    // request sends a request to a connection of the service and returns a promise of the reply
+    // frame, rejected with the hub's error reason when the request times out or is refused.
+    // Options: timeout (milliseconds, capped by the hub's rpc timeout) and contentType.
+    request(service, payload, options = {}) {
+        const correlationId = `${Date.now()}-${++this.counter}`;
+        return new Promise((resolve, reject) => {
+            this.calls.set(correlationId, {resolve, reject});
+            this.sendFrame({
+                type: 'request',
+                service: service,
+                correlation_id: correlationId,
+                payload: payload,
+                ttl: options.timeout,
+                content_type: options.contentType,
+            });
+        });
+    }
+
+    // ping sends a message to the echo room and returns a promise of the round trip, resolving with
+    // rtt, the milliseconds until the hub's echo arrived, and serverTime, when the hub echoed it
+    // (unix milliseconds by the hub's clock), or rejected after timeout milliseconds (5000 by default).
+    ping(options = {}) {
+        const id = `ping-${Date.now()}-${++this.counter}`;
+        return new Promise((resolve, reject) => {
+            const timer = setTimeout(() => {
+                this.echoes.delete(id);
+                reject(new Error('timeout'));
+            }, options.timeout || 5000);
+            this.echoes.set(id, {resolve, timer, sentAt: performance.now()});
+            this.sendFrame({type: 'message', id: id, room: ECHO_ROOM, payload: null});
+        });
+    }
+
+    // whoami returns a promise of the state of the connection as the hub perceives it: its id, the
+    // hub, identity, rooms, enabled capabilities and message rate budget, for debugging.
+    whoami() {
+        const correlationId = `whoami-${Date.now()}-${++this.counter}`;
+        return new Promise((resolve, reject) => {
+            this.calls.set(correlationId, {resolve: (frame) => resolve(frame.payload), reject});
+            this.sendFrame({type: 'whoami', correlation_id: correlationId});
+        });
+    }
+
+    // onConfig calls callback with every client configuration the hub pushes, such as feature flags
+    // or poll intervals, and right away with the last one received, if any. It returns a function
+    // that stops calling it.
+    onConfig(callback) {
+        const listener = (event) => callback(event.detail);
+        this.addEventListener('config', listener);
+        if (this.config !== null) {
+            callback(this.config);
+        }
+        return () => this.removeEventListener('config', listener);
+    }
+
+    // reply answers a request frame received as a request event.
+    reply(request, payload, options = {}) {
+        this.sendFrame({
+            type: 'reply',
+            service: request.service,
+            correlation_id: request.correlation_id,
+            origin_id: request.origin_id,
+            payload: payload,
+            content_type: options.contentType,
+        });
+    }
+
+    // join subscribes to a room, receiving only the messages whose payload passes options.filter
+    // when it is given; joining a room again replaces its filter.
+    join(room, options = {}) {
+        if (!this.joined.has(room)) {
+            this.forgetRoom(room);
+        }
+        this.joined.set(room, options.filter);
+        this.sendFrame({type: 'join', room: room, filter: options.filter});
+    }
+
+    leave(room) {
+        this.joined.delete(room);
+        this.forgetRoom(room);
+        this.statePayloads.delete(room);
+        this.sendFrame({type: 'leave', room: room});
+    }
+
+    // forgetRoom drops the state of a room's messages, discarding those held back for reordering.
+    forgetRoom(room) {
+        clearTimeout(this.rooms.get(room)?.timer);
+        this.rooms.delete(room);
+    }
+
+    // grant allows the hub to deliver count more messages.
+    grant(count) {
+        this.sendFrame({type: 'credit', count: count});
+    }
+
+    // ack marks a received message as read, sending a read receipt to its publisher.
+    ack(frame) {
+        this.sendFrame({type: 'ack', id: frame.id, origin_id: frame.origin_id});
+    }
+
+    sendFrame(frame) {
+        const data = JSON.stringify(frame);
+        if (frame.type !== 'message' || data.length <= this.options.maxFrameSize) {
+            this.socket.send(data);
+            return;
+        }
+
+        const {payload, ...rest} = frame;
+        const bytes = encoder.encode(JSON.stringify(payload));
+        const size = this.options.uploadChunkSize;
+        const total = Math.ceil(bytes.length / size);
+        for (let seq = 0; seq < total; seq++) {
+            const part = bytes.subarray(seq * size, (seq + 1) * size);
+            this.socket.send(JSON.stringify({
+                ...rest, type: 'chunk', seq: seq, total: total,
+                data: btoa(String.fromCharCode(...part)),
+            }));
+        }
+    }
+
+    handleData(data) {
+        const parsed = JSON.parse(data);
+        // Hubs send the frames of messages queued back to back in one array to clients with the
+        // batching capability
+        if (Array.isArray(parsed)) {
+            parsed.forEach((frame) => this.handleFrame(frame));
+            return;
+        }
+        this.handleFrame(parsed);
+    }
+
+    handleFrame(frame) {
+        if (frame.type === 'chunk') {
+            frame = this.assembleChunk(frame);
+            if (!frame) {
+                return;
+            }
+        }
+
+        if (frame.type === 'auth') {
+            if (frame.status === 'accepted') {
+                this.handleOpen();
+            }
+            return;
+        }
+
+        if (frame.correlation_id && (frame.type === 'reply' || frame.type === 'whoami' || frame.type === 'error')) {
+            const call = this.calls.get(frame.correlation_id);
+            if (call) {
+                this.calls.delete(frame.correlation_id);
+                if (frame.type !== 'error') {
+                    call.resolve(frame);
+                } else {
+                    call.reject(new Error(frame.reason));
+                }
+            }
+            return;
+        }
+        if (frame.type === 'config') {
+            this.config = frame.payload;
+            this.dispatchEvent(new CustomEvent('config', {detail: frame.payload}));
+            return;
+        }
+        if (frame.type === 'receipt' || frame.type === 'nack' || frame.type === 'error' || frame.type === 'request' || frame.type === 'deprecation') {
+            this.dispatchEvent(new CustomEvent(frame.type, {detail: frame}));
+            return;
+        }
+        if (frame.type !== 'message') {
+            return;
+        }
+        if (frame.room === ECHO_ROOM && this.echoes.has(frame.id)) {
+            const echo = this.echoes.get(frame.id);
+            this.echoes.delete(frame.id);
+            clearTimeout(echo.timer);
+            echo.resolve({rtt: performance.now() - echo.sentAt, serverTime: frame.ingested_at});
+            return;
+        }
+        if (this.options.statePatches && !this.patchState(frame)) {
+            return;
+        }
+
+        this.deliver(frame);
+        if (this.options.credit > 0 && this.options.autoCredit) {
+            this.replenish();
+        }
+    }
+
+    // patchState replaces the JSON Patch of a state room message with the payload it turns the
+    // previous payload of the key into, and records the payload the key's next patch applies to.
+    // It reports false for patches without a previous payload, of rooms the client left meanwhile.
+    patchState(frame) {
+        if (!frame.room || !frame.key) {
+            return true;
+        }
+
+        let keys = this.statePayloads.get(frame.room);
+        if (frame.patch) {
+            if (!keys || !keys.has(frame.key)) {
+                return false;
+            }
+            frame.payload = applyPatch(keys.get(frame.key), frame.patch);
+            delete frame.patch;
+        }
+        if (frame.payload === null || frame.payload === undefined) {
+            keys?.delete(frame.key);
+            return true;
+        }
+        if (!keys) {
+            keys = new Map();
+            this.statePayloads.set(frame.room, keys);
+        }
+        // Kept apart from the dispatched payload, which the app may change
+        keys.set(frame.key, structuredClone(frame.payload));
+        return true;
+    }
+
+    // deliver dispatches a message frame. A jump in its room's sequence number means the hub
+    // dropped messages, which are recovered before the frame is dispatched.
+    deliver(frame) {
+        if (!frame.room || (!frame.room_seq && !frame.cursor)) {
+            this.dispatchMessage(frame);
+            return;
+        }
+
+        let state = this.rooms.get(frame.room);
+        if (!state) {
+            state = {seq: 0, cursor: '', pending: null, next: 0, held: new Map(), timer: null};
+            this.rooms.set(frame.room, state);
+        }
+        const missed = frame.room_seq && state.seq > 0 ? frame.room_seq - state.seq - 1 : 0;
+        if (frame.room_seq) {
+            state.seq = frame.room_seq;
+        }
+
+        if (state.pending) {
+            state.pending.push(frame);
+        } else if (missed > 0) {
+            this.recover(frame.room, state, missed, [frame]);
+        } else {
+            this.dispatchRoomMessage(state, frame);
+        }
+    }
+
+    // recover replays the room's history after the last message received, holding back the
+    // messages arriving meanwhile, or reports a gap when the room keeps no history.
+    async recover(room, state, missed, pending = []) {
+        if (!this.options.replay || !state.cursor || state.pending) {
+            if (state.pending) {
+                state.pending.push(...pending);
+                return;
+            }
+            this.dispatchGap(room, missed, null);
+            pending.forEach((frame) => this.dispatchRoomMessage(state, frame));
+            return;
+        }
+
+        state.pending = pending;
+        try {
+            let after = state.cursor;
+            for (;;) {
+                const page = await this.fetchHistory(room, after);
+                for (const message of page.messages) {
+                    this.dispatchRoomMessage(state, message);
+                }
+                if (page.messages.length < historyPageSize) {
+                    break;
+                }
+                after = page.next;
+            }
+        } catch (error) {
+            this.dispatchGap(room, missed, error);
+        }
+
+        const queued = state.pending;
+        state.pending = null;
+        queued.forEach((frame) => this.dispatchRoomMessage(state, frame));
+    }
+
+    // fetchHistory reads a page of the room's history after the cursor.
+    async fetchHistory(room, after) {
+        const scheme = this.scheme === 'wss' ? 'https' : 'http';
+        const params = new URLSearchParams({after: after, limit: String(historyPageSize)});
+        if (this.options.token) {
+            params.set('access_token', this.options.token);
+        }
+
+        const response = await fetch(`${scheme}://${this.hubAddr}/rooms/${encodeURIComponent(room)}/messages?${params}`);
+        if (!response.ok) {
+            throw new Error(`history of room ${room} unavailable: ${response.status}`);
+        }
+        return response.json();
+    }
+
+    // dispatchRoomMessage dispatches a message of a room unless a replay already did.
+    dispatchRoomMessage(state, frame) {
+        if (frame.cursor) {
+            if (state.cursor && compareCursors(frame.cursor, state.cursor) <= 0) {
+                return;
+            }
+            state.cursor = frame.cursor;
+        }
+        if (frame.sequence && this.options.reorderWindow > 0) {
+            this.reorder(state, frame);
+            return;
+        }
+        this.dispatchMessage(frame);
+    }
+
+    // reorder dispatches the messages of a sequenced room in sequence order, holding back those
+    // arriving ahead of a missing number for up to reorderWindow ms. Numbers still missing then are
+    // skipped, and messages arriving after a higher number was dispatched are dispatched late.
+    reorder(state, frame) {
+        if (state.next && frame.sequence < state.next) {
+            this.dispatchMessage(frame);
+            return;
+        }
+        if (!state.next) {
+            state.next = frame.sequence;
+        }
+        state.held.set(frame.sequence, frame);
+        this.releaseHeld(state);
+    }
+
+    // releaseHeld dispatches the held messages of a room that follow the last one dispatched, and
+    // waits for the reorder window before skipping to the next held message.
+    releaseHeld(state) {
+        while (state.held.has(state.next)) {
+            const frame = state.held.get(state.next);
+            state.held.delete(state.next);
+            state.next++;
+            this.dispatchMessage(frame);
+        }
+        if (state.held.size === 0) {
+            clearTimeout(state.timer);
+            state.timer = null;
+        } else if (!state.timer) {
+            state.timer = setTimeout(() => {
+                state.timer = null;
+                state.next = Math.min(...state.held.keys());
+                this.releaseHeld(state);
+            }, this.options.reorderWindow);
+        }
+    }
+
+    dispatchMessage(frame) {
+        this.dispatchEvent(new CustomEvent('message', {detail: frame}));
+        if (frame.receipt && this.options.autoAck) {
+            this.ack(frame);
+        }
+    }
+
+    dispatchGap(room, missed, error) {
+        this.dispatchEvent(new CustomEvent('gap', {detail: {room: room, missed: missed, error: error}}));
+    }
+
+    // replenish grants credit for processed messages once half of the window has been used.
+    replenish() {
+        this.processed++;
+        if (this.processed >= Math.ceil(this.options.credit / 2)) {
+            this.grant(this.processed);
+            this.processed = 0;
+        }
+    }
+
+    // assembleChunk collects chunk frames and returns the reassembled message frame once all chunks arrived.
+    assembleChunk(frame) {
+        let pending = this.chunks.get(frame.id);
+        if (!pending) {
+            pending = {parts: new Array(frame.total), received: 0};
+            this.chunks.set(frame.id, pending);
+        }
+        const seq = frame.seq || 0;
+        if (!pending.parts[seq]) {
+            pending.parts[seq] = Uint8Array.from(atob(frame.data || ''), (c) => c.charCodeAt(0));
+            pending.received++;
+        }
+        if (pending.received < frame.total) {
+            return null;
+        }
+        this.chunks.delete(frame.id);
+
+        const size = pending.parts.reduce((sum, part) => sum + part.length, 0);
+        const bytes = new Uint8Array(size);
+        let offset = 0;
+        for (const part of pending.parts) {
+            bytes.set(part, offset);
+            offset += part.length;
+        }
+
+        const text = decoder.decode(bytes);
+        let payload;
+        try {
+            payload = JSON.parse(text);
+        } catch (e) {
+            payload = text;
+        }
+        const {data, seq: _, total, ...rest} = frame;
+        return {...rest, type: 'message', payload: payload};
+    }
+
+    handleClose(event) {
+        this.ready = false;
+        const hint = reconnectHint(event);
+        this.dispatchEvent(new CustomEvent('close', {detail: {code: event.code, reason: event.reason, hint: hint}}));
+        // Replies and timeouts of pending requests would only have arrived on the closed connection
+        for (const call of this.calls.values()) {
+            call.reject(new Error('connection closed'));
+        }
+        this.calls.clear();
+        if (event.code === CloseCodes.IDLE) {
+            this.idle = true;
+            this.dispatchEvent(new CustomEvent('idle'));
+            if (!this.closing && this.options.reconnect) {
+                this.reconnectWhenVisible();
+            }
+            return;
+        }
+        // Reconnecting with a token the hub refused would be refused again
+        if (event.code === CloseCodes.UNAUTHORIZED || this.closing || !this.options.reconnect) {
+            this.dropOffline('closed');
+            return;
+        }
+        // Closes without a hint are left to the app to reconnect, which flushes the offline queue
+        if (!hint) {
+            return;
+        }
+
+        const delay = hint.retry_after_ms || 0;
+        if (hint.alt_hub) {
+            this.hubAddr = hint.alt_hub;
+        }
+        // The next connection resumes the rooms handed off by a draining hub
+        this.handoff = hint.handoff || '';
+        this.dispatchEvent(new CustomEvent('reconnect', {detail: {delay: delay, hubAddr: this.hubAddr}}));
+        setTimeout(() => this.connect(), delay);
+    }
+
+    // reconnectWhenVisible reopens a connection closed for idleness once the page becomes visible
+    // again, unless the app reconnected or closed the client meanwhile.
+    reconnectWhenVisible() {
+        if (typeof document === 'undefined') {
+            return;
+        }
+        const listener = () => {
+            if (document.visibilityState !== 'visible') {
+                return;
+            }
+            document.removeEventListener('visibilitychange', listener);
+            if (this.idle && !this.closing) {
+                this.connect();
+            }
+        };
+        document.addEventListener('visibilitychange', listener);
+    }
+}
+
+// compareCursors orders two room history cursors, of the form <ms>-<seq>.
+function compareCursors(a, b) {
+    const [aMs, aSeq] = a.split('-').map(BigInt);
+    const [bMs, bSeq] = b.split('-').map(BigInt);
+    if (aMs !== bMs) {
+        return aMs < bMs ? -1 : 1;
+    }
+    return aSeq < bSeq ? -1 : aSeq > bSeq ? 1 : 0;
+}
+
+// applyPatch returns the document a JSON Patch of add, remove and replace operations, as sent by
+// the hub, turns the document into, leaving the document untouched.
+function applyPatch(document, patch) {
+    let result = structuredClone(document);
+    for (const op of patch) {
+        const tokens = op.path.split('/').slice(1).map((token) => token.replace(/~1/g, '/').replace(/~0/g, '~'));
+        if (tokens.length === 0) {
+            result = op.value;
+            continue;
+        }
+
+        const last = tokens.pop();
+        let parent = result;
+        for (const token of tokens) {
+            parent = parent[Array.isArray(parent) ? Number(token) : token];
+        }
+        if (Array.isArray(parent)) {
+            const index = last === '-' ? parent.length : Number(last);
+            if (op.op === 'add') {
+                parent.splice(index, 0, op.value);
+            } else if (op.op === 'remove') {
+                parent.splice(index, 1);
+            } else {
+                parent[index] = op.value;
+            }
+        } else if (op.op === 'remove') {
+            delete parent[last];
+        } else {
+            parent[last] = op.value;
+        }
+    }
+    return result;
+}
+
+// WebSocket opcodes of the messages of a WebTransport connection.
+const OPCODE_TEXT = 1;
+const OPCODE_BINARY = 2;
+const OPCODE_CLOSE = 8;
+const OPCODE_PING = 9;
+const OPCODE_PONG = 10;
+
+// WebTransportSocket carries a hub connection over a bidirectional stream of a WebTransport
+// session, behind the part of the WebSocket interface HubClient uses. Each message of the stream is
+// its WebSocket opcode, its length as a big-endian uint32 and its data, and the hub's pings are
+// answered with pongs. opened tells closes of sessions that failed to open apart.
+class WebTransportSocket extends EventTarget {
+    constructor(url) {
+        super();
+        this.readyState = WebSocket.CONNECTING;
+        this.opened = false;
+        this.closeCode = 1006;
+        this.closeReason = '';
+        this.writer = null;
+        this.transport = new WebTransport(url);
+        this.run().catch(() => {}).finally(() => this.handleClosed());
+    }
+
+    async run() {
+        await this.transport.ready;
+        const stream = await this.transport.createBidirectionalStream();
+        this.writer = stream.writable.getWriter();
+        // The hub accepts the stream once data arrives on it
+        this.write(OPCODE_PING, new Uint8Array(0));
+        this.readyState = WebSocket.OPEN;
+        this.opened = true;
+        this.dispatchEvent(new Event('open'));
+
+        const reader = stream.readable.getReader();
+        let buffer = new Uint8Array(0);
+        for (;;) {
+            const {value, done} = await reader.read();
+            if (done) {
+                return;
+            }
+            const joined = new Uint8Array(buffer.length + value.length);
+            joined.set(buffer);
+            joined.set(value, buffer.length);
+            buffer = joined;
+            while (buffer.length >= 5) {
+                const size = new DataView(buffer.buffer, buffer.byteOffset + 1, 4).getUint32(0);
+                if (buffer.length < 5 + size) {
+                    break;
+                }
+                this.receive(buffer[0], buffer.slice(5, 5 + size));
+                buffer = buffer.slice(5 + size);
+            }
+        }
+    }
+
+    receive(opcode, data) {
+        switch (opcode) {
+        case OPCODE_TEXT:
+            this.dispatchEvent(new MessageEvent('message', {data: decoder.decode(data)}));
+            break;
+        case OPCODE_BINARY:
+            this.dispatchEvent(new MessageEvent('message', {data: data.buffer}));
+            break;
+        case OPCODE_PING:
+            this.write(OPCODE_PONG, data);
+            break;
+        case OPCODE_CLOSE:
+            if (data.length >= 2) {
+                this.closeCode = new DataView(data.buffer).getUint16(0);
+                this.closeReason = decoder.decode(data.subarray(2));
+            }
+            this.transport.close();
+            break;
+        }
+    }
+
+    send(data) {
+        if (typeof data === 'string') {
+            this.write(OPCODE_TEXT, encoder.encode(data));
+        } else {
+            this.write(OPCODE_BINARY, new Uint8Array(data));
+        }
+    }
+
+    write(opcode, data) {
+        const message = new Uint8Array(5 + data.length);
+        message[0] = opcode;
+        new DataView(message.buffer).setUint32(1, data.length);
+        message.set(data, 5);
+        this.writer.write(message).catch(() => {});
+    }
+
+    close(code = 1000, reason = '') {
+        if (this.readyState >= WebSocket.CLOSING) {
+            return;
+        }
+        if (this.readyState === WebSocket.OPEN) {
+            const text = encoder.encode(reason);
+            const data = new Uint8Array(2 + text.length);
+            new DataView(data.buffer).setUint16(0, code);
+            data.set(text, 2);
+            this.write(OPCODE_CLOSE, data);
+            this.closeCode = code;
+            this.closeReason = reason;
+        }
+        this.readyState = WebSocket.CLOSING;
+        this.transport.close();
+    }
+
+    handleClosed() {
+        this.readyState = WebSocket.CLOSED;
+        const event = new Event('close');
+        event.code = this.closeCode;
+        event.reason = this.closeReason;
+        this.dispatchEvent(event);
+    }
+}
+
+// reconnectHint returns the JSON reason of a close sent by the hub, or null for other closes.
+export function reconnectHint(event) {
+    if (event.code < 4000 || event.code > 4999 || !event.reason) {
+        return null;
+    }
+    try {
+        return JSON.parse(event.reason);
+    } catch (e) {
+        return null;
+    }
+}
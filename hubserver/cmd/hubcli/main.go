@@ -0,0 +1,295 @@
+// Command hubcli is a terminal client of a hub: it connects as a hub.v1 client, joins rooms, prints
+// the messages it receives with their timestamps and sends the lines typed to the current room.
+// It is meant for smoke-testing deployments and for observing a room.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// timeLayout is the layout of the timestamps printed with each frame.
+const timeLayout = "15:04:05.000"
+
+// options holds the command's flags.
+type options struct {
+	addr     string
+	token    string
+	rooms    []string
+	filter   string
+	insecure bool
+	echo     string
+	listen   bool
+	tui      bool
+	timeout  time.Duration
+}
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	opts := &options{}
+	root := &cobra.Command{
+		Use:   "hubcli",
+		Short: "hubcli is a terminal client of a hub",
+		Long: `hubcli connects to a hub, joins the rooms given with --room and prints the messages it receives.
+Lines typed are sent to the current room, the last one joined, and lines starting with a slash are commands:
+
+  /join <room> [filter]  join a room, receiving only the messages passing the filter expression
+  /leave [room]          leave a room, the current one by default
+  /room <room>           make a joined room the current one
+  /rooms                 list the joined rooms
+  /quit                  disconnect`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cmd.Context(), opts, cmd.InOrStdin(), cmd.OutOrStdout())
+		},
+	}
+
+	flags := root.Flags()
+	flags.StringVar(&opts.addr, "addr", envOr("HUBCLI_ADDR", "localhost:"+config.DefaultPort), "Address of the hub, host:port or a ws(s) URL of its WebSocket endpoint (env HUBCLI_ADDR)")
+	flags.StringVar(&opts.token, "token", os.Getenv("HUBCLI_TOKEN"), "Access token the hub authenticates the connection with (env HUBCLI_TOKEN)")
+	flags.StringArrayVar(&opts.rooms, "room", nil, "Room to join once connected, repeatable")
+	flags.StringVar(&opts.filter, "filter", "", "Filter expression selecting the messages of the rooms given with --room")
+	flags.BoolVar(&opts.insecure, "insecure", false, "Skip the verification of the hub's TLS certificate for wss addresses")
+	flags.StringVar(&opts.echo, "echo", "", "Echo policy of the lines sent: others (the hub's default), all to receive them back too, or none")
+	flags.BoolVar(&opts.listen, "listen", false, "Only print the frames received, ignoring standard input")
+	flags.BoolVar(&opts.tui, "tui", false, "Show received frames above a fixed input line in the terminal")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Second, "Timeout of the connection to the hub")
+	return root
+}
+
+// envOr returns the value of the environment variable, or fallback when it is unset.
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// run connects to the hub and exchanges frames with it until the input ends, /quit is typed, the
+// process is interrupted or the hub closes the connection.
+func run(ctx context.Context, opts *options, in io.Reader, out io.Writer) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dialOpts := client.Options{Token: opts.token, HandshakeTimeout: opts.timeout, Echo: opts.echo}
+	if opts.insecure {
+		dialOpts.TLSConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // opted into with --insecure
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	c, err := client.Dial(dialCtx, opts.addr, dialOpts)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var screen display = &lines{out: out}
+	if opts.tui {
+		if screen, err = newTUI(in, out); err != nil {
+			return err
+		}
+	}
+	defer screen.Close()
+
+	s := &session{client: c, screen: screen}
+	for _, room := range opts.rooms {
+		if err := s.join(room, opts.filter); err != nil {
+			return err
+		}
+	}
+	screen.Printf("connected to %s", opts.addr)
+
+	input := make(chan string)
+	if !opts.listen {
+		go screen.Read(in, input)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case frame, ok := <-c.Frames():
+			if !ok {
+				if err := c.Err(); !errors.Is(err, client.ErrClosed) {
+					return fmt.Errorf("connection to the hub closed: %w", err)
+				}
+				return nil
+			}
+			s.print(frame)
+		case line, ok := <-input:
+			if !ok || s.handle(line) {
+				return nil
+			}
+		}
+	}
+}
+
+// session holds the rooms joined on the connection and the current room lines are sent to.
+type session struct {
+	client *client.Client
+	screen display
+	rooms  []string
+}
+
+// current returns the room lines are sent to, empty when no room is joined.
+func (s *session) current() string {
+	if len(s.rooms) == 0 {
+		return ""
+	}
+	return s.rooms[len(s.rooms)-1]
+}
+
+func (s *session) join(room, filter string) error {
+	if err := s.client.Join(room, filter); err != nil {
+		return err
+	}
+	s.rooms = slices.DeleteFunc(s.rooms, func(r string) bool { return r == room })
+	s.rooms = append(s.rooms, room)
+	s.screen.SetPrompt(room)
+	return nil
+}
+
+// handle sends a line typed to the current room or runs the command it holds, and returns whether
+// the session ends.
+func (s *session) handle(line string) (quit bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return false
+	}
+	if !strings.HasPrefix(line, "/") {
+		if s.current() == "" {
+			s.screen.Printf("join a room with /join <room> before sending")
+			return false
+		}
+		if _, err := s.client.Send(s.current(), []byte(line)); err != nil {
+			s.screen.Printf("failed to send: %v", err)
+		}
+		return false
+	}
+
+	command, arg, _ := strings.Cut(line[1:], " ")
+	arg = strings.TrimSpace(arg)
+	switch command {
+	case "quit", "exit":
+		return true
+	case "join":
+		room, filter, _ := strings.Cut(arg, " ")
+		if room == "" {
+			s.screen.Printf("usage: /join <room> [filter]")
+			return false
+		}
+		if err := s.join(room, strings.TrimSpace(filter)); err != nil {
+			s.screen.Printf("failed to join %s: %v", room, err)
+		}
+	case "leave":
+		room := arg
+		if room == "" {
+			room = s.current()
+		}
+		if !slices.Contains(s.rooms, room) {
+			s.screen.Printf("not in room %q", room)
+			return false
+		}
+		if err := s.client.Leave(room); err != nil {
+			s.screen.Printf("failed to leave %s: %v", room, err)
+			return false
+		}
+		s.rooms = slices.DeleteFunc(s.rooms, func(r string) bool { return r == room })
+		s.screen.SetPrompt(s.current())
+	case "room":
+		if !slices.Contains(s.rooms, arg) {
+			s.screen.Printf("not in room %q, join it with /join %s", arg, arg)
+			return false
+		}
+		s.rooms = slices.DeleteFunc(s.rooms, func(r string) bool { return r == arg })
+		s.rooms = append(s.rooms, arg)
+		s.screen.SetPrompt(arg)
+	case "rooms":
+		s.screen.Printf("rooms: %s (current %s)", strings.Join(s.rooms, ", "), s.current())
+	default:
+		s.screen.Printf("unknown command /%s", command)
+	}
+	return false
+}
+
+// print shows a frame received from the hub, stamped with the time the hub ingested its message or,
+// for other frames, the time it was received.
+func (s *session) print(frame client.Frame) {
+	at := time.Now()
+	if frame.IngestedAt > 0 {
+		at = time.UnixMilli(frame.IngestedAt)
+	}
+	stamp := at.Format(timeLayout)
+
+	switch frame.Type {
+	case client.FrameMessage:
+		room := frame.Room
+		if room == "" {
+			room = "*"
+		}
+		s.screen.Printf("%s [%s] %s: %s", stamp, room, frame.OriginID, frame.Payload)
+	case client.FrameError, client.FrameNack:
+		s.screen.Printf("%s %s %s %s %s", stamp, frame.Type, frame.Room, frame.Status, frame.Reason)
+	case client.FrameDeprecation:
+		s.screen.Printf("%s the hub deprecates this client's protocol version %d, the oldest supported is %d", stamp, frame.ProtocolVersion, frame.MinProtocolVersion)
+	case client.FrameConfig:
+		s.screen.Printf("%s config %s", stamp, frame.Payload)
+	}
+}
+
+// display is where the session shows frames and reads the lines typed.
+type display interface {
+	// Printf shows a line
+	Printf(format string, args ...any)
+	// SetPrompt shows the current room
+	SetPrompt(room string)
+	// Read sends the lines typed to input until the input ends, then closes it
+	Read(in io.Reader, input chan<- string)
+	Close() error
+}
+
+// lines is the plain display, printing a line per frame and reading lines from the input as-is so
+// hubcli can be scripted.
+type lines struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (l *lines) Printf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, format+"\n", args...)
+}
+
+func (l *lines) SetPrompt(string) {}
+
+func (l *lines) Read(in io.Reader, input chan<- string) {
+	defer close(input)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		input <- scanner.Text()
+	}
+}
+
+func (l *lines) Close() error { return nil }
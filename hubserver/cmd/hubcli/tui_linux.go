@@ -0,0 +1,147 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+// tui shows the frames received in a region scrolling above a status line, holding the current
+// room, and the line being typed at the bottom of the terminal, which it puts in raw mode.
+type tui struct {
+	mu     sync.Mutex
+	out    io.Writer
+	fd     int
+	saved  *unix.Termios
+	rows   int
+	room   string
+	typed  []rune
+	closed bool
+}
+
+func newTUI(in io.Reader, out io.Writer) (display, error) {
+	file, ok := in.(*os.File)
+	if !ok {
+		return nil, errors.New("--tui needs a terminal as standard input")
+	}
+	fd := int(file.Fd())
+	saved, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, errors.New("--tui needs a terminal as standard input")
+	}
+	size, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || size.Row < 4 {
+		return nil, errors.New("--tui needs a terminal of at least 4 rows")
+	}
+
+	raw := *saved
+	raw.Lflag &^= unix.ECHO | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Iflag &^= unix.ICRNL | unix.IXON
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, fmt.Errorf("failed to put the terminal in raw mode: %w", err)
+	}
+
+	t := &tui{out: out, fd: fd, saved: saved, rows: int(size.Row)}
+	// clear the screen and scroll the rows above the status and input lines only
+	fmt.Fprintf(out, "\x1b[2J\x1b[1;%dr", t.rows-2)
+	t.redrawLocked()
+	return t, nil
+}
+
+func (t *tui) Printf(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	// print on the last row of the scrolling region, scrolling it up, then return to the input line
+	fmt.Fprintf(t.out, "\x1b[%d;1H\n%s", t.rows-2, fmt.Sprintf(format, args...))
+	t.redrawLocked()
+}
+
+func (t *tui) SetPrompt(room string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.room = room
+	t.redrawLocked()
+}
+
+// Read reads the keys typed, editing the input line, and sends it once enter is pressed. Ctrl-C and
+// ctrl-D end the input.
+func (t *tui) Read(in io.Reader, input chan<- string) {
+	defer close(input)
+	buf := make([]byte, 64)
+	var pending []byte
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		pending = append(pending, buf[:n]...)
+		for len(pending) > 0 {
+			r, size := utf8.DecodeRune(pending)
+			if r == utf8.RuneError && !utf8.FullRune(pending) {
+				break
+			}
+			pending = pending[size:]
+
+			switch {
+			case r == 3 || r == 4:
+				return
+			case r == '\r' || r == '\n':
+				t.mu.Lock()
+				line := string(t.typed)
+				t.typed = t.typed[:0]
+				t.redrawLocked()
+				t.mu.Unlock()
+				input <- line
+			case r == 127 || r == 8:
+				t.mu.Lock()
+				if len(t.typed) > 0 {
+					t.typed = t.typed[:len(t.typed)-1]
+				}
+				t.redrawLocked()
+				t.mu.Unlock()
+			case r == 0x1b:
+				// drop escape sequences such as the arrow keys
+				pending = nil
+			case r >= ' ':
+				t.mu.Lock()
+				t.typed = append(t.typed, r)
+				t.redrawLocked()
+				t.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Close restores the terminal.
+func (t *tui) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	fmt.Fprintf(t.out, "\x1b[r\x1b[%d;1H\x1b[2K\r\n", t.rows)
+	return unix.IoctlSetTermios(t.fd, unix.TCSETS, t.saved)
+}
+
+// redrawLocked draws the status and input lines, leaving the cursor after the text typed.
+func (t *tui) redrawLocked() {
+	room := t.room
+	if room == "" {
+		room = "no room, /join <room>"
+	}
+	fmt.Fprintf(t.out, "\x1b[%d;1H\x1b[2K\x1b[7m room: %s \x1b[0m", t.rows-1, room)
+	fmt.Fprintf(t.out, "\x1b[%d;1H\x1b[2K> %s", t.rows, string(t.typed))
+}
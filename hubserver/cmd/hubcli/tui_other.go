@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func newTUI(io.Reader, io.Writer) (display, error) {
+	return nil, errors.New("--tui is only supported on Linux")
+}
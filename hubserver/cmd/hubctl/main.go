@@ -0,0 +1,753 @@
+// Command hubctl operates a running hub through its admin API: listing and kicking connections,
+// listing rooms, broadcasting messages and draining the hub.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/websocket"
+	"github.com/spf13/cobra"
+)
+
+// options holds the flags shared by every command.
+type options struct {
+	addr    string
+	token   string
+	timeout time.Duration
+	json    bool
+}
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	opts := &options{}
+	root := &cobra.Command{
+		Use:          "hubctl",
+		Short:        "hubctl operates a running hub through its admin API",
+		SilenceUsage: true,
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&opts.addr, "addr", envOr("HUBCTL_ADDR", config.DefaultAdminAddr), "Admin address of the hub, host:port or an http(s) URL (env HUBCTL_ADDR)")
+	flags.StringVar(&opts.token, "token", os.Getenv("HUBCTL_TOKEN"), "Bearer token matching the hub's --admin-token (env HUBCTL_TOKEN)")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Second, "Timeout of each admin API request")
+	flags.BoolVar(&opts.json, "json", false, "Print responses as JSON instead of tables")
+
+	root.AddCommand(
+		newStatsCommand(opts),
+		newConnectionsCommand(opts),
+		newRoomsCommand(opts),
+		newPeersCommand(opts),
+		newBandwidthCommand(opts),
+		newBroadcastCommand(opts),
+		newGroupsCommand(opts),
+		newDrainCommand(opts),
+		newKeepaliveCommand(opts),
+		newClientConfigCommand(opts),
+		newRoutesCommand(opts),
+		newDeadLettersCommand(opts),
+	)
+	return root
+}
+
+// envOr returns the value of the environment variable, or fallback when it is unset.
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// run calls fn with a client of the hub's admin API and a context bounded by the request timeout.
+func (o *options) run(fn func(ctx context.Context, client *adminClient) error) error {
+	client, err := newAdminClient(o.addr, o.token, o.timeout)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	return fn(ctx, client)
+}
+
+// print writes v as indented JSON when --json is set, otherwise calls table with a tab writer.
+func (o *options) print(w io.Writer, v any, table func(tw *tabwriter.Writer)) error {
+	if o.json {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+func newStatsCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats",
+		Short: "Show the hub's load",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				var stats websocket.Stats
+				if err := client.do(ctx, http.MethodGet, "/admin/stats", nil, &stats); err != nil {
+					return err
+				}
+				return opts.print(cmd.OutOrStdout(), stats, func(tw *tabwriter.Writer) {
+					fmt.Fprintf(tw, "connections\t%d\n", stats.Connections)
+					fmt.Fprintf(tw, "messages processed\t%d\n", stats.MessagesProcessed)
+					fmt.Fprintf(tw, "broadcast queue depth\t%d\n", stats.BroadcastQueueDepth)
+					fmt.Fprintf(tw, "write queue depth\t%d\n", stats.WriteQueueDepth)
+					fmt.Fprintf(tw, "write queue bytes\t%d\n", stats.WriteQueueBytes)
+					fmt.Fprintf(tw, "paused connections\t%d\n", stats.PausedConnections)
+					fmt.Fprintf(tw, "draining\t%t\n", stats.Draining)
+					fmt.Fprintf(tw, "awaiting reconnection\t%d\n", stats.AwaitingReconnection)
+				})
+			})
+		},
+	}
+}
+
+func newConnectionsCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "connections",
+		Aliases: []string{"conns"},
+		Short:   "List and kick the hub's connections",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the hub's connections, oldest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				var conns []websocket.ConnectionInfo
+				if err := client.do(ctx, http.MethodGet, "/admin/connections", nil, &conns); err != nil {
+					return err
+				}
+				return opts.print(cmd.OutOrStdout(), conns, func(tw *tabwriter.Writer) {
+					fmt.Fprintln(tw, "ID\tUSER\tREMOTE IP\tFRAMED\tQUEUED\tAGE\tIN\tOUT\tROOMS\tGROUPS")
+					for _, conn := range conns {
+						fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%d\t%s\t%d\t%d\t%s\t%s\n", conn.ID, orDash(conn.UserID), orDash(conn.RemoteIP),
+							conn.Framed, conn.WriteQueueDepth, time.Since(conn.ConnectedAt).Round(time.Second), conn.BytesIn, conn.BytesOut,
+							orDash(strings.Join(conn.Rooms, ",")), orDash(strings.Join(conn.Groups, ",")))
+					}
+				})
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "kick <conn-id>...",
+		Short: "Close connections with the evicted close code",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				for _, connID := range args {
+					if err := client.do(ctx, http.MethodDelete, "/admin/connections/"+url.PathEscape(connID), nil, nil); err != nil {
+						return err
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "kicked %s\n", connID)
+				}
+				return nil
+			})
+		},
+	})
+	return cmd
+}
+
+func newRoomsCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rooms",
+		Short: "List the hub's rooms",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the rooms of the hub, including the ones left without subscribers for less than the grace period",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				var rooms []websocket.RoomInfo
+				if err := client.do(ctx, http.MethodGet, "/admin/rooms", nil, &rooms); err != nil {
+					return err
+				}
+				return opts.print(cmd.OutOrStdout(), rooms, func(tw *tabwriter.Writer) {
+					fmt.Fprintln(tw, "ROOM\tSUBSCRIBERS\tAGE\tDESTROYED IN")
+					for _, room := range rooms {
+						destroyIn := "-"
+						if room.DestroyAt != nil {
+							destroyIn = time.Until(*room.DestroyAt).Round(time.Second).String()
+						}
+						fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", room.Name, room.Subscribers, time.Since(room.CreatedAt).Round(time.Second), destroyIn)
+					}
+				})
+			})
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "events",
+		Short: "List the last rooms created and destroyed on the hub",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				var resp struct {
+					Events []websocket.RoomEvent `json:"events"`
+				}
+				if err := client.do(ctx, http.MethodGet, "/admin/room-events", nil, &resp); err != nil {
+					return err
+				}
+				return opts.print(cmd.OutOrStdout(), resp.Events, func(tw *tabwriter.Writer) {
+					fmt.Fprintln(tw, "AT\tEVENT\tROOM")
+					for _, event := range resp.Events {
+						fmt.Fprintf(tw, "%s\t%s\t%s\n", event.At.Format(time.RFC3339), event.Type, event.Room)
+					}
+				})
+			})
+		},
+	})
+	return cmd
+}
+
+func newPeersCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "peers",
+		Short: "List the other hubs heard from through the broker, with the delay and loss of their heartbeats",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				var peers []websocket.PeerInfo
+				if err := client.do(ctx, http.MethodGet, "/admin/peers", nil, &peers); err != nil {
+					return err
+				}
+				return opts.print(cmd.OutOrStdout(), peers, func(tw *tabwriter.Writer) {
+					fmt.Fprintln(tw, "HUB\tZONE\tLAST SEEN\tDELAY\tRECEIVED\tLOST\tHEALTHY")
+					for _, peer := range peers {
+						fmt.Fprintf(tw, "%s\t%s\t%s\t%.2fms\t%d\t%d\t%t\n", peer.HubID, orDash(peer.Zone),
+							time.Since(peer.LastSeen).Round(time.Second), peer.DelayMS, peer.Received, peer.Lost, peer.Healthy)
+					}
+				})
+			})
+		},
+	}
+}
+
+func newBandwidthCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "bandwidth",
+		Short: "Show the bytes each user sent and received, and their usage of the bandwidth caps",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				var users []websocket.UserBandwidth
+				if err := client.do(ctx, http.MethodGet, "/admin/bandwidth", nil, &users); err != nil {
+					return err
+				}
+				return opts.print(cmd.OutOrStdout(), users, func(tw *tabwriter.Writer) {
+					fmt.Fprintln(tw, "KEY\tCONNECTIONS\tIN\tOUT\tDAILY\tMONTHLY\tCAPPED")
+					for _, user := range users {
+						fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", user.Key, user.Connections, user.BytesIn, user.BytesOut,
+							user.Daily, user.Monthly, orDash(user.Capped))
+					}
+				})
+			})
+		},
+	}
+}
+
+func newBroadcastCommand(opts *options) *cobra.Command {
+	var room string
+	cmd := &cobra.Command{
+		Use:   "broadcast <json-payload|->",
+		Short: "Broadcast a JSON payload to a room, or to every connection without --room",
+		Long: "Broadcast a JSON payload to a room, or to every connection without --room. The payload is\n" +
+			"read from standard input when it is -.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			payload, err := readPayload(cmd, args[0])
+			if err != nil {
+				return err
+			}
+
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				body := struct {
+					Room    string          `json:"room,omitempty"`
+					Payload json.RawMessage `json:"payload"`
+				}{Room: room, Payload: payload}
+				var result websocket.PublishResult
+				if err := client.do(ctx, http.MethodPost, "/admin/broadcast", body, &result); err != nil {
+					return err
+				}
+				return opts.print(cmd.OutOrStdout(), result, func(tw *tabwriter.Writer) {
+					fmt.Fprintf(tw, "id\t%s\n", result.ID)
+					fmt.Fprintf(tw, "local recipients\t%d\n", result.LocalRecipients)
+					fmt.Fprintf(tw, "forwarded\t%t\n", result.Forwarded)
+					if result.Hubs >= 0 {
+						fmt.Fprintf(tw, "hubs reached\t%d\n", result.Hubs)
+					}
+				})
+			})
+		},
+	}
+	cmd.Flags().StringVar(&room, "room", "", "Room to broadcast to (empty broadcasts to every connection)")
+	return cmd
+}
+
+// readPayload returns the JSON payload given as an argument, read from standard input when it is -.
+func readPayload(cmd *cobra.Command, arg string) ([]byte, error) {
+	payload := []byte(arg)
+	if arg == "-" {
+		var err error
+		if payload, err = io.ReadAll(cmd.InOrStdin()); err != nil {
+			return nil, fmt.Errorf("failed to read payload: %w", err)
+		}
+	}
+	if !json.Valid(payload) {
+		return nil, fmt.Errorf("payload is not valid JSON")
+	}
+	return payload, nil
+}
+
+func newGroupsCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "groups",
+		Short: "Assign the hub's connections to named groups and broadcast to, kick or rate limit a group at once",
+	}
+
+	// groupPath returns the path of a group's endpoint.
+	groupPath := func(group, endpoint string) string {
+		return "/admin/groups/" + url.PathEscape(group) + "/" + endpoint
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the groups with connections on the hub or a message rate set",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				var groups []websocket.GroupInfo
+				if err := client.do(ctx, http.MethodGet, "/admin/groups", nil, &groups); err != nil {
+					return err
+				}
+				return opts.print(cmd.OutOrStdout(), groups, func(tw *tabwriter.Writer) {
+					fmt.Fprintln(tw, "GROUP\tCONNECTIONS\tRATE\tBURST")
+					for _, group := range groups {
+						rate, burst := "-", "-"
+						if group.Rate != nil {
+							rate, burst = strconv.FormatFloat(group.Rate.MessagesPerSecond, 'g', -1, 64), strconv.Itoa(group.Rate.Burst)
+						}
+						fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", group.Name, group.Connections, rate, burst)
+					}
+				})
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "add <group> <conn-id>...",
+		Short: "Add connections to a group",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				body := struct {
+					Connections []string `json:"connections"`
+				}{Connections: args[1:]}
+				var resp struct {
+					Connections int `json:"connections"`
+				}
+				if err := client.do(ctx, http.MethodPost, groupPath(args[0], "connections"), body, &resp); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "added %d of %d connections to %s\n", resp.Connections, len(args)-1, args[0])
+				return nil
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove <group> <conn-id>...",
+		Short: "Remove connections from a group",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				for _, connID := range args[1:] {
+					if err := client.do(ctx, http.MethodDelete, groupPath(args[0], "connections/"+url.PathEscape(connID)), nil, nil); err != nil {
+						return err
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "removed %s from %s\n", connID, args[0])
+				}
+				return nil
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "broadcast <group> <json-payload|->",
+		Short: "Send a JSON payload to every connection of a group",
+		Long: "Send a JSON payload to every connection of a group on the hub, outside of any room. The\n" +
+			"payload is read from standard input when it is -.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			payload, err := readPayload(cmd, args[1])
+			if err != nil {
+				return err
+			}
+
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				body := struct {
+					Payload json.RawMessage `json:"payload"`
+				}{Payload: payload}
+				var result websocket.PublishResult
+				if err := client.do(ctx, http.MethodPost, groupPath(args[0], "broadcast"), body, &result); err != nil {
+					return err
+				}
+				return opts.print(cmd.OutOrStdout(), result, func(tw *tabwriter.Writer) {
+					fmt.Fprintf(tw, "id\t%s\n", result.ID)
+					fmt.Fprintf(tw, "local recipients\t%d\n", result.LocalRecipients)
+				})
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "kick <group>",
+		Short: "Close every connection of a group with the evicted close code",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				var resp struct {
+					Connections int `json:"connections"`
+				}
+				if err := client.do(ctx, http.MethodPost, groupPath(args[0], "kick"), nil, &resp); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "kicked %d connections of %s\n", resp.Connections, args[0])
+				return nil
+			})
+		},
+	})
+
+	var burst int
+	rate := &cobra.Command{
+		Use:   "rate <group> <messages-per-second|off>",
+		Short: "Hold the connections of a group to a message rate in place of their quota, or clear it with off",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if args[1] == "off" {
+				return opts.run(func(ctx context.Context, client *adminClient) error {
+					if err := client.do(ctx, http.MethodDelete, groupPath(args[0], "rate"), nil, nil); err != nil {
+						return err
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "cleared the rate of %s\n", args[0])
+					return nil
+				})
+			}
+
+			perSecond, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid messages per second %q", args[1])
+			}
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				body := websocket.GroupRate{MessagesPerSecond: perSecond, Burst: burst}
+				if err := client.do(ctx, http.MethodPut, groupPath(args[0], "rate"), body, nil); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "set the rate of %s to %g messages per second\n", args[0], perSecond)
+				return nil
+			})
+		},
+	}
+	rate.Flags().IntVar(&burst, "burst", 1, "Messages each connection of the group may send in a burst above the rate")
+	cmd.AddCommand(rate)
+	return cmd
+}
+
+func newDrainCommand(opts *options) *cobra.Command {
+	var over time.Duration
+	cmd := &cobra.Command{
+		Use:   "drain",
+		Short: "Stop the hub accepting connections and close the existing ones with the drain close code",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				path := "/admin/drain?over=" + url.QueryEscape(over.String())
+				var resp struct {
+					Connections int `json:"connections"`
+				}
+				if err := client.do(ctx, http.MethodPost, path, nil, &resp); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "draining %d connections over %s\n", resp.Connections, over)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().DurationVar(&over, "over", 0, "Spread the closes evenly over this duration so clients don't reconnect all at once")
+	return cmd
+}
+
+func newKeepaliveCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keepalive",
+		Short: "Show and replace the keepalive of connection classes",
+	}
+
+	// printClasses prints the classes returned by the keepalive-classes endpoint.
+	printClasses := func(ctx context.Context, cmd *cobra.Command, client *adminClient, method string, body any) error {
+		var resp struct {
+			Classes []string `json:"classes"`
+		}
+		if err := client.do(ctx, method, "/admin/keepalive-classes", body, &resp); err != nil {
+			return err
+		}
+		return opts.print(cmd.OutOrStdout(), resp.Classes, func(tw *tabwriter.Writer) {
+			fmt.Fprintln(tw, "CLASS\tPING INTERVAL\tMAX MISSED PONGS")
+			for _, spec := range resp.Classes {
+				name, settings, _ := strings.Cut(spec, "=")
+				interval, missed, _ := strings.Cut(settings, ":")
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", name, interval, missed)
+			}
+		})
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the keepalive of every connection class",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				return printClasses(ctx, cmd, client, http.MethodGet, nil)
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <class=ping-interval[:max-missed-pongs]>...",
+		Short: "Replace the connection classes; established connections pick them up from their next ping",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				body := struct {
+					Classes []string `json:"classes"`
+				}{Classes: args}
+				return printClasses(ctx, cmd, client, http.MethodPut, body)
+			})
+		},
+	})
+	return cmd
+}
+
+func newClientConfigCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "client-config",
+		Short: "Show, set and push the configuration the hub's clients receive in config frames",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "get",
+		Short: "Print the client configuration new connections receive",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				var resp struct {
+					Config json.RawMessage `json:"config"`
+				}
+				if err := client.do(ctx, http.MethodGet, "/admin/client-config", nil, &resp); err != nil {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(resp.Config))
+				return nil
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <json-object|->",
+		Short: "Set the client configuration and push it to every connection of the hub",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := readPayload(cmd, args[0])
+			if err != nil {
+				return err
+			}
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				body := struct {
+					Config json.RawMessage `json:"config"`
+				}{Config: config}
+				var resp struct {
+					Connections int `json:"connections"`
+				}
+				if err := client.do(ctx, http.MethodPut, "/admin/client-config", body, &resp); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "pushed the client configuration to %d connections\n", resp.Connections)
+				return nil
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "clear",
+		Short: "Stop sending a client configuration to new connections",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				return client.do(ctx, http.MethodDelete, "/admin/client-config", nil, nil)
+			})
+		},
+	})
+
+	var target websocket.ConfigTarget
+	push := &cobra.Command{
+		Use:   "push <json-object|->",
+		Short: "Push a client configuration to selected connections without setting it for new ones",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := readPayload(cmd, args[0])
+			if err != nil {
+				return err
+			}
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				body := struct {
+					Config json.RawMessage `json:"config"`
+					websocket.ConfigTarget
+				}{Config: config, ConfigTarget: target}
+				var resp struct {
+					Connections int `json:"connections"`
+				}
+				if err := client.do(ctx, http.MethodPost, "/admin/client-config/push", body, &resp); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "pushed the client configuration to %d connections\n", resp.Connections)
+				return nil
+			})
+		},
+	}
+	push.Flags().StringSliceVar(&target.Connections, "connection", nil, "Push to the connection with this id (repeatable)")
+	push.Flags().StringSliceVar(&target.Groups, "group", nil, "Push to the connections of this group (repeatable)")
+	push.Flags().StringSliceVar(&target.Users, "user", nil, "Push to the connections of this user (repeatable)")
+	cmd.AddCommand(push)
+	return cmd
+}
+
+func newRoutesCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "routes",
+		Short: "Show and replace the rules routing the keys of published messages to rooms",
+	}
+
+	// printRules prints the rules returned by the routing-rules endpoint.
+	printRules := func(ctx context.Context, cmd *cobra.Command, client *adminClient, method string, body any) error {
+		var resp struct {
+			Rules []string `json:"rules"`
+		}
+		if err := client.do(ctx, method, "/admin/routing-rules", body, &resp); err != nil {
+			return err
+		}
+		return opts.print(cmd.OutOrStdout(), resp.Rules, func(tw *tabwriter.Writer) {
+			fmt.Fprintln(tw, "ROUTING KEY\tROOM")
+			for _, spec := range resp.Rules {
+				key, room, _ := strings.Cut(spec, "=")
+				fmt.Fprintf(tw, "%s\t%s\n", key, room)
+			}
+		})
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the routing rules",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				return printRules(ctx, cmd, client, http.MethodGet, nil)
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set [key=room]...",
+		Short: "Replace the routing rules; messages published from then on are routed by them",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				body := struct {
+					Rules []string `json:"rules"`
+				}{Rules: args}
+				return printRules(ctx, cmd, client, http.MethodPut, body)
+			})
+		},
+	})
+	return cmd
+}
+
+func newDeadLettersCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dead-letters",
+		Short: "Inspect and replay the envelopes from other hubs that could not be delivered",
+	}
+
+	var limit int
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the most recent dead letters, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				var letters []redis.DeadLetter
+				if err := client.do(ctx, http.MethodGet, "/admin/dead-letters?limit="+strconv.Itoa(limit), nil, &letters); err != nil {
+					return err
+				}
+				return opts.print(cmd.OutOrStdout(), letters, func(tw *tabwriter.Writer) {
+					fmt.Fprintln(tw, "ID\tAGE\tHUB\tCHANNEL\tREASON\tERROR")
+					for _, letter := range letters {
+						fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", letter.ID, time.Since(letter.At).Round(time.Second), letter.Hub,
+							letter.Channel, letter.Reason, letter.Error)
+					}
+				})
+			})
+		},
+	}
+	list.Flags().IntVar(&limit, "limit", 100, "Maximum number of dead letters to list")
+	cmd.AddCommand(list)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "replay <id>...",
+		Short: "Publish dead letters to the hubs again and remove them",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(func(ctx context.Context, client *adminClient) error {
+				for _, id := range args {
+					if err := client.do(ctx, http.MethodPost, "/admin/dead-letters/"+url.PathEscape(id)+"/replay", nil, nil); err != nil {
+						return err
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "replayed %s\n", id)
+				}
+				return nil
+			})
+		},
+	})
+	return cmd
+}
+
+// orDash returns s, or a dash for an empty table cell.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,222 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+const (
+	consumerTag    = "hubserver"
+	reconnectDelay = 2 * time.Second
+	// maxConsumeBackoff caps the backoff between attempts to consume the hub's queue, which doubles
+	// from reconnectDelay while they keep failing.
+	maxConsumeBackoff = time.Minute
+)
+
+// PubSub manages cross-hub messaging over a RabbitMQ fanout exchange. Every hub
+// instance consumes from its own auto-delete queue bound to the exchange.
+type PubSub struct {
+	url      string
+	exchange string
+	queue    string
+	hubID    string
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	stopped bool
+
+	logger *zap.Logger
+}
+
+// NewPubSub connects to RabbitMQ and declares the fanout exchange and the hub's queue.
+func NewPubSub(url, exchange, hubID string, logger *zap.Logger) (*PubSub, error) {
+	ps := &PubSub{
+		url:      url,
+		exchange: exchange,
+		queue:    fmt.Sprintf("%s.%s", exchange, hubID),
+		hubID:    hubID,
+		logger:   logger,
+	}
+
+	if err := ps.connect(); err != nil {
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+// connect dials RabbitMQ and declares the exchange, queue and binding used by the hub.
+func (ps *PubSub) connect() error {
+	conn, err := amqp.Dial(ps.url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+
+	if err := ch.ExchangeDeclare(ps.exchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to declare exchange %s: %w", ps.exchange, err)
+	}
+
+	if _, err := ch.QueueDeclare(ps.queue, false, true, true, false, nil); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to declare queue %s: %w", ps.queue, err)
+	}
+
+	if err := ch.QueueBind(ps.queue, "", ps.exchange, false, nil); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to bind queue %s to exchange %s: %w", ps.queue, ps.exchange, err)
+	}
+
+	ps.mu.Lock()
+	ps.conn = conn
+	ps.channel = ch
+	ps.mu.Unlock()
+
+	ps.logger.Info("Connected to RabbitMQ", zap.String("exchange", ps.exchange), zap.String("queue", ps.queue))
+	return nil
+}
+
+// reconnect re-establishes the connection until it succeeds or the PubSub is stopped.
+func (ps *PubSub) reconnect() bool {
+	for {
+		if ps.isStopped() {
+			return false
+		}
+
+		ps.mu.Lock()
+		if ps.conn != nil {
+			_ = ps.conn.Close()
+		}
+		ps.mu.Unlock()
+
+		if err := ps.connect(); err != nil {
+			ps.logger.Error("Failed to reconnect to RabbitMQ", zap.Error(err))
+			time.Sleep(reconnectDelay)
+			continue
+		}
+		return true
+	}
+}
+
+func (ps *PubSub) isStopped() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.stopped
+}
+
+// Subscribe consumes the hub's queue and forwards messages from other hubs to broadcastCh,
+// reconnecting when the connection to RabbitMQ is lost.
+func (ps *PubSub) Subscribe(ctx context.Context, broadcastCh chan<- message.MessageDetails) {
+	backoff := reconnectDelay
+	for {
+		ps.mu.Lock()
+		ch := ps.channel
+		ps.mu.Unlock()
+
+		deliveries, err := ch.ConsumeWithContext(ctx, ps.queue, consumerTag, true, true, false, false, nil)
+		if err != nil {
+			ps.logger.Error("Failed to consume RabbitMQ queue", zap.String("queue", ps.queue), zap.Error(err))
+		} else {
+			for d := range deliveries {
+				backoff = reconnectDelay
+				var md message.MessageDetails
+				if err := md.FromJSON(d.Body); err != nil {
+					ps.logger.Error("Failed to unmarshal message", zap.Error(err))
+					continue
+				}
+
+				if md.HubID != ps.hubID {
+					md.SenderID = ps.exchange
+					broadcastCh <- md
+				}
+			}
+		}
+
+		if ps.isStopped() || ctx.Err() != nil {
+			return
+		}
+
+		// RabbitMQ may keep accepting connections while it refuses or cancels the consumer, so the
+		// attempts back off, jittered, until a message is consumed again
+		delay := backoff/2 + rand.N(backoff/2)
+		backoff = min(2*backoff, maxConsumeBackoff)
+		ps.logger.Warn("RabbitMQ consumer closed, reconnecting", zap.String("queue", ps.queue), zap.Duration("delay", delay))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if !ps.reconnect() {
+			return
+		}
+	}
+}
+
+// Unsubscribe cancels the consumer on the hub's queue.
+func (ps *PubSub) Unsubscribe(ctx context.Context) error {
+	ps.mu.Lock()
+	ps.stopped = true
+	ch := ps.channel
+	ps.mu.Unlock()
+
+	if err := ch.Cancel(consumerTag, false); err != nil {
+		ps.logger.Error("Failed to cancel RabbitMQ consumer", zap.String("queue", ps.queue), zap.Error(err))
+		return fmt.Errorf("failed to cancel RabbitMQ consumer on queue: %s, error: %w", ps.queue, err)
+	}
+
+	ps.logger.Info("Unsubscribed from RabbitMQ queue", zap.String("queue", ps.queue))
+	return nil
+}
+
+// Publish publishes a message to the fanout exchange.
+func (ps *PubSub) Publish(ctx context.Context, md *message.MessageDetails) error {
+	data, err := md.ToJSON()
+	if err != nil {
+		ps.logger.Error("Failed to marshal message", zap.Error(err))
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	ps.mu.Lock()
+	ch := ps.channel
+	ps.mu.Unlock()
+
+	err = ch.PublishWithContext(ctx, ps.exchange, "", false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        data,
+	})
+	if err != nil {
+		ps.logger.Error("Failed to publish message to RabbitMQ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the RabbitMQ channel and connection.
+func (ps *PubSub) Close() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.stopped = true
+	if err := ps.conn.Close(); err != nil {
+		ps.logger.Error("Failed to close RabbitMQ connection", zap.String("exchange", ps.exchange), zap.Error(err))
+		return fmt.Errorf("failed to close RabbitMQ connection: %w", err)
+	}
+
+	ps.logger.Info("RabbitMQ connection closed successfully", zap.String("exchange", ps.exchange))
+	return nil
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrMissingToken is returned when authentication is required and the request carries no token.
+	ErrMissingToken = errors.New("missing access token")
+	// ErrInvalidToken is returned when the request carries a token that fails verification.
+	ErrInvalidToken = errors.New("invalid access token")
+)
+
+// Identity describes the authenticated user behind a connection. The zero value is an anonymous user.
+type Identity struct {
+	UserID string
+	Roles  []string
+	Claims jwt.MapClaims
+}
+
+// ServiceRolePrefix prefixes the roles granting an identity to serve the RPC service they name, such
+// as service:pricing.
+const ServiceRolePrefix = "service:"
+
+// IsAnonymous reports whether the identity carries no authenticated user.
+func (id Identity) IsAnonymous() bool {
+	return id.UserID == ""
+}
+
+// Serves reports whether the identity holds the role of the RPC service.
+func (id Identity) Serves(service string) bool {
+	return slices.Contains(id.Roles, ServiceRolePrefix+service)
+}
+
+// Authenticator verifies HS256 signed JWT bearer tokens, or HMAC signed connect URLs, presented
+// when a client connects.
+type Authenticator struct {
+	secret    []byte
+	urlSecret []byte
+	maxURLTTL time.Duration
+	required  bool
+
+	// certUser names the user of verified client certificates, which authenticate requests when set
+	certUser  func(*x509.Certificate) string
+	certRoles map[string][]string
+}
+
+// NewAuthenticator creates a new Authenticator. An empty secret disables token verification and an
+// empty urlSecret signed URLs, accepted until at most maxURLTTL ahead; without either every client
+// is treated as anonymous.
+func NewAuthenticator(secret, urlSecret string, maxURLTTL time.Duration, required bool) *Authenticator {
+	return &Authenticator{
+		secret:    []byte(secret),
+		urlSecret: []byte(urlSecret),
+		maxURLTTL: maxURLTTL,
+		required:  required,
+	}
+}
+
+// Authenticate verifies the request's client certificate, signed URL or token. Requests with none
+// are anonymous unless authentication is required.
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	if a.certUser != nil {
+		if cert := verifiedCertificate(r); cert != nil {
+			return a.certificateIdentity(cert)
+		}
+	}
+	if len(a.urlSecret) > 0 {
+		if query := r.URL.Query(); isSignedURL(query) {
+			return verifySignedURL(a.urlSecret, a.maxURLTTL, query, time.Now())
+		}
+	}
+	if len(a.secret) == 0 {
+		if a.required {
+			return Identity{}, ErrMissingToken
+		}
+		return Identity{}, nil
+	}
+
+	token := Token(r)
+	if token == "" {
+		if a.required {
+			return Identity{}, ErrMissingToken
+		}
+		return Identity{}, nil
+	}
+
+	return a.Verify(token)
+}
+
+// Verify parses and validates a signed token and returns the identity it carries. The subject
+// becomes the user id and the optional roles claim the user's roles.
+func (a *Authenticator) Verify(token string) (Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return a.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return Identity{}, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	var roles []string
+	if values, ok := claims["roles"].([]interface{}); ok {
+		for _, value := range values {
+			if role, ok := value.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	return Identity{
+		UserID: subject,
+		Roles:  roles,
+		Claims: claims,
+	}, nil
+}
+
+// Token returns the bearer token from the Authorization header or, for browsers that cannot set
+// headers on WebSocket requests, the access_token query parameter.
+func Token(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get("access_token")
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// ErrInvalidCertificate is returned when a verified client certificate does not name a user.
+var ErrInvalidCertificate = errors.New("invalid client certificate")
+
+// TrustClientCertificates makes requests presenting a client certificate verified by the TLS
+// listener authenticate as the user that user names from the certificate, with the organizational
+// units of its subject and the roles listed for the user as roles. Bearer tokens and signed URLs
+// are ignored on such requests.
+func (a *Authenticator) TrustClientCertificates(user func(*x509.Certificate) string, roles map[string][]string) {
+	a.certUser = user
+	a.certRoles = roles
+}
+
+// verifiedCertificate returns the leaf of the request's verified client certificate chain, or nil
+// when the request presented none. Chains are only verified on listeners requiring client certificates.
+func verifiedCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certificateIdentity returns the identity of a verified client certificate.
+func (a *Authenticator) certificateIdentity(cert *x509.Certificate) (Identity, error) {
+	user := a.certUser(cert)
+	if user == "" {
+		return Identity{}, ErrInvalidCertificate
+	}
+
+	roles := slices.Clone(cert.Subject.OrganizationalUnit)
+	for _, role := range a.certRoles[user] {
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return Identity{UserID: user, Roles: roles}, nil
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var urlSecret = []byte("url-signing-secret")
+
+// signedQuery returns the query of a URL with the parameters signed by SignURL to expire at expires.
+func signedQuery(t *testing.T, params string, expires time.Time) url.Values {
+	t.Helper()
+
+	signed, err := SignURL(urlSecret, "wss://hub.example.com/ws?"+params, expires)
+	if err != nil {
+		t.Fatalf("SignURL: %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("SignURL returned %s: %v", signed, err)
+	}
+	return u.Query()
+}
+
+func TestVerifySignedURLReturnsTheIdentityItNames(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		params string
+		want   Identity
+	}{
+		{"sub=alice&roles=admin,support", Identity{UserID: "alice", Roles: []string{"admin", "support"}}},
+		{"sub=alice&room=orders", Identity{UserID: "alice"}},
+		{"", Identity{}},
+	} {
+		identity, err := verifySignedURL(urlSecret, time.Hour, signedQuery(t, tc.params, now.Add(time.Minute)), now)
+		if err != nil {
+			t.Fatalf("verifySignedURL(%s): %v", tc.params, err)
+		}
+		if !reflect.DeepEqual(identity, tc.want) {
+			t.Fatalf("URL with %q names %+v, want %+v", tc.params, identity, tc.want)
+		}
+	}
+}
+
+func TestVerifySignedURLRejects(t *testing.T) {
+	now := time.Now()
+	valid := func() url.Values { return signedQuery(t, "sub=alice&roles=support", now.Add(time.Minute)) }
+	for _, tc := range []struct {
+		name   string
+		query  func() url.Values
+		maxTTL time.Duration
+		err    string
+	}{
+		{"tampered subject", func() url.Values { q := valid(); q.Set(ParamSubject, "mallory"); return q }, time.Hour, "does not match"},
+		{"tampered roles", func() url.Values { q := valid(); q.Set(ParamRoles, "admin"); return q }, time.Hour, "does not match"},
+		{"added parameter", func() url.Values { q := valid(); q.Set("room", "admin"); return q }, time.Hour, "does not match"},
+		{"removed parameter", func() url.Values { q := valid(); q.Del(ParamRoles); return q }, time.Hour, "does not match"},
+		{"extended expiry", func() url.Values {
+			q := valid()
+			q.Set(ParamExpires, strconv.FormatInt(now.Add(30*time.Minute).Unix(), 10))
+			return q
+		}, time.Hour, "does not match"},
+		{"other secret", func() url.Values {
+			q := valid()
+			q.Set(ParamSignature, SignQuery([]byte("other-secret"), q))
+			return q
+		}, time.Hour, "does not match"},
+		{"missing signature", func() url.Values { q := valid(); q.Del(ParamSignature); return q }, time.Hour, "does not match"},
+		{"malformed signature", func() url.Values { q := valid(); q.Set(ParamSignature, "not-hex"); return q }, time.Hour, "malformed url signature"},
+		{"truncated signature", func() url.Values {
+			q := valid()
+			q.Set(ParamSignature, q.Get(ParamSignature)[:32])
+			return q
+		}, time.Hour, "does not match"},
+		{"missing expiry", func() url.Values {
+			q := url.Values{ParamSubject: {"alice"}}
+			q.Set(ParamSignature, SignQuery(urlSecret, q))
+			return q
+		}, time.Hour, "missing url expiry"},
+		{"malformed expiry", func() url.Values {
+			q := url.Values{ParamSubject: {"alice"}, ParamExpires: {"tomorrow"}}
+			q.Set(ParamSignature, SignQuery(urlSecret, q))
+			return q
+		}, time.Hour, "missing url expiry"},
+		{"expired", func() url.Values { return signedQuery(t, "sub=alice", now.Add(-time.Second)) }, time.Hour, "url expired"},
+		{"expiring now", func() url.Values { return signedQuery(t, "sub=alice", now.Truncate(time.Second)) }, time.Hour, "url expired"},
+		{"beyond the max TTL", func() url.Values { return signedQuery(t, "sub=alice", now.Add(2*time.Hour)) }, time.Hour, "more than 1h0m0s ahead"},
+	} {
+		_, err := verifySignedURL(urlSecret, tc.maxTTL, tc.query(), now)
+		if !errors.Is(err, ErrInvalidToken) || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: verifySignedURL = %v, want an invalid token error containing %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestVerifySignedURLWithoutMaxTTLAcceptsDistantExpiries(t *testing.T) {
+	now := time.Now()
+	if _, err := verifySignedURL(urlSecret, 0, signedQuery(t, "sub=alice", now.Add(365*24*time.Hour)), now); err != nil {
+		t.Fatalf("verifySignedURL: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package chaos
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Faults configures the failures injected by an Injector. A zero value disables the fault.
+type Faults struct {
+	// PublishDelay is the maximum random delay added to broker publishes.
+	PublishDelay time.Duration
+	// PublishDropRate is the fraction of broker publishes silently dropped.
+	PublishDropRate float64
+	// WriteStall is how long a stalled write pump blocks before writing a message, and
+	// WriteStallRate the fraction of messages whose write stalls.
+	WriteStall     time.Duration
+	WriteStallRate float64
+	// DisconnectRate is the fraction of connections closed without a close frame every DisconnectInterval.
+	DisconnectRate     float64
+	DisconnectInterval time.Duration
+}
+
+// Enabled reports whether any fault is configured.
+func (f Faults) Enabled() bool {
+	return f.PublishDelay > 0 || f.PublishDropRate > 0 || (f.WriteStall > 0 && f.WriteStallRate > 0) || f.DisconnectRate > 0
+}
+
+// Injector injects the configured faults into the hub, for resilience testing in staging. A nil
+// Injector injects nothing.
+type Injector struct {
+	faults Faults
+	logger *zap.Logger
+}
+
+// NewInjector creates a new Injector for the faults.
+func NewInjector(faults Faults, logger *zap.Logger) *Injector {
+	return &Injector{faults: faults, logger: logger}
+}
+
+// Publish delays a broker publish and reports whether it should go ahead.
+func (i *Injector) Publish(ctx context.Context) bool {
+	if i == nil {
+		return true
+	}
+
+	if i.faults.PublishDelay > 0 {
+		metrics.FaultsInjected.WithLabelValues("publish_delay").Inc()
+		select {
+		case <-ctx.Done():
+		case <-time.After(rand.N(i.faults.PublishDelay)):
+		}
+	}
+
+	if hit(i.faults.PublishDropRate) {
+		metrics.FaultsInjected.WithLabelValues("publish_drop").Inc()
+		i.logger.Debug("Dropping broker publish")
+		return false
+	}
+	return true
+}
+
+// StallWrite blocks a connection's write pump before it writes a message.
+func (i *Injector) StallWrite() {
+	if i == nil || i.faults.WriteStall <= 0 || !hit(i.faults.WriteStallRate) {
+		return
+	}
+
+	metrics.FaultsInjected.WithLabelValues("write_stall").Inc()
+	time.Sleep(i.faults.WriteStall)
+}
+
+// DisconnectInterval returns how often connections are picked for disconnection, or 0 when
+// connections are never disconnected.
+func (i *Injector) DisconnectInterval() time.Duration {
+	if i == nil || i.faults.DisconnectRate <= 0 {
+		return 0
+	}
+	return i.faults.DisconnectInterval
+}
+
+// Disconnect reports whether a connection should be closed this interval.
+func (i *Injector) Disconnect() bool {
+	if i == nil || !hit(i.faults.DisconnectRate) {
+		return false
+	}
+
+	metrics.FaultsInjected.WithLabelValues("disconnect").Inc()
+	return true
+}
+
+// hit reports whether an event with the given probability happens.
+func hit(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
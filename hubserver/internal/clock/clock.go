@@ -0,0 +1,27 @@
+// Package clock provides the time sources hubs stamp messages with: a wall clock that tests can
+// replace, and a hybrid logical clock ordering messages across hubs whose clocks disagree.
+package clock
+
+import "time"
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the clock of the machine the hub runs on.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Func adapts a function to a Clock, such as a fake clock in tests.
+type Func func() time.Time
+
+// Now calls f.
+func (f Func) Now() time.Time {
+	return f()
+}
@@ -0,0 +1,124 @@
+package clock
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLogical is the largest logical counter of a timestamp; the clock moves its wall time a
+// millisecond ahead rather than overflow it.
+const maxLogical = 99999
+
+var errBadTimestamp = errors.New("hybrid logical timestamp must be <ms>-<logical>")
+
+// Timestamp is a hybrid logical clock reading: the largest wall time in milliseconds the clock has
+// seen, and a counter ordering the events within that millisecond.
+type Timestamp struct {
+	Wall    int64
+	Logical uint32
+}
+
+// String formats the timestamp as a fixed-width <ms>-<logical>, so timestamps order as strings.
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%013d-%05d", t.Wall, t.Logical)
+}
+
+// ParseTimestamp parses a timestamp formatted by String.
+func ParseTimestamp(s string) (Timestamp, error) {
+	wall, logical, ok := strings.Cut(s, "-")
+	if !ok {
+		return Timestamp{}, errBadTimestamp
+	}
+	w, err := strconv.ParseInt(wall, 10, 64)
+	if err != nil {
+		return Timestamp{}, errBadTimestamp
+	}
+	l, err := strconv.ParseUint(logical, 10, 32)
+	if err != nil || l > maxLogical {
+		return Timestamp{}, errBadTimestamp
+	}
+	return Timestamp{Wall: w, Logical: uint32(l)}, nil
+}
+
+// Compare returns -1, 0 or 1 as t orders before, with or after u.
+func (t Timestamp) Compare(u Timestamp) int {
+	switch {
+	case t.Wall < u.Wall:
+		return -1
+	case t.Wall > u.Wall:
+		return 1
+	case t.Logical < u.Logical:
+		return -1
+	case t.Logical > u.Logical:
+		return 1
+	}
+	return 0
+}
+
+// HLC is a hybrid logical clock. Its timestamps stay close to the wall clock, yet a hub that
+// received a message always stamps later messages after it, even when the publishing hub's clock
+// runs ahead of its own.
+type HLC struct {
+	clock Clock
+	// maxDrift bounds how far ahead of the wall clock a received timestamp may pull the clock;
+	// timestamps further ahead come from a broken clock and are ignored
+	maxDrift time.Duration
+
+	mu   sync.Mutex
+	last Timestamp
+}
+
+// NewHLC creates a new HLC reading wall time from the clock.
+func NewHLC(clock Clock, maxDrift time.Duration) *HLC {
+	return &HLC{clock: clock, maxDrift: maxDrift}
+}
+
+// Now returns a timestamp after every timestamp the clock returned or received.
+func (c *HLC) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.clock.Now().UnixMilli()
+	if wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.tick()
+	}
+	return c.last
+}
+
+// Update advances the clock past a timestamp received from another hub and reports whether it was
+// within the allowed drift.
+func (c *HLC) Update(remote Timestamp) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.clock.Now().UnixMilli()
+	if remote.Wall-wall > c.maxDrift.Milliseconds() {
+		return false
+	}
+
+	switch {
+	case wall > c.last.Wall && wall > remote.Wall:
+		c.last = Timestamp{Wall: wall}
+	case remote.Compare(c.last) > 0:
+		c.last = remote
+		c.tick()
+	default:
+		c.tick()
+	}
+	return true
+}
+
+// tick moves the clock to the next logical time. c.mu must be held.
+func (c *HLC) tick() {
+	if c.last.Logical == maxLogical {
+		c.last = Timestamp{Wall: c.last.Wall + 1}
+		return
+	}
+	c.last.Logical++
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Room actions controlled by room access control lists.
+const (
+	RoomPublish   = "publish"
+	RoomSubscribe = "subscribe"
+)
+
+// AnyRole in a room access control list grants the action to every client, including anonymous ones.
+const AnyRole = "*"
+
+// RoomACL lists the roles allowed to publish to and to subscribe to a room. A nil list leaves the
+// action open to every client.
+type RoomACL struct {
+	Publish   []string
+	Subscribe []string
+}
+
+// Allows reports whether a client with the roles may perform the action on the room.
+func (a RoomACL) Allows(action string, roles []string) bool {
+	allowed := a.Publish
+	if action == RoomSubscribe {
+		allowed = a.Subscribe
+	}
+	if allowed == nil || slices.Contains(allowed, AnyRole) {
+		return true
+	}
+
+	for _, role := range roles {
+		if slices.Contains(allowed, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// MTLSUserRoles parses the mtls-roles settings, each of the form user=role[|role], into the roles
+// of every listed user authenticated with a client certificate.
+func (c *Config) MTLSUserRoles() (map[string][]string, error) {
+	roles := make(map[string][]string, len(c.MTLSRoles))
+	for _, spec := range c.MTLSRoles {
+		user, list, ok := strings.Cut(spec, "=")
+		userRoles := ParseRoles(list)
+		if !ok || user == "" || len(userRoles) == 0 {
+			return nil, fmt.Errorf("mtls-roles entry must be user=role[|role], got %q", spec)
+		}
+		roles[user] = append(roles[user], userRoles...)
+	}
+	return roles, nil
+}
+
+// ParseRoles splits a |-separated list of roles.
+func ParseRoles(list string) []string {
+	roles := strings.Split(list, "|")
+	return slices.DeleteFunc(roles, func(role string) bool { return role == "" })
+}
+
+// RoomAccess parses the room-acls settings, each of the form room:action=role[|role] where action
+// is publish or subscribe, into the access control list of every listed room.
+func (c *Config) RoomAccess() (map[string]RoomACL, error) {
+	acls := make(map[string]RoomACL, len(c.RoomACLs))
+	for _, spec := range c.RoomACLs {
+		target, list, ok := strings.Cut(spec, "=")
+		room, action, _ := strings.Cut(target, ":")
+		roles := ParseRoles(list)
+		if !ok || room == "" || len(roles) == 0 {
+			return nil, fmt.Errorf("room-acls entry must be room:action=role[|role], got %q", spec)
+		}
+
+		acl := acls[room]
+		switch action {
+		case RoomPublish:
+			acl.Publish = append(acl.Publish, roles...)
+		case RoomSubscribe:
+			acl.Subscribe = append(acl.Subscribe, roles...)
+		default:
+			return nil, fmt.Errorf("room-acls action for room %s must be %s or %s, got %q", room, RoomPublish, RoomSubscribe, action)
+		}
+		acls[room] = acl
+	}
+	return acls, nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Built-in reducers combining the messages of an aggregation room.
+const (
+	ReducerCount  = "count"
+	ReducerConcat = "concat"
+)
+
+// Aggregation combines the messages published to a room over each window with a reducer, delivering
+// subscribers a single message per window.
+type Aggregation struct {
+	Window  time.Duration
+	Reducer string
+}
+
+// AggregateRooms parses the aggregate-rooms settings, each of the form room=window:reducer, into the
+// aggregation of every listed room.
+func (c *Config) AggregateRooms() (map[string]Aggregation, error) {
+	aggregations := make(map[string]Aggregation, len(c.AggregateRoom))
+	for _, spec := range c.AggregateRoom {
+		room, rules, ok := strings.Cut(spec, "=")
+		window, reducer, hasReducer := strings.Cut(rules, ":")
+		if !ok || room == "" || !hasReducer {
+			return nil, fmt.Errorf("aggregate-rooms entry must be room=window:reducer, got %q", spec)
+		}
+
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("aggregate-rooms window for room %s must be a positive duration, got %q", room, window)
+		}
+		if reducer != ReducerCount && reducer != ReducerConcat {
+			return nil, fmt.Errorf("aggregate-rooms reducer for room %s must be %q or %q, got %q", room, ReducerCount, ReducerConcat, reducer)
+		}
+
+		aggregations[room] = Aggregation{Window: d, Reducer: reducer}
+	}
+	return aggregations, nil
+}
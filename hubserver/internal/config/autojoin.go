@@ -0,0 +1,99 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Placeholder sources of auto-join room templates.
+const (
+	PlaceholderLanguage = "language"
+	PlaceholderLocale   = "locale"
+	PlaceholderClaim    = "claim"
+	PlaceholderHeader   = "header"
+)
+
+// RoomTemplate is the name of a room joined automatically on connect, with placeholders filled in
+// from the connection's token claims, upgrade request headers or preferred language.
+type RoomTemplate struct {
+	parts []templatePart
+}
+
+// templatePart is either literal text or, when source is set, a placeholder.
+type templatePart struct {
+	text   string
+	source string
+}
+
+// Expand fills in the template's placeholders with the values returned by lookup for their source
+// and name. It reports false when a placeholder has no value, so the room is not joined.
+func (t RoomTemplate) Expand(lookup func(source, name string) string) (string, bool) {
+	var room strings.Builder
+	for _, part := range t.parts {
+		if part.source == "" {
+			room.WriteString(part.text)
+			continue
+		}
+		value := lookup(part.source, part.text)
+		if value == "" {
+			return "", false
+		}
+		room.WriteString(value)
+	}
+	return room.String(), true
+}
+
+// AutoJoinRooms parses the auto-join-rooms settings into room templates. A template is a room name
+// with placeholders in braces: {language}, {locale}, {claim:name} or {header:name}.
+func (c *Config) AutoJoinRooms() ([]RoomTemplate, error) {
+	templates := make([]RoomTemplate, 0, len(c.AutoJoinRoom))
+	for _, spec := range c.AutoJoinRoom {
+		template, err := parseRoomTemplate(spec)
+		if err != nil {
+			return nil, fmt.Errorf("auto-join-rooms entry %q: %w", spec, err)
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+func parseRoomTemplate(spec string) (RoomTemplate, error) {
+	if spec == "" {
+		return RoomTemplate{}, errors.New("room template must not be empty")
+	}
+
+	var template RoomTemplate
+	for rest := spec; rest != ""; {
+		text, placeholder, found := strings.Cut(rest, "{")
+		if strings.Contains(text, "}") {
+			return RoomTemplate{}, errors.New("unexpected } in room template")
+		}
+		if text != "" {
+			template.parts = append(template.parts, templatePart{text: text})
+		}
+		if !found {
+			break
+		}
+
+		placeholder, rest, found = strings.Cut(placeholder, "}")
+		if !found {
+			return RoomTemplate{}, errors.New("unterminated placeholder in room template")
+		}
+		source, name, _ := strings.Cut(placeholder, ":")
+		switch source {
+		case PlaceholderLanguage, PlaceholderLocale:
+			if name != "" {
+				return RoomTemplate{}, fmt.Errorf("placeholder {%s} takes no name", source)
+			}
+		case PlaceholderClaim, PlaceholderHeader:
+			if name == "" {
+				return RoomTemplate{}, fmt.Errorf("placeholder {%s:name} needs a name", source)
+			}
+		default:
+			return RoomTemplate{}, fmt.Errorf("unknown placeholder {%s}, must be {language}, {locale}, {claim:name} or {header:name}", placeholder)
+		}
+		template.parts = append(template.parts, templatePart{text: name, source: source})
+	}
+	return template, nil
+}
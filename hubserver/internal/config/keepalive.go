@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultKeepaliveClass is the keepalive class of connections that did not choose one, pinged
+// every ping-interval and closed after max-missed-pongs unless a keepalive class overrides it.
+const DefaultKeepaliveClass = "default"
+
+// KeepaliveClass sets how often the connections of a class are pinged and how many pings in a row
+// they may leave unanswered before they are considered half-open.
+type KeepaliveClass struct {
+	PingInterval   time.Duration
+	MaxMissedPongs int
+}
+
+// String formats the class as ping-interval:max-missed-pongs, as it is written in keepalive-classes.
+func (k KeepaliveClass) String() string {
+	return fmt.Sprintf("%s:%d", k.PingInterval, k.MaxMissedPongs)
+}
+
+// KeepaliveClasses parses the keepalive-classes settings into the keepalive of every class,
+// including the default class of ping-interval and max-missed-pongs.
+func (c *Config) KeepaliveClasses() (map[string]KeepaliveClass, error) {
+	return ParseKeepaliveClasses(c.KeepaliveClass, KeepaliveClass{PingInterval: c.PingInterval, MaxMissedPongs: c.MaxMissedPongs})
+}
+
+// ParseKeepaliveClasses parses keepalive class specs, each of the form
+// class=ping-interval[:max-missed-pongs], into the keepalive of every class. Classes without
+// max-missed-pongs take the default's, and the default class is included unless a spec overrides it.
+func ParseKeepaliveClasses(specs []string, defaults KeepaliveClass) (map[string]KeepaliveClass, error) {
+	classes := map[string]KeepaliveClass{DefaultKeepaliveClass: defaults}
+	for _, spec := range specs {
+		name, settings, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || settings == "" {
+			return nil, fmt.Errorf("keepalive-classes entry must be class=ping-interval[:max-missed-pongs], got %q", spec)
+		}
+
+		interval, missed, hasMissed := strings.Cut(settings, ":")
+		class := KeepaliveClass{MaxMissedPongs: defaults.MaxMissedPongs}
+		var err error
+		if class.PingInterval, err = time.ParseDuration(interval); err != nil || class.PingInterval <= 0 {
+			return nil, fmt.Errorf("keepalive-classes ping interval of class %s must be a positive duration, got %q", name, interval)
+		}
+		if hasMissed {
+			if class.MaxMissedPongs, err = strconv.Atoi(missed); err != nil || class.MaxMissedPongs < 1 {
+				return nil, fmt.Errorf("keepalive-classes max missed pongs of class %s must be at least 1, got %q", name, missed)
+			}
+		}
+
+		classes[name] = class
+	}
+	return classes, nil
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OutboxRooms parses the outbox-tables settings, each of the form table or table=room, into the
+// room the rows inserted into every listed table are published to. Tables listed without a room
+// map to the empty room: their rows name the room they are published to in their room column.
+func (c *Config) OutboxRooms() (map[string]string, error) {
+	rooms := make(map[string]string, len(c.OutboxTable))
+	for _, spec := range c.OutboxTable {
+		table, room, _ := strings.Cut(spec, "=")
+		if table == "" {
+			return nil, fmt.Errorf("outbox-tables entry must be table[=room], got %q", spec)
+		}
+		if _, ok := rooms[table]; ok {
+			return nil, fmt.Errorf("outbox-tables table %s is listed twice", table)
+		}
+		rooms[table] = room
+	}
+	return rooms, nil
+}
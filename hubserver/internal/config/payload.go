@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// PayloadPolicy restricts the payloads published to a room. Zero limits are unlimited.
+type PayloadPolicy struct {
+	// ContentType is the media type publishers must declare; JSON media types additionally
+	// require the payload to be valid JSON nested at most MaxDepth levels deep, with no string
+	// longer than MaxString bytes
+	ContentType string
+	MaxSize     int
+	MaxDepth    int
+	MaxString   int
+}
+
+// RoomPayloadPolicies parses the room-payload-policies settings, each of the form
+// room=content-type[:max-size[:max-depth[:max-string]]], into the payload policy of every listed room. The room
+// * applies to messages published to rooms without a policy of their own and to every connection.
+func (c *Config) RoomPayloadPolicies() (map[string]PayloadPolicy, error) {
+	policies := make(map[string]PayloadPolicy, len(c.RoomPayloadPolicy))
+	for _, spec := range c.RoomPayloadPolicy {
+		room, rules, ok := strings.Cut(spec, "=")
+		if !ok || room == "" || rules == "" {
+			return nil, fmt.Errorf("room-payload-policies entry must be room=content-type[:max-size[:max-depth[:max-string]]], got %q", spec)
+		}
+
+		parts := strings.Split(rules, ":")
+		if len(parts) > 4 {
+			return nil, fmt.Errorf("room-payload-policies entry for room %s has too many fields, got %q", room, rules)
+		}
+		contentType, _, err := mime.ParseMediaType(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("room-payload-policies content type for room %s must be a media type, got %q", room, parts[0])
+		}
+
+		policy := PayloadPolicy{ContentType: contentType}
+		for i, limit := range []*int{&policy.MaxSize, &policy.MaxDepth, &policy.MaxString} {
+			if len(parts) <= i+1 || parts[i+1] == "" {
+				continue
+			}
+			n, err := strconv.Atoi(parts[i+1])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("room-payload-policies limits for room %s must be positive numbers, got %q", room, parts[i+1])
+			}
+			*limit = n
+		}
+
+		policies[room] = policy
+	}
+	return policies, nil
+}
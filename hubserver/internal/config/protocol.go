@@ -0,0 +1,22 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProtocolCutoffTime parses the protocol-cutoff setting, an RFC 3339 time or a date at midnight
+// UTC, returning the zero time when it is unset.
+func (c *Config) ProtocolCutoffTime() (time.Time, error) {
+	if c.ProtocolCutoff == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, c.ProtocolCutoff); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, c.ProtocolCutoff)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("protocol-cutoff must be an RFC 3339 time or a date, got %q", c.ProtocolCutoff)
+	}
+	return t, nil
+}
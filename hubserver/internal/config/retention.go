@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy bounds the history kept for a room by message count and by age. Zero values
+// leave the corresponding bound unset.
+type RetentionPolicy struct {
+	MaxCount int64
+	MaxAge   time.Duration
+}
+
+// RoomRetention parses the room-history settings, each of the form room=count[:age] or room=:age,
+// into the retention policy of every room with history enabled.
+func (c *Config) RoomRetention() (map[string]RetentionPolicy, error) {
+	policies := make(map[string]RetentionPolicy, len(c.RoomHistory))
+	for _, spec := range c.RoomHistory {
+		room, bounds, ok := strings.Cut(spec, "=")
+		if !ok || room == "" || bounds == "" {
+			return nil, fmt.Errorf("room-history entry must be room=count[:age], got %q", spec)
+		}
+
+		var policy RetentionPolicy
+		count, age, _ := strings.Cut(bounds, ":")
+		if count != "" {
+			n, err := strconv.ParseInt(count, 10, 64)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("room-history count for room %s must be a positive number, got %q", room, count)
+			}
+			policy.MaxCount = n
+		}
+		if age != "" {
+			d, err := time.ParseDuration(age)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("room-history age for room %s must be a positive duration, got %q", room, age)
+			}
+			policy.MaxAge = d
+		}
+		if policy == (RetentionPolicy{}) {
+			return nil, fmt.Errorf("room-history entry for room %s needs a count or an age", room)
+		}
+
+		policies[room] = policy
+	}
+	return policies, nil
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RoutingRule routes the messages published with a matching routing key to a room. Keys ending in
+// * match every routing key with the prefix before it.
+type RoutingRule struct {
+	Key  string
+	Room string
+}
+
+// String returns the rule as a key=room spec.
+func (r RoutingRule) String() string {
+	return r.Key + "=" + r.Room
+}
+
+// RoutingRules parses the routing-rules settings into the rules routing keys are resolved with.
+func (c *Config) RoutingRules() ([]RoutingRule, error) {
+	return ParseRoutingRules(c.RoutingRule)
+}
+
+// ParseRoutingRules parses routing rule specs, each of the form key=room where key may end in *,
+// into routing rules. A routing key may be listed once.
+func ParseRoutingRules(specs []string) ([]RoutingRule, error) {
+	rules := make([]RoutingRule, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		key, room, ok := strings.Cut(spec, "=")
+		if !ok || key == "" || room == "" {
+			return nil, fmt.Errorf("routing-rules entry must be key=room, got %q", spec)
+		}
+		if strings.Contains(strings.TrimSuffix(key, "*"), "*") {
+			return nil, fmt.Errorf("routing-rules key may only end in *, got %q", key)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("routing-rules key %s is listed twice", key)
+		}
+		seen[key] = true
+
+		rules = append(rules, RoutingRule{Key: key, Room: room})
+	}
+	return rules, nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Issuers of the sequence numbers of sequenced rooms.
+const (
+	// SequenceRedis issues sequence numbers with a Redis counter shared by every hub, ordering the
+	// room's messages across hubs
+	SequenceRedis = "redis"
+	// SequenceLocal issues sequence numbers with a counter of the hub, for rooms whose publishers and
+	// subscribers share a hub
+	SequenceLocal = "local"
+)
+
+// SequencedRooms parses the sequenced-rooms settings, each of the form room or room=issuer, into
+// the issuer of the sequence numbers of every listed room, redis by default. The room * sequences
+// the messages of every room without an entry of its own.
+func (c *Config) SequencedRooms() (map[string]string, error) {
+	issuers := make(map[string]string, len(c.SequencedRoom))
+	for _, spec := range c.SequencedRoom {
+		room, issuer, ok := strings.Cut(spec, "=")
+		if !ok {
+			issuer = SequenceRedis
+		}
+		if room == "" {
+			return nil, fmt.Errorf("sequenced-rooms entry must be room[=redis|local], got %q", spec)
+		}
+		if issuer != SequenceRedis && issuer != SequenceLocal {
+			return nil, fmt.Errorf("sequenced-rooms issuer for room %s must be redis or local, got %q", room, issuer)
+		}
+		if _, ok := issuers[room]; ok {
+			return nil, fmt.Errorf("sequenced-rooms room %s is listed twice", room)
+		}
+		issuers[room] = issuer
+	}
+	return issuers, nil
+}
+
+// sequencesInRedis reports whether any sequenced room has its sequence numbers issued by Redis.
+func (c *Config) sequencesInRedis() bool {
+	issuers, _ := c.SequencedRooms()
+	for _, issuer := range issuers {
+		if issuer == SequenceRedis {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// UpgradeHeaders parses the upgrade-header settings into the headers added to upgrade responses,
+// nil when there are none.
+func (c *Config) UpgradeHeaders() (http.Header, error) {
+	if len(c.UpgradeHeader) == 0 {
+		return nil, nil
+	}
+
+	header := make(http.Header, len(c.UpgradeHeader))
+	for _, spec := range c.UpgradeHeader {
+		name, value, ok := strings.Cut(spec, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !validHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("upgrade-header entry must be \"Name: value\", got %q", spec)
+		}
+		if HandshakeHeader(name) {
+			return nil, fmt.Errorf("upgrade-header entry %q sets a header of the WebSocket handshake", spec)
+		}
+		header.Add(name, value)
+	}
+	return header, nil
+}
+
+// HandshakeHeader reports whether the named header belongs to the WebSocket handshake, which the
+// hub writes itself on upgrade responses.
+func HandshakeHeader(name string) bool {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	return name == "Upgrade" || name == "Connection" || strings.HasPrefix(name, "Sec-Websocket-")
+}
+
+// validHeaderName reports whether name is a non-empty HTTP token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
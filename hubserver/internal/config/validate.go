@@ -0,0 +1,489 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validate checks the configuration and reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.HubName == "" {
+		errs = append(errs, fmt.Errorf("hub-name is required (set --hub-name, %s or hub-name in the config file)", envName("hub-name")))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be a number between 1 and 65535, got %q", c.Port))
+	}
+	if c.AdminAddr != "" {
+		if _, port, err := net.SplitHostPort(c.AdminAddr); err != nil {
+			errs = append(errs, fmt.Errorf("admin-addr must be host:port, got %q", c.AdminAddr))
+		} else if port == c.Port {
+			errs = append(errs, fmt.Errorf("admin-addr must not use the public port %s", c.Port))
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls-cert-file and tls-key-file must be set together"))
+	}
+	if c.MTLSAddr != "" {
+		if _, port, err := net.SplitHostPort(c.MTLSAddr); err != nil {
+			errs = append(errs, fmt.Errorf("mtls-addr must be host:port, got %q", c.MTLSAddr))
+		} else if port == c.Port {
+			errs = append(errs, fmt.Errorf("mtls-addr must not use the public port %s", c.Port))
+		}
+		if c.TLSCertFile == "" {
+			errs = append(errs, errors.New("mtls-addr needs tls-cert-file and tls-key-file"))
+		}
+		if c.MTLSClientCAFile == "" {
+			errs = append(errs, errors.New("mtls-addr needs mtls-client-ca-file"))
+		}
+	}
+	if c.WebTransportAddr != "" {
+		if _, _, err := net.SplitHostPort(c.WebTransportAddr); err != nil {
+			errs = append(errs, fmt.Errorf("webtransport-addr must be host:port, got %q", c.WebTransportAddr))
+		}
+		if c.TLSCertFile == "" {
+			errs = append(errs, errors.New("webtransport-addr needs tls-cert-file and tls-key-file"))
+		}
+	}
+	switch c.MTLSUserField {
+	case MTLSUserCN, MTLSUserDNS, MTLSUserURI, MTLSUserEmail:
+	default:
+		errs = append(errs, fmt.Errorf("mtls-user-field must be %s, %s, %s or %s, got %q",
+			MTLSUserCN, MTLSUserDNS, MTLSUserURI, MTLSUserEmail, c.MTLSUserField))
+	}
+	if _, err := c.MTLSUserRoles(); err != nil {
+		errs = append(errs, err)
+	}
+	switch c.Broker {
+	case BrokerRedis, BrokerAMQP, BrokerNone:
+	case BrokerMesh:
+		if len(c.MeshPeers) == 0 && c.MeshDNSName == "" {
+			errs = append(errs, errors.New("broker mesh needs mesh-peers or mesh-dns-name to discover the other hubs"))
+		}
+		if c.MeshSecret == "" {
+			errs = append(errs, errors.New("broker mesh needs mesh-secret, as the /mesh endpoint is served on the public port"))
+		}
+		if c.MeshRefresh <= 0 {
+			errs = append(errs, fmt.Errorf("mesh-refresh must be positive, got %s", c.MeshRefresh))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("broker must be %q, %q, %q or %q, got %q", BrokerRedis, BrokerAMQP, BrokerMesh, BrokerNone, c.Broker))
+	}
+	if c.BroadcastWorkers < 1 {
+		errs = append(errs, fmt.Errorf("broadcast-workers must be at least 1, got %d", c.BroadcastWorkers))
+	}
+	if c.BroadcastBatchSize < 1 {
+		errs = append(errs, fmt.Errorf("broadcast-batch-size must be at least 1, got %d", c.BroadcastBatchSize))
+	}
+	switch c.RedisCompression {
+	case "", "snappy", "zstd":
+	default:
+		errs = append(errs, fmt.Errorf("redis-compression must be snappy or zstd, got %q", c.RedisCompression))
+	}
+	if c.RedisCompressionThreshold < 0 {
+		errs = append(errs, fmt.Errorf("redis-compression-threshold must not be negative, got %d", c.RedisCompressionThreshold))
+	}
+	if c.RedisCredentialsRefresh <= 0 {
+		errs = append(errs, fmt.Errorf("redis-credentials-refresh must be positive, got %s", c.RedisCredentialsRefresh))
+	}
+	if c.RedisShards < 1 {
+		errs = append(errs, fmt.Errorf("redis-shards must be at least 1, got %d", c.RedisShards))
+	}
+	if c.UpgradeRate < 0 {
+		errs = append(errs, fmt.Errorf("upgrade-rate must not be negative, got %g", c.UpgradeRate))
+	}
+	if c.UpgradeRate > 0 && c.UpgradeBurst < 1 {
+		errs = append(errs, fmt.Errorf("upgrade-burst must be at least 1, got %d", c.UpgradeBurst))
+	}
+	if _, err := c.UpgradeHeaders(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.UpgradeRatePerIP < 0 {
+		errs = append(errs, fmt.Errorf("upgrade-rate-per-ip must not be negative, got %g", c.UpgradeRatePerIP))
+	}
+	if c.UpgradeRatePerIP > 0 && c.UpgradeBurstPerIP < 1 {
+		errs = append(errs, fmt.Errorf("upgrade-burst-per-ip must be at least 1, got %d", c.UpgradeBurstPerIP))
+	}
+	if c.MaxConnectionsPerIP < 0 {
+		errs = append(errs, fmt.Errorf("max-connections-per-ip must not be negative, got %d", c.MaxConnectionsPerIP))
+	}
+	if c.MaxRoomsPerConnection < 0 {
+		errs = append(errs, fmt.Errorf("max-rooms-per-connection must not be negative, got %d", c.MaxRoomsPerConnection))
+	}
+	if c.MaxRoomsPerUser < 0 {
+		errs = append(errs, fmt.Errorf("max-rooms-per-user must not be negative, got %d", c.MaxRoomsPerUser))
+	}
+	if c.ChunkSize < 0 {
+		errs = append(errs, fmt.Errorf("chunk-size must not be negative, got %d", c.ChunkSize))
+	}
+	if c.MaxChunkedMessageSize < 0 {
+		errs = append(errs, fmt.Errorf("max-chunked-message-size must not be negative, got %d", c.MaxChunkedMessageSize))
+	}
+	if c.WriteTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("write-timeout must be positive, got %s", c.WriteTimeout))
+	}
+	if c.WriteRetries < 0 {
+		errs = append(errs, fmt.Errorf("write-retries must not be negative, got %d", c.WriteRetries))
+	}
+	if c.PingInterval <= 0 {
+		errs = append(errs, fmt.Errorf("ping-interval must be positive, got %s", c.PingInterval))
+	}
+	if c.MaxMissedPongs < 1 {
+		errs = append(errs, fmt.Errorf("max-missed-pongs must be at least 1, got %d", c.MaxMissedPongs))
+	}
+	if c.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("idle-timeout must not be negative, got %s", c.IdleTimeout))
+	}
+	if c.ReconnectRetryAfter < 0 {
+		errs = append(errs, fmt.Errorf("reconnect-retry-after must not be negative, got %s", c.ReconnectRetryAfter))
+	}
+	if c.StatsInterval < 0 {
+		errs = append(errs, fmt.Errorf("stats-interval must not be negative, got %s", c.StatsInterval))
+	}
+	if c.StatsInterval > 0 && c.StatsKeyPrefix == "" {
+		errs = append(errs, errors.New("stats-key-prefix is required when stats-interval is set"))
+	}
+	if c.ScheduleInterval < 0 {
+		errs = append(errs, fmt.Errorf("schedule-interval must not be negative, got %s", c.ScheduleInterval))
+	}
+	if c.ScheduleInterval > 0 && c.ScheduleKey == "" {
+		errs = append(errs, errors.New("schedule-key is required when schedule-interval is set"))
+	}
+	if _, err := c.KeepaliveClasses(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.RoomPayloadPolicies(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.RoomRetention(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.AggregateRooms(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.SequencedRooms(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.RoutingRules(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.DrainHandoffTTL < 0 {
+		errs = append(errs, fmt.Errorf("drain-handoff-ttl must not be negative, got %s", c.DrainHandoffTTL))
+	}
+	if _, err := c.AutoJoinRooms(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, enricher := range c.Enrichers {
+		switch enricher {
+		case EnricherHub, EnricherGeo, EnricherDisplayName:
+		default:
+			errs = append(errs, fmt.Errorf("enrichers must be %q, %q or %q, got %q", EnricherHub, EnricherGeo, EnricherDisplayName, enricher))
+		}
+	}
+	if slices.Contains(c.Enrichers, EnricherDisplayName) && c.DisplayNameCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("display-name-cache-ttl must not be negative, got %s", c.DisplayNameCacheTTL))
+	}
+	if _, err := c.RoomAccess(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ZoneAwareRouting {
+		if c.Zone == "" {
+			errs = append(errs, errors.New("zone-aware-routing needs the hub's zone"))
+		}
+		if c.Broker != BrokerRedis {
+			errs = append(errs, fmt.Errorf("zone-aware-routing needs the redis broker, got %q", c.Broker))
+		}
+		if c.ZoneRefresh <= 0 {
+			errs = append(errs, fmt.Errorf("zone-refresh must be positive, got %s", c.ZoneRefresh))
+		}
+	}
+	if c.PeerHeartbeatInterval < 0 {
+		errs = append(errs, fmt.Errorf("peer-heartbeat-interval must not be negative, got %s", c.PeerHeartbeatInterval))
+	}
+	if c.TargetedRouting && c.Broker != BrokerRedis {
+		errs = append(errs, fmt.Errorf("targeted-routing needs the redis broker, got %q", c.Broker))
+	}
+	if c.DeadLetterStream != "" {
+		if c.Broker != BrokerRedis {
+			errs = append(errs, fmt.Errorf("dead-letter-stream needs the redis broker, got %q", c.Broker))
+		}
+		if c.DeadLetterMaxLen < 1 {
+			errs = append(errs, fmt.Errorf("dead-letter-max-len must be at least 1, got %d", c.DeadLetterMaxLen))
+		}
+	}
+	if c.PushWorkers < 1 {
+		errs = append(errs, fmt.Errorf("push-workers must be at least 1, got %d", c.PushWorkers))
+	}
+	if c.PushMaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("push-max-attempts must be at least 1, got %d", c.PushMaxAttempts))
+	}
+	if c.PushTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("push-timeout must be positive, got %s", c.PushTimeout))
+	}
+	if c.PushBackoff <= 0 {
+		errs = append(errs, fmt.Errorf("push-backoff must be positive, got %s", c.PushBackoff))
+	}
+	if c.PushMaxBackoff < c.PushBackoff {
+		errs = append(errs, fmt.Errorf("push-max-backoff must be at least push-backoff, got %s", c.PushMaxBackoff))
+	}
+	if c.RoomGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("room-grace-period must not be negative, got %s", c.RoomGracePeriod))
+	}
+	if c.RoomTTL < 0 {
+		errs = append(errs, fmt.Errorf("room-ttl must not be negative, got %s", c.RoomTTL))
+	}
+	if c.RoomTTL > 0 && c.RoomTTL < time.Second {
+		errs = append(errs, fmt.Errorf("room-ttl must be at least 1s, got %s", c.RoomTTL))
+	}
+	if c.RoomEventsURL != "" {
+		if u, err := url.Parse(c.RoomEventsURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("room-events-url must be an absolute http or https URL, got %q", c.RoomEventsURL))
+		}
+		if c.RoomEventsSecret == "" {
+			errs = append(errs, errors.New("room-events-secret is required with room-events-url"))
+		}
+	}
+	if c.MirrorRedisAddr != "" {
+		if c.MirrorQueueSize < 1 {
+			errs = append(errs, fmt.Errorf("mirror-queue-size must be at least 1, got %d", c.MirrorQueueSize))
+		}
+		if c.MirrorMaxAttempts < 1 {
+			errs = append(errs, fmt.Errorf("mirror-max-attempts must be at least 1, got %d", c.MirrorMaxAttempts))
+		}
+		if c.MirrorTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("mirror-timeout must be positive, got %s", c.MirrorTimeout))
+		}
+		if c.MirrorBackoff <= 0 {
+			errs = append(errs, fmt.Errorf("mirror-backoff must be positive, got %s", c.MirrorBackoff))
+		}
+		if c.MirrorMaxBackoff < c.MirrorBackoff {
+			errs = append(errs, fmt.Errorf("mirror-max-backoff must be at least mirror-backoff, got %s", c.MirrorMaxBackoff))
+		}
+		if c.MirrorRedisAddr == c.PubSubHostName && (c.MirrorChannel == "" || c.MirrorChannel == c.PubSubChannelName) && c.Broker == BrokerRedis {
+			errs = append(errs, errors.New("mirror-redis-addr and mirror-channel must not name the hub's own pubsub channel"))
+		}
+	}
+	if c.OutboxPostgresURL != "" {
+		if len(c.OutboxTable) == 0 {
+			errs = append(errs, errors.New("outbox-postgres-url needs outbox-tables"))
+		}
+		if _, err := c.OutboxRooms(); err != nil {
+			errs = append(errs, err)
+		}
+		if c.OutboxSlot == "" || c.OutboxPublication == "" {
+			errs = append(errs, errors.New("outbox-slot and outbox-publication are required with outbox-postgres-url"))
+		}
+		if c.OutboxPollInterval <= 0 {
+			errs = append(errs, fmt.Errorf("outbox-poll-interval must be positive, got %s", c.OutboxPollInterval))
+		}
+		if c.OutboxBatchSize < 1 {
+			errs = append(errs, fmt.Errorf("outbox-batch-size must be at least 1, got %d", c.OutboxBatchSize))
+		}
+	}
+	if c.StatePatches && len(c.StateRooms) == 0 {
+		errs = append(errs, errors.New("state-patches needs state-rooms"))
+	}
+	if c.RoomACLsFromRedis && c.RoomACLCacheTTL <= 0 {
+		errs = append(errs, fmt.Errorf("room-acl-cache-ttl must be positive, got %s", c.RoomACLCacheTTL))
+	}
+	pushesStatsD := false
+	for _, sink := range c.MetricsSinks {
+		switch sink {
+		case MetricsSinkPrometheus:
+		case MetricsSinkStatsD, MetricsSinkDogStatsD:
+			pushesStatsD = true
+		default:
+			errs = append(errs, fmt.Errorf("metrics-sinks entry must be %q, %q or %q, got %q", MetricsSinkPrometheus, MetricsSinkStatsD, MetricsSinkDogStatsD, sink))
+		}
+	}
+	if slices.Contains(c.MetricsSinks, MetricsSinkStatsD) && slices.Contains(c.MetricsSinks, MetricsSinkDogStatsD) {
+		errs = append(errs, errors.New("metrics-sinks may list only one of statsd and dogstatsd"))
+	}
+	if pushesStatsD {
+		if c.StatsDAddr == "" {
+			errs = append(errs, errors.New("statsd-addr is required by the statsd and dogstatsd metrics sinks"))
+		}
+		if c.StatsDInterval <= 0 {
+			errs = append(errs, fmt.Errorf("statsd-interval must be positive, got %s", c.StatsDInterval))
+		}
+	}
+	for _, tag := range c.StatsDTags {
+		if tag == "" || strings.ContainsAny(tag, ",|#") {
+			errs = append(errs, fmt.Errorf("statsd-tags entry must be a non-empty tag without commas, pipes or hashes, got %q", tag))
+		}
+	}
+	if slices.Contains(c.RoomMetrics, "*") && c.RoomMetricsLimit < 1 {
+		errs = append(errs, fmt.Errorf("room-metrics-limit must be at least 1, got %d", c.RoomMetricsLimit))
+	}
+	for _, buffer := range []struct {
+		name string
+		size int
+	}{
+		{"broadcast-buffer-size", c.BroadcastBufferSize},
+		{"remove-buffer-size", c.RemoveBufferSize},
+		{"read-buffer-size", c.ReadBufferSize},
+		{"write-buffer-size", c.WriteBufferSize},
+		{"push-queue-size", c.PushQueueSize},
+	} {
+		if buffer.size < 1 {
+			errs = append(errs, fmt.Errorf("%s must be at least 1, got %d", buffer.name, buffer.size))
+		}
+	}
+
+	if c.MaxQueuedBytes < 0 {
+		errs = append(errs, fmt.Errorf("max-queued-bytes must not be negative, got %d", c.MaxQueuedBytes))
+	}
+
+	if c.WSReadBufferSize < 1 {
+		errs = append(errs, fmt.Errorf("ws-read-buffer-size must be at least 1, got %d", c.WSReadBufferSize))
+	}
+	if c.WSWriteBufferSize < 1 {
+		errs = append(errs, fmt.Errorf("ws-write-buffer-size must be at least 1, got %d", c.WSWriteBufferSize))
+	}
+
+	if c.SpillDir != "" {
+		if c.SpillSegmentSize < 1 {
+			errs = append(errs, fmt.Errorf("spill-segment-size must be at least 1, got %d", c.SpillSegmentSize))
+		}
+		if c.SpillMaxSize < 0 {
+			errs = append(errs, fmt.Errorf("spill-max-size must not be negative, got %d", c.SpillMaxSize))
+		}
+	}
+
+	if c.AuthRequired && c.AuthJWTSecret == "" && c.AuthURLSigningSecret == "" {
+		errs = append(errs, errors.New("auth-required needs auth-jwt-secret or auth-url-signing-secret to verify clients"))
+	}
+	if c.AuthGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("auth-grace-period must not be negative, got %s", c.AuthGracePeriod))
+	}
+	if c.MinProtocolVersion < 0 {
+		errs = append(errs, fmt.Errorf("min-protocol-version must not be negative, got %d", c.MinProtocolVersion))
+	}
+	if c.DeprecateProtocolBelow < 0 {
+		errs = append(errs, fmt.Errorf("deprecate-protocol-below must not be negative, got %d", c.DeprecateProtocolBelow))
+	}
+	if _, err := c.ProtocolCutoffTime(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ProtocolCutoff != "" && c.DeprecateProtocolBelow == 0 {
+		errs = append(errs, errors.New("protocol-cutoff needs deprecate-protocol-below"))
+	}
+	if c.AuthGracePeriod > 0 && (!c.AuthRequired || c.AuthJWTSecret == "") {
+		errs = append(errs, errors.New("auth-grace-period needs auth-required and auth-jwt-secret to verify the tokens of auth frames"))
+	}
+	if c.UserMessageRate < 0 {
+		errs = append(errs, fmt.Errorf("user-message-rate must not be negative, got %g", c.UserMessageRate))
+	}
+	if c.UserMessageRate > 0 && c.UserMessageBurst < 1 {
+		errs = append(errs, fmt.Errorf("user-message-burst must be at least 1, got %d", c.UserMessageBurst))
+	}
+	if c.AuthURLSigningSecret != "" && c.AuthURLMaxTTL <= 0 {
+		errs = append(errs, fmt.Errorf("auth-url-max-ttl must be positive, got %s", c.AuthURLMaxTTL))
+	}
+	switch c.DuplicateConnectionPolicy {
+	case PolicyAllowMultiple:
+	case PolicyKickOldest, PolicyRejectNew:
+		if c.AuthJWTSecret == "" {
+			errs = append(errs, fmt.Errorf("duplicate-connection-policy %q needs auth-jwt-secret to identify users", c.DuplicateConnectionPolicy))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("duplicate-connection-policy must be %q, %q or %q, got %q",
+			PolicyAllowMultiple, PolicyKickOldest, PolicyRejectNew, c.DuplicateConnectionPolicy))
+	}
+	if c.MaxConnectionsPerUser < 1 {
+		errs = append(errs, fmt.Errorf("max-connections-per-user must be at least 1, got %d", c.MaxConnectionsPerUser))
+	}
+
+	for _, rule := range c.RedactFields {
+		if field, roles, ok := strings.Cut(rule, "="); !ok || field == "" || roles == "" {
+			errs = append(errs, fmt.Errorf("redact-fields rule must be field=role[|role], got %q", rule))
+		}
+	}
+
+	if len(c.ReplayProtectedRooms) > 0 {
+		if c.PublishSigningSecret == "" {
+			errs = append(errs, errors.New("replay-protected-rooms needs publish-signing-secret to verify publishes"))
+		}
+		if c.AuthJWTSecret == "" {
+			errs = append(errs, errors.New("replay-protected-rooms needs auth-jwt-secret to identify publishers"))
+		}
+	}
+	if c.EphemeralTTL < 0 {
+		errs = append(errs, fmt.Errorf("ephemeral-ttl must not be negative, got %s", c.EphemeralTTL))
+	}
+	if c.ReplayWindow <= 0 {
+		errs = append(errs, fmt.Errorf("replay-window must be positive, got %s", c.ReplayWindow))
+	}
+	if c.RPCTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("rpc-timeout must be positive, got %s", c.RPCTimeout))
+	}
+	if c.RPCMaxPending < 0 {
+		errs = append(errs, fmt.Errorf("rpc-max-pending must not be negative, got %d", c.RPCMaxPending))
+	}
+	if c.HLCMaxDrift <= 0 {
+		errs = append(errs, fmt.Errorf("hlc-max-drift must be positive, got %s", c.HLCMaxDrift))
+	}
+
+	if c.BandwidthDailyCap < 0 {
+		errs = append(errs, fmt.Errorf("bandwidth-daily-cap must not be negative, got %d", c.BandwidthDailyCap))
+	}
+	if c.BandwidthMonthlyCap < 0 {
+		errs = append(errs, fmt.Errorf("bandwidth-monthly-cap must not be negative, got %d", c.BandwidthMonthlyCap))
+	}
+	switch c.BandwidthCapAction {
+	case BandwidthNotify, BandwidthDisconnect:
+	case BandwidthThrottle:
+		if c.BandwidthThrottleRate < 1 {
+			errs = append(errs, fmt.Errorf("bandwidth-throttle-rate must be at least 1, got %d", c.BandwidthThrottleRate))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("bandwidth-cap-action must be %q, %q or %q, got %q",
+			BandwidthNotify, BandwidthThrottle, BandwidthDisconnect, c.BandwidthCapAction))
+	}
+
+	if slices.Contains(c.EnvelopeSigningSecrets, "") {
+		errs = append(errs, errors.New("envelope-signing-secrets must not contain empty secrets"))
+	}
+	if c.EnvelopeKeysFile != "" || c.EnvelopeKeysEnv != "" {
+		if c.EnvelopeKeysFile != "" && c.EnvelopeKeysEnv != "" {
+			errs = append(errs, errors.New("envelope-keys-file and envelope-keys-env are mutually exclusive"))
+		}
+		if c.Broker != BrokerRedis {
+			errs = append(errs, fmt.Errorf("envelope encryption needs the redis broker, got %q", c.Broker))
+		}
+		if c.EnvelopeKeysRefresh <= 0 {
+			errs = append(errs, fmt.Errorf("envelope-keys-refresh must be positive, got %s", c.EnvelopeKeysRefresh))
+		}
+	}
+
+	for _, rate := range []struct {
+		name  string
+		value float64
+	}{
+		{"chaos-publish-drop-rate", c.ChaosPublishDropRate},
+		{"chaos-write-stall-rate", c.ChaosWriteStallRate},
+		{"chaos-disconnect-rate", c.ChaosDisconnectRate},
+	} {
+		if rate.value < 0 || rate.value > 1 {
+			errs = append(errs, fmt.Errorf("%s must be between 0 and 1, got %g", rate.name, rate.value))
+		}
+	}
+	if c.ChaosPublishDelay < 0 {
+		errs = append(errs, fmt.Errorf("chaos-publish-delay must not be negative, got %s", c.ChaosPublishDelay))
+	}
+	if c.ChaosWriteStall < 0 {
+		errs = append(errs, fmt.Errorf("chaos-write-stall must not be negative, got %s", c.ChaosWriteStall))
+	}
+	if c.ChaosDisconnectRate > 0 && c.ChaosDisconnectInterval <= 0 {
+		errs = append(errs, fmt.Errorf("chaos-disconnect-interval must be positive, got %s", c.ChaosDisconnectInterval))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,460 @@
+// Package filter implements the expressions subscriptions select the messages of a room with, such
+// as `type == "trade" && symbol in ["AAPL", "MSFT"]`, compiled once and evaluated against the
+// decoded JSON payload of every message fanned out to the subscription.
+package filter
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MaxLength is the length of the longest expression Compile accepts, in bytes.
+const MaxLength = 1024
+
+// maxDepth is the deepest nesting of operators and parentheses Compile accepts, so evaluating a
+// filter cannot exhaust the stack of a broadcast worker.
+const maxDepth = 32
+
+// Filter is a compiled expression.
+type Filter struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression. Operands are payload fields, named by dotted paths such as
+// `order.side`, and string, number, boolean, null and list literals; they are compared with ==, !=,
+// <, <=, >, >= and in, and combined with &&, || and !, grouped with parentheses.
+func Compile(source string) (*Filter, error) {
+	if len(source) > MaxLength {
+		return nil, fmt.Errorf("filter is longer than %d bytes", MaxLength)
+	}
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected %s at offset %d", t, t.pos)
+	}
+	return &Filter{source: source, root: root}, nil
+}
+
+// Match reports whether a decoded JSON payload passes the filter. Fields missing from the payload
+// are null, and comparisons of values of different types are false.
+func (f *Filter) Match(payload any) bool {
+	return truthy(f.root.eval(payload))
+}
+
+// String returns the expression the filter was compiled from.
+func (f *Filter) String() string {
+	return f.source
+}
+
+// node is an expression of the syntax tree, evaluated to a value of the payload's JSON types.
+type node interface {
+	eval(payload any) any
+}
+
+type (
+	fieldNode   []string
+	literalNode struct{ value any }
+	listNode    []node
+	notNode     struct{ operand node }
+	andNode     struct{ left, right node }
+	orNode      struct{ left, right node }
+	compareNode struct {
+		op          string
+		left, right node
+	}
+)
+
+func (n fieldNode) eval(payload any) any {
+	value := payload
+	for _, name := range n {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+func (n literalNode) eval(any) any {
+	return n.value
+}
+
+func (n listNode) eval(payload any) any {
+	values := make([]any, len(n))
+	for i, item := range n {
+		values[i] = item.eval(payload)
+	}
+	return values
+}
+
+func (n notNode) eval(payload any) any {
+	return !truthy(n.operand.eval(payload))
+}
+
+func (n andNode) eval(payload any) any {
+	return truthy(n.left.eval(payload)) && truthy(n.right.eval(payload))
+}
+
+func (n orNode) eval(payload any) any {
+	return truthy(n.left.eval(payload)) || truthy(n.right.eval(payload))
+}
+
+func (n compareNode) eval(payload any) any {
+	left, right := n.left.eval(payload), n.right.eval(payload)
+	switch n.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	case "in":
+		return contains(right, left)
+	}
+
+	var c int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		c = compare(l, r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(l, r)
+	default:
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// truthy reports whether a value holds in a condition: only true does, so a bare field tests a
+// boolean flag of the payload.
+func truthy(value any) bool {
+	b, ok := value.(bool)
+	return ok && b
+}
+
+// equal reports whether two JSON values are equal.
+func equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// contains reports whether a list holds the value, or a string the substring.
+func contains(collection, value any) bool {
+	switch c := collection.(type) {
+	case []any:
+		for _, item := range c {
+			if equal(item, value) {
+				return true
+			}
+		}
+	case string:
+		if s, ok := value.(string); ok {
+			return strings.Contains(c, s)
+		}
+	}
+	return false
+}
+
+func compare(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// parser parses tokens by recursive descent, from the loosest operator, ||, to operands.
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEnd {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) or(depth int) (node, error) {
+	left, err := p.and(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is(tokenOperator, "||") {
+		p.take()
+		right, err := p.and(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and(depth int) (node, error) {
+	left, err := p.unary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is(tokenOperator, "&&") {
+		p.take()
+		right, err := p.unary(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary(depth int) (node, error) {
+	if depth >= maxDepth {
+		return nil, fmt.Errorf("filter is nested deeper than %d levels", maxDepth)
+	}
+	if p.peek().is(tokenOperator, "!") {
+		p.take()
+		operand, err := p.unary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.comparison(depth)
+}
+
+func (p *parser) comparison(depth int) (node, error) {
+	left, err := p.operand(depth)
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokenOperator || !isComparison(t.text) {
+		return left, nil
+	}
+	p.take()
+	right, err := p.operand(depth)
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{op: t.text, left: left, right: right}, nil
+}
+
+func (p *parser) operand(depth int) (node, error) {
+	if depth >= maxDepth {
+		return nil, fmt.Errorf("filter is nested deeper than %d levels", maxDepth)
+	}
+	t := p.take()
+	switch t.kind {
+	case tokenField:
+		switch t.text {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null":
+			return literalNode{nil}, nil
+		}
+		return fieldNode(strings.Split(t.text, ".")), nil
+	case tokenString:
+		return literalNode{t.value}, nil
+	case tokenNumber:
+		return literalNode{t.value}, nil
+	case tokenPunct:
+		switch t.text {
+		case "(":
+			inner, err := p.or(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if closing := p.take(); !closing.is(tokenPunct, ")") {
+				return nil, fmt.Errorf("expected ) at offset %d, got %s", closing.pos, closing)
+			}
+			return inner, nil
+		case "[":
+			return p.list(depth)
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", t, t.pos)
+}
+
+func (p *parser) list(depth int) (node, error) {
+	var items listNode
+	if p.peek().is(tokenPunct, "]") {
+		p.take()
+		return items, nil
+	}
+	for {
+		item, err := p.operand(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		switch t := p.take(); {
+		case t.is(tokenPunct, "]"):
+			return items, nil
+		case !t.is(tokenPunct, ","):
+			return nil, fmt.Errorf("expected , or ] at offset %d, got %s", t.pos, t)
+		}
+	}
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "in":
+		return true
+	}
+	return false
+}
+
+// Kinds of tokens.
+const (
+	tokenEnd = iota
+	tokenField
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenPunct
+)
+
+type token struct {
+	kind int
+	text string
+	// value is the value of a string or number literal
+	value any
+	pos   int
+}
+
+func (t token) is(kind int, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+func (t token) String() string {
+	if t.kind == tokenEnd {
+		return "end of filter"
+	}
+	return strconv.Quote(t.text)
+}
+
+// lex splits an expression into tokens, ending with a tokenEnd.
+func lex(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isIdentStart(c):
+			start := i
+			for i < len(source) && (isIdentStart(source[i]) || isDigit(source[i]) || source[i] == '.') {
+				i++
+			}
+			text := source[start:i]
+			if strings.HasPrefix(text, ".") || strings.HasSuffix(text, ".") || strings.Contains(text, "..") {
+				return nil, fmt.Errorf("invalid field %q at offset %d", text, start)
+			}
+			kind := tokenField
+			if text == "in" {
+				kind = tokenOperator
+			}
+			tokens = append(tokens, token{kind: kind, text: text, pos: start})
+		case isDigit(c) || (c == '-' && i+1 < len(source) && isDigit(source[i+1])):
+			start := i
+			i++
+			for i < len(source) && (isDigit(source[i]) || strings.IndexByte(".eE+-", source[i]) >= 0) {
+				if (source[i] == '+' || source[i] == '-') && source[i-1] != 'e' && source[i-1] != 'E' {
+					break
+				}
+				i++
+			}
+			number, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", source[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:i], value: number, pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			for i < len(source) && source[i] != c {
+				if source[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(source) {
+				return nil, fmt.Errorf("unterminated string at offset %d", start)
+			}
+			i++
+			text := source[start:i]
+			quoted := text
+			if c == '\'' {
+				quoted = `"` + strings.ReplaceAll(strings.ReplaceAll(text[1:len(text)-1], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s at offset %d", text, start)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, value: value, pos: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"} {
+				if strings.HasPrefix(source[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op != "" {
+				tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+				i += len(op)
+				continue
+			}
+			if strings.IndexByte("()[],", c) < 0 {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenPunct, text: string(c), pos: i})
+			i++
+		}
+	}
+	return append(tokens, token{kind: tokenEnd, pos: len(source)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
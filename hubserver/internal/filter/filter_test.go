@@ -0,0 +1,132 @@
+package filter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// payload decodes a JSON payload as the broadcast workers do.
+func payload(t *testing.T, data string) any {
+	t.Helper()
+
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("payload %s: %v", data, err)
+	}
+	return value
+}
+
+func TestMatch(t *testing.T) {
+	trade := `{"type":"trade","symbol":"AAPL","price":187.5,"qty":10,"urgent":true,"order":{"side":"buy"},"tags":["fast","dark"],"note":null}`
+	for _, tc := range []struct {
+		filter  string
+		payload string
+		want    bool
+	}{
+		// && binds tighter than ||, and ! tighter than both
+		{`type == "quote" && symbol == "MSFT" || urgent`, trade, true},
+		{`type == "quote" && (symbol == "MSFT" || urgent)`, trade, false},
+		{`urgent || type == "quote" && symbol == "MSFT"`, trade, true},
+		{`!urgent || qty > 5`, trade, true},
+		{`!urgent && qty > 5`, trade, false},
+		{`!(urgent && qty > 5)`, trade, false},
+		{`!!urgent`, trade, true},
+
+		{`order.side == "buy"`, trade, true},
+		{`order.missing == null`, trade, true},
+		{`missing.deeper == null`, trade, true},
+		{`note == null`, trade, true},
+		{`symbol.nested == null`, trade, true},
+		{`price >= 187.5 && price < 200`, trade, true},
+		{`qty != 10`, trade, false},
+		{`symbol > "AAA" && symbol <= "AAPL"`, trade, true},
+		{`'it\'s' == "it's"`, `{}`, true},
+
+		// in tests lists for their items and strings for their substrings
+		{`symbol in ["AAPL", "MSFT"]`, trade, true},
+		{`symbol in ["GOOG"]`, trade, false},
+		{`qty in [1, 10]`, trade, true},
+		{`symbol in []`, trade, false},
+		{`"dark" in tags`, trade, true},
+		{`"slow" in tags`, trade, false},
+		{`"AP" in symbol`, trade, true},
+		{`"ap" in symbol`, trade, false},
+		{`qty in symbol`, trade, false},
+		{`symbol in qty`, trade, false},
+		{`null in [null]`, `{}`, true},
+
+		// values of different types are neither equal nor ordered
+		{`qty == "10"`, trade, false},
+		{`qty != "10"`, trade, true},
+		{`symbol < 5`, trade, false},
+		{`symbol >= 5`, trade, false},
+		{`price > true`, trade, false},
+		{`urgent > false`, trade, false},
+		{`missing < 1`, trade, false},
+		{`order == "buy"`, trade, false},
+
+		// only true holds in a condition
+		{`symbol`, trade, false},
+		{`qty`, trade, false},
+		{`missing`, trade, false},
+		{`urgent`, `[1, 2]`, false},
+	} {
+		f, err := Compile(tc.filter)
+		if err != nil {
+			t.Fatalf("Compile(%s): %v", tc.filter, err)
+		}
+		if got := f.Match(payload(t, tc.payload)); got != tc.want {
+			t.Errorf("%s matched %s: %v, want %v", tc.filter, tc.payload, got, tc.want)
+		}
+	}
+}
+
+func TestCompileRejects(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		err    string
+	}{
+		{``, "unexpected end of filter"},
+		{`qty >`, "unexpected end of filter"},
+		{`qty > 1 2`, `unexpected "2"`},
+		{`qty = 1`, `unexpected character '='`},
+		{`(qty > 1`, "expected ) at offset 8"},
+		{`qty in [1 2]`, "expected , or ] at offset 10"},
+		{`qty in [1,`, "unexpected end of filter"},
+		{`qty == 1 2 == 3`, `unexpected "2"`},
+		{`qty < 1 < 2`, `unexpected "<"`},
+		{`a && || b`, `unexpected "||"`},
+		{`.qty == 1`, "unexpected character '.'"},
+		{`order..side == 1`, `invalid field "order..side"`},
+		{`order. == 1`, `invalid field "order."`},
+		{`qty == 1e`, `invalid number "1e"`},
+		{`symbol == "AAPL`, "unterminated string at offset 10"},
+		{`symbol == "\q"`, "invalid string"},
+		{`symbol == $`, "unexpected character '$'"},
+		{strings.Repeat("(", maxDepth) + "urgent" + strings.Repeat(")", maxDepth), "nested deeper than 32 levels"},
+		{strings.Repeat("!", maxDepth) + "urgent", "nested deeper than 32 levels"},
+		{"qty in " + strings.Repeat("[", maxDepth) + "1" + strings.Repeat("]", maxDepth), "nested deeper than 32 levels"},
+		{strings.Repeat(" ", MaxLength-len("urgent")+1) + "urgent", "longer than 1024 bytes"},
+	} {
+		if _, err := Compile(tc.filter); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Compile(%.40s) = %v, want an error containing %q", tc.filter, err, tc.err)
+		}
+	}
+}
+
+func TestCompileAcceptsTheLimits(t *testing.T) {
+	for _, filter := range []string{
+		strings.Repeat("(", maxDepth-1) + "urgent" + strings.Repeat(")", maxDepth-1),
+		strings.Repeat("!", maxDepth-1) + "urgent",
+		strings.Repeat(" ", MaxLength-len("urgent")) + "urgent",
+	} {
+		f, err := Compile(filter)
+		if err != nil {
+			t.Fatalf("Compile(%.40s): %v", filter, err)
+		}
+		if f.String() != filter {
+			t.Fatalf("String() = %.40s, want %.40s", f.String(), filter)
+		}
+	}
+}
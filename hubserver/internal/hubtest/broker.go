@@ -0,0 +1,137 @@
+// Package hubtest provides in-memory fakes of the hub's network dependencies, so the message
+// handler and its connections can be tested without Redis or real sockets.
+package hubtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+// inboxSize is the number of messages from other hubs a Broker queues before the hub subscribes.
+const inboxSize = 1024
+
+// Bus is an in-memory pub/sub channel shared by the Brokers of several hubs, standing in for a
+// Redis channel.
+type Bus struct {
+	channel string
+
+	mu      sync.Mutex
+	brokers []*Broker
+}
+
+// NewBus creates a Bus. Messages it delivers carry the channel as their sender, as those received
+// from Redis do.
+func NewBus(channel string) *Bus {
+	return &Bus{channel: channel}
+}
+
+// Broker returns a new Broker of the hub with the given id connected to the bus.
+func (b *Bus) Broker(hubID string) *Broker {
+	broker := newBroker(b, hubID)
+
+	b.mu.Lock()
+	b.brokers = append(b.brokers, broker)
+	b.mu.Unlock()
+	return broker
+}
+
+// publish delivers a message to every broker on the bus except the publishing hub's.
+func (b *Bus) publish(md message.MessageDetails) {
+	b.mu.Lock()
+	brokers := append([]*Broker(nil), b.brokers...)
+	b.mu.Unlock()
+
+	for _, broker := range brokers {
+		if broker.hubID != md.HubID {
+			broker.Deliver(md)
+		}
+	}
+}
+
+// Broker is an in-memory broker recording the messages its hub publishes and delivering the messages
+// of other hubs, either from its Bus or injected with Deliver. It implements websocket.Broker.
+type Broker struct {
+	bus   *Bus
+	hubID string
+	inbox chan message.MessageDetails
+
+	mu        sync.Mutex
+	published []message.MessageDetails
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBroker creates a Broker of the hub with the given id that is connected to no other hub.
+func NewBroker(channel, hubID string) *Broker {
+	return NewBus(channel).Broker(hubID)
+}
+
+func newBroker(bus *Bus, hubID string) *Broker {
+	return &Broker{
+		bus:   bus,
+		hubID: hubID,
+		inbox: make(chan message.MessageDetails, inboxSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// Subscribe forwards the messages delivered to the broker to broadcastCh until ctx is done or the
+// broker is closed.
+func (b *Broker) Subscribe(ctx context.Context, broadcastCh chan<- message.MessageDetails) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.done:
+			return
+		case md := <-b.inbox:
+			select {
+			case broadcastCh <- md:
+			case <-ctx.Done():
+				return
+			case <-b.done:
+				return
+			}
+		}
+	}
+}
+
+// Publish records the message and delivers it to the other hubs on the bus.
+func (b *Broker) Publish(ctx context.Context, md *message.MessageDetails) error {
+	b.mu.Lock()
+	b.published = append(b.published, *md)
+	b.mu.Unlock()
+
+	b.bus.publish(*md)
+	return nil
+}
+
+// Unsubscribe is a no-op; Close ends the subscription.
+func (b *Broker) Unsubscribe(ctx context.Context) error {
+	return nil
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (b *Broker) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return nil
+}
+
+// Deliver queues a message as if another hub had published it, blocking while the inbox is full.
+func (b *Broker) Deliver(md message.MessageDetails) {
+	md.SenderID = b.bus.channel
+	select {
+	case b.inbox <- md:
+	case <-b.done:
+	}
+}
+
+// Published returns the messages published through the broker so far.
+func (b *Broker) Published() []message.MessageDetails {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]message.MessageDetails(nil), b.published...)
+}
@@ -0,0 +1,236 @@
+package hubtest
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// connBuffer is the number of messages queued in each direction of a Conn.
+const connBuffer = 256
+
+// Conn is an in-memory WebSocket connection implementing websocket.Conn. The hub reads what the
+// test sends with Send and writes what the test reads with Receive; writes block once connBuffer
+// messages are waiting to be received, like a slow client. Deadlines are ignored, and pings are
+// answered unless SetAutoPong(false) simulates a half-open connection.
+type Conn struct {
+	subprotocol string
+	inbound     chan []byte
+	outbound    chan []byte
+	readLimit   atomic.Int64
+	pings       atomic.Int32
+	autoPong    atomic.Bool
+
+	mu          sync.Mutex
+	pongHandler func(string) error
+	closeCode   int
+	closeText   string
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewConn creates a Conn that negotiated the given subprotocol, empty for raw payloads.
+func NewConn(subprotocol string) *Conn {
+	c := &Conn{
+		subprotocol: subprotocol,
+		inbound:     make(chan []byte, connBuffer),
+		outbound:    make(chan []byte, connBuffer),
+		closeCode:   websocket.CloseNoStatusReceived,
+		closed:      make(chan struct{}),
+	}
+	c.autoPong.Store(true)
+	return c
+}
+
+// Subprotocol returns the negotiated subprotocol.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// SetReadLimit sets the largest message ReadMessage accepts.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit.Store(limit)
+}
+
+// SetReadDeadline is a no-op.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetPongHandler sets the handler called when a ping is answered.
+func (c *Conn) SetPongHandler(h func(appData string) error) {
+	c.mu.Lock()
+	c.pongHandler = h
+	c.mu.Unlock()
+}
+
+// ReadMessage returns the next message sent with Send, or a close error once the connection is closed.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-c.inbound:
+		if limit := c.readLimit.Load(); limit > 0 && int64(len(data)) > limit {
+			c.close(websocket.CloseMessageTooBig, "")
+			return 0, nil, websocket.ErrReadLimit
+		}
+		return websocket.TextMessage, data, nil
+	case <-c.closed:
+		code, text := c.CloseFrame()
+		return 0, nil, &websocket.CloseError{Code: code, Text: text}
+	}
+}
+
+// SetWriteDeadline is a no-op.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// WriteMessage queues a data message for Receive, or counts and answers a ping.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType == websocket.PingMessage {
+		return c.ping()
+	}
+
+	data = append([]byte(nil), data...)
+	select {
+	case <-c.closed:
+		return websocket.ErrCloseSent
+	default:
+	}
+	select {
+	case c.outbound <- data:
+		return nil
+	case <-c.closed:
+		return websocket.ErrCloseSent
+	}
+}
+
+// WriteControl records a close frame and closes the connection, or counts and answers a ping.
+func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	switch messageType {
+	case websocket.CloseMessage:
+		code, text := websocket.CloseNoStatusReceived, ""
+		if len(data) >= 2 {
+			code, text = int(data[0])<<8|int(data[1]), string(data[2:])
+		}
+		c.close(code, text)
+	case websocket.PingMessage:
+		return c.ping()
+	}
+	return nil
+}
+
+// Close closes the connection without a close frame if none was written.
+func (c *Conn) Close() error {
+	c.close(websocket.CloseAbnormalClosure, "")
+	return nil
+}
+
+func (c *Conn) ping() error {
+	c.pings.Add(1)
+	if !c.autoPong.Load() {
+		return nil
+	}
+
+	c.mu.Lock()
+	handler := c.pongHandler
+	c.mu.Unlock()
+	if handler != nil {
+		return handler("")
+	}
+	return nil
+}
+
+// close closes the connection once, recording the close code and text.
+func (c *Conn) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closeCode, c.closeText = code, text
+		c.mu.Unlock()
+		close(c.closed)
+	})
+}
+
+// Send queues a text message for the hub to read. It fails once the connection is closed.
+func (c *Conn) Send(data []byte) error {
+	select {
+	case <-c.closed:
+		return websocket.ErrCloseSent
+	default:
+	}
+	select {
+	case c.inbound <- data:
+		return nil
+	case <-c.closed:
+		return websocket.ErrCloseSent
+	}
+}
+
+// SendJSON queues the JSON encoding of v for the hub to read.
+func (c *Conn) SendJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(data)
+}
+
+// Receive returns the next data message written by the hub, waiting until ctx is done. Messages
+// written before the connection was closed can still be received.
+func (c *Conn) Receive(ctx context.Context) ([]byte, error) {
+	select {
+	case data := <-c.outbound:
+		return data, nil
+	default:
+	}
+	select {
+	case data := <-c.outbound:
+		return data, nil
+	case <-c.closed:
+		code, text := c.CloseFrame()
+		return nil, &websocket.CloseError{Code: code, Text: text}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ReceiveJSON decodes the next data message written by the hub into v.
+func (c *Conn) ReceiveJSON(ctx context.Context, v any) error {
+	data, err := c.Receive(ctx)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// CloseFromClient closes the connection as if the client had sent a close frame with the code.
+func (c *Conn) CloseFromClient(code int) {
+	c.close(code, "")
+}
+
+// Closed returns a channel closed once either side closed the connection.
+func (c *Conn) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// CloseFrame returns the code and text of the close frame, CloseNoStatusReceived while the
+// connection is open.
+func (c *Conn) CloseFrame() (int, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeCode, c.closeText
+}
+
+// Pings returns the number of pings the hub sent.
+func (c *Conn) Pings() int {
+	return int(c.pings.Load())
+}
+
+// SetAutoPong sets whether pings are answered.
+func (c *Conn) SetAutoPong(enabled bool) {
+	c.autoPong.Store(enabled)
+}
@@ -0,0 +1,225 @@
+package ipfilter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrDenied is returned when the address matches the denylist.
+	ErrDenied = errors.New("address is denylisted")
+	// ErrNotAllowed is returned when an allowlist is configured and the address does not match it.
+	ErrNotAllowed = errors.New("address is not allowlisted")
+	// ErrLimitExceeded is returned when the address already holds the maximum number of connections.
+	ErrLimitExceeded = errors.New("too many connections from address")
+)
+
+// Filter admits or rejects client addresses based on CIDR allow/deny lists and
+// a per-IP concurrent connection limit. The lists are read from files and can be
+// reloaded at runtime.
+type Filter struct {
+	allowFile string
+	denyFile  string
+	maxPerIP  int
+	trusted   []netip.Prefix
+
+	listMu sync.RWMutex
+	allow  []netip.Prefix
+	deny   []netip.Prefix
+
+	countMu sync.Mutex
+	counts  map[netip.Addr]int
+
+	logger *zap.Logger
+}
+
+// NewFilter creates a new Filter and loads the configured lists. Empty file paths
+// disable the corresponding list and a maxPerIP of 0 disables the connection limit.
+// Requests from trustedProxies are attributed to the client named in X-Forwarded-For.
+func NewFilter(allowFile, denyFile string, trustedProxies []string, maxPerIP int, logger *zap.Logger) (*Filter, error) {
+	trusted := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, entry := range trustedProxies {
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		trusted = append(trusted, prefix)
+	}
+
+	f := &Filter{
+		allowFile: allowFile,
+		denyFile:  denyFile,
+		maxPerIP:  maxPerIP,
+		trusted:   trusted,
+		counts:    make(map[netip.Addr]int),
+		logger:    logger,
+	}
+
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Reload re-reads the allow and deny lists from disk. On error the previously loaded lists are kept.
+func (f *Filter) Reload() error {
+	allow, err := loadPrefixes(f.allowFile)
+	if err != nil {
+		return fmt.Errorf("failed to load allowlist: %w", err)
+	}
+
+	deny, err := loadPrefixes(f.denyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load denylist: %w", err)
+	}
+
+	f.listMu.Lock()
+	f.allow = allow
+	f.deny = deny
+	f.listMu.Unlock()
+
+	f.logger.Info("IP filter lists loaded", zap.Int("allow", len(allow)), zap.Int("deny", len(deny)))
+	return nil
+}
+
+// ClientAddr returns the address of the client that made the request. When the request comes from a
+// trusted proxy, the right-most address in X-Forwarded-For that is not a trusted proxy is used instead.
+func (f *Filter) ClientAddr(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid remote address %q: %w", r.RemoteAddr, err)
+	}
+	addr = addr.Unmap()
+
+	if !contains(f.trusted, addr) {
+		return addr, nil
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !contains(f.trusted, addr) {
+			break
+		}
+	}
+	return addr, nil
+}
+
+// Acquire checks the address against the lists and reserves a connection slot for it.
+// Every successful Acquire must be paired with a Release.
+func (f *Filter) Acquire(addr netip.Addr) error {
+	addr = addr.Unmap()
+
+	f.listMu.RLock()
+	denied := contains(f.deny, addr)
+	allowed := len(f.allow) == 0 || contains(f.allow, addr)
+	f.listMu.RUnlock()
+
+	if denied {
+		return ErrDenied
+	}
+	if !allowed {
+		return ErrNotAllowed
+	}
+
+	f.countMu.Lock()
+	defer f.countMu.Unlock()
+
+	if f.maxPerIP > 0 && f.counts[addr] >= f.maxPerIP {
+		return ErrLimitExceeded
+	}
+	f.counts[addr]++
+	return nil
+}
+
+// Release frees a connection slot previously reserved with Acquire.
+func (f *Filter) Release(addr netip.Addr) {
+	addr = addr.Unmap()
+
+	f.countMu.Lock()
+	defer f.countMu.Unlock()
+
+	if f.counts[addr] <= 1 {
+		delete(f.counts, addr)
+		return
+	}
+	f.counts[addr]--
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPrefixes reads one IP address or CIDR per line. Blank lines and lines starting with '#' are ignored.
+func loadPrefixes(path string) ([]netip.Prefix, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+func parsePrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,121 @@
+package mesh
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// link is an outbound connection to a peer, redialled with backoff until it is stopped.
+type link struct {
+	mesh *Mesh
+	addr string
+	send chan []byte
+	done chan struct{}
+}
+
+func newLink(m *Mesh, addr string) *link {
+	return &link{
+		mesh: m,
+		addr: addr,
+		send: make(chan []byte, linkBufferSize),
+		done: make(chan struct{}),
+	}
+}
+
+// stop closes the link. It must be called with the mesh lock held, once.
+func (l *link) stop() {
+	close(l.done)
+}
+
+// run dials the peer and writes queued messages to it, redialling whenever the link fails.
+func (l *link) run() {
+	delay := minRedialDelay
+	for {
+		ws, err := l.dial()
+		if err == nil {
+			delay = minRedialDelay
+			err = l.write(ws)
+			_ = ws.Close()
+		}
+		if err == errSelf {
+			l.mesh.markSelf(l.addr)
+			return
+		}
+
+		select {
+		case <-l.done:
+			return
+		default:
+		}
+
+		l.mesh.logger.Warn("Mesh link failed, redialling", zap.String("peer", l.addr), zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-l.done:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxRedialDelay)
+	}
+}
+
+// dial opens the link and checks that the peer is not this hub.
+func (l *link) dial() (*websocket.Conn, error) {
+	header := http.Header{hubIDHeader: {l.mesh.hubID}}
+	if l.mesh.secret != "" {
+		header.Set("Authorization", "Bearer "+l.mesh.secret)
+	}
+
+	u := url.URL{Scheme: "ws", Host: l.addr, Path: Path}
+	ws, resp, err := l.mesh.dialer.DialContext(l.mesh.ctx, u.String(), header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get(hubIDHeader) == l.mesh.hubID {
+		_ = ws.Close()
+		return nil, errSelf
+	}
+
+	l.mesh.logger.Info("Connected mesh link", zap.String("peer", l.addr), zap.String("peer-hub", resp.Header.Get(hubIDHeader)))
+	return ws, nil
+}
+
+// write forwards queued messages and pings the peer until the link fails or is stopped.
+func (l *link) write(ws *websocket.Conn) error {
+	// Drain control frames from the peer so pongs and close frames are processed.
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+		case err := <-readErr:
+			return err
+		case data := <-l.send:
+			if err := ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+				return err
+			}
+			if err := ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return err
+			}
+		}
+	}
+}
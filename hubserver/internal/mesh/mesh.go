@@ -0,0 +1,277 @@
+package mesh
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+const (
+	// Path is the HTTP path on which hubs accept links from their peers.
+	Path = "/mesh"
+
+	hubIDHeader     = "X-Hub-ID"
+	linkBufferSize  = 1024
+	writeWait       = 5 * time.Second
+	pongWait        = 60 * time.Second
+	pingPeriod      = (pongWait * 9) / 10
+	minRedialDelay  = time.Second
+	maxRedialDelay  = 30 * time.Second
+	maxEnvelopeSize = 4 * 1024 * 1024
+)
+
+// errSelf is returned when a discovered peer address turns out to reach this hub.
+var errSelf = errors.New("mesh peer is this hub")
+
+// Mesh forwards messages between hub instances over persistent WebSocket links, for deployments
+// without Redis or RabbitMQ. Every hub dials every peer it discovers, from a static list or a DNS
+// name, and publishes on its outbound links; messages arriving on inbound links are delivered to
+// its own connections only, so the full mesh never relays a message twice.
+type Mesh struct {
+	channel string
+	hubID   string
+	secret  string
+	peers   []string
+	dnsName string
+	refresh time.Duration
+
+	dialer   *websocket.Dialer
+	upgrader websocket.Upgrader
+
+	mu          sync.Mutex
+	links       map[string]*link
+	self        map[string]bool
+	broadcastCh chan<- message.MessageDetails
+	stopped     bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	logger *zap.Logger
+}
+
+// NewMesh creates a new Mesh for the hub. Peers are host:port addresses of other hubs; dnsName is
+// a host:port whose host resolves to the addresses of every hub and is re-resolved every refresh.
+// Links carry the secret and only links presenting it are accepted, so a mesh without a secret
+// accepts no links.
+func NewMesh(channel, hubID, secret string, peers []string, dnsName string, refresh time.Duration, logger *zap.Logger) *Mesh {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Mesh{
+		channel: channel,
+		hubID:   hubID,
+		secret:  secret,
+		peers:   peers,
+		dnsName: dnsName,
+		refresh: refresh,
+		dialer:  &websocket.Dialer{HandshakeTimeout: writeWait},
+		links:   make(map[string]*link),
+		self:    make(map[string]bool),
+		ctx:     ctx,
+		cancel:  cancel,
+		logger:  logger,
+	}
+}
+
+// Subscribe maintains links to the discovered peers and forwards messages received from them to
+// broadcastCh until ctx is cancelled or the mesh is stopped.
+func (m *Mesh) Subscribe(ctx context.Context, broadcastCh chan<- message.MessageDetails) {
+	m.mu.Lock()
+	m.broadcastCh = broadcastCh
+	m.mu.Unlock()
+
+	ticker := time.NewTicker(m.refresh)
+	defer ticker.Stop()
+
+	for {
+		m.syncLinks(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncLinks dials newly discovered peers and drops links to peers that disappeared.
+func (m *Mesh) syncLinks(ctx context.Context) {
+	addrs, err := m.discover(ctx)
+	if err != nil {
+		m.logger.Warn("Failed to discover mesh peers", zap.String("dns-name", m.dnsName), zap.Error(err))
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return
+	}
+
+	wanted := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		wanted[addr] = true
+		if _, ok := m.links[addr]; ok || m.self[addr] {
+			continue
+		}
+
+		l := newLink(m, addr)
+		m.links[addr] = l
+		go l.run()
+	}
+
+	for addr, l := range m.links {
+		if !wanted[addr] {
+			l.stop()
+			delete(m.links, addr)
+		}
+	}
+}
+
+// discover returns the addresses of the static peers and of the hubs behind the DNS name.
+func (m *Mesh) discover(ctx context.Context) ([]string, error) {
+	addrs := append([]string(nil), m.peers...)
+	if m.dnsName != "" {
+		host, port, err := net.SplitHostPort(m.dnsName)
+		if err != nil {
+			return nil, err
+		}
+
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// Publish sends the message to every connected peer. Peers whose link is backed up miss it.
+func (m *Mesh) Publish(ctx context.Context, md *message.MessageDetails) error {
+	_, err := m.PublishCounted(ctx, md)
+	return err
+}
+
+// PublishCounted sends the message to every connected peer and returns the number of peers it was
+// queued for.
+func (m *Mesh) PublishCounted(ctx context.Context, md *message.MessageDetails) (int, error) {
+	data, err := md.ToJSON()
+	if err != nil {
+		m.logger.Error("Failed to marshal message", zap.Error(err))
+		return 0, fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent := 0
+	for addr, l := range m.links {
+		select {
+		case l.send <- data:
+			sent++
+		default:
+			m.logger.Warn("Mesh link is backed up, dropping message", zap.String("peer", addr), zap.String("id", md.ID))
+		}
+	}
+	return sent, nil
+}
+
+// ServeHTTP accepts a link from a peer and delivers the messages it carries.
+func (m *Mesh) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if m.secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(m.secret)) != 1 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ws, err := m.upgrader.Upgrade(w, r, http.Header{hubIDHeader: {m.hubID}})
+	if err != nil {
+		m.logger.Error("Failed to accept mesh link", zap.String("remote-addr", r.RemoteAddr), zap.Error(err))
+		return
+	}
+	defer ws.Close()
+
+	peer := r.Header.Get(hubIDHeader)
+	if peer == m.hubID {
+		return
+	}
+	m.logger.Info("Accepted mesh link", zap.String("peer", peer), zap.String("remote-addr", r.RemoteAddr))
+
+	ws.SetReadLimit(maxEnvelopeSize)
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			m.logger.Info("Mesh link closed", zap.String("peer", peer), zap.Error(err))
+			return
+		}
+
+		var md message.MessageDetails
+		if err := md.FromJSON(data); err != nil {
+			m.logger.Error("Failed to unmarshal message", zap.Error(err))
+			continue
+		}
+
+		m.mu.Lock()
+		broadcastCh := m.broadcastCh
+		m.mu.Unlock()
+		if broadcastCh == nil || md.HubID == m.hubID {
+			continue
+		}
+
+		md.SenderID = m.channel
+		broadcastCh <- md
+	}
+}
+
+// markSelf records that addr reaches this hub, as DNS discovery also returns the hub's own address.
+func (m *Mesh) markSelf(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.self[addr] = true
+	if l, ok := m.links[addr]; ok {
+		l.stop()
+		delete(m.links, addr)
+	}
+}
+
+// Unsubscribe stops maintaining links to peers.
+func (m *Mesh) Unsubscribe(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = true
+	m.broadcastCh = nil
+	m.logger.Info("Unsubscribed from mesh peers")
+	return nil
+}
+
+// Close closes every outbound link.
+func (m *Mesh) Close() error {
+	m.cancel()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = true
+	for addr, l := range m.links {
+		l.stop()
+		delete(m.links, addr)
+	}
+	m.logger.Info("Mesh links closed")
+	return nil
+}
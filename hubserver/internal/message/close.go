@@ -0,0 +1,51 @@
+package message
+
+import "encoding/json"
+
+// Close codes sent by the hub in WebSocket close frames. They are in the range reserved for applications.
+const (
+	CloseShutdown     = 4001
+	CloseEvicted      = 4002
+	CloseDrain        = 4003
+	CloseUnauthorized = 4004
+	CloseIdle         = 4005
+)
+
+// Reasons carried in the close reason payload.
+const (
+	ReasonShutdown     = "shutdown"
+	ReasonEvicted      = "evicted"
+	ReasonDrain        = "drain"
+	ReasonUnauthorized = "unauthorized"
+	ReasonAuthTimeout  = "auth_timeout"
+	ReasonBandwidthCap = "bandwidth_cap"
+	ReasonSlowConsumer = "slow_consumer"
+	ReasonIdle         = "idle"
+)
+
+// maxCloseReasonSize is the maximum size of a close frame reason allowed by RFC 6455.
+const maxCloseReasonSize = 123
+
+// CloseReason is the JSON payload sent in the reason of a close frame so clients can decide how to reconnect.
+type CloseReason struct {
+	Reason       string `json:"reason"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+	AltHub       string `json:"alt_hub,omitempty"`
+	// Handoff is the token resuming the closed connection's rooms on the next connection
+	Handoff string `json:"handoff,omitempty"`
+}
+
+// ToJSON encodes the close reason, dropping the alternate hub address if the payload would not fit in a close frame.
+func (cr CloseReason) ToJSON() []byte {
+	data, err := json.Marshal(cr)
+	if err == nil && len(data) <= maxCloseReasonSize {
+		return data
+	}
+
+	cr.AltHub = ""
+	data, err = json.Marshal(cr)
+	if err != nil || len(data) > maxCloseReasonSize {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,89 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// Codec converts payloads between JSON, the form in which the hub delivers, transforms and stores
+// them, and the encoding of a content type, such as Avro with schemas looked up in a schema registry.
+type Codec interface {
+	// Encode converts a JSON payload to the content type's encoding.
+	Encode(ctx context.Context, payload []byte) ([]byte, error)
+	// Decode converts a payload in the content type's encoding to JSON.
+	Decode(ctx context.Context, data []byte) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+// RegisterCodec registers the codec of a content type, replacing any codec registered for it.
+// Parameters of the content type are ignored. Every hub exchanging the content type through the
+// broker must register the same codec.
+func RegisterCodec(contentType string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs[mediaType(contentType)] = codec
+}
+
+// LookupCodec returns the codec registered for a content type.
+func LookupCodec(contentType string) (Codec, bool) {
+	if contentType == "" {
+		return nil, false
+	}
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[mediaType(contentType)]
+	return codec, ok
+}
+
+func mediaType(contentType string) string {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mt
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// EncodePayload converts the JSON payload to the encoding of the envelope's content type for
+// transit, when a codec is registered for it, and records that it did.
+func (md *MessageDetails) EncodePayload(ctx context.Context) error {
+	codec, ok := LookupCodec(md.ContentType)
+	if !ok || md.Encoded {
+		return nil
+	}
+
+	data, err := codec.Encode(ctx, md.Message)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s payload: %w", md.ContentType, err)
+	}
+	md.Message = data
+	md.Encoded = true
+	return nil
+}
+
+// DecodePayload restores the JSON payload of an envelope encoded by EncodePayload.
+func (md *MessageDetails) DecodePayload(ctx context.Context) error {
+	if !md.Encoded {
+		return nil
+	}
+
+	codec, ok := LookupCodec(md.ContentType)
+	if !ok {
+		return fmt.Errorf("no codec registered for content type %q", md.ContentType)
+	}
+	payload, err := codec.Decode(ctx, md.Message)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s payload: %w", md.ContentType, err)
+	}
+	md.Message = payload
+	md.Encoded = false
+	return nil
+}
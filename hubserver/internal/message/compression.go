@@ -0,0 +1,64 @@
+package message
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings of compressed envelope payloads.
+const (
+	EncodingNone   = ""
+	EncodingSnappy = "snappy"
+	EncodingZstd   = "zstd"
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Compress compresses the payload with the encoding when it is larger than threshold bytes and
+// records the encoding in the envelope. Payloads that are already encoded are left alone.
+func (md *MessageDetails) Compress(encoding string, threshold int) error {
+	if encoding == EncodingNone || md.ContentEncoding != EncodingNone || len(md.Message) <= threshold {
+		return nil
+	}
+
+	switch encoding {
+	case EncodingSnappy:
+		md.Message = snappy.Encode(nil, md.Message)
+	case EncodingZstd:
+		md.Message = zstdEncoder.EncodeAll(md.Message, nil)
+	default:
+		return fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	md.ContentEncoding = encoding
+	return nil
+}
+
+// Decompress restores the payload of an envelope compressed by Compress.
+func (md *MessageDetails) Decompress() error {
+	var (
+		payload []byte
+		err     error
+	)
+	switch md.ContentEncoding {
+	case EncodingNone:
+		return nil
+	case EncodingSnappy:
+		payload, err = snappy.Decode(nil, md.Message)
+	case EncodingZstd:
+		payload, err = zstdDecoder.DecodeAll(md.Message, nil)
+	default:
+		return fmt.Errorf("unsupported content encoding %q", md.ContentEncoding)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode %s payload: %w", md.ContentEncoding, err)
+	}
+
+	md.Message = payload
+	md.ContentEncoding = EncodingNone
+	return nil
+}
@@ -0,0 +1,61 @@
+package message
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Cipher seals and opens envelope payloads with the keys it holds, named by an id recorded in the
+// envelope so receivers open them with the key they were sealed with while keys rotate.
+type Cipher interface {
+	// Seal encrypts the payload, authenticating the additional data with it, and returns the id of
+	// the key it used
+	Seal(payload, additionalData []byte) (keyID string, sealed []byte, err error)
+	// Open decrypts a payload sealed with the key of the id
+	Open(keyID string, sealed, additionalData []byte) ([]byte, error)
+}
+
+// Encrypt seals the payload with the cipher and records the id of its key in the envelope. The
+// envelope's id, kind, hub, target and room are authenticated with the payload, so a sealed payload
+// cannot be moved to another envelope. Envelopes are encrypted after they are encoded and
+// compressed, as ciphertext does not compress. Payloads that are already encrypted are left alone.
+func (md *MessageDetails) Encrypt(c Cipher) error {
+	if md.KeyID != "" {
+		return nil
+	}
+
+	keyID, sealed, err := c.Seal(md.Message, md.additionalData())
+	if err != nil {
+		return fmt.Errorf("failed to encrypt payload: %w", err)
+	}
+	md.Message = sealed
+	md.KeyID = keyID
+	return nil
+}
+
+// Decrypt restores the payload of an envelope encrypted by Encrypt. Envelopes that are not
+// encrypted are left alone.
+func (md *MessageDetails) Decrypt(c Cipher) error {
+	if md.KeyID == "" {
+		return nil
+	}
+
+	payload, err := c.Open(md.KeyID, md.Message, md.additionalData())
+	if err != nil {
+		return fmt.Errorf("failed to decrypt payload with key %q: %w", md.KeyID, err)
+	}
+	md.Message = payload
+	md.KeyID = ""
+	return nil
+}
+
+// additionalData returns the envelope fields authenticated with an encrypted payload, each prefixed
+// with its length like the signed fields.
+func (md *MessageDetails) additionalData() []byte {
+	var data []byte
+	for _, field := range []string{md.ID, md.Kind, md.HubID, md.TargetID, md.Room} {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(field)))
+		data = append(data, field...)
+	}
+	return data
+}
@@ -0,0 +1,152 @@
+package message
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Subprotocol is the WebSocket subprotocol negotiated by clients that exchange JSON frames with the hub.
+// Clients that do not negotiate it send and receive raw message payloads.
+const Subprotocol = "hub.v1"
+
+// ProtocolVersion is the revision of the hub.v1 protocol the hub speaks. Clients announce theirs
+// when they connect; those predating version negotiation speak version 0.
+const ProtocolVersion = 1
+
+// EchoRoom is the reserved diagnostic room whose messages the hub reflects back to their sender
+// alone, for clients to measure round trips and verify connectivity.
+const EchoRoom = "__echo__"
+
+// Frame types exchanged with clients that negotiated the hub subprotocol.
+const (
+	FrameMessage = "message"
+	FrameAck     = "ack"
+	FrameReceipt = "receipt"
+	FrameChunk   = "chunk"
+	FrameJoin    = "join"
+	FrameLeave   = "leave"
+	FrameCredit  = "credit"
+	FrameError   = "error"
+	FrameAuth    = "auth"
+	FrameRequest = "request"
+	FrameReply   = "reply"
+	FrameNack    = "nack"
+	// FrameDeprecation warns a client that the hub will stop accepting its protocol version
+	FrameDeprecation = "deprecation"
+	// FrameWhoami asks the hub for the state of the client's connection, which it answers with
+	FrameWhoami = "whoami"
+	// FrameConfig pushes the client configuration set through the admin API to a client
+	FrameConfig = "config"
+)
+
+// AuthAccepted is the status of the auth frame the hub answers an accepted auth frame with.
+const AuthAccepted = "accepted"
+
+// Receipt statuses carried in receipt frames.
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// Reasons carried in nack frames for messages that reached no connection.
+const (
+	// NackNoRecipients means no connection of any hub was subscribed
+	NackNoRecipients = "no_recipients"
+	// NackQueuesFull means every subscribed connection's queue was full
+	NackQueuesFull = "queues_full"
+)
+
+// Frame represents a JSON frame exchanged with clients that negotiated the hub subprotocol.
+type Frame struct {
+	Type        string          `json:"type"`
+	ID          string          `json:"id,omitempty"`
+	OriginID    string          `json:"origin_id,omitempty"`
+	HubID       string          `json:"hub_id,omitempty"`
+	Room        string          `json:"room,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Receipt     bool            `json:"receipt,omitempty"`
+	Nack        bool            `json:"nack,omitempty"`
+	Ephemeral   bool            `json:"ephemeral,omitempty"`
+	Local       bool            `json:"local,omitempty"`
+	Status      string          `json:"status,omitempty"`
+	Count       int             `json:"count,omitempty"`
+	RecipientID string          `json:"recipient_id,omitempty"`
+	Seq         int             `json:"seq,omitempty"`
+	Total       int             `json:"total,omitempty"`
+	Data        []byte          `json:"data,omitempty"`
+	DeliverAt   *time.Time      `json:"deliver_at,omitempty"`
+	TTL         int64           `json:"ttl,omitempty"`
+	Nonce       string          `json:"nonce,omitempty"`
+	Timestamp   int64           `json:"ts,omitempty"`
+	Signature   string          `json:"signature,omitempty"`
+	ContentType string          `json:"content_type,omitempty"`
+	Reason      string          `json:"reason,omitempty"`
+	Cursor      string          `json:"cursor,omitempty"`
+	RoomSeq     uint64          `json:"room_seq,omitempty"`
+	Sequence    uint64          `json:"sequence,omitempty"`
+	Token       string          `json:"token,omitempty"`
+	Key         string          `json:"key,omitempty"`
+	RoutingKey  string          `json:"routing_key,omitempty"`
+	IngestedAt  int64           `json:"ingested_at,omitempty"`
+	HLC         string          `json:"hlc,omitempty"`
+	// Annotations are the server-side fields added to a message by the hub that received it
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Patch replaces the payload of a state room message with the JSON Patch (RFC 6902) turning the
+	// previous payload of its key delivered to the client into it
+	Patch json.RawMessage `json:"patch,omitempty"`
+	// Service names the user whose connections serve a request, and CorrelationID pairs the
+	// request with its reply
+	Service       string `json:"service,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// ProtocolVersion is the version a deprecation frame's client connected with, MinProtocolVersion
+	// the version it needs from Cutoff on
+	ProtocolVersion    int        `json:"protocol_version,omitempty"`
+	MinProtocolVersion int        `json:"min_protocol_version,omitempty"`
+	Cutoff             *time.Time `json:"cutoff,omitempty"`
+	// Filter is the expression selecting the messages of the room a join frame subscribes to
+	Filter string `json:"filter,omitempty"`
+	// Echo overrides the echo policy of the connection for the message a frame publishes
+	Echo string `json:"echo,omitempty"`
+}
+
+// NewMessageFrame creates the frame used to deliver a message to a client.
+func NewMessageFrame(md *MessageDetails) Frame {
+	return Frame{
+		Type:        FrameMessage,
+		ID:          md.ID,
+		OriginID:    md.OriginID,
+		Room:        md.Room,
+		Payload:     payloadJSON(md.Message),
+		Receipt:     md.Receipt,
+		Ephemeral:   md.Ephemeral,
+		ContentType: md.ContentType,
+		Cursor:      md.Cursor,
+		RoomSeq:     md.RoomSeq,
+		Sequence:    md.Sequence,
+		Key:         md.Key,
+		IngestedAt:  md.IngestedAt,
+		HLC:         md.HLC,
+		Annotations: md.Annotations,
+	}
+}
+
+// ToJSON converts the Frame to a JSON string.
+func (f *Frame) ToJSON() ([]byte, error) {
+	return json.Marshal(f)
+}
+
+// FromJSON populates the Frame from a JSON string.
+func (f *Frame) FromJSON(data []byte) error {
+	return json.Unmarshal(data, f)
+}
+
+// payloadJSON returns the payload as-is when it is valid JSON, otherwise as a JSON string.
+// Payloads from raw clients are arbitrary text and must be quoted to be embedded in a frame.
+func payloadJSON(payload []byte) json.RawMessage {
+	if json.Valid(payload) {
+		return payload
+	}
+
+	quoted, _ := json.Marshal(string(payload))
+	return quoted
+}
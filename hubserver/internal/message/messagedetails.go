@@ -1,13 +1,124 @@
 package message
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
-// MessageDetails represents a WebSocket message.
+// Message kinds carried in the envelope.
+const (
+	// KindMessage is a regular message broadcast to connections.
+	KindMessage = ""
+	// KindControl carries a pre-encoded control frame addressed to a single connection.
+	KindControl = "control"
+	// KindEvict asks the hub holding the target connection to close it.
+	KindEvict = "evict"
+	// KindRequest carries an encoded request frame for a connection of the service it names.
+	KindRequest = "request"
+	// KindReply carries an encoded reply frame for the requesting connection.
+	KindReply = "reply"
+	// KindHeartbeat announces the hub that published it to the other hubs, which measure their
+	// delay and loss from it.
+	KindHeartbeat = "heartbeat"
+)
+
+// Echo policies, naming which connections of the user that published a message receive it.
+const (
+	// EchoOthers delivers the message to the user's other connections but not to the one that
+	// published it; it is the default, carried as an empty policy
+	EchoOthers = "others"
+	// EchoAll delivers the message to the publishing connection as well, for clients taking the
+	// echo as the source of truth for what the hub accepted
+	EchoAll = "all"
+	// EchoNone delivers the message to none of the user's connections, on any hub
+	EchoNone = "none"
+)
+
+// ValidEcho reports whether the echo policy is known, the empty default included.
+func ValidEcho(echo string) bool {
+	return echo == "" || echo == EchoOthers || echo == EchoAll || echo == EchoNone
+}
+
+// Timing holds the times, in Unix nanoseconds, at which a message was read from its publisher's
+// connection, queued for broadcasting, taken in a batch by a broadcast worker and fanned out to the
+// connections of the hub, zero for the stages it skipped, such as reading for messages from other hubs.
+type Timing struct {
+	Read      int64
+	Queued    int64
+	Batched   int64
+	FannedOut int64
+}
+
+// MessageDetails represents a WebSocket message. Ephemeral messages are never persisted or
+// retried and are the first to be dropped under backpressure. Local messages are delivered only
+// to connections of the hub that received them and are not forwarded to the broker. Messages
+// published to a room are delivered only to connections subscribed to it, otherwise to every connection.
+// Messages asking for a nack are answered with a nack frame when they reach no connection.
 type MessageDetails struct {
-	OriginID string `json:"origin_id"`
-	HubID    string `json:"hub_id"`
-	SenderID string `json:"sender_id"`
-	Message  []byte `json:"message"`
+	ID        string `json:"id,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	OriginID  string `json:"origin_id"`
+	HubID     string `json:"hub_id"`
+	SenderID  string `json:"sender_id"`
+	TargetID  string `json:"target_id,omitempty"`
+	Room      string `json:"room,omitempty"`
+	Message   []byte `json:"message"`
+	Receipt   bool   `json:"receipt,omitempty"`
+	Ephemeral bool   `json:"ephemeral,omitempty"`
+	Local     bool   `json:"local,omitempty"`
+	Nack      bool   `json:"nack,omitempty"`
+	// Echo is the message's echo policy, empty for EchoOthers, and UserID the user of the
+	// connection that published it, set for EchoNone only
+	Echo   string `json:"echo,omitempty"`
+	UserID string `json:"user_id,omitempty"`
+	// ExpiresAt is the unix time in milliseconds after which the message is no longer worth
+	// delivering; zero means it never expires
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// IngestedAt is the unix time in milliseconds at which the hub that received the message from
+	// its publisher took it in, and HLC its hybrid logical timestamp then, ordering messages across
+	// hubs with skewed clocks
+	IngestedAt int64  `json:"ingested_at,omitempty"`
+	HLC        string `json:"hlc,omitempty"`
+	// Annotations are the fields the enrichers of the hub that received the message added about
+	// its publisher, such as their display name or country
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Zone is the zone or region of the hub that published the message to the broker
+	Zone string `json:"zone,omitempty"`
+	// Hops lists the hubs that published the message to a broker, in order, for hubs to drop
+	// messages that come back to them
+	Hops []string `json:"hops,omitempty"`
+	// Cursor is the message's position in its room's history, when the room keeps history
+	Cursor string `json:"cursor,omitempty"`
+	// Key identifies the value the message sets in a state room, which keeps the latest message of each key
+	Key string `json:"key,omitempty"`
+	// Sequence is the message's number in its room, issued once by the hub that received it for
+	// sequenced rooms; it orders the room's messages, unlike RoomSeq, across connections and hubs
+	Sequence uint64 `json:"sequence,omitempty"`
+	// RoomSeq numbers the messages of a room delivered to one connection; it is set on the
+	// connection's copy and never leaves the hub
+	RoomSeq uint64 `json:"-"`
+	// Timing records when the message entered the stages of the broadcast pipeline of the hub
+	// handling it; it never leaves the hub either
+	Timing Timing `json:"-"`
+	// Frame is the frame encoded once for the copies of a broadcast queued for the hub's
+	// connections; it never leaves the hub either
+	Frame *SharedFrame `json:"-"`
+	// Sealed is the envelope as the broker delivered it, still encrypted, when the hub decrypted
+	// it, for the envelope to be dead-lettered without its payload in the clear; it never leaves
+	// the hub either
+	Sealed []byte `json:"-"`
+
+	// ContentType is the media type of the payload, which is JSON in the hub; Encoded reports that
+	// Message is in the encoding of the content type's codec in transit
+	ContentType string `json:"content_type,omitempty"`
+	Encoded     bool   `json:"encoded,omitempty"`
+
+	// ContentEncoding names the compression applied to Message in transit, if any
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// KeyID names the key Message is encrypted with in transit, if any
+	KeyID string `json:"key_id,omitempty"`
+	// Signature authenticates the envelope between hubs sharing an envelope signing secret
+	Signature string `json:"signature,omitempty"`
 }
 
 // NewMessageDetails creates a new MessageDetails instance.
@@ -20,14 +131,86 @@ func NewMessageDetails(originID, hubID, senderID string, message []byte) Message
 	}
 }
 
+// NewControlMessageDetails creates an envelope carrying an encoded control frame for the target connection.
+func NewControlMessageDetails(hubID, targetID string, frame []byte) MessageDetails {
+	return MessageDetails{
+		Kind:     KindControl,
+		HubID:    hubID,
+		SenderID: hubID,
+		TargetID: targetID,
+		Message:  frame,
+	}
+}
+
+// NewEvictMessageDetails creates an envelope asking the hub holding the target connection to evict it.
+func NewEvictMessageDetails(hubID, targetID string) MessageDetails {
+	return MessageDetails{
+		Kind:     KindEvict,
+		HubID:    hubID,
+		SenderID: hubID,
+		TargetID: targetID,
+	}
+}
+
+// NewRequestMessageDetails creates an envelope carrying an encoded request frame to the hubs
+// holding a connection of the service it names.
+func NewRequestMessageDetails(hubID string, frame []byte) MessageDetails {
+	return MessageDetails{
+		Kind:     KindRequest,
+		HubID:    hubID,
+		SenderID: hubID,
+		Message:  frame,
+	}
+}
+
+// NewReplyMessageDetails creates an envelope carrying an encoded reply frame for the requesting connection.
+func NewReplyMessageDetails(hubID, targetID string, frame []byte) MessageDetails {
+	return MessageDetails{
+		Kind:     KindReply,
+		HubID:    hubID,
+		SenderID: hubID,
+		TargetID: targetID,
+		Message:  frame,
+	}
+}
+
+// NewHeartbeatMessageDetails creates an envelope announcing the hub to the other hubs, with its
+// heartbeat encoded in the payload.
+func NewHeartbeatMessageDetails(hubID string, heartbeat []byte) MessageDetails {
+	return MessageDetails{
+		Kind:     KindHeartbeat,
+		HubID:    hubID,
+		SenderID: hubID,
+		Message:  heartbeat,
+	}
+}
+
+// IsControl checks if the message carries a control frame rather than a broadcast payload.
+func (md *MessageDetails) IsControl() bool {
+	return md.Kind == KindControl
+}
+
+// Expired reports whether the message has a TTL that elapsed before now.
+func (md *MessageDetails) Expired(now time.Time) bool {
+	return md.ExpiresAt > 0 && now.UnixMilli() >= md.ExpiresAt
+}
+
 // IsFromPubSub checks if the message is from the Pub/Sub channel.
 func (md *MessageDetails) IsFromPubSub(pubSubChannel string) bool {
 	return md.SenderID == pubSubChannel
 }
 
-// ShouldBroadcastToClient checks if the message should be broadcast to a given client.
-func (md *MessageDetails) ShouldBroadcastToClient(clientID string) bool {
-	return md.OriginID != clientID
+// ShouldBroadcastToClient checks if the message should be broadcast to a given client, a
+// connection of the user, under the message's echo policy.
+func (md *MessageDetails) ShouldBroadcastToClient(clientID, userID string) bool {
+	switch md.Echo {
+	case EchoAll:
+		return true
+	case EchoNone:
+		return md.OriginID != clientID && (md.UserID == "" || md.UserID != userID)
+	default:
+		return md.OriginID != clientID
+	}
 }
 
 // ToJSON converts the MessageDetails to a JSON string.
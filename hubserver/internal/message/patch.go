@@ -0,0 +1,149 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Operations of JSON Patches.
+const (
+	PatchAdd     = "add"
+	PatchRemove  = "remove"
+	PatchReplace = "replace"
+)
+
+// PatchOp is an operation of a JSON Patch (RFC 6902).
+type PatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// Diff returns the JSON Patch turning the JSON document from into the document to, made of add,
+// remove and replace operations only. It reports false when either document is not valid JSON.
+// Arrays are patched element by element, with additions and removals at their end, so inserting
+// near the start of a long array gives a long patch.
+func Diff(from, to []byte) ([]byte, bool) {
+	a, ok := decodeValue(from)
+	if !ok {
+		return nil, false
+	}
+	b, ok := decodeValue(to)
+	if !ok {
+		return nil, false
+	}
+
+	ops := []PatchOp{}
+	if !diffValues("", a, b, &ops) {
+		return nil, false
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return nil, false
+	}
+	return patch, true
+}
+
+// decodeValue decodes a JSON document, keeping numbers as written.
+func decodeValue(data []byte) (any, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return nil, false
+	}
+	return value, true
+}
+
+// diffValues appends the operations turning a into b at the JSON pointer path to ops.
+func diffValues(path string, a, b any, ops *[]PatchOp) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			return diffObjects(path, a, b, ops)
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			return diffArrays(path, a, b, ops)
+		}
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	return appendOp(ops, PatchReplace, path, b)
+}
+
+func diffObjects(path string, a, b map[string]any, ops *[]PatchOp) bool {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		child := path + "/" + pointerEscaper.Replace(key)
+		av, inA := a[key]
+		bv, inB := b[key]
+		var ok bool
+		switch {
+		case !inB:
+			ok = appendOp(ops, PatchRemove, child, nil)
+		case !inA:
+			ok = appendOp(ops, PatchAdd, child, bv)
+		default:
+			ok = diffValues(child, av, bv, ops)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func diffArrays(path string, a, b []any, ops *[]PatchOp) bool {
+	common := min(len(a), len(b))
+	for i := 0; i < common; i++ {
+		if !diffValues(path+"/"+strconv.Itoa(i), a[i], b[i], ops) {
+			return false
+		}
+	}
+	for i := common; i < len(b); i++ {
+		if !appendOp(ops, PatchAdd, path+"/-", b[i]) {
+			return false
+		}
+	}
+	// Removing from the end keeps the indexes of the elements still to remove
+	for i := len(a) - 1; i >= common; i-- {
+		if !appendOp(ops, PatchRemove, path+"/"+strconv.Itoa(i), nil) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendOp appends an operation to ops, with the value for add and replace operations.
+func appendOp(ops *[]PatchOp, op, path string, value any) bool {
+	patchOp := PatchOp{Op: op, Path: path}
+	if op != PatchRemove {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return false
+		}
+		patchOp.Value = data
+	}
+	*ops = append(*ops, patchOp)
+	return true
+}
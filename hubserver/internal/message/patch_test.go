@@ -0,0 +1,157 @@
+package message
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// applyPatch applies a JSON Patch of add, remove and replace operations to a decoded document, as
+// the clients do.
+func applyPatch(t *testing.T, doc any, patch []byte) any {
+	t.Helper()
+
+	var ops []PatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		t.Fatalf("patch %s: %v", patch, err)
+	}
+	for _, op := range ops {
+		var value any
+		if op.Op != PatchRemove {
+			var ok bool
+			if value, ok = decodeValue(op.Value); !ok {
+				t.Fatalf("operation %+v has an invalid value", op)
+			}
+		}
+		if op.Path == "" {
+			if op.Op != PatchReplace {
+				t.Fatalf("operation %+v on the whole document", op)
+			}
+			doc = value
+			continue
+		}
+		doc = applyOp(t, doc, strings.Split(op.Path, "/")[1:], op, value)
+	}
+	return doc
+}
+
+// applyOp applies an operation at the reference tokens of its path into parent.
+func applyOp(t *testing.T, parent any, tokens []string, op PatchOp, value any) any {
+	t.Helper()
+
+	token := pointerUnescaper.Replace(tokens[0])
+	switch p := parent.(type) {
+	case map[string]any:
+		if len(tokens) > 1 {
+			p[token] = applyOp(t, p[token], tokens[1:], op, value)
+			return p
+		}
+		if _, ok := p[token]; ok == (op.Op == PatchAdd) {
+			t.Fatalf("operation %+v on an object holding %v", op, p)
+		}
+		if op.Op == PatchRemove {
+			delete(p, token)
+		} else {
+			p[token] = value
+		}
+		return p
+	case []any:
+		if token == "-" && len(tokens) == 1 && op.Op == PatchAdd {
+			return append(p, value)
+		}
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i >= len(p) {
+			t.Fatalf("operation %+v on an array of %d elements", op, len(p))
+		}
+		switch {
+		case len(tokens) > 1:
+			p[i] = applyOp(t, p[i], tokens[1:], op, value)
+		case op.Op == PatchRemove:
+			return append(p[:i], p[i+1:]...)
+		case op.Op == PatchReplace:
+			p[i] = value
+		default:
+			t.Fatalf("operation %+v inserts into an array", op)
+		}
+		return p
+	}
+	t.Fatalf("operation %+v on %v", op, parent)
+	return nil
+}
+
+func TestDiffPatchesFromIntoTo(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+	}{
+		{`{"a":1}`, `{"a":1}`},
+		{`{"a":1,"b":2}`, `{"a":1,"b":3,"c":4}`},
+		{`{"a":1,"b":2}`, `{"b":2}`},
+		{`{"order":{"side":"buy","qty":10,"fills":{"1":5}}}`, `{"order":{"side":"sell","qty":10,"fills":{"1":5,"2":5}}}`},
+		{`{"order":{"side":"buy"}}`, `{"order":"cancelled"}`},
+		{`{"a":{"b":{"c":{"d":1}}}}`, `{"a":{"b":{"c":{"d":2,"e":[1]}}}}`},
+
+		// keys with ~ and / are escaped in the paths
+		{`{"a/b":1,"c~d":2,"~1":3}`, `{"a/b":2,"c~d":3,"~1":4}`},
+		{`{"a/b":{"~":1}}`, `{"a/b":{"~":2,"/":3}}`},
+		{`{"a/b":1}`, `{"c~d":1}`},
+
+		// arrays grow and shrink at their end
+		{`[1,2]`, `[1,2,3,4]`},
+		{`[1,2,3,4]`, `[1,2]`},
+		{`[1,2,3]`, `[]`},
+		{`[]`, `[{"a":1}]`},
+		{`{"items":[{"id":1,"tags":["x"]},{"id":2}]}`, `{"items":[{"id":1,"tags":["x","y"]}]}`},
+		{`{"items":[[1,2],[3]]}`, `{"items":[[1],[3,4],[5]]}`},
+
+		// values change type and numbers keep their precision
+		{`{"a":[1]}`, `{"a":{"0":1}}`},
+		{`{"a":1}`, `{"a":null}`},
+		{`{"n":12345678901234567890}`, `{"n":12345678901234567891}`},
+		{`{"a":1}`, `[1]`},
+		{`"x"`, `"y"`},
+		{`null`, `{"a":1}`},
+		{`{"a":1}`, `null`},
+	} {
+		patch, ok := Diff([]byte(tc.from), []byte(tc.to))
+		if !ok {
+			t.Fatalf("Diff(%s, %s) failed", tc.from, tc.to)
+		}
+		from, _ := decodeValue([]byte(tc.from))
+		to, _ := decodeValue([]byte(tc.to))
+		if got := applyPatch(t, from, patch); !reflect.DeepEqual(got, to) {
+			t.Errorf("patch %s turned %s into %v, want %s", patch, tc.from, got, tc.to)
+		}
+		if tc.from == tc.to && string(patch) != "[]" {
+			t.Errorf("patch between equal documents %s is %s", tc.from, patch)
+		}
+	}
+}
+
+func TestDiffEscapesPaths(t *testing.T) {
+	patch, ok := Diff([]byte(`{"a/b":{"c~d":1}}`), []byte(`{"a/b":{"c~d":2}}`))
+	if !ok {
+		t.Fatal("Diff failed")
+	}
+	if want := `[{"op":"replace","path":"/a~1b/c~0d","value":2}]`; string(patch) != want {
+		t.Fatalf("patch is %s, want %s", patch, want)
+	}
+}
+
+func TestDiffRejectsInvalidDocuments(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+	}{
+		{`{"a":`, `{"a":1}`},
+		{`{"a":1}`, `not json`},
+		{`{"a":1} {"b":2}`, `{"a":1}`},
+		{``, `{}`},
+	} {
+		if patch, ok := Diff([]byte(tc.from), []byte(tc.to)); ok {
+			t.Errorf("Diff(%s, %s) = %s", tc.from, tc.to, patch)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package message
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// SharedFrame is the message frame of a broadcast, encoded once on first use for all the
+// connections the message is queued for rather than once per connection. Their frames differ only
+// in the room_seq numbering the message for each connection, which AppendTo adds.
+type SharedFrame struct {
+	once sync.Once
+	data []byte
+	err  error
+	// queued counts the copies of the broadcast waiting in the write queues of connections
+	queued atomic.Int32
+}
+
+// Queue records a copy of the broadcast queued for a connection and reports whether it is the only
+// one queued, so the payload the copies share is accounted for once.
+func (s *SharedFrame) Queue() bool {
+	return s.queued.Add(1) == 1
+}
+
+// Dequeue records a copy of the broadcast taken from a connection's queue and reports whether it
+// was the last one queued.
+func (s *SharedFrame) Dequeue() bool {
+	return s.queued.Add(-1) == 0
+}
+
+// AppendTo appends the message frame delivering md, a connection's copy of the broadcast, to dst.
+// The copies of the broadcast must only differ in their RoomSeq.
+func (s *SharedFrame) AppendTo(dst []byte, md *MessageDetails) ([]byte, error) {
+	s.once.Do(func() {
+		frame := NewMessageFrame(md)
+		frame.RoomSeq = 0
+		s.data, s.err = frame.ToJSON()
+	})
+	if s.err != nil {
+		return dst, s.err
+	}
+	if md.RoomSeq == 0 {
+		return append(dst, s.data...), nil
+	}
+
+	// The frame is a JSON object: add room_seq before its closing brace
+	dst = append(dst, s.data[:len(s.data)-1]...)
+	dst = append(dst, `,"room_seq":`...)
+	dst = strconv.AppendUint(dst, md.RoomSeq, 10)
+	return append(dst, '}'), nil
+}
@@ -0,0 +1,410 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "hubserver"
+
+// ConnectionsRejected counts WebSocket upgrade attempts rejected before the upgrade, and connections
+// closed for failing to authenticate after it, labelled by reason.
+var ConnectionsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "connections_rejected_total",
+	Help:      "Number of WebSocket connection attempts rejected before the upgrade.",
+}, []string{"reason"})
+
+// Handoffs counts the connection states saved by draining hubs and the handoffs resumed by
+// reconnecting clients, labelled by outcome: saved, resumed or unknown for expired or foreign tokens.
+var Handoffs = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "handoffs_total",
+	Help:      "Number of drained connection states saved and resumed, by outcome.",
+}, []string{"outcome"})
+
+// AwaitingReconnection is the number of clients a restarted hub handed off while draining before the
+// restart that are yet to reconnect, telling restarts apart from churn.
+var AwaitingReconnection = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "awaiting_reconnection",
+	Help:      "Number of clients handed off before the hub restarted that are yet to reconnect.",
+})
+
+// Echoes counts the messages reflected back to their sender by the echo room.
+var Echoes = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "echoes_total",
+	Help:      "Number of messages echoed back to their sender by the echo room.",
+})
+
+// Nacks counts the nack frames sent to publishers of messages that reached no connection, by reason.
+var Nacks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "nacks_total",
+	Help:      "Number of nack frames sent for messages that reached no connection, by reason.",
+}, []string{"reason"})
+
+// MessagesShed counts messages shed from full connection queues, labelled by class: ephemeral and
+// normal messages, or control when a connection is closed rather than shed a control frame.
+var MessagesShed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "messages_shed_total",
+	Help:      "Number of messages shed from full connection queues, by class.",
+}, []string{"class"})
+
+// MessagesFiltered counts messages withheld from connections by the filter they joined the room with.
+var MessagesFiltered = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "messages_filtered_total",
+	Help:      "Number of room messages withheld from connections by their subscription filter.",
+})
+
+// MessagesDropped counts messages received from clients and dropped before broadcasting, labelled by reason.
+var MessagesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "messages_dropped_total",
+	Help:      "Number of client messages dropped before broadcasting.",
+}, []string{"reason"})
+
+// GoroutinePanics counts panics recovered by the goroutine supervisor, labelled by goroutine.
+var GoroutinePanics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "goroutine_panics_total",
+	Help:      "Number of panics recovered in supervised goroutines.",
+}, []string{"goroutine"})
+
+// BufferLength reports the number of queued items in the hub's channels, labelled by buffer.
+// Per-connection buffers report the total across connections.
+var BufferLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "buffer_length",
+	Help:      "Number of items queued in the hub's buffers.",
+}, []string{"buffer"})
+
+// BufferCapacity reports the capacity of the hub's channels, labelled by buffer.
+// Per-connection buffers report the total across connections.
+var BufferCapacity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "buffer_capacity",
+	Help:      "Capacity of the hub's buffers.",
+}, []string{"buffer"})
+
+// BufferMaxSaturation reports the fullest instance of each buffer as a ratio of its capacity.
+var BufferMaxSaturation = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "buffer_max_saturation_ratio",
+	Help:      "Occupancy of the fullest instance of each buffer as a ratio of its capacity.",
+}, []string{"buffer"})
+
+// FaultsInjected counts the failures injected by the chaos fault injector, labelled by fault.
+var FaultsInjected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "faults_injected_total",
+	Help:      "Number of failures injected for resilience testing.",
+}, []string{"fault"})
+
+// BroadcastBatchSize observes the number of messages fanned out together by a broadcast worker.
+var BroadcastBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "broadcast_batch_size",
+	Help:      "Number of messages fanned out to connections in one pass.",
+	Buckets:   prometheus.ExponentialBuckets(1, 2, 9),
+})
+
+// WriteRetries counts writes to clients resumed after timing out.
+var WriteRetries = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "write_retries_total",
+	Help:      "Number of writes to clients resumed after a transient timeout.",
+})
+
+// HalfOpenConnections counts connections closed after leaving too many pings unanswered.
+var HalfOpenConnections = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "half_open_connections_total",
+	Help:      "Number of connections closed because the client stopped answering pings.",
+})
+
+// PeerPropagationSeconds observes the time heartbeats of other hubs took to reach the hub through
+// the broker, labelled by the hub that sent them.
+var PeerPropagationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "peer_propagation_seconds",
+	Help:      "Time heartbeats of peer hubs took to arrive through the broker, by peer.",
+	Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+}, []string{"peer"})
+
+// PeerHeartbeatsLost counts heartbeats of other hubs that never arrived, labelled by the hub that sent them.
+var PeerHeartbeatsLost = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "peer_heartbeats_lost_total",
+	Help:      "Number of heartbeats of peer hubs missing from those received, by peer.",
+}, []string{"peer"})
+
+// PeerHubs reports the peer hubs heard from, labelled by whether their heartbeats are arriving.
+var PeerHubs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "peer_hubs",
+	Help:      "Number of peer hubs heard from, by health (healthy or stale).",
+}, []string{"health"})
+
+// SessionsResumed counts connections continuing the session of a previous connection of their client.
+var SessionsResumed = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "sessions_resumed_total",
+	Help:      "Number of connections that continued the session of a previous connection of their client.",
+})
+
+// RedisCredentialRefreshes counts refreshes of the credentials Redis connections authenticate with by outcome.
+var RedisCredentialRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "redis_credential_refreshes_total",
+	Help:      "Number of Redis credential refreshes by outcome (refreshed or failed).",
+}, []string{"outcome"})
+
+// EncryptionKeyRefreshes counts refreshes of the keys envelope payloads are encrypted with by outcome.
+var EncryptionKeyRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "encryption_key_refreshes_total",
+	Help:      "Number of envelope encryption key refreshes by outcome (refreshed or failed).",
+}, []string{"outcome"})
+
+// ProtocolVersions counts the upgrade requests of hub.v1 clients by the protocol version they announced.
+var ProtocolVersions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "protocol_versions_total",
+	Help:      "Number of hub.v1 upgrade requests by the protocol version the client announced.",
+}, []string{"version"})
+
+// AggregatedMessages counts messages of aggregation rooms combined into the messages delivered per window.
+var AggregatedMessages = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "aggregated_messages_total",
+	Help:      "Number of messages of aggregation rooms combined into one message per window.",
+})
+
+// RoutedMessages counts messages published with a routing key, labelled by whether a routing rule
+// chose their room, they fell back to the room they named or were dropped for having neither.
+var RoutedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "routed_messages_total",
+	Help:      "Number of messages published with a routing key routed by a rule, delivered to their own room or dropped.",
+}, []string{"result"})
+
+// IdleConnectionsClosed counts connections closed after sending no message for the idle timeout.
+var IdleConnectionsClosed = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "idle_connections_closed_total",
+	Help:      "Number of connections closed because the client sent no message for the idle timeout.",
+})
+
+// ExpiredMessages counts messages pruned from connections' write queues because their TTL elapsed.
+var ExpiredMessages = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "expired_messages_total",
+	Help:      "Number of queued messages pruned instead of written because their TTL elapsed.",
+})
+
+// RoomLimitExceeded counts joins refused for exceeding a subscription limit, labelled by the limit:
+// connection or user.
+var RoomLimitExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "room_limit_exceeded_total",
+	Help:      "Number of room joins refused for exceeding the rooms a connection or user may be subscribed to.",
+}, []string{"limit"})
+
+// RoomAccessDenied counts joins and publishes denied by room access control, labelled by action.
+var RoomAccessDenied = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "room_access_denied_total",
+	Help:      "Number of room joins and publishes denied by room access control.",
+}, []string{"action"})
+
+// PushDeliveries counts messages pushed to HTTP subscriptions, labelled by result: delivered, failed or dropped.
+var PushDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "push_deliveries_total",
+	Help:      "Number of messages pushed to HTTP subscriptions by result.",
+}, []string{"result"})
+
+// PushRetries counts push requests retried after a failed attempt.
+var PushRetries = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "push_retries_total",
+	Help:      "Number of push requests retried after a failed attempt.",
+})
+
+// RoomMessages counts messages broadcast to rooms with per-room metrics, labelled by room.
+var RoomMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "room_messages_total",
+	Help:      "Number of messages broadcast to each room.",
+}, []string{"room"})
+
+// RoomDeliveries counts messages queued for the subscribers of rooms with per-room metrics, labelled by room.
+var RoomDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "room_deliveries_total",
+	Help:      "Number of messages queued for the subscribers of each room.",
+}, []string{"room"})
+
+// RoomDrops counts messages dropped for subscribers of rooms with per-room metrics whose write queue was full, labelled by room.
+var RoomDrops = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "room_drops_total",
+	Help:      "Number of messages dropped for subscribers of each room because their write queue was full.",
+}, []string{"room"})
+
+// RoomSubscribers reports the connections subscribed to rooms with per-room metrics, labelled by room.
+var RoomSubscribers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "room_subscribers",
+	Help:      "Number of local connections subscribed to each room.",
+}, []string{"room"})
+
+// ZoneRoutedMessages counts messages published to other hubs with zone-aware routing, labelled by
+// whether they were kept within the zone or sent to every zone.
+var ZoneRoutedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "zone_routed_messages_total",
+	Help:      "Number of messages published to the hub's zone only or to every zone.",
+}, []string{"scope"})
+
+// TargetedMessages counts messages addressed to one connection published with targeted routing,
+// labelled by whether they were routed to the hub holding the connection or sent to every hub.
+var TargetedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "targeted_messages_total",
+	Help:      "Number of messages addressed to one connection routed to its hub or sent to every hub.",
+}, []string{"result"})
+
+// BroadcastLoops counts messages received from the broker that were dropped for having been through
+// the hub before, labelled by whether the hub was among their hops or they exceeded the hop limit.
+var BroadcastLoops = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "broadcast_loops_total",
+	Help:      "Number of messages from the broker dropped for looping back to the hub.",
+}, []string{"reason"})
+
+// DeadLetters counts envelopes received from other hubs recorded in the dead-letter stream,
+// labelled by the reason they were rejected.
+var DeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "dead_letters_total",
+	Help:      "Number of undeliverable envelopes received from other hubs recorded in the dead-letter stream.",
+}, []string{"reason"})
+
+// SpilledMessages counts messages that overflowed the broadcast queue and were spilled to disk.
+var SpilledMessages = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "spilled_messages_total",
+	Help:      "Number of messages spilled to disk because the broadcast queue was full.",
+})
+
+// SpillLength reports the number of messages waiting in the spill queue.
+var SpillLength = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "spill_length",
+	Help:      "Number of messages waiting in the disk-backed spill queue.",
+})
+
+// SpillBytes reports the bytes the spill queue's messages take on disk.
+var SpillBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "spill_bytes",
+	Help:      "Bytes of messages waiting in the disk-backed spill queue.",
+})
+
+// WriteQueueBytes reports the bytes of the messages queued for all connections together.
+var WriteQueueBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "write_queue_bytes",
+	Help:      "Bytes of messages queued for all connections.",
+})
+
+// QueueMemoryEvictions counts connections closed for holding the largest queue while the messages
+// queued for all connections exceeded their memory cap.
+var QueueMemoryEvictions = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "queue_memory_evictions_total",
+	Help:      "Number of connections closed to bring the messages queued for all connections under their memory cap.",
+})
+
+// ConnectionBytes counts the bytes of WebSocket messages read from and written to clients, labelled by direction.
+var ConnectionBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "connection_bytes_total",
+	Help:      "Bytes of messages read from (in) and written to (out) client connections.",
+}, []string{"direction"})
+
+// BandwidthCapsExceeded counts users found over a bandwidth cap, labelled by the cap's period and
+// the action taken.
+var BandwidthCapsExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "bandwidth_caps_exceeded_total",
+	Help:      "Number of times a user exceeded its daily or monthly bandwidth cap.",
+}, []string{"period", "action"})
+
+// HLCDriftRejected counts hybrid logical timestamps received from other hubs that were too far
+// ahead of the hub's clock to advance it.
+var HLCDriftRejected = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "hlc_drift_rejected_total",
+	Help:      "Number of received hybrid logical timestamps ignored for exceeding the maximum drift.",
+})
+
+// StatePatches counts the messages of state rooms written to clients that apply JSON Patches,
+// labelled by whether they were sent as a patch or in full because the patch was not smaller.
+var StatePatches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "state_patches_total",
+	Help:      "Number of state room messages with a previous payload sent as a JSON Patch (patched) or whole (full).",
+}, []string{"result"})
+
+// StatePatchBytesSaved counts the bytes of payloads not written to clients because a smaller JSON
+// Patch was sent instead.
+var StatePatchBytesSaved = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "state_patch_bytes_saved_total",
+	Help:      "Bytes saved by sending state room messages as JSON Patches instead of their payload.",
+})
+
+// PipelineStageSeconds observes the time messages spend in each stage of the broadcast pipeline,
+// labelled by stage.
+var PipelineStageSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "pipeline_stage_seconds",
+	Help:      "Time messages spent in each broadcast pipeline stage: read, ingest_wait, record, fan_out, write_wait, write and publish.",
+	Buckets:   prometheus.ExponentialBuckets(0.00005, 2, 16),
+}, []string{"stage"})
+
+// MirroredMessages counts cross-hub publishes mirrored to the secondary region, labelled by result:
+// mirrored, failed or dropped.
+var MirroredMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "mirrored_messages_total",
+	Help:      "Number of cross-hub publishes mirrored to the secondary region by result.",
+}, []string{"result"})
+
+// MirrorRetries counts mirror publishes retried after a failed attempt.
+var MirrorRetries = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "mirror_retries_total",
+	Help:      "Number of publishes to the secondary region retried after a failed attempt.",
+})
+
+// MirrorQueueLength reports the number of messages waiting for their mirror publish.
+var MirrorQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "mirror_queue_length",
+	Help:      "Number of messages waiting to be mirrored to the secondary region.",
+})
+
+// OutboxMessages counts the rows of outbox tables handled, labelled by result: published, or
+// no_room for rows skipped for naming no room.
+var OutboxMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "outbox_messages_total",
+	Help:      "Number of rows inserted into outbox tables handled by result.",
+}, []string{"result"})
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Sink exposes the metrics of the hub, recorded in the Prometheus default registry, to a monitoring
+// backend. Sinks scraped by their backend serve the metrics with Handler, mounted at /metrics on the
+// admin address; sinks pushing them to their backend do so from Run.
+type Sink interface {
+	// Handler serves the metrics to scrapers, or is nil for sinks pushing them
+	Handler() http.Handler
+	// Run pushes the metrics to the backend until ctx is done; sinks that are scraped return at once
+	Run(ctx context.Context)
+}
+
+// PrometheusSink serves the metrics in the Prometheus exposition format.
+type PrometheusSink struct{}
+
+// Handler serves the metrics of the default registry.
+func (PrometheusSink) Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Run returns at once, as Prometheus scrapes the metrics.
+func (PrometheusSink) Run(context.Context) {}
@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// maxStatsDPacketSize is the size of the UDP packets metrics are batched into, which fit in the
+// MTU of most networks.
+const maxStatsDPacketSize = 1432
+
+// StatsDSink pushes the metrics to a StatsD server over UDP. Counters are sent as the increase
+// since the previous push, gauges as their value, and histograms and summaries as the increase of
+// their count and sum, with the quantiles of summaries as gauges. DogStatsD sinks send labels as
+// tags; plain StatsD has no tags, so label values are appended to the metric name instead.
+type StatsDSink struct {
+	addr      string
+	interval  time.Duration
+	prefix    string
+	tags      []string
+	dogStatsD bool
+	gatherer  prometheus.Gatherer
+	logger    *zap.Logger
+
+	// sent holds the value of every counter series at the previous push, by series
+	sent map[string]float64
+}
+
+// NewStatsDSink creates a new StatsDSink pushing the metrics of the default registry to addr
+// every interval, with their names prefixed with prefix and, for DogStatsD, the tags added.
+func NewStatsDSink(addr string, interval time.Duration, prefix string, tags []string, dogStatsD bool, logger *zap.Logger) *StatsDSink {
+	return &StatsDSink{
+		addr:      addr,
+		interval:  interval,
+		prefix:    prefix,
+		tags:      tags,
+		dogStatsD: dogStatsD,
+		gatherer:  prometheus.DefaultGatherer,
+		logger:    logger,
+		sent:      make(map[string]float64),
+	}
+}
+
+// Handler returns nil, as the metrics are pushed.
+func (s *StatsDSink) Handler() http.Handler {
+	return nil
+}
+
+// Run pushes the metrics every interval until ctx is done, and once more before returning so the
+// last increases of the counters are not lost.
+func (s *StatsDSink) Run(ctx context.Context) {
+	conn, err := net.Dial("udp", s.addr)
+	if err != nil {
+		s.logger.Error("Failed to dial StatsD, metrics will not be pushed", zap.String("addr", s.addr), zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.flush(conn)
+			return
+		case <-ticker.C:
+			s.flush(conn)
+		}
+	}
+}
+
+// flush pushes the current metrics, logging failures, which are retried at the next push.
+func (s *StatsDSink) flush(w io.Writer) {
+	if err := s.push(w); err != nil {
+		s.logger.Warn("Failed to push metrics to StatsD", zap.String("addr", s.addr), zap.Error(err))
+	}
+}
+
+// push gathers the metrics and writes them to w in packets of at most maxStatsDPacketSize bytes.
+func (s *StatsDSink) push(w io.Writer) error {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	p := &statsDPacket{w: w}
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				s.count(p, name, m.GetLabel(), m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				s.gauge(p, name, m.GetLabel(), m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				s.gauge(p, name, m.GetLabel(), m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				s.count(p, name+"_count", m.GetLabel(), float64(h.GetSampleCount()))
+				s.count(p, name+"_sum", m.GetLabel(), h.GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				s.count(p, name+"_count", m.GetLabel(), float64(summary.GetSampleCount()))
+				s.count(p, name+"_sum", m.GetLabel(), summary.GetSampleSum())
+				for _, q := range summary.GetQuantile() {
+					quantile := &dto.LabelPair{Name: proto("quantile"), Value: proto(strconv.FormatFloat(q.GetQuantile(), 'f', -1, 64))}
+					s.gauge(p, name, append(slices.Clip(m.GetLabel()), quantile), q.GetValue())
+				}
+			}
+		}
+	}
+	return p.flush()
+}
+
+// count writes the increase of a counter series since the previous push, or its value when the
+// counter was reset, skipping counters that did not move.
+func (s *StatsDSink) count(p *statsDPacket, name string, labels []*dto.LabelPair, value float64) {
+	key := seriesKey(name, labels)
+	delta := value - s.sent[key]
+	if delta < 0 {
+		delta = value
+	}
+	s.sent[key] = value
+	if delta != 0 {
+		p.add(s.line(name, labels, delta, "c"))
+	}
+}
+
+// gauge writes the value of a gauge series.
+func (s *StatsDSink) gauge(p *statsDPacket, name string, labels []*dto.LabelPair, value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	p.add(s.line(name, labels, value, "g"))
+}
+
+// line formats a series in the StatsD line protocol, with its labels as DogStatsD tags or, for
+// plain StatsD, as components of its name.
+func (s *StatsDSink) line(name string, labels []*dto.LabelPair, value float64, kind string) string {
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	if !s.dogStatsD {
+		for _, label := range labels {
+			b.WriteByte('.')
+			b.WriteString(sanitizeStatsD(label.GetValue(), ":|@#,."))
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteByte('|')
+	b.WriteString(kind)
+
+	if s.dogStatsD && len(s.tags)+len(labels) > 0 {
+		b.WriteString("|#")
+		tags := make([]string, 0, len(s.tags)+len(labels))
+		tags = append(tags, s.tags...)
+		for _, label := range labels {
+			tags = append(tags, label.GetName()+":"+sanitizeStatsD(label.GetValue(), "|#,"))
+		}
+		b.WriteString(strings.Join(tags, ","))
+	}
+	return b.String()
+}
+
+// sanitizeStatsD replaces the characters of a label value that are special in the line protocol.
+func sanitizeStatsD(value, special string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(special, r) || r == '\n' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// seriesKey identifies a series by its name and labels.
+func seriesKey(name string, labels []*dto.LabelPair) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, label := range labels {
+		b.WriteByte(0)
+		b.WriteString(label.GetName())
+		b.WriteByte(0)
+		b.WriteString(label.GetValue())
+	}
+	return b.String()
+}
+
+// proto returns a pointer to the string, for the fields of metric protobufs.
+func proto(s string) *string {
+	return &s
+}
+
+// statsDPacket batches lines into packets, writing each packet when the next line doesn't fit.
+type statsDPacket struct {
+	w   io.Writer
+	buf bytes.Buffer
+	err error
+}
+
+// add appends a line to the packet, writing the packet first when the line doesn't fit in it.
+func (p *statsDPacket) add(line string) {
+	if p.buf.Len() > 0 && p.buf.Len()+1+len(line) > maxStatsDPacketSize {
+		p.write()
+	}
+	if p.buf.Len() > 0 {
+		p.buf.WriteByte('\n')
+	}
+	p.buf.WriteString(line)
+}
+
+// write sends the packet, keeping the first error.
+func (p *statsDPacket) write() {
+	if _, err := p.w.Write(p.buf.Bytes()); err != nil && p.err == nil {
+		p.err = err
+	}
+	p.buf.Reset()
+}
+
+// flush sends the last packet and returns the first error writing the packets.
+func (p *statsDPacket) flush() error {
+	if p.buf.Len() > 0 {
+		p.write()
+	}
+	return p.err
+}
@@ -0,0 +1,308 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// reconnectDelay is the wait before reconnecting to Postgres after the slot could not be read.
+	reconnectDelay = 2 * time.Second
+	// advanceTimeout bounds the advance of the slot past published transactions, which outlives
+	// the tailing's context so they are not published again after shutdown.
+	advanceTimeout = 5 * time.Second
+	// roomColumn names the room rows of tables listed without one are published to.
+	roomColumn = "room"
+	// payloadColumn holds the payload of the messages published for rows; rows without one are
+	// published as a JSON object of their columns.
+	payloadColumn = "payload"
+)
+
+// messageNamespace derives the IDs of the messages published for rows from their position in the
+// WAL, so a row published again after a failure keeps its ID.
+var messageNamespace = uuid.MustParse("5b1f6a43-95a4-4c1e-9fd4-5a3c9e0f7d21")
+
+// Message is a hub message published for a row inserted into an outbox table.
+type Message struct {
+	// ID is the same every time the row is published
+	ID      string
+	Table   string
+	Room    string
+	Payload []byte
+}
+
+// Tailer publishes the rows inserted into the tables of a Postgres publication as hub messages,
+// decoding them from a logical replication slot with the pgoutput plugin. Rows are published in
+// commit order, and the slot only advances past a transaction once all of its rows are published,
+// so backend transactions drive realtime updates without a dual write: a row is published at least
+// once if, and only if, its transaction commits. One hub at a time tails a slot, the others
+// waiting on an advisory lock to take over.
+type Tailer struct {
+	url         string
+	slot        string
+	publication string
+	rooms       map[string]string
+	interval    time.Duration
+	batchSize   int
+	publish     func(ctx context.Context, msg Message) error
+	logger      *zap.Logger
+}
+
+// NewTailer creates a new Tailer publishing the rows inserted into the tables of the publication
+// listed in rooms, by name or schema-qualified name, to their room, or to the room in their room
+// column for tables mapped to the empty room.
+func NewTailer(url, slot, publication string, rooms map[string]string, interval time.Duration, batchSize int,
+	publish func(ctx context.Context, msg Message) error, logger *zap.Logger) *Tailer {
+	return &Tailer{
+		url:         url,
+		slot:        slot,
+		publication: publication,
+		rooms:       rooms,
+		interval:    interval,
+		batchSize:   batchSize,
+		publish:     publish,
+		logger:      logger.With(zap.String("slot", slot)),
+	}
+}
+
+// Run tails the slot until ctx is done, reconnecting after failures. Rows of a transaction that
+// was not fully published are published again from the first row once the slot is read anew.
+func (t *Tailer) Run(ctx context.Context) {
+	for {
+		err := t.tail(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		t.logger.Error("Failed to tail outbox slot, reconnecting", zap.Error(err))
+		select {
+		case <-time.After(reconnectDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tail connects to Postgres, waits to hold the slot's advisory lock and publishes its changes
+// until ctx is done or reading them fails.
+func (t *Tailer) tail(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, t.url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if err := t.lock(ctx, conn); err != nil {
+		return err
+	}
+	if err := t.createSlot(ctx, conn); err != nil {
+		return err
+	}
+	t.logger.Info("Tailing outbox slot", zap.String("publication", t.publication))
+
+	relations := make(map[uint32]relation)
+	for {
+		changes, err := t.readChanges(ctx, conn, relations)
+		if err != nil {
+			return err
+		}
+		if changes >= t.batchSize {
+			continue
+		}
+		select {
+		case <-time.After(t.interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// lock waits until the connection holds the advisory lock of the slot, which the hub tailing it
+// holds until its connection closes.
+func (t *Tailer) lock(ctx context.Context, conn *pgx.Conn) error {
+	for waiting := false; ; waiting = true {
+		var locked bool
+		if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", t.slot).Scan(&locked); err != nil {
+			return fmt.Errorf("failed to lock outbox slot: %w", err)
+		}
+		if locked {
+			return nil
+		}
+		if !waiting {
+			t.logger.Info("Outbox slot is tailed by another hub, waiting")
+		}
+		select {
+		case <-time.After(reconnectDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// createSlot creates the slot with the pgoutput plugin unless it exists.
+func (t *Tailer) createSlot(ctx context.Context, conn *pgx.Conn) error {
+	var exists bool
+	if err := conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)", t.slot).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up outbox slot: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_create_logical_replication_slot($1, 'pgoutput')", t.slot); err != nil {
+		return fmt.Errorf("failed to create outbox slot: %w", err)
+	}
+	t.logger.Info("Created outbox slot")
+	return nil
+}
+
+// change is a pgoutput message read from the slot.
+type change struct {
+	lsn  string
+	data []byte
+}
+
+// readChanges reads up to a batch of changes from the slot without consuming them, publishes the
+// rows of each transaction, advances the slot past the last transaction whose rows were all
+// published and returns the number of changes read.
+func (t *Tailer) readChanges(ctx context.Context, conn *pgx.Conn, relations map[uint32]relation) (int, error) {
+	rows, err := conn.Query(ctx,
+		"SELECT lsn::text, data FROM pg_logical_slot_peek_binary_changes($1, NULL, $2, 'proto_version', '1', 'publication_names', $3)",
+		t.slot, t.batchSize, t.publication)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox slot: %w", err)
+	}
+	var changes []change
+	for rows.Next() {
+		var c change
+		if err := rows.Scan(&c.lsn, &c.data); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read outbox change: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read outbox slot: %w", err)
+	}
+
+	var confirmed uint64
+	err = t.publishChanges(ctx, changes, relations, &confirmed)
+	if confirmed != 0 {
+		// Advance past the published transactions even when a later one failed, so they are not
+		// published again
+		advanceCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), advanceTimeout)
+		defer cancel()
+		if _, advanceErr := conn.Exec(advanceCtx, "SELECT pg_replication_slot_advance($1, $2::pg_lsn)", t.slot, formatLSN(confirmed)); advanceErr != nil && err == nil {
+			err = fmt.Errorf("failed to advance outbox slot: %w", advanceErr)
+		}
+	}
+	return len(changes), err
+}
+
+// publishChanges publishes the rows of the transactions of the changes, recording in confirmed the
+// LSN each transaction whose rows were all published ends at.
+func (t *Tailer) publishChanges(ctx context.Context, changes []change, relations map[uint32]relation, confirmed *uint64) error {
+	var pending []Message
+	for _, c := range changes {
+		if len(c.data) == 0 {
+			continue
+		}
+		body := c.data[1:]
+		switch c.data[0] {
+		case pgoutputBegin:
+			pending = pending[:0]
+		case pgoutputRelation:
+			id, rel, err := decodeRelation(body)
+			if err != nil {
+				return err
+			}
+			relations[id] = rel
+		case pgoutputInsert:
+			id, values, err := decodeInsert(body)
+			if err != nil {
+				return err
+			}
+			if msg, ok := t.message(relations[id], values, c.lsn); ok {
+				pending = append(pending, msg)
+			}
+		case pgoutputCommit:
+			end, err := decodeCommit(body)
+			if err != nil {
+				return err
+			}
+			for _, msg := range pending {
+				if err := t.publish(ctx, msg); err != nil {
+					return fmt.Errorf("failed to publish outbox row of %s: %w", msg.Table, err)
+				}
+				metrics.OutboxMessages.WithLabelValues("published").Inc()
+			}
+			pending = pending[:0]
+			*confirmed = end
+		}
+	}
+	return nil
+}
+
+// message returns the message published for a row inserted into the relation, or false when the
+// relation is not an outbox table or the row names no room.
+func (t *Tailer) message(rel relation, values []value, lsn string) (Message, bool) {
+	table := rel.namespace + "." + rel.name
+	room, ok := t.rooms[table]
+	if !ok {
+		if room, ok = t.rooms[rel.name]; !ok {
+			return Message{}, false
+		}
+	}
+
+	row := make(map[string]json.RawMessage, len(rel.columns))
+	var payload []byte
+	for i, column := range rel.columns {
+		if i >= len(values) {
+			break
+		}
+		v := values[i]
+		switch {
+		case column.name == roomColumn && room == "" && !v.null:
+			room = string(v.text)
+		case column.name == payloadColumn && !v.null:
+			payload = v.text
+		}
+		row[column.name] = columnJSON(column, v)
+	}
+	if room == "" {
+		metrics.OutboxMessages.WithLabelValues("no_room").Inc()
+		t.logger.Warn("Skipping outbox row without a room", zap.String("table", table), zap.String("lsn", lsn))
+		return Message{}, false
+	}
+	if payload == nil {
+		payload, _ = json.Marshal(row)
+	}
+
+	return Message{
+		ID:      uuid.NewSHA1(messageNamespace, []byte(lsn)).String(),
+		Table:   table,
+		Room:    room,
+		Payload: payload,
+	}, true
+}
+
+// columnJSON returns the JSON encoding of a column value: JSON values as they are and the others
+// as strings.
+func columnJSON(column relationColumn, v value) json.RawMessage {
+	if v.null {
+		return json.RawMessage("null")
+	}
+	if (column.typeOID == oidJSON || column.typeOID == oidJSONB) && json.Valid(v.text) {
+		return v.text
+	}
+	encoded, err := json.Marshal(string(v.text))
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return encoded
+}
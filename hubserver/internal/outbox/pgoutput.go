@@ -0,0 +1,174 @@
+package outbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Types of the pgoutput messages the outbox decodes; the others are skipped.
+const (
+	pgoutputBegin    = 'B'
+	pgoutputCommit   = 'C'
+	pgoutputRelation = 'R'
+	pgoutputInsert   = 'I'
+)
+
+// Kinds of the column values of a pgoutput tuple.
+const (
+	tupleNull      = 'n'
+	tupleUnchanged = 'u'
+	tupleText      = 't'
+)
+
+// Type OIDs of the Postgres JSON types, whose values are published as they are.
+const (
+	oidJSON  = 114
+	oidJSONB = 3802
+)
+
+// errTruncated is returned for pgoutput messages shorter than their contents.
+var errTruncated = errors.New("truncated pgoutput message")
+
+// relation is a table described by a pgoutput relation message.
+type relation struct {
+	namespace string
+	name      string
+	columns   []relationColumn
+}
+
+// relationColumn is a column of a relation, with the OID of its type.
+type relationColumn struct {
+	name    string
+	typeOID uint32
+}
+
+// value is the text of a column of an inserted row, with null set for NULL values.
+type value struct {
+	text []byte
+	null bool
+}
+
+// decoder reads the fields of a pgoutput message in order.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || len(d.data) < n {
+		d.err = errTruncated
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) uint8() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// string reads a NUL-terminated string.
+func (d *decoder) string() string {
+	if d.err != nil {
+		return ""
+	}
+	for i, c := range d.data {
+		if c == 0 {
+			s := string(d.data[:i])
+			d.data = d.data[i+1:]
+			return s
+		}
+	}
+	d.err = errTruncated
+	return ""
+}
+
+// decodeRelation decodes the body of a relation message into its id and relation.
+func decodeRelation(data []byte) (uint32, relation, error) {
+	d := &decoder{data: data}
+	id := d.uint32()
+	rel := relation{namespace: d.string(), name: d.string()}
+	d.uint8() // replica identity
+	n := int(d.uint16())
+	for i := 0; i < n && d.err == nil; i++ {
+		d.uint8() // flags
+		column := relationColumn{name: d.string(), typeOID: d.uint32()}
+		d.uint32() // type modifier
+		rel.columns = append(rel.columns, column)
+	}
+	if d.err != nil {
+		return 0, relation{}, fmt.Errorf("failed to decode relation: %w", d.err)
+	}
+	return id, rel, nil
+}
+
+// decodeInsert decodes the body of an insert message into the id of its relation and the values
+// of the inserted row.
+func decodeInsert(data []byte) (uint32, []value, error) {
+	d := &decoder{data: data}
+	id := d.uint32()
+	if kind := d.uint8(); d.err == nil && kind != 'N' {
+		return 0, nil, fmt.Errorf("unexpected tuple kind %q in insert", kind)
+	}
+	n := int(d.uint16())
+	values := make([]value, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		switch kind := d.uint8(); kind {
+		case tupleNull, tupleUnchanged:
+			values = append(values, value{null: true})
+		case tupleText:
+			values = append(values, value{text: d.take(int(d.uint32()))})
+		default:
+			if d.err == nil {
+				return 0, nil, fmt.Errorf("unexpected column kind %q in insert", kind)
+			}
+		}
+	}
+	if d.err != nil {
+		return 0, nil, fmt.Errorf("failed to decode insert: %w", d.err)
+	}
+	return id, values, nil
+}
+
+// decodeCommit decodes the body of a commit message into the LSN the transaction ends at.
+func decodeCommit(data []byte) (uint64, error) {
+	d := &decoder{data: data}
+	d.uint8()  // flags
+	d.uint64() // commit LSN
+	end := d.uint64()
+	if d.err != nil {
+		return 0, fmt.Errorf("failed to decode commit: %w", d.err)
+	}
+	return end, nil
+}
+
+// formatLSN formats an LSN the way Postgres does.
+func formatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", uint32(lsn>>32), uint32(lsn))
+}
@@ -0,0 +1,153 @@
+package outbox
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// pgoutputMessage builds pgoutput messages as Postgres encodes them.
+type pgoutputMessage []byte
+
+func (m pgoutputMessage) uint8(v byte) pgoutputMessage    { return append(m, v) }
+func (m pgoutputMessage) uint16(v uint16) pgoutputMessage { return binary.BigEndian.AppendUint16(m, v) }
+func (m pgoutputMessage) uint32(v uint32) pgoutputMessage { return binary.BigEndian.AppendUint32(m, v) }
+func (m pgoutputMessage) uint64(v uint64) pgoutputMessage { return binary.BigEndian.AppendUint64(m, v) }
+func (m pgoutputMessage) string(s string) pgoutputMessage { return append(append(m, s...), 0) }
+func (m pgoutputMessage) text(s string) pgoutputMessage {
+	return append(m.uint8(tupleText).uint32(uint32(len(s))), s...)
+}
+func (m pgoutputMessage) column(name string, oid uint32) pgoutputMessage {
+	return m.uint8(0).string(name).uint32(oid).uint32(0xffffffff)
+}
+
+// ordersRelation is the body of the relation message of public.orders(room text, payload jsonb, note text).
+func ordersRelation() pgoutputMessage {
+	return pgoutputMessage{}.uint32(16384).string("public").string("orders").uint8('d').uint16(3).
+		column("room", 25).column("payload", oidJSONB).column("note", 25)
+}
+
+// ordersInsert is the body of the insert message of a row of public.orders.
+func ordersInsert() pgoutputMessage {
+	return pgoutputMessage{}.uint32(16384).uint8('N').uint16(3).
+		text("orders").text(`{"id":1}`).uint8(tupleNull)
+}
+
+// ordersCommit is the body of the commit message of a transaction ending at 0/16B3748.
+func ordersCommit() pgoutputMessage {
+	return pgoutputMessage{}.uint8(0).uint64(0x16B3740).uint64(0x16B3748)
+}
+
+func TestDecodeRelation(t *testing.T) {
+	id, rel, err := decodeRelation(ordersRelation())
+	if err != nil {
+		t.Fatalf("decodeRelation: %v", err)
+	}
+	want := relation{namespace: "public", name: "orders", columns: []relationColumn{
+		{name: "room", typeOID: 25}, {name: "payload", typeOID: oidJSONB}, {name: "note", typeOID: 25},
+	}}
+	if id != 16384 || !reflect.DeepEqual(rel, want) {
+		t.Fatalf("decoded relation %d %+v, want 16384 %+v", id, rel, want)
+	}
+}
+
+func TestDecodeInsert(t *testing.T) {
+	data := pgoutputMessage{}.uint32(16384).uint8('N').uint16(4).
+		text("orders").text("").uint8(tupleNull).uint8(tupleUnchanged)
+	id, values, err := decodeInsert(data)
+	if err != nil {
+		t.Fatalf("decodeInsert: %v", err)
+	}
+	want := []value{{text: []byte("orders")}, {text: []byte{}}, {null: true}, {null: true}}
+	if id != 16384 || !reflect.DeepEqual(values, want) {
+		t.Fatalf("decoded insert %d %+v, want 16384 %+v", id, values, want)
+	}
+}
+
+func TestDecodeInsertRejectsUnexpectedKinds(t *testing.T) {
+	if _, _, err := decodeInsert(pgoutputMessage{}.uint32(16384).uint8('K').uint16(0)); err == nil {
+		t.Fatal("insert with a key tuple decoded")
+	}
+	if _, _, err := decodeInsert(pgoutputMessage{}.uint32(16384).uint8('N').uint16(1).uint8('b')); err == nil {
+		t.Fatal("insert with a binary column decoded")
+	}
+}
+
+func TestDecodeCommit(t *testing.T) {
+	end, err := decodeCommit(ordersCommit())
+	if err != nil {
+		t.Fatalf("decodeCommit: %v", err)
+	}
+	if got := formatLSN(end); got != "0/16B3748" {
+		t.Fatalf("commit ends at %s, want 0/16B3748", got)
+	}
+	if got := formatLSN(0x1_0000_00A0); got != "1/A0" {
+		t.Fatalf("formatLSN = %s, want 1/A0", got)
+	}
+}
+
+func TestDecodeRejectsTruncatedMessages(t *testing.T) {
+	decoders := map[string]struct {
+		data   []byte
+		decode func([]byte) error
+	}{
+		"relation": {ordersRelation(), func(data []byte) error { _, _, err := decodeRelation(data); return err }},
+		"insert":   {ordersInsert(), func(data []byte) error { _, _, err := decodeInsert(data); return err }},
+		"commit":   {ordersCommit(), func(data []byte) error { _, err := decodeCommit(data); return err }},
+	}
+	for name, d := range decoders {
+		for n := 0; n < len(d.data); n++ {
+			if err := d.decode(d.data[:n]); !errors.Is(err, errTruncated) {
+				t.Fatalf("%s truncated to %d of %d bytes decoded with %v", name, n, len(d.data), err)
+			}
+		}
+	}
+
+	huge := pgoutputMessage{}.uint32(16384).uint8('N').uint16(1).uint8(tupleText).uint32(0xffffffff).string("short")
+	if _, _, err := decodeInsert(huge); !errors.Is(err, errTruncated) {
+		t.Fatalf("insert with a column longer than the message decoded with %v", err)
+	}
+}
+
+func TestPublishChangesPublishesCommittedRows(t *testing.T) {
+	var published []Message
+	tailer := NewTailer("", "hub_outbox", "hub_outbox", map[string]string{"public.orders": ""}, 0, 0,
+		func(_ context.Context, msg Message) error {
+			published = append(published, msg)
+			return nil
+		}, zap.NewNop())
+
+	begin := pgoutputMessage{pgoutputBegin}.uint64(0x16B3748).uint64(0).uint32(731)
+	changes := []change{
+		{lsn: "0/16B3700", data: begin},
+		{lsn: "0/16B3700", data: append(pgoutputMessage{pgoutputRelation}, ordersRelation()...)},
+		{lsn: "0/16B3710", data: append(pgoutputMessage{pgoutputInsert}, ordersInsert()...)},
+		{lsn: "0/16B3748", data: append(pgoutputMessage{pgoutputCommit}, ordersCommit()...)},
+		// A transaction not committed in the batch is published once its commit is read
+		{lsn: "0/16B3800", data: begin},
+		{lsn: "0/16B3810", data: append(pgoutputMessage{pgoutputInsert}, ordersInsert()...)},
+	}
+	relations := make(map[uint32]relation)
+	var confirmed uint64
+	if err := tailer.publishChanges(context.Background(), changes, relations, &confirmed); err != nil {
+		t.Fatalf("publishChanges: %v", err)
+	}
+	if len(published) != 1 {
+		t.Fatalf("published %+v, want the row of the committed transaction", published)
+	}
+	if msg := published[0]; msg.Table != "public.orders" || msg.Room != "orders" || string(msg.Payload) != `{"id":1}` {
+		t.Fatalf("published %+v", msg)
+	}
+	if formatLSN(confirmed) != "0/16B3748" {
+		t.Fatalf("confirmed %s, want the end of the committed transaction", formatLSN(confirmed))
+	}
+
+	truncated := []change{{lsn: "0/16B3900", data: pgoutputMessage{pgoutputInsert}.uint32(16384)}}
+	if err := tailer.publishChanges(context.Background(), truncated, relations, &confirmed); !errors.Is(err, errTruncated) {
+		t.Fatalf("publishChanges of a truncated insert: %v", err)
+	}
+}
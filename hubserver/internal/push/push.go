@@ -0,0 +1,276 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Headers set on every push request.
+const (
+	HeaderSignature    = "X-Hub-Signature-256"
+	HeaderSubscription = "X-Hub-Subscription"
+	HeaderDelivery     = "X-Hub-Delivery"
+)
+
+// ErrInvalidSubscription is returned when a subscription is registered without a room, with a
+// callback URL that is not absolute http or https, or without a secret.
+var ErrInvalidSubscription = errors.New("invalid push subscription")
+
+// Subscription is an HTTP callback receiving the messages published to a room, or the events of
+// the hub for webhooks, which have no room.
+type Subscription struct {
+	ID   string `json:"id"`
+	Room string `json:"room"`
+	URL  string `json:"url"`
+
+	// secret signs the request bodies; it is never returned once registered
+	secret []byte
+}
+
+// Options configures the delivery of push requests.
+type Options struct {
+	// Workers is the number of requests sent in parallel and QueueSize the number of deliveries
+	// waiting for a worker; deliveries are dropped once the queue is full.
+	Workers   int
+	QueueSize int
+	// Timeout bounds each request.
+	Timeout time.Duration
+	// MaxAttempts is the number of times a delivery is tried before it is given up. Failed attempts
+	// are retried after Backoff, doubling after each attempt up to MaxBackoff.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// delivery is a message queued for one subscription.
+type delivery struct {
+	sub  Subscription
+	body []byte
+	id   string
+}
+
+// Dispatcher POSTs the messages published to rooms to the subscriptions registered for them.
+type Dispatcher struct {
+	opts   Options
+	client *http.Client
+	queue  chan delivery
+	logger *zap.Logger
+
+	mu            sync.RWMutex
+	subscriptions map[string]Subscription
+}
+
+// NewDispatcher creates a new Dispatcher without subscriptions.
+func NewDispatcher(opts Options, logger *zap.Logger) *Dispatcher {
+	return &Dispatcher{
+		opts:          opts,
+		client:        &http.Client{Timeout: opts.Timeout},
+		queue:         make(chan delivery, opts.QueueSize),
+		logger:        logger,
+		subscriptions: make(map[string]Subscription),
+	}
+}
+
+// Subscribe registers a callback URL for the messages published to a room. Request bodies are
+// signed with the secret.
+func (d *Dispatcher) Subscribe(room, callbackURL, secret string) (Subscription, error) {
+	if room == "" {
+		return Subscription{}, fmt.Errorf("%w: room is required", ErrInvalidSubscription)
+	}
+	sub, err := NewWebhook(callbackURL, secret)
+	if err != nil {
+		return Subscription{}, err
+	}
+	sub.Room = room
+
+	d.mu.Lock()
+	d.subscriptions[sub.ID] = sub
+	d.mu.Unlock()
+
+	d.logger.Info("Registered push subscription", zap.String("id", sub.ID), zap.String("room", room), zap.String("url", sub.URL))
+	return sub, nil
+}
+
+// NewWebhook returns a subscription without a room for a callback URL receiving the events the
+// hub delivers to it with Deliver. Request bodies are signed with the secret.
+func NewWebhook(callbackURL, secret string) (Subscription, error) {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Subscription{}, fmt.Errorf("%w: callback url must be an absolute http or https URL, got %q", ErrInvalidSubscription, callbackURL)
+	}
+	if secret == "" {
+		return Subscription{}, fmt.Errorf("%w: secret is required", ErrInvalidSubscription)
+	}
+	return Subscription{ID: uuid.New().String(), URL: u.String(), secret: []byte(secret)}, nil
+}
+
+// Unsubscribe removes a subscription and reports whether it existed. Deliveries already queued for
+// it are still sent.
+func (d *Dispatcher) Unsubscribe(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.subscriptions[id]; !ok {
+		return false
+	}
+	delete(d.subscriptions, id)
+	return true
+}
+
+// Subscriptions returns the registered subscriptions ordered by room.
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mu.RLock()
+	subs := make([]Subscription, 0, len(d.subscriptions))
+	for _, sub := range d.subscriptions {
+		subs = append(subs, sub)
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Room != subs[j].Room {
+			return subs[i].Room < subs[j].Room
+		}
+		return subs[i].ID < subs[j].ID
+	})
+	return subs
+}
+
+// Push queues a message for every subscription of its room. It never blocks: deliveries that do
+// not fit in the queue are dropped. Ephemeral messages are never pushed.
+func (d *Dispatcher) Push(md message.MessageDetails) {
+	if md.Room == "" || md.Ephemeral {
+		return
+	}
+
+	d.mu.RLock()
+	var subs []Subscription
+	for _, sub := range d.subscriptions {
+		if sub.Room == md.Room {
+			subs = append(subs, sub)
+		}
+	}
+	d.mu.RUnlock()
+	if len(subs) == 0 {
+		return
+	}
+
+	frame := message.NewMessageFrame(&md)
+	body, err := frame.ToJSON()
+	if err != nil {
+		d.logger.Error("Failed to encode pushed message", zap.String("id", md.ID), zap.Error(err))
+		return
+	}
+
+	for _, sub := range subs {
+		d.Deliver(sub, md.ID, body)
+	}
+}
+
+// Deliver queues a request body for a subscription, identified by id in the X-Hub-Delivery header.
+// It never blocks: deliveries that do not fit in the queue are dropped.
+func (d *Dispatcher) Deliver(sub Subscription, id string, body []byte) {
+	select {
+	case d.queue <- delivery{sub: sub, body: body, id: id}:
+	default:
+		metrics.PushDeliveries.WithLabelValues("dropped").Inc()
+		d.logger.Warn("Push queue is full, dropping delivery", zap.String("subscription", sub.ID), zap.String("id", id))
+	}
+}
+
+// Run sends queued deliveries until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < d.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case dl := <-d.queue:
+					d.deliver(ctx, dl)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver sends a delivery, retrying with exponential backoff on network errors, 429 and 5xx responses.
+func (d *Dispatcher) deliver(ctx context.Context, dl delivery) {
+	backoff := d.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := d.send(ctx, dl)
+		if err == nil {
+			metrics.PushDeliveries.WithLabelValues("delivered").Inc()
+			return
+		}
+		if !retry || attempt >= d.opts.MaxAttempts {
+			metrics.PushDeliveries.WithLabelValues("failed").Inc()
+			d.logger.Error("Giving up push delivery", zap.String("subscription", dl.sub.ID), zap.String("id", dl.id),
+				zap.Int("attempts", attempt), zap.Error(err))
+			return
+		}
+
+		metrics.PushRetries.Inc()
+		d.logger.Warn("Push delivery failed, retrying", zap.String("subscription", dl.sub.ID), zap.String("id", dl.id),
+			zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, d.opts.MaxBackoff)
+	}
+}
+
+// send POSTs a delivery once and reports whether a failure is worth retrying.
+func (d *Dispatcher) send(ctx context.Context, dl delivery) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.sub.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, "sha256="+Sign(dl.sub.secret, dl.body))
+	req.Header.Set(HeaderSubscription, dl.sub.ID)
+	req.Header.Set(HeaderDelivery, dl.id)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("callback responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("callback responded %s", resp.Status)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of a request body under a subscription secret, as sent in the
+// X-Hub-Signature-256 header after the sha256= prefix.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,32 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const bandwidthKeyPrefix = "bandwidth:"
+
+// BandwidthUsage adds up the bytes each user sends and receives per cap period across every hub
+// instance, in counters named bandwidth:<key>:<period> that expire after their period.
+type BandwidthUsage struct {
+	client *Client
+}
+
+// NewBandwidthUsage creates a new BandwidthUsage.
+func NewBandwidthUsage(client *Client) *BandwidthUsage {
+	return &BandwidthUsage{client: client}
+}
+
+// Add adds bytes to the key's usage in the period and returns the usage so far.
+func (u *BandwidthUsage) Add(ctx context.Context, key, period string, bytes int64, ttl time.Duration) (int64, error) {
+	name := bandwidthKeyPrefix + key + ":" + period
+	pipe := u.client.TxPipeline()
+	total := pipe.IncrBy(ctx, name, bytes)
+	pipe.Expire(ctx, name, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to add bandwidth usage of %s: %w", key, err)
+	}
+	return total.Val(), nil
+}
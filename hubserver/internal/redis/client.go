@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
@@ -12,6 +13,11 @@ import (
 type Client struct {
 	*redis.Client
 	logger *zap.Logger
+
+	// credentials are the credentials new connections authenticate with when the client was
+	// created with a credentials provider, refreshed until stopRefresh is called
+	credentials atomic.Pointer[Credentials]
+	stopRefresh context.CancelFunc
 }
 
 // NewClient creates a new Redis client with the provided address and logger.
@@ -43,6 +49,9 @@ func (c *Client) Ping(ctx context.Context) error {
 
 // Close closes the Redis client.
 func (c *Client) Close() error {
+	if c.stopRefresh != nil {
+		c.stopRefresh()
+	}
 	if err := c.Client.Close(); err != nil {
 		c.logger.Error("Failed to close Redis client", zap.Error(err))
 		return fmt.Errorf("failed to close Redis client: %w", err)
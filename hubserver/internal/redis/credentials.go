@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Credentials authenticate connections to Redis. An empty username authenticates as the default user.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialsProvider issues the credentials connections to Redis authenticate with, such as the
+// short-lived IAM auth tokens of managed Redis services.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// CredentialsFunc adapts a function to a CredentialsProvider.
+type CredentialsFunc func(ctx context.Context) (Credentials, error)
+
+// Credentials calls f.
+func (f CredentialsFunc) Credentials(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// FileCredentials returns a provider authenticating as username with the password read from the
+// file on every refresh, for auth tokens rotated by a sidecar or a mounted secret.
+func FileCredentials(username, path string) CredentialsProvider {
+	return CredentialsFunc(func(context.Context) (Credentials, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("failed to read Redis password file: %w", err)
+		}
+		return Credentials{Username: username, Password: strings.TrimSpace(string(data))}, nil
+	})
+}
+
+// NewClientWithCredentials creates a Redis client authenticating every new connection with the
+// provider's current credentials, refreshed every refresh until the client is closed.
+//
+// Pooled connections are replaced once they are refresh old, so they re-authenticate with fresh
+// credentials before services capping the lifetime of a token's connections drop them. Subscribed
+// connections cannot re-authenticate in place; when Redis drops one, the subscription reconnects
+// with the current credentials and resubscribes to its channels, instead of failing with the
+// expired ones.
+func NewClientWithCredentials(ctx context.Context, addr string, provider CredentialsProvider, refresh time.Duration, logger *zap.Logger) (*Client, error) {
+	credentials, err := provider.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Redis credentials: %w", err)
+	}
+
+	c := &Client{logger: logger}
+	c.credentials.Store(&credentials)
+	c.Client = redis.NewClient(&redis.Options{
+		Addr:       addr,
+		MaxConnAge: refresh,
+		OnConnect:  c.authenticate,
+	})
+
+	refreshCtx, cancel := context.WithCancel(context.Background())
+	c.stopRefresh = cancel
+	go c.refreshCredentials(refreshCtx, provider, refresh)
+	return c, nil
+}
+
+// authenticate authenticates a new connection with the current credentials.
+func (c *Client) authenticate(ctx context.Context, cn *redis.Conn) error {
+	credentials := c.credentials.Load()
+	if credentials.Username == "" {
+		return cn.Auth(ctx, credentials.Password).Err()
+	}
+	return cn.AuthACL(ctx, credentials.Username, credentials.Password).Err()
+}
+
+// refreshCredentials periodically replaces the current credentials with the provider's. When the
+// provider fails, connections keep authenticating with the previous credentials.
+func (c *Client) refreshCredentials(ctx context.Context, provider CredentialsProvider, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		credentials, err := provider.Credentials(ctx)
+		if err != nil {
+			metrics.RedisCredentialRefreshes.WithLabelValues("failed").Inc()
+			c.logger.Warn("Failed to refresh Redis credentials", zap.Error(err))
+			continue
+		}
+		c.credentials.Store(&credentials)
+		metrics.RedisCredentialRefreshes.WithLabelValues("refreshed").Inc()
+	}
+}
@@ -0,0 +1,146 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Fields of a dead-letter stream entry.
+const (
+	deadLetterChannelField  = "channel"
+	deadLetterReasonField   = "reason"
+	deadLetterErrorField    = "error"
+	deadLetterHubField      = "hub"
+	deadLetterEnvelopeField = "envelope"
+)
+
+// Reasons envelopes received from other hubs are dead-lettered for.
+const (
+	DeadLetterUnmarshal  = "unmarshal"
+	DeadLetterDecrypt    = "decrypt"
+	DeadLetterDecompress = "decompress"
+	DeadLetterDecode     = "decode"
+	DeadLetterSignature  = "signature"
+)
+
+// DeadLetter is an envelope received from another hub that could not be delivered, with the
+// channel it was received on, the hub that received it and the reason it was rejected.
+type DeadLetter struct {
+	ID       string    `json:"id"`
+	Channel  string    `json:"channel"`
+	Hub      string    `json:"hub"`
+	Reason   string    `json:"reason"`
+	Error    string    `json:"error"`
+	Envelope string    `json:"envelope"`
+	At       time.Time `json:"at"`
+}
+
+// ErrDeadLetterOfOtherHub is returned when replaying a dead letter recorded by another hub, which
+// is the only one that can replay it.
+var ErrDeadLetterOfOtherHub = errors.New("dead letter was recorded by another hub")
+
+// DeadLetters keeps the undeliverable envelopes received by the hub in a Redis stream, for
+// operators to inspect and, once the cause is fixed, replay them to the hub that received them.
+type DeadLetters struct {
+	client *Client
+	stream string
+	maxLen int64
+	hubID  string
+
+	// redeliver hands a replayed envelope received on the channel to the hub like a new one
+	redeliver func(ctx context.Context, channel string, envelope []byte) error
+}
+
+// NewDeadLetters creates a new DeadLetters recording the envelopes received by the hub in the
+// stream, trimmed to about maxLen entries.
+func NewDeadLetters(client *Client, stream string, maxLen int64, hubID string) *DeadLetters {
+	return &DeadLetters{client: client, stream: stream, maxLen: maxLen, hubID: hubID}
+}
+
+// Add records an envelope received on the channel that was rejected for the reason.
+func (d *DeadLetters) Add(ctx context.Context, channel, reason string, cause error, envelope []byte) error {
+	err := d.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: d.stream,
+		MaxLen: d.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			deadLetterChannelField:  channel,
+			deadLetterReasonField:   reason,
+			deadLetterErrorField:    cause.Error(),
+			deadLetterHubField:      d.hubID,
+			deadLetterEnvelopeField: envelope,
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to add dead letter: %w", err)
+	}
+	return nil
+}
+
+// List returns up to count of the most recent dead letters, newest first.
+func (d *DeadLetters) List(ctx context.Context, count int64) ([]DeadLetter, error) {
+	entries, err := d.client.XRevRangeN(ctx, d.stream, "+", "-", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letters: %w", err)
+	}
+
+	letters := make([]DeadLetter, len(entries))
+	for i, entry := range entries {
+		letters[i] = deadLetter(entry)
+	}
+	return letters, nil
+}
+
+// Replay delivers the envelope of a dead letter recorded by this hub to it again, as if it was
+// received anew on its channel, and removes it from the stream, reporting false when there is no
+// such dead letter. Other hubs are not affected, so those that delivered the envelope the first
+// time don't deliver it twice; an envelope that still fails is recorded again under a new id.
+func (d *DeadLetters) Replay(ctx context.Context, id string) (bool, error) {
+	entries, err := d.client.XRange(ctx, d.stream, id, id).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to read dead letter %s: %w", id, err)
+	}
+	if len(entries) == 0 {
+		return false, nil
+	}
+
+	letter := deadLetter(entries[0])
+	if letter.Hub != d.hubID {
+		return false, fmt.Errorf("%w: %s", ErrDeadLetterOfOtherHub, letter.Hub)
+	}
+	if d.redeliver == nil {
+		return false, errors.New("dead letters are not redelivered by the broker")
+	}
+	if err := d.redeliver(ctx, letter.Channel, []byte(letter.Envelope)); err != nil {
+		return false, fmt.Errorf("failed to replay dead letter %s: %w", id, err)
+	}
+	if err := d.client.XDel(ctx, d.stream, id).Err(); err != nil {
+		return true, fmt.Errorf("failed to remove replayed dead letter %s: %w", id, err)
+	}
+	return true, nil
+}
+
+// deadLetter returns the dead letter recorded in a stream entry.
+func deadLetter(entry redis.XMessage) DeadLetter {
+	field := func(name string) string {
+		value, _ := entry.Values[name].(string)
+		return value
+	}
+
+	letter := DeadLetter{
+		ID:       entry.ID,
+		Channel:  field(deadLetterChannelField),
+		Reason:   field(deadLetterReasonField),
+		Error:    field(deadLetterErrorField),
+		Hub:      field(deadLetterHubField),
+		Envelope: field(deadLetterEnvelopeField),
+	}
+	if ms, _, ok := parseStreamID(entry.ID); ok {
+		letter.At = time.UnixMilli(int64(ms))
+	}
+	return letter
+}
@@ -0,0 +1,33 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DisplayNames reads the display names of users from a Redis hash mapping user ids to display
+// names, maintained by the application.
+type DisplayNames struct {
+	client *Client
+	key    string
+}
+
+// NewDisplayNames creates a new DisplayNames reading the hash at key.
+func NewDisplayNames(client *Client, key string) *DisplayNames {
+	return &DisplayNames{client: client, key: key}
+}
+
+// Get returns the display name of the user, or "" when the hash has none.
+func (d *DisplayNames) Get(ctx context.Context, userID string) (string, error) {
+	name, err := d.client.HGet(ctx, d.key, userID).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get display name: %w", err)
+	}
+	return name, nil
+}
@@ -0,0 +1,199 @@
+package redis
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// EncryptionKey is an AES key envelope payloads are encrypted with, named by the id envelopes record.
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// KeyProvider issues the keys envelope payloads are encrypted with, such as data keys unwrapped by
+// a KMS. The first key encrypts and every key decrypts, so a new key is rolled out by adding it to
+// the end on every hub, moving it to the front once they all hold it, and dropping the old one once
+// no envelope in flight uses it.
+type KeyProvider interface {
+	Keys(ctx context.Context) ([]EncryptionKey, error)
+}
+
+// KeysFunc adapts a function to a KeyProvider.
+type KeysFunc func(ctx context.Context) ([]EncryptionKey, error)
+
+// Keys calls f.
+func (f KeysFunc) Keys(ctx context.Context) ([]EncryptionKey, error) {
+	return f(ctx)
+}
+
+// ParseKeys parses keys given as id=key entries separated by commas or whitespace, with the keys
+// encoded in standard base64 and 16, 24 or 32 bytes long, for AES-128, AES-192 or AES-256.
+func ParseKeys(s string) ([]EncryptionKey, error) {
+	entries := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	keys := make([]EncryptionKey, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		id, encoded, ok := strings.Cut(entry, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("encryption key %q must be id=key", entry)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("encryption key %q is given twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q is not base64: %w", id, err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("encryption key %q must be 16, 24 or 32 bytes, got %d", id, len(key))
+		}
+		seen[id] = true
+		keys = append(keys, EncryptionKey{ID: id, Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys given")
+	}
+	return keys, nil
+}
+
+// EnvKeys returns a provider of the keys held in the environment variable, parsed by ParseKeys.
+func EnvKeys(name string) KeyProvider {
+	return KeysFunc(func(context.Context) ([]EncryptionKey, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("encryption key variable %s is not set", name)
+		}
+		return ParseKeys(value)
+	})
+}
+
+// FileKeys returns a provider of the keys held in the file, parsed by ParseKeys and re-read on
+// every refresh, for keys rotated by a sidecar or a mounted secret.
+func FileKeys(path string) KeyProvider {
+	return KeysFunc(func(context.Context) ([]EncryptionKey, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		return ParseKeys(string(data))
+	})
+}
+
+// errUnencrypted is the cause of the envelopes received without encryption rejected by hubs that
+// encrypt them.
+var errUnencrypted = errors.New("envelope is not encrypted")
+
+// keySet holds the AES-GCM ciphers of a provider's keys by id.
+type keySet struct {
+	active  string
+	ciphers map[string]cipher.AEAD
+}
+
+// Keyring encrypts envelope payloads with AES-GCM under the provider's first key and decrypts them
+// with any of its keys, refreshed from the provider until its context is done.
+type Keyring struct {
+	keys   atomic.Pointer[keySet]
+	logger *zap.Logger
+}
+
+// NewKeyring creates a keyring of the provider's keys, refreshed every refresh until ctx is done.
+func NewKeyring(ctx context.Context, provider KeyProvider, refresh time.Duration, logger *zap.Logger) (*Keyring, error) {
+	k := &Keyring{logger: logger}
+	if err := k.load(ctx, provider); err != nil {
+		return nil, err
+	}
+	go k.refreshKeys(ctx, provider, refresh)
+	return k, nil
+}
+
+// load replaces the keys of the keyring with the provider's.
+func (k *Keyring) load(ctx context.Context, provider KeyProvider) error {
+	keys, err := provider.Keys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return errors.New("failed to get encryption keys: provider has none")
+	}
+
+	set := &keySet{active: keys[0].ID, ciphers: make(map[string]cipher.AEAD, len(keys))}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return fmt.Errorf("invalid encryption key %q: %w", key.ID, err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("invalid encryption key %q: %w", key.ID, err)
+		}
+		set.ciphers[key.ID] = gcm
+	}
+	if previous := k.keys.Swap(set); previous != nil && previous.active != set.active {
+		k.logger.Info("Envelope encryption key rotated", zap.String("previous", previous.active), zap.String("key-id", set.active))
+	}
+	return nil
+}
+
+// refreshKeys periodically replaces the keys of the keyring with the provider's. When the provider
+// fails, envelopes keep being encrypted and decrypted with the previous keys.
+func (k *Keyring) refreshKeys(ctx context.Context, provider KeyProvider, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := k.load(ctx, provider); err != nil {
+			metrics.EncryptionKeyRefreshes.WithLabelValues("failed").Inc()
+			k.logger.Warn("Failed to refresh envelope encryption keys", zap.Error(err))
+			continue
+		}
+		metrics.EncryptionKeyRefreshes.WithLabelValues("refreshed").Inc()
+	}
+}
+
+// Seal encrypts the payload with the active key, prefixed with the random nonce it was sealed with.
+func (k *Keyring) Seal(payload, additionalData []byte) (string, []byte, error) {
+	set := k.keys.Load()
+	gcm := set.ciphers[set.active]
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(payload)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return set.active, gcm.Seal(nonce, nonce, payload, additionalData), nil
+}
+
+// Open decrypts a payload sealed with the key of the id.
+func (k *Keyring) Open(keyID string, sealed, additionalData []byte) ([]byte, error) {
+	gcm, ok := k.keys.Load().ciphers[keyID]
+	if !ok {
+		return nil, errors.New("unknown encryption key")
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed payload is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
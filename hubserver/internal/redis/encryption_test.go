@@ -0,0 +1,208 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// rotatingKeys is a key provider whose keys the test replaces.
+type rotatingKeys struct {
+	mu   sync.Mutex
+	keys []EncryptionKey
+}
+
+func (p *rotatingKeys) Keys(context.Context) ([]EncryptionKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keys, nil
+}
+
+func (p *rotatingKeys) set(keys ...EncryptionKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+}
+
+var (
+	oldKey = EncryptionKey{ID: "2024-01", Key: bytes.Repeat([]byte{1}, 32)}
+	newKey = EncryptionKey{ID: "2024-02", Key: bytes.Repeat([]byte{2}, 16)}
+)
+
+// newTestKeyring returns a keyring of the provider's keys, reloaded by the test with load.
+func newTestKeyring(t *testing.T, provider KeyProvider) *Keyring {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	k, err := NewKeyring(ctx, provider, time.Hour, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return k
+}
+
+func TestKeyringOpensEnvelopesSealedBeforeARotation(t *testing.T) {
+	provider := &rotatingKeys{}
+	provider.set(oldKey)
+	k := newTestKeyring(t, provider)
+
+	sealedID, sealed, err := k.Seal([]byte(`{"id":1}`), []byte("aad"))
+	if err != nil || sealedID != oldKey.ID {
+		t.Fatalf("Seal = %s, %v, want the key %s", sealedID, err, oldKey.ID)
+	}
+
+	// The new key is rolled out last, then moved first
+	provider.set(oldKey, newKey)
+	if err := k.load(context.Background(), provider); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if keyID, _, _ := k.Seal([]byte(`{"id":2}`), nil); keyID != oldKey.ID {
+		t.Fatalf("sealed with %s before the new key was moved first", keyID)
+	}
+	provider.set(newKey, oldKey)
+	if err := k.load(context.Background(), provider); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	rotatedID, rotated, err := k.Seal([]byte(`{"id":2}`), []byte("aad"))
+	if err != nil || rotatedID != newKey.ID {
+		t.Fatalf("Seal after the rotation = %s, %v, want the key %s", rotatedID, err, newKey.ID)
+	}
+	if payload, err := k.Open(sealedID, sealed, []byte("aad")); err != nil || string(payload) != `{"id":1}` {
+		t.Fatalf("Open of the envelope sealed before the rotation = %s, %v", payload, err)
+	}
+
+	// Dropping the old key leaves what it sealed unreadable
+	provider.set(newKey)
+	if err := k.load(context.Background(), provider); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, err := k.Open(sealedID, sealed, []byte("aad")); err == nil {
+		t.Fatal("opened an envelope sealed with a dropped key")
+	}
+	if payload, err := k.Open(rotatedID, rotated, []byte("aad")); err != nil || string(payload) != `{"id":2}` {
+		t.Fatalf("Open with the active key = %s, %v", payload, err)
+	}
+}
+
+func TestKeyringRejectsTamperedEnvelopes(t *testing.T) {
+	provider := &rotatingKeys{}
+	provider.set(newKey, oldKey)
+	k := newTestKeyring(t, provider)
+
+	keyID, sealed, err := k.Seal([]byte(`{"id":1}`), []byte("aad"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-1] ^= 1
+	for name, open := range map[string]func() ([]byte, error){
+		"additional data": func() ([]byte, error) { return k.Open(keyID, sealed, []byte("other")) },
+		"other key":       func() ([]byte, error) { return k.Open(oldKey.ID, sealed, []byte("aad")) },
+		"unknown key":     func() ([]byte, error) { return k.Open("2023-12", sealed, []byte("aad")) },
+		"ciphertext":      func() ([]byte, error) { return k.Open(keyID, flipped, []byte("aad")) },
+		"truncated":       func() ([]byte, error) { return k.Open(keyID, sealed[:8], []byte("aad")) },
+	} {
+		if payload, err := open(); err == nil {
+			t.Errorf("opened an envelope with a tampered %s: %s", name, payload)
+		}
+	}
+}
+
+func TestEncryptedPayloadsCannotMoveToAnotherEnvelope(t *testing.T) {
+	provider := &rotatingKeys{}
+	provider.set(newKey)
+	k := newTestKeyring(t, provider)
+
+	md := message.NewMessageDetails("client-1", "hub-1", "hub-1", []byte(`{"id":1}`))
+	md.ID, md.Room = "order-1", "orders"
+	if err := md.Encrypt(k); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	moved := md
+	moved.Room = "admin"
+	if err := moved.Decrypt(k); err == nil {
+		t.Fatal("decrypted a payload moved to another room")
+	}
+	if err := md.Decrypt(k); err != nil || string(md.Message) != `{"id":1}` || md.KeyID != "" {
+		t.Fatalf("Decrypt = %s, %v", md.Message, err)
+	}
+}
+
+// received returns the envelope of md as other hubs receive it.
+func received(t *testing.T, md message.MessageDetails) *redis.Message {
+	t.Helper()
+
+	data, err := md.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	return &redis.Message{Channel: "test-channel", Payload: string(data)}
+}
+
+func TestEncryptingHubsRejectUnencryptedEnvelopes(t *testing.T) {
+	provider := &rotatingKeys{}
+	provider.set(newKey)
+	k := newTestKeyring(t, provider)
+
+	encrypted := message.NewMessageDetails("client-1", "hub-1", "hub-1", []byte(`{"id":1}`))
+	encrypted.ID = "order-1"
+	if err := encrypted.Encrypt(k); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	plaintext := message.NewMessageDetails("client-1", "hub-1", "hub-1", []byte(`{"id":2}`))
+	plaintext.ID = "order-2"
+
+	for _, acceptPlaintext := range []bool{false, true} {
+		ps := NewPubSub(nil, "test-channel", "hub-2", "", 0, zap.NewNop())
+		ps.EncryptWith(k, acceptPlaintext)
+		broadcastCh := make(chan message.MessageDetails, 2)
+		ps.forward(context.Background(), received(t, encrypted), broadcastCh)
+		ps.forward(context.Background(), received(t, plaintext), broadcastCh)
+		close(broadcastCh)
+
+		var ids []string
+		for md := range broadcastCh {
+			ids = append(ids, md.ID)
+		}
+		want := []string{"order-1"}
+		if acceptPlaintext {
+			want = append(want, "order-2")
+		}
+		if !slices.Equal(ids, want) {
+			t.Fatalf("hub accepting plaintext %v forwarded %v, want %v", acceptPlaintext, ids, want)
+		}
+	}
+}
+
+func TestDecryptedEnvelopesKeepTheSealedEnvelope(t *testing.T) {
+	provider := &rotatingKeys{}
+	provider.set(newKey)
+	k := newTestKeyring(t, provider)
+
+	md := message.NewMessageDetails("client-1", "hub-1", "hub-1", []byte(`{"card":"4111"}`))
+	md.ID = "order-1"
+	if err := md.Encrypt(k); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	msg := received(t, md)
+
+	ps := NewPubSub(nil, "test-channel", "hub-2", "", 0, zap.NewNop())
+	ps.EncryptWith(k, false)
+	broadcastCh := make(chan message.MessageDetails, 1)
+	ps.forward(context.Background(), msg, broadcastCh)
+	forwarded := <-broadcastCh
+	if string(forwarded.Message) != `{"card":"4111"}` {
+		t.Fatalf("forwarded %s, want the decrypted payload", forwarded.Message)
+	}
+	if string(forwarded.Sealed) != msg.Payload || bytes.Contains(forwarded.Sealed, []byte("4111")) {
+		t.Fatalf("forwarded envelope sealed as %s, want the encrypted envelope %s", forwarded.Sealed, msg.Payload)
+	}
+}
@@ -0,0 +1,126 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	handoffKeyPrefix  = "handoff:"
+	snapshotKeyPrefix = "handoff-snapshot:"
+)
+
+// HandoffState is the state of a connection closed by a draining hub that the client's next
+// connection, to any hub, resumes: the user it belonged to and the rooms it was subscribed to.
+type HandoffState struct {
+	UserID string        `json:"user_id,omitempty"`
+	Rooms  []HandoffRoom `json:"rooms"`
+}
+
+// HandoffRoom is a room of a handed-off connection with the history cursor of the last message
+// written to the client, empty when the room keeps no history or none was written.
+type HandoffRoom struct {
+	Room   string `json:"room"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Handoffs keeps the state of drained connections in Redis under handoff:<token> until the client
+// reconnects with the token or the state expires.
+type Handoffs struct {
+	client *Client
+}
+
+// NewHandoffs creates a new Handoffs.
+func NewHandoffs(client *Client) *Handoffs {
+	return &Handoffs{client: client}
+}
+
+// Save stores the state of a drained connection under the token for ttl.
+func (h *Handoffs) Save(ctx context.Context, token string, state HandoffState, ttl time.Duration) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal handoff state: %w", err)
+	}
+	if err := h.client.Set(ctx, handoffKeyPrefix+token, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save handoff state: %w", err)
+	}
+	return nil
+}
+
+// Take removes and returns the state stored under the token, reporting false when there is none,
+// so each handoff is resumed at most once.
+func (h *Handoffs) Take(ctx context.Context, token string) (HandoffState, bool, error) {
+	data, err := h.client.GetDel(ctx, handoffKeyPrefix+token).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return HandoffState{}, false, nil
+	}
+	if err != nil {
+		return HandoffState{}, false, fmt.Errorf("failed to take handoff state: %w", err)
+	}
+
+	var state HandoffState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return HandoffState{}, false, fmt.Errorf("failed to unmarshal handoff state: %w", err)
+	}
+	return state, true, nil
+}
+
+// SaveSnapshot stores the tokens of the connections a hub handed off while draining under
+// handoff-snapshot:<hub> for ttl, for the hub to await their clients once it restarts.
+func (h *Handoffs) SaveSnapshot(ctx context.Context, hubName string, tokens []string, ttl time.Duration) error {
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return fmt.Errorf("failed to marshal handoff snapshot: %w", err)
+	}
+	if err := h.client.Set(ctx, snapshotKeyPrefix+hubName, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save handoff snapshot: %w", err)
+	}
+	return nil
+}
+
+// TakeSnapshot removes and returns the tokens the hub handed off before it restarted, none when
+// it saved no snapshot or the snapshot expired.
+func (h *Handoffs) TakeSnapshot(ctx context.Context, hubName string) ([]string, error) {
+	data, err := h.client.GetDel(ctx, snapshotKeyPrefix+hubName).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take handoff snapshot: %w", err)
+	}
+
+	var tokens []string
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal handoff snapshot: %w", err)
+	}
+	return tokens, nil
+}
+
+// Pending returns the tokens whose handoff is yet to be resumed by any hub and has not expired.
+func (h *Handoffs) Pending(ctx context.Context, tokens []string) ([]string, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	pipe := h.client.Pipeline()
+	exists := make([]*redis.IntCmd, len(tokens))
+	for i, token := range tokens {
+		exists[i] = pipe.Exists(ctx, handoffKeyPrefix+token)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to check handoffs: %w", err)
+	}
+
+	pending := make([]string, 0, len(tokens))
+	for i, cmd := range exists {
+		if cmd.Val() > 0 {
+			pending = append(pending, tokens[i])
+		}
+	}
+	return pending, nil
+}
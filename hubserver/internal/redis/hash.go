@@ -0,0 +1,19 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WriteHash sets the fields of the hash at key and refreshes its expiry in a single round trip.
+func (c *Client) WriteHash(ctx context.Context, key string, values map[string]interface{}, ttl time.Duration) error {
+	pipe := c.TxPipeline()
+	pipe.HSet(ctx, key, values)
+	pipe.Expire(ctx, key, ttl)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to write hash %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+const (
+	historyKeyPrefix = "room-history:"
+	historyField     = "envelope"
+)
+
+// HistoryEntry is a message kept in a room's history. Cursor identifies its position and is
+// passed back to read the messages after it.
+type HistoryEntry struct {
+	Cursor  string
+	Message message.MessageDetails
+}
+
+// History keeps the recent messages of rooms in Redis streams, trimmed to each room's retention policy.
+type History struct {
+	client   *Client
+	policies map[string]config.RetentionPolicy
+	logger   *zap.Logger
+}
+
+// NewHistory creates a new History for the rooms with a retention policy.
+func NewHistory(client *Client, policies map[string]config.RetentionPolicy, logger *zap.Logger) *History {
+	return &History{
+		client:   client,
+		policies: policies,
+		logger:   logger,
+	}
+}
+
+// Enabled reports whether the room keeps history.
+func (h *History) Enabled(room string) bool {
+	_, ok := h.policies[room]
+	return ok
+}
+
+// Append records the message in its room's history, trims the history to the room's policy and
+// returns the cursor of the recorded message.
+func (h *History) Append(ctx context.Context, md *message.MessageDetails) (string, error) {
+	policy, ok := h.policies[md.Room]
+	if !ok {
+		return "", nil
+	}
+
+	data, err := md.ToJSON()
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal history message: %w", err)
+	}
+
+	key := historyKeyPrefix + md.Room
+	pipe := h.client.TxPipeline()
+	added := pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: policy.MaxCount,
+		Approx: true,
+		Values: map[string]interface{}{historyField: data},
+	})
+	if policy.MaxAge > 0 {
+		pipe.XTrimMinIDApprox(ctx, key, minID(policy.MaxAge), 0)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", fmt.Errorf("failed to append to history of room %s: %w", md.Room, err)
+	}
+	return added.Val(), nil
+}
+
+// Range returns up to limit messages of the room's history after the cursor, oldest first. An
+// empty cursor reads from the start of the retained history.
+func (h *History) Range(ctx context.Context, room, after string, limit int64) ([]HistoryEntry, error) {
+	policy := h.policies[room]
+
+	start := "-"
+	if after != "" {
+		start = "(" + after
+	}
+	if policy.MaxAge > 0 && (after == "" || streamIDBefore(after, minID(policy.MaxAge))) {
+		// Trimming is approximate, so entries past the age bound may linger until the next append.
+		start = minID(policy.MaxAge)
+	}
+
+	entries, err := h.client.XRangeN(ctx, historyKeyPrefix+room, start, "+", limit).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of room %s: %w", room, err)
+	}
+
+	history := make([]HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		data, _ := entry.Values[historyField].(string)
+
+		var md message.MessageDetails
+		if err := md.FromJSON([]byte(data)); err != nil {
+			h.logger.Error("Skipping malformed history message", zap.String("room", room), zap.String("cursor", entry.ID), zap.Error(err))
+			continue
+		}
+		history = append(history, HistoryEntry{Cursor: entry.ID, Message: md})
+	}
+	return history, nil
+}
+
+// minID returns the stream id of the oldest entry younger than maxAge.
+func minID(maxAge time.Duration) string {
+	return strconv.FormatInt(time.Now().Add(-maxAge).UnixMilli(), 10) + "-0"
+}
+
+// ValidCursor reports whether the cursor has the form of a stream entry id.
+func ValidCursor(cursor string) bool {
+	_, _, ok := parseStreamID(cursor)
+	return ok
+}
+
+// CursorBefore reports whether cursor a precedes cursor b, false when either is invalid.
+func CursorBefore(a, b string) bool {
+	return ValidCursor(a) && ValidCursor(b) && streamIDBefore(a, b)
+}
+
+// streamIDBefore reports whether stream id a precedes b. Both must be valid.
+func streamIDBefore(a, b string) bool {
+	aMs, aSeq, _ := parseStreamID(a)
+	bMs, bSeq, _ := parseStreamID(b)
+	return aMs < bMs || (aMs == bMs && aSeq < bSeq)
+}
+
+func parseStreamID(id string) (uint64, uint64, bool) {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	msValue, errMs := strconv.ParseUint(ms, 10, 64)
+	seqValue, errSeq := strconv.ParseUint(seq, 10, 64)
+	return msValue, seqValue, errMs == nil && errSeq == nil
+}
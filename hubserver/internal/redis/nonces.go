@@ -0,0 +1,34 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const nonceKeyPrefix = "publish-nonces:"
+
+// NonceStore records the nonces of signed publishes so a frame replayed to any hub is detected.
+type NonceStore struct {
+	client *Client
+	logger *zap.Logger
+}
+
+// NewNonceStore creates a new NonceStore.
+func NewNonceStore(client *Client, logger *zap.Logger) *NonceStore {
+	return &NonceStore{
+		client: client,
+		logger: logger,
+	}
+}
+
+// Claim records the nonce for ttl and reports whether it was unused.
+func (s *NonceStore) Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	claimed, err := s.client.SetNX(ctx, nonceKeyPrefix+nonce, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim nonce: %w", err)
+	}
+	return claimed, nil
+}
@@ -2,56 +2,277 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
 	"go.uber.org/zap"
 )
 
 // PubSub manages the Redis pub/sub operations.
 type PubSub struct {
-	client      *Client
-	pubSub      *redis.PubSub
-	channel     string
-	hubID       string
-	broadcastCh chan<- message.MessageDetails
-	logger      *zap.Logger
+	client  *Client
+	pubSub  *redis.PubSub
+	channel string
+	hubID   string
+
+	// shards is the number of channels messages of rooms are spread over by room hash, each received
+	// on its own subscription in shardSubs
+	shards    int
+	shardSubs []*redis.PubSub
+
+	// Payloads larger than compressionThreshold bytes are published compressed with compression
+	compression          string
+	compressionThreshold int
+	// keyring encrypts the payloads of published envelopes and decrypts those received, when set;
+	// received envelopes without a key id are rejected unless acceptPlaintext is set
+	keyring         *Keyring
+	acceptPlaintext bool
+
+	// zones routes messages of rooms without members in other zones over the zone's channel
+	zones *ZoneDirectory
+	// routes routes messages addressed to one connection over the channel of the hub holding it
+	routes *RouteTable
+	// deadLetters records the received envelopes that could not be decoded, which are replayed to
+	// the channel the hub's subscriptions forward messages to
+	deadLetters *DeadLetters
+	received    atomic.Pointer[chan<- message.MessageDetails]
+
+	logger *zap.Logger
 }
 
-// NewPubSub creates a new PubSub instance.
-func NewPubSub(client *Client, channel, hubID string, broadcastCh chan<- message.MessageDetails, logger *zap.Logger) *PubSub {
+// NewPubSub creates a new PubSub instance. Payloads larger than threshold bytes are compressed with
+// the given content encoding before publishing; an empty encoding disables compression.
+func NewPubSub(client *Client, channel, hubID, compression string, threshold int, logger *zap.Logger) *PubSub {
 	return &PubSub{
-		client:      client,
-		channel:     channel,
-		hubID:       hubID,
-		broadcastCh: broadcastCh,
-		logger:      logger,
+		client:               client,
+		channel:              channel,
+		hubID:                hubID,
+		compression:          compression,
+		compressionThreshold: threshold,
+		logger:               logger,
+	}
+}
+
+// RouteByZone publishes messages of rooms whose members are all in the hub's zone on a channel
+// only the zone's hubs subscribe to, so they don't cross zones.
+func (ps *PubSub) RouteByZone(zones *ZoneDirectory) {
+	ps.zones = zones
+}
+
+// zoneChannel returns the channel of the hub's zone.
+func (ps *PubSub) zoneChannel() string {
+	return ps.channel + ":zone:" + ps.zones.Zone()
+}
+
+// ShardRooms spreads the messages of rooms over shards channels by room hash, so a single channel
+// does not bound the hub's cross-hub throughput. The hub receives each shard on its own
+// subscription and goroutine; messages of a room stay in order since they share a shard.
+func (ps *PubSub) ShardRooms(shards int) {
+	ps.shards = shards
+}
+
+// shardChannel returns the shard channel messages of the room are published on.
+func (ps *PubSub) shardChannel(room string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(room))
+	return ps.shardName(int(h.Sum32() % uint32(ps.shards)))
+}
+
+// shardName returns the name of the shard channel with the index.
+func (ps *PubSub) shardName(shard int) string {
+	return ps.channel + ":shard:" + strconv.Itoa(shard)
+}
+
+// shardChannels returns every shard channel, none when rooms are not sharded.
+func (ps *PubSub) shardChannels() []string {
+	if ps.shards < 2 {
+		return nil
+	}
+	channels := make([]string, ps.shards)
+	for i := range channels {
+		channels[i] = ps.shardName(i)
 	}
+	return channels
 }
 
-func (ps *PubSub) Subscribe(ctx context.Context) {
-	ps.pubSub = ps.client.Subscribe(ctx, ps.channel)
-	for msg := range ps.pubSub.Channel() {
-		var md message.MessageDetails
-		if err := md.FromJSON([]byte(msg.Payload)); err != nil {
-			ps.logger.Error("Failed to unmarshal message", zap.Error(err))
-			continue
+// RouteTargets publishes messages addressed to one connection, such as receipts and evictions, on a
+// channel only the hub holding the connection subscribes to, instead of to every hub.
+func (ps *PubSub) RouteTargets(routes *RouteTable) {
+	ps.routes = routes
+}
+
+// EncryptWith encrypts the payloads of the envelopes the hub publishes with the keyring, after
+// compressing them, and decrypts those of the envelopes it receives. Envelopes received unencrypted
+// are rejected, so anyone who can publish to Redis cannot inject plaintext ones, unless
+// acceptPlaintext is set while encryption rolls out to hubs that predate it.
+func (ps *PubSub) EncryptWith(keyring *Keyring, acceptPlaintext bool) {
+	ps.keyring = keyring
+	ps.acceptPlaintext = acceptPlaintext
+}
+
+// DeadLetterTo records the envelopes received from other hubs that cannot be decoded in the
+// dead letters, instead of only logging them, and delivers the dead letters replayed to the hub.
+func (ps *PubSub) DeadLetterTo(deadLetters *DeadLetters) {
+	ps.deadLetters = deadLetters
+	deadLetters.redeliver = ps.redeliver
+}
+
+// redeliver forwards a replayed envelope received on the channel like the hub's subscriptions do.
+func (ps *PubSub) redeliver(ctx context.Context, channel string, envelope []byte) error {
+	broadcastCh := ps.received.Load()
+	if broadcastCh == nil {
+		return errors.New("hub is not subscribed")
+	}
+	ps.forward(ctx, &redis.Message{Channel: channel, Payload: string(envelope)}, *broadcastCh)
+	return nil
+}
+
+// hubChannel returns the channel of a single hub.
+func (ps *PubSub) hubChannel(hubID string) string {
+	return ps.channel + ":hub:" + hubID
+}
+
+// channels returns the channels the hub receives messages on.
+func (ps *PubSub) channels() []string {
+	channels := []string{ps.channel}
+	if ps.zones != nil {
+		channels = append(channels, ps.zoneChannel())
+	}
+	if ps.routes != nil {
+		channels = append(channels, ps.hubChannel(ps.routes.HubID()))
+	}
+	return channels
+}
+
+// route returns the channel a message is published on: the channel of the hub holding its target
+// connection, the zone's channel for rooms without members in other zones, the room's shard
+// channel, or the shared channel.
+func (ps *PubSub) route(ctx context.Context, md *message.MessageDetails) string {
+	if ps.routes != nil && md.TargetID != "" {
+		hubID, err := ps.routes.Lookup(ctx, md.TargetID)
+		switch {
+		case err != nil:
+			ps.logger.Warn("Failed to look up connection route, publishing to every hub", zap.String("target-id", md.TargetID), zap.Error(err))
+		case hubID != "":
+			metrics.TargetedMessages.WithLabelValues("routed").Inc()
+			return ps.hubChannel(hubID)
+		}
+		metrics.TargetedMessages.WithLabelValues("broadcast").Inc()
+		return ps.channel
+	}
+
+	if ps.zones != nil {
+		if md.Room != "" && md.Kind == message.KindMessage && !ps.zones.RemoteMembers(ctx, md.Room) {
+			metrics.ZoneRoutedMessages.WithLabelValues("zone").Inc()
+			return ps.zoneChannel()
 		}
+		metrics.ZoneRoutedMessages.WithLabelValues("global").Inc()
+	}
+	if ps.shards > 1 && md.Room != "" && md.Kind == message.KindMessage {
+		return ps.shardChannel(md.Room)
+	}
+	return ps.channel
+}
+
+// Subscribe subscribes to the Redis pub/sub channel and forwards messages from other hubs to broadcastCh.
+func (ps *PubSub) Subscribe(ctx context.Context, broadcastCh chan<- message.MessageDetails) {
+	ps.received.Store(&broadcastCh)
+	ps.pubSub = ps.client.Subscribe(ctx, ps.channels()...)
+
+	var wg sync.WaitGroup
+	for _, channel := range ps.shardChannels() {
+		sub := ps.client.Subscribe(ctx, channel)
+		ps.shardSubs = append(ps.shardSubs, sub)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ps.receive(ctx, sub, broadcastCh)
+		}()
+	}
+	ps.receive(ctx, ps.pubSub, broadcastCh)
+	wg.Wait()
+}
+
+// receive forwards the messages of other hubs received on the subscription to broadcastCh until it is closed.
+func (ps *PubSub) receive(ctx context.Context, sub *redis.PubSub, broadcastCh chan<- message.MessageDetails) {
+	for msg := range sub.Channel() {
+		ps.forward(ctx, msg, broadcastCh)
+	}
+}
 
-		if md.HubID != ps.hubID {
-			md.SenderID = ps.channel
-			ps.broadcastCh <- md
+// forward decodes an envelope received on a channel and forwards it to broadcastCh unless this hub
+// published it.
+func (ps *PubSub) forward(ctx context.Context, msg *redis.Message, broadcastCh chan<- message.MessageDetails) {
+	var md message.MessageDetails
+	if err := md.FromJSON([]byte(msg.Payload)); err != nil {
+		ps.logger.Error("Failed to unmarshal message", zap.Error(err))
+		ps.deadLetter(ctx, msg, DeadLetterUnmarshal, err)
+		return
+	}
+	if ps.keyring != nil {
+		if md.KeyID == "" && !ps.acceptPlaintext {
+			ps.logger.Error("Rejecting unencrypted message", zap.String("id", md.ID), zap.String("hub-id", md.HubID))
+			ps.deadLetter(ctx, msg, DeadLetterDecrypt, errUnencrypted)
+			return
+		}
+		if md.KeyID != "" {
+			md.Sealed = []byte(msg.Payload)
+		}
+		if err := md.Decrypt(ps.keyring); err != nil {
+			ps.logger.Error("Failed to decrypt message", zap.String("id", md.ID), zap.Error(err))
+			ps.deadLetter(ctx, msg, DeadLetterDecrypt, err)
+			return
 		}
 	}
+	if err := md.Decompress(); err != nil {
+		ps.logger.Error("Failed to decompress message", zap.String("id", md.ID), zap.Error(err))
+		ps.deadLetter(ctx, msg, DeadLetterDecompress, err)
+		return
+	}
+	if err := md.DecodePayload(ctx); err != nil {
+		ps.logger.Error("Failed to decode message", zap.String("id", md.ID), zap.Error(err))
+		ps.deadLetter(ctx, msg, DeadLetterDecode, err)
+		return
+	}
+
+	if md.HubID != ps.hubID {
+		md.SenderID = ps.channel
+		broadcastCh <- md
+	}
+}
+
+// deadLetter records a received envelope that was rejected for the reason, when dead letters are enabled.
+func (ps *PubSub) deadLetter(ctx context.Context, msg *redis.Message, reason string, cause error) {
+	if ps.deadLetters == nil {
+		return
+	}
+	if err := ps.deadLetters.Add(ctx, msg.Channel, reason, cause, []byte(msg.Payload)); err != nil {
+		ps.logger.Error("Failed to dead-letter message", zap.String("reason", reason), zap.Error(err))
+		return
+	}
+	metrics.DeadLetters.WithLabelValues(reason).Inc()
 }
 
 // Unsubscribe unsubscribes from the Redis pub/sub channel.
 func (ps *PubSub) Unsubscribe(ctx context.Context) error {
-	if err := ps.pubSub.Unsubscribe(ctx, ps.channel); err != nil {
+	if err := ps.pubSub.Unsubscribe(ctx, ps.channels()...); err != nil {
 		ps.logger.Error("Failed to unsubscribe from Redis channel", zap.String("channel", ps.channel), zap.Error(err))
 		return fmt.Errorf("failed to unsubscribe from Redis channel: %s, error: %w", ps.channel, err)
 	}
+	for _, sub := range ps.shardSubs {
+		if err := sub.Unsubscribe(ctx); err != nil {
+			ps.logger.Error("Failed to unsubscribe from Redis shard channel", zap.String("channel", ps.channel), zap.Error(err))
+			return fmt.Errorf("failed to unsubscribe from Redis shard channels of %s: %w", ps.channel, err)
+		}
+	}
 
 	ps.logger.Info("Unsubscribed from Redis channel", zap.String("channel", ps.channel))
 	return nil
@@ -59,19 +280,47 @@ func (ps *PubSub) Unsubscribe(ctx context.Context) error {
 
 // Publish publishes a message to the Redis pub/sub channel.
 func (ps *PubSub) Publish(ctx context.Context, md *message.MessageDetails) error {
-	data, err := md.ToJSON()
+	_, err := ps.PublishCounted(ctx, md)
+	return err
+}
+
+// PublishCounted publishes a message to the other hubs and returns the number of hubs subscribed to
+// the channel it was published on, besides this one.
+func (ps *PubSub) PublishCounted(ctx context.Context, md *message.MessageDetails) (int, error) {
+	envelope := *md
+	if err := envelope.EncodePayload(ctx); err != nil {
+		ps.logger.Error("Failed to encode message", zap.Error(err))
+		return 0, fmt.Errorf("failed to publish message: %w", err)
+	}
+	if err := envelope.Compress(ps.compression, ps.compressionThreshold); err != nil {
+		ps.logger.Error("Failed to compress message", zap.Error(err))
+		return 0, fmt.Errorf("failed to publish message: %w", err)
+	}
+	if ps.keyring != nil {
+		if err := envelope.Encrypt(ps.keyring); err != nil {
+			ps.logger.Error("Failed to encrypt message", zap.Error(err))
+			return 0, fmt.Errorf("failed to publish message: %w", err)
+		}
+	}
+
+	data, err := envelope.ToJSON()
 	if err != nil {
 		ps.logger.Error("Failed to marshal message", zap.Error(err))
-		return fmt.Errorf("failed to publish message: %w", err)
+		return 0, fmt.Errorf("failed to publish message: %w", err)
 	}
 
-	result := ps.client.Publish(ctx, ps.channel, data)
-	if err := result.Err(); err != nil {
+	channel := ps.route(ctx, md)
+	receivers, err := ps.client.Publish(ctx, channel, data).Result()
+	if err != nil {
 		ps.logger.Error("Failed to publish message to Redis", zap.Error(err))
-		return err
+		return 0, err
 	}
 
-	return nil
+	// The hub receives what it publishes on its own channels and drops it
+	if slices.Contains(ps.channels(), channel) || slices.Contains(ps.shardChannels(), channel) {
+		receivers--
+	}
+	return max(int(receivers), 0), nil
 }
 
 // Close closes the PubSub connection.
@@ -80,6 +329,12 @@ func (ps *PubSub) Close() error {
 		ps.logger.Error("Failed to close Redis pubsub connection", zap.String("channel", ps.channel), zap.Error(err))
 		return fmt.Errorf("failed to close Redis pubsub connection: %w", err)
 	}
+	for _, sub := range ps.shardSubs {
+		if err := sub.Close(); err != nil {
+			ps.logger.Error("Failed to close Redis shard pubsub connection", zap.String("channel", ps.channel), zap.Error(err))
+			return fmt.Errorf("failed to close Redis shard pubsub connection: %w", err)
+		}
+	}
 
 	ps.logger.Info("Redis pubsub connection closed successfully", zap.String("channel", ps.channel))
 	return nil
@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const rateLimitKeyPrefix = "rate-limit:"
+
+// takeTokenScript refills the token bucket in KEYS[1] at ARGV[1] tokens per second up to ARGV[2]
+// tokens, by the milliseconds elapsed since its last refill on the Redis clock, and takes a token
+// from it. It returns 1 when a token was taken and 0 when the bucket was empty. Buckets expire once
+// they would have refilled completely.
+var takeTokenScript = redis.NewScript(`
+if redis.replicate_commands then
+	redis.replicate_commands()
+end
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = time[1] * 1000 + math.floor(time[2] / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+else
+	tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return allowed
+`)
+
+// RateLimiter enforces a message rate per key shared by every hub instance, with a token bucket per
+// key held in Redis under rate-limit:<key>.
+type RateLimiter struct {
+	client *Client
+	rate   float64
+	burst  int
+	// ttl is the time an untouched bucket takes to refill, after which it is dropped
+	ttl time.Duration
+}
+
+// NewRateLimiter creates a new RateLimiter allowing rate messages per second per key, with bursts
+// up to burst.
+func NewRateLimiter(client *Client, rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		client: client,
+		rate:   rate,
+		burst:  burst,
+		ttl:    time.Duration(math.Ceil(float64(burst)/rate*1000)) * time.Millisecond,
+	}
+}
+
+// Allow takes a token from the key's bucket and reports whether one was left.
+func (l *RateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	args := []interface{}{l.rate, l.burst, max(l.ttl.Milliseconds(), 1)}
+	allowed, err := takeTokenScript.Run(ctx, l.client.Client, []string{rateLimitKeyPrefix + key}, args...).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to take rate limit token for %s: %w", key, err)
+	}
+	return allowed == 1, nil
+}
@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"go.uber.org/zap"
+)
+
+const (
+	roomACLKeyPrefix = "room-acl:"
+	// maxCachedRoomACLs bounds the lookups cached at once, as clients choose the rooms looked up.
+	maxCachedRoomACLs = 10000
+)
+
+// cachedRoomACL is a room lookup cached until expiresAt; found is false for rooms without an ACL.
+type cachedRoomACL struct {
+	acl       config.RoomACL
+	found     bool
+	expiresAt time.Time
+}
+
+// RoomACLStore reads room access control lists from Redis hashes named room-acl:<room>, whose
+// publish and subscribe fields hold |-separated roles. Lookups are cached for ttl, so changes made
+// in Redis take effect within ttl on every hub.
+type RoomACLStore struct {
+	client *Client
+	ttl    time.Duration
+	logger *zap.Logger
+
+	mu    sync.Mutex
+	cache map[string]cachedRoomACL
+}
+
+// NewRoomACLStore creates a new RoomACLStore.
+func NewRoomACLStore(client *Client, ttl time.Duration, logger *zap.Logger) *RoomACLStore {
+	return &RoomACLStore{
+		client: client,
+		ttl:    ttl,
+		logger: logger,
+		cache:  make(map[string]cachedRoomACL),
+	}
+}
+
+// Get returns the room's access control list and whether the room has one.
+func (s *RoomACLStore) Get(ctx context.Context, room string) (config.RoomACL, bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	cached, ok := s.cache[room]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.acl, cached.found, nil
+	}
+
+	fields, err := s.client.HGetAll(ctx, roomACLKeyPrefix+room).Result()
+	if err != nil {
+		s.logger.Error("Failed to read room ACL", zap.String("room", room), zap.Error(err))
+		return config.RoomACL{}, false, fmt.Errorf("failed to read room ACL: %w", err)
+	}
+
+	cached = cachedRoomACL{found: len(fields) > 0, expiresAt: now.Add(s.ttl)}
+	if list, ok := fields[config.RoomPublish]; ok {
+		cached.acl.Publish = config.ParseRoles(list)
+	}
+	if list, ok := fields[config.RoomSubscribe]; ok {
+		cached.acl.Subscribe = config.ParseRoles(list)
+	}
+
+	s.mu.Lock()
+	if len(s.cache) >= maxCachedRoomACLs {
+		clear(s.cache)
+	}
+	s.cache[room] = cached
+	s.mu.Unlock()
+
+	return cached.acl, cached.found, nil
+}
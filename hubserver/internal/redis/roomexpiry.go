@@ -0,0 +1,47 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RoomExpiry expires the history and state Redis keeps for rooms once they go unused. Every hub
+// refreshes the expiry of the rooms it holds subscribers of and of the rooms messages were
+// recorded for, at least once per ttl, so the keys of a room expire ttl after it was last used on
+// any hub.
+type RoomExpiry struct {
+	client *Client
+	ttl    time.Duration
+}
+
+// NewRoomExpiry creates a new RoomExpiry expiring the keys of rooms unused for ttl.
+func NewRoomExpiry(client *Client, ttl time.Duration) *RoomExpiry {
+	return &RoomExpiry{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+// TTL returns the time the keys of rooms are kept after their last refresh.
+func (e *RoomExpiry) TTL() time.Duration {
+	return e.ttl
+}
+
+// Refresh extends the expiry of the history and state of the rooms to ttl from now. Rooms that
+// keep neither are skipped by Redis.
+func (e *RoomExpiry) Refresh(ctx context.Context, rooms []string) error {
+	if len(rooms) == 0 {
+		return nil
+	}
+
+	pipe := e.client.Pipeline()
+	for _, room := range rooms {
+		pipe.Expire(ctx, historyKeyPrefix+room, e.ttl)
+		pipe.Expire(ctx, stateKeyPrefix+room, e.ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to refresh the expiry of %d rooms: %w", len(rooms), err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	connRouteKeyPrefix = "conn-route:"
+	// RouteRefresh is how often a hub refreshes the routes of its connections, which expire after
+	// routeTTL so routes of hubs that stopped without removing them are forgotten.
+	RouteRefresh = 30 * time.Second
+	routeTTL     = 3 * RouteRefresh
+)
+
+// RouteTable maps connection ids to the hub holding them in keys named conn-route:<conn-id>, so
+// messages addressed to one connection are published to that hub alone. Users have no routes, as no
+// envelope is addressed to a user.
+type RouteTable struct {
+	client *Client
+	hubID  string
+}
+
+// NewRouteTable creates a new RouteTable recording the connections of the hub.
+func NewRouteTable(client *Client, hubID string) *RouteTable {
+	return &RouteTable{
+		client: client,
+		hubID:  hubID,
+	}
+}
+
+// HubID returns the id of the hub recording its connections.
+func (rt *RouteTable) HubID() string {
+	return rt.hubID
+}
+
+// Add records that the hub holds the connection.
+func (rt *RouteTable) Add(ctx context.Context, connID string) error {
+	if err := rt.client.Set(ctx, connRouteKeyPrefix+connID, rt.hubID, routeTTL).Err(); err != nil {
+		return fmt.Errorf("failed to add route of connection %s: %w", connID, err)
+	}
+	return nil
+}
+
+// Remove forgets the route of a connection of the hub.
+func (rt *RouteTable) Remove(ctx context.Context, connID string) error {
+	if err := rt.client.Del(ctx, connRouteKeyPrefix+connID).Err(); err != nil {
+		return fmt.Errorf("failed to remove route of connection %s: %w", connID, err)
+	}
+	return nil
+}
+
+// Refresh extends the routes of the hub's connections.
+func (rt *RouteTable) Refresh(ctx context.Context, connIDs []string) error {
+	if len(connIDs) == 0 {
+		return nil
+	}
+
+	pipe := rt.client.Pipeline()
+	for _, connID := range connIDs {
+		pipe.Set(ctx, connRouteKeyPrefix+connID, rt.hubID, routeTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to refresh %d connection routes: %w", len(connIDs), err)
+	}
+	return nil
+}
+
+// Lookup returns the hub holding a connection, or an empty id when no hub has a route to it.
+func (rt *RouteTable) Lookup(ctx context.Context, connID string) (string, error) {
+	hubID, err := rt.client.Get(ctx, connRouteKeyPrefix+connID).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up route of connection %s: %w", connID, err)
+	}
+	return hubID, nil
+}
@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// scheduledBatchSize bounds the number of due messages claimed per poll.
+const scheduledBatchSize = 100
+
+// claimDueScript atomically removes and returns up to ARGV[2] messages scheduled at or before
+// ARGV[1], so each message is claimed by exactly one hub.
+var claimDueScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+if #due > 0 then
+	redis.call('ZREM', KEYS[1], unpack(due))
+end
+return due
+`)
+
+// Scheduler holds messages for delayed delivery in a Redis sorted set scored by delivery time.
+// Every hub polls the set and the hub that claims a due message delivers it.
+type Scheduler struct {
+	client *Client
+	key    string
+	logger *zap.Logger
+}
+
+// NewScheduler creates a new Scheduler storing messages in the sorted set at key.
+func NewScheduler(client *Client, key string, logger *zap.Logger) *Scheduler {
+	return &Scheduler{
+		client: client,
+		key:    key,
+		logger: logger,
+	}
+}
+
+// Schedule holds the message until deliverAt.
+func (s *Scheduler) Schedule(ctx context.Context, md *message.MessageDetails, deliverAt time.Time) error {
+	data, err := md.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal scheduled message: %w", err)
+	}
+
+	member := &redis.Z{Score: float64(deliverAt.UnixMilli()), Member: string(data)}
+	if err := s.client.ZAdd(ctx, s.key, member).Err(); err != nil {
+		return fmt.Errorf("failed to schedule message %s: %w", md.ID, err)
+	}
+	return nil
+}
+
+// Run polls for due messages every interval and passes them to deliver until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration, deliver func(message.MessageDetails)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			due, err := s.claimDue(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.Warn("Failed to claim scheduled messages", zap.String("key", s.key), zap.Error(err))
+				}
+				break
+			}
+			for _, md := range due {
+				deliver(md)
+			}
+			if len(due) < scheduledBatchSize {
+				break
+			}
+		}
+	}
+}
+
+func (s *Scheduler) claimDue(ctx context.Context) ([]message.MessageDetails, error) {
+	args := []interface{}{time.Now().UnixMilli(), scheduledBatchSize}
+	members, err := claimDueScript.Run(ctx, s.client.Client, []string{s.key}, args...).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	due := make([]message.MessageDetails, 0, len(members))
+	for _, member := range members {
+		var md message.MessageDetails
+		if err := md.FromJSON([]byte(member)); err != nil {
+			s.logger.Error("Dropping malformed scheduled message", zap.Error(err))
+			continue
+		}
+		due = append(due, md)
+	}
+	return due, nil
+}
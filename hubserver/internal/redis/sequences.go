@@ -0,0 +1,40 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const sequenceKeyPrefix = "room-seq:"
+
+// RoomSequences issues the sequence numbers of rooms from counters named room-seq:<room>, shared
+// by every hub so the numbers order a room's messages whichever hub they were published to.
+type RoomSequences struct {
+	client *Client
+}
+
+// NewRoomSequences creates a new RoomSequences.
+func NewRoomSequences(client *Client) *RoomSequences {
+	return &RoomSequences{client: client}
+}
+
+// Next issues the next sequence number of each of the rooms in a single round trip, in order, so a
+// room listed several times gets increasing numbers.
+func (s *RoomSequences) Next(ctx context.Context, rooms []string) ([]uint64, error) {
+	pipe := s.client.Pipeline()
+	incrs := make([]*redis.IntCmd, len(rooms))
+	for i, room := range rooms {
+		incrs[i] = pipe.Incr(ctx, sequenceKeyPrefix+room)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to issue room sequence numbers: %w", err)
+	}
+
+	seqs := make([]uint64, len(rooms))
+	for i, incr := range incrs {
+		seqs[i] = uint64(incr.Val())
+	}
+	return seqs, nil
+}
@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	sessionKeyPrefix   = "user-sessions:"
+	hubAliveKeyPrefix  = "hub-alive:"
+	hubAliveTTL        = 15 * time.Second
+	hubAliveRefresh    = 5 * time.Second
+	sessionMemberDelim = "/"
+)
+
+// registerSessionScript atomically prunes the sessions in ARGV[5] onwards, held by hubs found to be no
+// longer alive, applies the policy and registers the new session. It returns 0 when the session is
+// rejected, otherwise 1 followed by the members of the sessions to evict, never the new one.
+var registerSessionScript = redis.NewScript(`
+if #ARGV > 4 then
+	redis.call('ZREM', KEYS[1], unpack(ARGV, 5))
+end
+
+local count = redis.call('ZCARD', KEYS[1])
+local max = tonumber(ARGV[4])
+if ARGV[3] == 'reject-new' and count >= max then
+	return {0}
+end
+
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+local result = {1}
+if ARGV[3] == 'kick-oldest' and count + 1 > max then
+	local excess = count + 1 - max
+	local evicted = {}
+	for _, member in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+		if #evicted == excess then
+			break
+		end
+		if member ~= ARGV[2] then
+			table.insert(evicted, member)
+			table.insert(result, member)
+		end
+	end
+	if #evicted > 0 then
+		redis.call('ZREM', KEYS[1], unpack(evicted))
+	end
+end
+return result
+`)
+
+// Session identifies a connection held by a user on a hub.
+type Session struct {
+	HubID  string
+	ConnID string
+}
+
+// SessionRegistry tracks the connections of every user across all hub instances so a
+// per-user connection limit can be enforced fleet-wide.
+type SessionRegistry struct {
+	client *Client
+	hubID  string
+	policy string
+	max    int
+	logger *zap.Logger
+}
+
+// NewSessionRegistry creates a new SessionRegistry allowing max connections per user. When a user
+// exceeds it, the "kick-oldest" policy evicts the oldest sessions and "reject-new" refuses the new one.
+func NewSessionRegistry(client *Client, hubID, policy string, max int, logger *zap.Logger) *SessionRegistry {
+	return &SessionRegistry{
+		client: client,
+		hubID:  hubID,
+		policy: policy,
+		max:    max,
+		logger: logger,
+	}
+}
+
+// Register records a new connection for the user. It reports whether the connection is accepted and,
+// under the kick-oldest policy, the sessions that must be evicted to make room for it.
+func (sr *SessionRegistry) Register(ctx context.Context, userID, connID string) (bool, []Session, error) {
+	key := sessionKeyPrefix + userID
+	stale, err := sr.staleSessions(ctx, key)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to register session for user %s: %w", userID, err)
+	}
+	args := []interface{}{time.Now().UnixMilli(), sr.member(connID), sr.policy, sr.max}
+	for _, member := range stale {
+		args = append(args, member)
+	}
+
+	result, err := registerSessionScript.Run(ctx, sr.client.Client, []string{key}, args...).Slice()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to register session for user %s: %w", userID, err)
+	}
+
+	if accepted, _ := result[0].(int64); accepted == 0 {
+		return false, nil, nil
+	}
+
+	var evicted []Session
+	for _, value := range result[1:] {
+		member, _ := value.(string)
+		i := strings.LastIndex(member, sessionMemberDelim)
+		if i < 0 {
+			continue
+		}
+		evicted = append(evicted, Session{HubID: member[:i], ConnID: member[i+1:]})
+	}
+	return true, evicted, nil
+}
+
+// staleSessions returns the members of the sessions at key held by hubs that are no longer alive. The
+// liveness of the hubs is read outside the script, whose keys must all be declared up front.
+func (sr *SessionRegistry) staleSessions(ctx context.Context, key string) ([]string, error) {
+	members, err := sr.client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+
+	alive := make(map[string]*redis.IntCmd)
+	pipe := sr.client.Pipeline()
+	for _, member := range members {
+		i := strings.LastIndex(member, sessionMemberDelim)
+		if i < 0 {
+			continue
+		}
+		if hub := member[:i]; alive[hub] == nil {
+			alive[hub] = pipe.Exists(ctx, hubAliveKeyPrefix+hub)
+		}
+	}
+	if len(alive) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var stale []string
+	for _, member := range members {
+		i := strings.LastIndex(member, sessionMemberDelim)
+		if i < 0 || alive[member[:i]].Val() == 0 {
+			stale = append(stale, member)
+		}
+	}
+	return stale, nil
+}
+
+// Unregister removes a connection of the user.
+func (sr *SessionRegistry) Unregister(ctx context.Context, userID, connID string) error {
+	if err := sr.client.ZRem(ctx, sessionKeyPrefix+userID, sr.member(connID)).Err(); err != nil {
+		return fmt.Errorf("failed to unregister session for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// KeepAlive marks the hub as alive until ctx is cancelled, so sessions registered by hubs that
+// stopped without unregistering them are pruned.
+func (sr *SessionRegistry) KeepAlive(ctx context.Context) {
+	key := hubAliveKeyPrefix + sr.hubID
+	ticker := time.NewTicker(hubAliveRefresh)
+	defer ticker.Stop()
+
+	for {
+		if err := sr.client.Set(ctx, key, time.Now().Unix(), hubAliveTTL).Err(); err != nil && ctx.Err() == nil {
+			sr.logger.Warn("Failed to refresh hub liveness", zap.String("key", key), zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := sr.client.Del(context.Background(), key).Err(); err != nil {
+				sr.logger.Warn("Failed to remove hub liveness", zap.String("key", key), zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (sr *SessionRegistry) member(connID string) string {
+	return sr.hubID + sessionMemberDelim + connID
+}
@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+const stateKeyPrefix = "room-state:"
+
+// tombstone is the payload that removes a key from a state room.
+var tombstone = []byte("null")
+
+// RoomState keeps the latest message of every key of state rooms in Redis hashes named
+// room-state:<room>, so a subscriber joining the room only needs the current value of each key
+// instead of the room's full history.
+type RoomState struct {
+	client *Client
+	rooms  []string
+	logger *zap.Logger
+}
+
+// NewRoomState creates a new RoomState for the given state rooms.
+func NewRoomState(client *Client, rooms []string, logger *zap.Logger) *RoomState {
+	return &RoomState{
+		client: client,
+		rooms:  rooms,
+		logger: logger,
+	}
+}
+
+// Enabled reports whether the room is a state room.
+func (s *RoomState) Enabled(room string) bool {
+	return slices.Contains(s.rooms, room)
+}
+
+// Set records the message as the latest value of its key, or removes the key when the message's
+// payload is null.
+func (s *RoomState) Set(ctx context.Context, md *message.MessageDetails) error {
+	key := stateKeyPrefix + md.Room
+	if bytes.Equal(bytes.TrimSpace(md.Message), tombstone) {
+		if err := s.client.HDel(ctx, key, md.Key).Err(); err != nil {
+			return fmt.Errorf("failed to remove key %s of state room %s: %w", md.Key, md.Room, err)
+		}
+		return nil
+	}
+
+	data, err := md.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal state message: %w", err)
+	}
+	if err := s.client.HSet(ctx, key, md.Key, data).Err(); err != nil {
+		return fmt.Errorf("failed to set key %s of state room %s: %w", md.Key, md.Room, err)
+	}
+	return nil
+}
+
+// Snapshot returns the latest message of every key of the room, ordered by key.
+func (s *RoomState) Snapshot(ctx context.Context, room string) ([]message.MessageDetails, error) {
+	values, err := s.client.HGetAll(ctx, stateKeyPrefix+room).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state of room %s: %w", room, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	snapshot := make([]message.MessageDetails, 0, len(keys))
+	for _, key := range keys {
+		var md message.MessageDetails
+		if err := md.FromJSON([]byte(values[key])); err != nil {
+			s.logger.Error("Skipping malformed state message", zap.String("room", room), zap.String("key", key), zap.Error(err))
+			continue
+		}
+		snapshot = append(snapshot, md)
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	roomZonesKeyPrefix = "room-zones:"
+	// maxCachedRoomZones bounds the lookups cached at once, as clients choose the rooms published to.
+	maxCachedRoomZones = 10000
+)
+
+// cachedRoomZones records until expiresAt whether a room has members outside the hub's zone.
+type cachedRoomZones struct {
+	remote    bool
+	expiresAt time.Time
+}
+
+// ZoneDirectory records which zones have members of each room in sorted sets named
+// room-zones:<room>, scored by when a hub of the zone last advertised the room. Zones that stop
+// advertising a room are forgotten after three refresh intervals.
+type ZoneDirectory struct {
+	client  *Client
+	zone    string
+	refresh time.Duration
+	logger  *zap.Logger
+
+	mu    sync.Mutex
+	cache map[string]cachedRoomZones
+}
+
+// NewZoneDirectory creates a new ZoneDirectory for a hub of the zone, refreshed every refresh interval.
+func NewZoneDirectory(client *Client, zone string, refresh time.Duration, logger *zap.Logger) *ZoneDirectory {
+	return &ZoneDirectory{
+		client:  client,
+		zone:    zone,
+		refresh: refresh,
+		logger:  logger,
+		cache:   make(map[string]cachedRoomZones),
+	}
+}
+
+// Zone returns the zone of the hub.
+func (d *ZoneDirectory) Zone() string {
+	return d.zone
+}
+
+// Refresh returns the interval at which the hub advertises its rooms.
+func (d *ZoneDirectory) Refresh() time.Duration {
+	return d.refresh
+}
+
+// Advertise records that the hub's zone has members of the rooms.
+func (d *ZoneDirectory) Advertise(ctx context.Context, rooms []string) error {
+	if len(rooms) == 0 {
+		return nil
+	}
+
+	now := float64(time.Now().Unix())
+	pipe := d.client.Pipeline()
+	for _, room := range rooms {
+		key := roomZonesKeyPrefix + room
+		pipe.ZAdd(ctx, key, &redis.Z{Score: now, Member: d.zone})
+		pipe.Expire(ctx, key, 3*d.refresh)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RemoteMembers reports whether a zone other than the hub's has members of the room. Lookups are
+// cached for one refresh interval; when Redis is unavailable the room is assumed to have remote
+// members, so messages keep reaching every zone.
+func (d *ZoneDirectory) RemoteMembers(ctx context.Context, room string) bool {
+	now := time.Now()
+	d.mu.Lock()
+	cached, ok := d.cache[room]
+	d.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.remote
+	}
+
+	minScore := strconv.FormatInt(now.Add(-3*d.refresh).Unix(), 10)
+	zones, err := d.client.ZRangeByScore(ctx, roomZonesKeyPrefix+room, &redis.ZRangeBy{Min: minScore, Max: "+inf"}).Result()
+	if err != nil {
+		d.logger.Warn("Failed to read room zones, routing across zones", zap.String("room", room), zap.Error(err))
+		return true
+	}
+
+	cached = cachedRoomZones{expiresAt: now.Add(d.refresh)}
+	for _, zone := range zones {
+		if zone != d.zone {
+			cached.remote = true
+			break
+		}
+	}
+
+	d.mu.Lock()
+	if len(d.cache) >= maxCachedRoomZones {
+		clear(d.cache)
+	}
+	d.cache[room] = cached
+	d.mu.Unlock()
+
+	return cached.remote
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/websocket"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultDeadLetterLimit and maxDeadLetterLimit bound the dead letters listed by a request.
+	defaultDeadLetterLimit = 100
+	maxDeadLetterLimit     = 1000
+)
+
+// newAdminServer creates the HTTP server for operational endpoints: metrics, profiling and admin
+// operations. It listens on its own address so these endpoints are never exposed on the public port.
+func (s *Server) newAdminServer() *http.Server {
+	router := gin.Default()
+
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+	})
+
+	for _, sink := range s.metricsSinks {
+		if handler := sink.Handler(); handler != nil {
+			router.GET("/metrics", gin.WrapH(handler))
+			break
+		}
+	}
+
+	// Profiling endpoints of net/http/pprof
+	debug := router.Group("/debug/pprof")
+	debug.GET("/", gin.WrapF(pprof.Index))
+	debug.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/profile", gin.WrapF(pprof.Profile))
+	debug.GET("/symbol", gin.WrapF(pprof.Symbol))
+	debug.POST("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/trace", gin.WrapF(pprof.Trace))
+	debug.GET("/:profile", func(c *gin.Context) {
+		pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+	})
+
+	admin := router.Group("/admin")
+	if s.cfg.AdminToken != "" {
+		admin.Use(requireBearerToken(s.cfg.AdminToken))
+	}
+	admin.GET("/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.messageHandler.Stats())
+	})
+	admin.POST("/ip-filter/reload", func(c *gin.Context) {
+		if err := s.messageHandler.ReloadIPFilter(); err != nil {
+			s.logger.Error("Failed to reload IP filter", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// Connections and rooms of the hub, see hubctl
+	admin.GET("/connections", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.messageHandler.Connections())
+	})
+	admin.DELETE("/connections/:id", func(c *gin.Context) {
+		if !s.messageHandler.Kick(c.Param("id")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	admin.GET("/rooms", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.messageHandler.Rooms())
+	})
+	admin.GET("/room-events", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"events": s.messageHandler.RoomEvents()})
+	})
+	admin.GET("/peers", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.messageHandler.Peers())
+	})
+	admin.GET("/bandwidth", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.messageHandler.Bandwidth())
+	})
+	admin.POST("/broadcast", func(c *gin.Context) {
+		var req struct {
+			Room    string          `json:"room"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(req.Payload) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payload is required"})
+			return
+		}
+
+		result, err := s.messageHandler.Broadcast(c.Request.Context(), req.Room, req.Payload)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+	admin.POST("/drain", func(c *gin.Context) {
+		var over time.Duration
+		if value := c.Query("over"); value != "" {
+			var err error
+			if over, err = time.ParseDuration(value); err != nil || over < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "over must be a non-negative duration"})
+				return
+			}
+		}
+		c.JSON(http.StatusAccepted, gin.H{"connections": s.messageHandler.Drain(over)})
+	})
+
+	// Keepalive of the connection classes, replaceable while connections are established
+	admin.GET("/keepalive-classes", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"classes": s.messageHandler.KeepaliveClasses()})
+	})
+	admin.PUT("/keepalive-classes", func(c *gin.Context) {
+		var req struct {
+			Classes []string `json:"classes"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.messageHandler.SetKeepaliveClasses(req.Classes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"classes": s.messageHandler.KeepaliveClasses()})
+	})
+
+	// Rules routing the keys of published messages to rooms, replaceable while publishers keep their keys
+	admin.GET("/routing-rules", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"rules": s.messageHandler.RoutingRules()})
+	})
+	admin.PUT("/routing-rules", func(c *gin.Context) {
+		var req struct {
+			Rules []string `json:"rules"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.messageHandler.SetRoutingRules(req.Rules); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"rules": s.messageHandler.RoutingRules()})
+	})
+
+	// Client configuration pushed to the hub's connections in config frames, set on each hub
+	admin.GET("/client-config", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"config": s.messageHandler.ClientConfig()})
+	})
+	admin.PUT("/client-config", func(c *gin.Context) {
+		var req struct {
+			Config json.RawMessage `json:"config"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(req.Config) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "config is required"})
+			return
+		}
+		connections, err := s.messageHandler.SetClientConfig(req.Config)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"connections": connections})
+	})
+	admin.DELETE("/client-config", func(c *gin.Context) {
+		_, _ = s.messageHandler.SetClientConfig(nil)
+		c.Status(http.StatusNoContent)
+	})
+	admin.POST("/client-config/push", func(c *gin.Context) {
+		var req struct {
+			Config json.RawMessage `json:"config"`
+			websocket.ConfigTarget
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		connections, err := s.messageHandler.PushClientConfig(req.Config, req.ConfigTarget)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"connections": connections})
+	})
+
+	// Named groups of the hub's connections, assigned by the authorizer or here, targeted in one operation
+	admin.GET("/groups", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.messageHandler.Groups())
+	})
+	admin.POST("/groups/:group/connections", func(c *gin.Context) {
+		var req struct {
+			Connections []string `json:"connections"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		added, err := s.messageHandler.AddToGroup(c.Param("group"), req.Connections)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"connections": added})
+	})
+	admin.DELETE("/groups/:group/connections/:id", func(c *gin.Context) {
+		if !s.messageHandler.RemoveFromGroup(c.Param("group"), c.Param("id")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "connection not in group"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	admin.POST("/groups/:group/broadcast", func(c *gin.Context) {
+		var req struct {
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(req.Payload) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payload is required"})
+			return
+		}
+
+		result, err := s.messageHandler.BroadcastToGroup(c.Param("group"), req.Payload)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+	admin.POST("/groups/:group/kick", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"connections": s.messageHandler.KickGroup(c.Param("group"))})
+	})
+	admin.PUT("/groups/:group/rate", func(c *gin.Context) {
+		var req websocket.GroupRate
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.messageHandler.SetGroupRate(c.Param("group"), &req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, req)
+	})
+	admin.DELETE("/groups/:group/rate", func(c *gin.Context) {
+		if err := s.messageHandler.SetGroupRate(c.Param("group"), nil); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// Envelopes from other hubs that could not be decoded or verified, replayable once fixed
+	admin.GET("/dead-letters", func(c *gin.Context) {
+		limit := int64(defaultDeadLetterLimit)
+		if value := c.Query("limit"); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+				return
+			}
+			limit = min(n, maxDeadLetterLimit)
+		}
+
+		letters, err := s.messageHandler.DeadLetters(c.Request.Context(), limit)
+		if err != nil {
+			s.deadLetterError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, letters)
+	})
+	admin.POST("/dead-letters/:id/replay", func(c *gin.Context) {
+		if !redis.ValidCursor(c.Param("id")) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dead letter id"})
+			return
+		}
+
+		replayed, err := s.messageHandler.ReplayDeadLetter(c.Request.Context(), c.Param("id"))
+		switch {
+		case err != nil:
+			s.deadLetterError(c, err)
+		case !replayed:
+			c.JSON(http.StatusNotFound, gin.H{"error": "dead letter not found"})
+		default:
+			c.Status(http.StatusNoContent)
+		}
+	})
+
+	// Live copy of the broadcast traffic for debugging, see MessageHandler.ServeTap
+	admin.GET("/tap", gin.WrapF(s.messageHandler.ServeTap))
+
+	// HTTP push subscriptions receiving the messages published to a room
+	admin.GET("/push-subscriptions", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.messageHandler.PushSubscriptions())
+	})
+	admin.POST("/push-subscriptions", func(c *gin.Context) {
+		var req struct {
+			Room   string `json:"room"`
+			URL    string `json:"url"`
+			Secret string `json:"secret"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		sub, err := s.messageHandler.AddPushSubscription(req.Room, req.URL, req.Secret)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, sub)
+	})
+	admin.DELETE("/push-subscriptions/:id", func(c *gin.Context) {
+		if !s.messageHandler.RemovePushSubscription(c.Param("id")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "push subscription not found"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	return &http.Server{
+		Addr:    s.cfg.AdminAddr,
+		Handler: router,
+	}
+}
+
+// requireBearerToken rejects requests whose Authorization header does not carry the bearer token.
+func requireBearerToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		presented, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// deadLetterError answers a failed dead-letter request.
+func (s *Server) deadLetterError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, websocket.ErrDeadLettersDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, redis.ErrDeadLetterOfOtherHub):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	s.logger.Error("Dead-letter request failed", zap.Error(err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
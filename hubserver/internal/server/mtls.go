@@ -0,0 +1,36 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+)
+
+// newMTLSServer creates the listener serving the hub's routes to internal services that
+// authenticate with a client certificate issued by one of the configured authorities. Connections
+// without such a certificate fail the TLS handshake.
+func newMTLSServer(cfg *config.Config, handler http.Handler) (*http.Server, error) {
+	pem, err := os.ReadFile(cfg.MTLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mtls client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("mtls client CA file holds no PEM certificates")
+	}
+
+	return &http.Server{
+		Addr:    cfg.MTLSAddr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  pool,
+			MinVersion: tls.VersionTLS12,
+		},
+	}, nil
+}
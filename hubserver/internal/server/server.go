@@ -4,14 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/amqp"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/mesh"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/outbox"
 	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/quic-go/webtransport-go"
 	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
 	"github.com/soumya-codes/realtime-hub/hubserver/internal/websocket"
 	"go.uber.org/zap"
@@ -19,21 +26,44 @@ import (
 
 // Server represents the hub server.
 type Server struct {
+	cfg            *config.Config
 	httpServer     *http.Server
+	adminServer    *http.Server
+	mtlsServer     *http.Server
+	webTransport   *webtransport.Server
 	messageHandler *websocket.MessageHandler
+	redisClient    *redis.Client
+	metricsSinks   []metrics.Sink
+	outbox         *outbox.Tailer
 	logger         *zap.Logger
 }
 
+// outboxPublisherID is the sender and origin of messages published for rows of outbox tables.
+const outboxPublisherID = "outbox"
+
 // NewServer creates a new Server instance.
 func NewServer(cfg *config.Config, logger *zap.Logger) (*Server, error) {
-	// Initialize Redis client
-	redisClient := redis.NewClient(cfg.PubSubHostName, cfg.RedisUsername, cfg.RedisPassword, logger)
-	if err := redisClient.Ping(context.Background()); err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	// Initialize Redis client if the broker or any Redis-backed feature needs it
+	var redisClient *redis.Client
+	if cfg.UsesRedis() {
+		var err error
+		redisClient, err = newRedisClient(cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		if err := redisClient.Ping(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+	}
+
+	// Initialize the cross-hub broker
+	broker, err := newBroker(cfg, redisClient, logger)
+	if err != nil {
+		return nil, err
 	}
 
 	// Initialize MessageHandler
-	messageHandler, err := websocket.NewMessageHandler(redisClient, cfg.PubSubChannelName, cfg.HubName, cfg.BroadcastWorkers, logger)
+	messageHandler, err := websocket.NewMessageHandler(broker, redisClient, cfg, logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create message handler: %w", err)
 	}
@@ -46,47 +76,248 @@ func NewServer(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
 	})
 
-	// Define the WebSocket endpoint
-	router.GET("/ws", func(c *gin.Context) {
+	// Define the WebSocket endpoint, reachable by HTTP/1.1 upgrade and by HTTP/2 extended CONNECT
+	serveWebSocket := func(c *gin.Context) {
 		messageHandler.ServeHTTP(c.Writer, c.Request)
+	}
+	router.GET("/ws", serveWebSocket)
+	router.Handle(http.MethodConnect, "/ws", serveWebSocket)
+
+	// Define the room history endpoint for backfilling missed messages
+	router.GET("/rooms/:room/messages", func(c *gin.Context) {
+		messageHandler.ServeRoomHistory(c.Writer, c.Request, c.Param("room"))
+	})
+	router.GET("/rooms/:room/state", func(c *gin.Context) {
+		messageHandler.ServeRoomState(c.Writer, c.Request, c.Param("room"))
 	})
+	router.POST("/rooms/:room/messages", func(c *gin.Context) {
+		messageHandler.ServePublish(c.Writer, c.Request, c.Param("room"))
+	})
+
+	// Accept links from mesh peers
+	if m, ok := broker.(*mesh.Mesh); ok {
+		router.GET(mesh.Path, gin.WrapH(m))
+	}
 
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf(":%s", cfg.Port),
 		Handler: router,
 	}
+	httpServer.RegisterOnShutdown(messageHandler.CloseHTTP2Connections)
 
-	return &Server{
+	s := &Server{
+		cfg:            cfg,
 		httpServer:     httpServer,
 		messageHandler: messageHandler,
+		redisClient:    redisClient,
+		metricsSinks:   newMetricsSinks(cfg, logger),
 		logger:         logger,
-	}, nil
+	}
+
+	// Publish the rows inserted into the outbox tables of Postgres
+	if cfg.OutboxPostgresURL != "" {
+		s.outbox = newOutbox(cfg, messageHandler, logger)
+	}
+
+	// Serve metrics, profiling and admin endpoints on the internal admin address only
+	if cfg.AdminAddr != "" {
+		s.adminServer = s.newAdminServer()
+	}
+
+	// Serve internal services authenticating with client certificates on their own listener
+	if cfg.MTLSAddr != "" {
+		if s.mtlsServer, err = newMTLSServer(cfg, router); err != nil {
+			return nil, err
+		}
+		s.mtlsServer.RegisterOnShutdown(messageHandler.CloseHTTP2Connections)
+	}
+
+	// Serve WebTransport sessions over HTTP/3 on their own UDP listener
+	if cfg.WebTransportAddr != "" {
+		s.webTransport = newWebTransportServer(cfg, messageHandler)
+	}
+
+	return s, nil
+}
+
+// newRedisClient creates the Redis client, authenticating with the password file's contents, refreshed
+// as they rotate, when one is configured.
+func newRedisClient(cfg *config.Config, logger *zap.Logger) (*redis.Client, error) {
+	if cfg.RedisPasswordFile == "" {
+		return redis.NewClient(cfg.PubSubHostName, cfg.RedisUsername, cfg.RedisPassword, logger), nil
+	}
+	provider := redis.FileCredentials(cfg.RedisUsername, cfg.RedisPasswordFile)
+	return redis.NewClientWithCredentials(context.Background(), cfg.PubSubHostName, provider, cfg.RedisCredentialsRefresh, logger)
+}
+
+// newOutbox creates the tailer of the outbox slot, publishing every row to the hub's connections and
+// the other hubs once the broadcast workers have fanned it out.
+func newOutbox(cfg *config.Config, messageHandler *websocket.MessageHandler, logger *zap.Logger) *outbox.Tailer {
+	rooms, _ := cfg.OutboxRooms()
+	publish := func(ctx context.Context, msg outbox.Message) error {
+		md := message.NewMessageDetails(outboxPublisherID, cfg.HubName, outboxPublisherID, msg.Payload)
+		md.ID = msg.ID
+		md.Room = msg.Room
+		_, err := messageHandler.PublishAndWait(ctx, md)
+		return err
+	}
+	return outbox.NewTailer(cfg.OutboxPostgresURL, cfg.OutboxSlot, cfg.OutboxPublication, rooms, cfg.OutboxPollInterval,
+		cfg.OutboxBatchSize, publish, logger)
+}
+
+// newMetricsSinks creates the sinks the metrics are exposed to.
+func newMetricsSinks(cfg *config.Config, logger *zap.Logger) []metrics.Sink {
+	sinks := make([]metrics.Sink, 0, len(cfg.MetricsSinks))
+	for _, sink := range cfg.MetricsSinks {
+		switch sink {
+		case config.MetricsSinkPrometheus:
+			sinks = append(sinks, metrics.PrometheusSink{})
+		case config.MetricsSinkStatsD, config.MetricsSinkDogStatsD:
+			sinks = append(sinks, metrics.NewStatsDSink(cfg.StatsDAddr, cfg.StatsDInterval, cfg.StatsDPrefix, cfg.StatsDTags,
+				sink == config.MetricsSinkDogStatsD, logger))
+		}
+	}
+	return sinks
+}
+
+// newBroker creates the cross-hub broker selected in the configuration.
+func newBroker(cfg *config.Config, redisClient *redis.Client, logger *zap.Logger) (websocket.Broker, error) {
+	switch cfg.Broker {
+	case config.BrokerRedis:
+		return redis.NewPubSub(redisClient, cfg.PubSubChannelName, cfg.HubName, cfg.RedisCompression, cfg.RedisCompressionThreshold, logger), nil
+	case config.BrokerAMQP:
+		pubSub, err := amqp.NewPubSub(cfg.AMQPURL, cfg.PubSubChannelName, cfg.HubName, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		}
+		return pubSub, nil
+	case config.BrokerMesh:
+		return mesh.NewMesh(cfg.PubSubChannelName, cfg.HubName, cfg.MeshSecret, cfg.MeshPeers, cfg.MeshDNSName, cfg.MeshRefresh, logger), nil
+	case config.BrokerNone:
+		return websocket.NewStandaloneBroker(), nil
+	default:
+		return nil, fmt.Errorf("unsupported broker %q", cfg.Broker)
+	}
 }
 
 // Run starts the server and listens for incoming connections.
 func (s *Server) Run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// Start the MessageHandler
 	go s.messageHandler.Run()
+
+	// Start publishing load statistics for autoscalers
+	statsDone := make(chan struct{})
+	go func() {
+		defer close(statsDone)
+		s.publishStats(ctx)
+	}()
+
+	// Start publishing the rows of the outbox tables
+	outboxDone := make(chan struct{})
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		defer close(outboxDone)
+		if s.outbox != nil {
+			s.outbox.Run(ctx)
+		}
+	}()
+
+	// Start pushing metrics to the sinks that aren't scraped
+	var sinks sync.WaitGroup
+	for _, sink := range s.metricsSinks {
+		sinks.Add(1)
+		go func() {
+			defer sinks.Done()
+			sink.Run(ctx)
+		}()
+	}
+
+	go func() {
+		var err error
+		if s.cfg.TLSCertFile != "" {
+			err = s.httpServer.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.logger.Fatal("HTTP server ListenAndServe", zap.Error(err))
 		}
 	}()
 	s.logger.Info("Server started", zap.String("addr", s.httpServer.Addr))
 
+	if s.adminServer != nil {
+		go func() {
+			if err := s.adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Fatal("Admin server ListenAndServe", zap.Error(err))
+			}
+		}()
+		s.logger.Info("Admin server started", zap.String("addr", s.adminServer.Addr))
+	}
+
+	if s.mtlsServer != nil {
+		go func() {
+			if err := s.mtlsServer.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Fatal("mTLS server ListenAndServeTLS", zap.Error(err))
+			}
+		}()
+		s.logger.Info("mTLS server started", zap.String("addr", s.mtlsServer.Addr))
+	}
+
+	if s.webTransport != nil {
+		go func() {
+			if err := s.webTransport.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Fatal("WebTransport server ListenAndServeTLS", zap.Error(err))
+			}
+		}()
+		s.logger.Info("WebTransport server started", zap.String("addr", s.webTransport.H3.Addr))
+	}
+
+	// Reload the IP filter lists on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	go func() {
+		for range reload {
+			if err := s.messageHandler.ReloadIPFilter(); err != nil {
+				s.logger.Error("Failed to reload IP filter", zap.Error(err))
+			}
+		}
+	}()
+
 	// Handle graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	s.logger.Info("Shutting down server...")
+	cancel()
+	<-statsDone
+	<-outboxDone
+	sinks.Wait()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
 
-	if err := s.httpServer.Shutdown(ctx); err != nil {
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
 		s.logger.Fatal("Server forced to shutdown", zap.Error(err))
 	}
+	if s.mtlsServer != nil {
+		if err := s.mtlsServer.Shutdown(shutdownCtx); err != nil {
+			s.logger.Error("mTLS server forced to shutdown", zap.Error(err))
+		}
+	}
+	if s.webTransport != nil {
+		if err := s.webTransport.Close(); err != nil {
+			s.logger.Error("WebTransport server forced to shutdown", zap.Error(err))
+		}
+	}
+	if s.adminServer != nil {
+		if err := s.adminServer.Shutdown(shutdownCtx); err != nil {
+			s.logger.Error("Admin server forced to shutdown", zap.Error(err))
+		}
+	}
 
 	// Clean up resources
 	if err := s.messageHandler.Close(); err != nil {
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// publishStats periodically writes the hub's load gauges to a Redis hash so autoscalers can read
+// every instance's load from one place. The hash expires if the hub stops refreshing it and is
+// removed when ctx is cancelled.
+func (s *Server) publishStats(ctx context.Context) {
+	interval := s.cfg.StatsInterval
+	if interval <= 0 {
+		return
+	}
+
+	key := fmt.Sprintf("%s:%s", s.cfg.StatsKeyPrefix, s.cfg.HubName)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := s.messageHandler.Stats()
+	lastAt := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.redisClient.Del(context.Background(), key).Err(); err != nil {
+				s.logger.Warn("Failed to remove hub stats", zap.String("key", key), zap.Error(err))
+			}
+			return
+
+		case now := <-ticker.C:
+			stats := s.messageHandler.Stats()
+			rate := float64(stats.MessagesProcessed-last.MessagesProcessed) / now.Sub(lastAt).Seconds()
+			last, lastAt = stats, now
+
+			values := map[string]interface{}{
+				"hub_id":                s.cfg.HubName,
+				"zone":                  s.cfg.Zone,
+				"connections":           stats.Connections,
+				"messages_per_second":   fmt.Sprintf("%.2f", rate),
+				"broadcast_queue_depth": stats.BroadcastQueueDepth,
+				"write_queue_depth":     stats.WriteQueueDepth,
+				"write_queue_bytes":     stats.WriteQueueBytes,
+				"paused_connections":    stats.PausedConnections,
+				"updated_at":            now.Unix(),
+			}
+
+			if err := s.redisClient.WriteHash(ctx, key, values, 3*interval); err != nil {
+				s.logger.Warn("Failed to publish hub stats", zap.String("key", key), zap.Error(err))
+			}
+		}
+	}
+}
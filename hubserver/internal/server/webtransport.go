@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/websocket"
+)
+
+// newWebTransportServer creates the experimental HTTP/3 listener serving WebTransport sessions of
+// the /ws endpoint as connections of the message handler. Like the WebSocket endpoint, it accepts
+// sessions from any origin.
+func newWebTransportServer(cfg *config.Config, messageHandler *websocket.MessageHandler) *webtransport.Server {
+	server := &webtransport.Server{
+		H3:          http3.Server{Addr: cfg.WebTransportAddr},
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		messageHandler.ServeWebTransport(server, w, r)
+	})
+	server.H3.Handler = mux
+	return server
+}
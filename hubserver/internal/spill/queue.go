@@ -0,0 +1,238 @@
+// Package spill implements a disk-backed FIFO queue absorbing bursts that overflow in-memory queues.
+package spill
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrFull is returned by Push when the record would grow the queue beyond its maximum size.
+var ErrFull = errors.New("spill queue is full")
+
+// headerSize is the size of the big-endian length prefixing every record in a segment.
+const headerSize = 4
+
+// segmentPattern matches the segment files of a queue's directory.
+const segmentPattern = "*.seg"
+
+// Queue is a FIFO queue of records appended to a log of segment files in a directory. Records are
+// written to the newest segment until it grows past the segment size, and segments are deleted once
+// all their records were popped. Spilled records are not recovered across restarts: Open discards
+// the segments left behind by a previous process and Close deletes the remaining ones.
+type Queue struct {
+	dir         string
+	segmentSize int64
+	maxSize     int64
+
+	mu       sync.Mutex
+	segments []*segment // oldest first; the last one is written to
+	nextID   uint64
+	size     int64 // bytes of unread records, including their headers
+	length   int
+}
+
+// segment is one file of the log.
+type segment struct {
+	path string
+	// writer appends to the segment while it is the newest one
+	writer *os.File
+	// reader reads the segment's records from the start once the first one is popped
+	reader     *bufio.Reader
+	readerFile *os.File
+	size       int64
+	readSize   int64
+	written    int
+	read       int
+}
+
+// Open creates a queue writing segments of about segmentSize bytes to dir, holding at most maxSize
+// bytes of records, or any amount when maxSize is zero. Segments left in dir by a previous process
+// are discarded.
+func Open(dir string, segmentSize, maxSize int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+
+	stale, err := filepath.Glob(filepath.Join(dir, segmentPattern))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list spill segments: %w", err)
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to discard stale spill segment: %w", err)
+		}
+	}
+
+	return &Queue{
+		dir:         dir,
+		segmentSize: segmentSize,
+		maxSize:     maxSize,
+	}, nil
+}
+
+// Push appends a record to the queue.
+func (q *Queue) Push(record []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := int64(headerSize + len(record))
+	if q.maxSize > 0 && q.size+n > q.maxSize {
+		return ErrFull
+	}
+
+	w, err := q.writeSegment()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, n)
+	binary.BigEndian.PutUint32(buf, uint32(len(record)))
+	copy(buf[headerSize:], record)
+	if _, err := w.writer.Write(buf); err != nil {
+		// Stop writing to the segment, whose end may now hold a partial record
+		_ = w.writer.Close()
+		w.writer = nil
+		return fmt.Errorf("failed to write spill segment: %w", err)
+	}
+
+	w.size += n
+	w.written++
+	q.size += n
+	q.length++
+	return nil
+}
+
+// writeSegment returns the segment records are appended to, starting a new one when the newest
+// segment is full or no longer written to.
+func (q *Queue) writeSegment() (*segment, error) {
+	if len(q.segments) > 0 {
+		w := q.segments[len(q.segments)-1]
+		if w.writer != nil && w.size < q.segmentSize {
+			return w, nil
+		}
+		if w.writer != nil {
+			if err := w.writer.Close(); err != nil {
+				return nil, fmt.Errorf("failed to close spill segment: %w", err)
+			}
+			w.writer = nil
+		}
+		// Earlier segments were read and removed before the newest one was read to its end
+		if w.read == w.written {
+			q.removeOldest()
+		}
+	}
+
+	path := filepath.Join(q.dir, fmt.Sprintf("%020d.seg", q.nextID))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill segment: %w", err)
+	}
+	q.nextID++
+
+	w := &segment{path: path, writer: file}
+	q.segments = append(q.segments, w)
+	return w, nil
+}
+
+// Pop removes and returns the oldest record, reporting false when the queue is empty. A segment
+// that cannot be read is discarded with the records it still held, which Pop reports as an error.
+func (q *Queue) Pop() ([]byte, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.length == 0 {
+		return nil, false, nil
+	}
+
+	s := q.segments[0]
+	record, err := s.next()
+	if err != nil {
+		lost := s.written - s.read
+		q.length -= lost
+		q.size -= s.size - s.readSize
+		if s.writer != nil {
+			_ = s.writer.Close()
+			s.writer = nil
+		}
+		q.removeOldest()
+		return nil, false, fmt.Errorf("discarded %d unreadable spilled records: %w", lost, err)
+	}
+
+	n := int64(headerSize + len(record))
+	s.read++
+	s.readSize += n
+	q.length--
+	q.size -= n
+	if s.read == s.written && s.writer == nil {
+		q.removeOldest()
+	}
+	return record, true, nil
+}
+
+// next reads the segment's next record.
+func (s *segment) next() ([]byte, error) {
+	if s.reader == nil {
+		file, err := os.Open(s.path)
+		if err != nil {
+			return nil, err
+		}
+		s.readerFile = file
+		s.reader = bufio.NewReader(file)
+	}
+
+	var header [headerSize]byte
+	if _, err := io.ReadFull(s.reader, header[:]); err != nil {
+		return nil, err
+	}
+	record := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(s.reader, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// removeOldest deletes the oldest segment, which is no longer written to.
+func (q *Queue) removeOldest() {
+	s := q.segments[0]
+	q.segments = q.segments[1:]
+	if s.readerFile != nil {
+		_ = s.readerFile.Close()
+	}
+	_ = os.Remove(s.path)
+}
+
+// Len returns the number of records in the queue.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.length
+}
+
+// Size returns the bytes the queue's records take on disk.
+func (q *Queue) Size() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// Close deletes the queue's segments and the records they still hold.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.segments) > 0 {
+		if w := q.segments[0]; w.writer != nil {
+			_ = w.writer.Close()
+			w.writer = nil
+		}
+		q.removeOldest()
+	}
+	q.size, q.length = 0, 0
+	return nil
+}
@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+func TestRoomACLsControlPublishAndSubscribeSeparately(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomACLs = []string{"orders:publish=trader|admin", "orders:subscribe=*", "audit:subscribe=auditor"}
+	h, _ := startHubWith(t, cfg)
+
+	trader := auth.Identity{UserID: "alice", Roles: []string{"viewer", "trader"}}
+	viewer := auth.Identity{UserID: "bob", Roles: []string{"viewer"}}
+	auditor := auth.Identity{UserID: "carol", Roles: []string{"auditor"}}
+	for _, tc := range []struct {
+		identity auth.Identity
+		room     string
+		action   string
+		want     bool
+	}{
+		{trader, "orders", config.RoomPublish, true},
+		{viewer, "orders", config.RoomPublish, false},
+		{auth.Identity{}, "orders", config.RoomPublish, false},
+		// * lets every client subscribe, anonymous ones too
+		{viewer, "orders", config.RoomSubscribe, true},
+		{auth.Identity{}, "orders", config.RoomSubscribe, true},
+		{auditor, "audit", config.RoomSubscribe, true},
+		{trader, "audit", config.RoomSubscribe, false},
+		// an action the list leaves out is open, and so are rooms without a list
+		{viewer, "audit", config.RoomPublish, true},
+		{viewer, "lobby", config.RoomPublish, true},
+		{viewer, "orders.eu", config.RoomPublish, true},
+	} {
+		if got := h.authorizeRoom(context.Background(), tc.identity, "", tc.room, tc.action); got != tc.want {
+			t.Errorf("%s with roles %v may %s %s: %v, want %v", tc.identity.UserID, tc.identity.Roles, tc.action, tc.room, got, tc.want)
+		}
+	}
+}
+
+func TestRoomAccessIsDeniedWhenTheAuthorizerFails(t *testing.T) {
+	h, _ := startHub(t)
+	h.SetRoomAuthorizer(RoomAuthorizerFunc(func(context.Context, auth.Identity, string, string) (bool, error) {
+		return true, errors.New("authorizer unavailable")
+	}))
+	for _, action := range []string{config.RoomPublish, config.RoomSubscribe} {
+		if h.authorizeRoom(context.Background(), auth.Identity{UserID: "alice"}, "", "orders", action) {
+			t.Errorf("%s allowed by a failing authorizer", action)
+		}
+	}
+}
+
+func TestPublishesAreHeldToTheRoomACL(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoomACLs = []string{"orders:publish=trader"}
+	h, _ := startHubWith(t, cfg)
+	_, member := attach(t, h, message.Subprotocol, "orders")
+	viewer := publisher(t, h, auth.Identity{UserID: "bob", Roles: []string{"viewer"}})
+	trader := publisher(t, h, auth.Identity{UserID: "alice", Roles: []string{"trader"}})
+
+	if err := viewer.SendJSON(message.Frame{Type: message.FrameMessage, ID: "order-1", Room: "orders", Payload: []byte(`{}`)}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := trader.SendJSON(message.Frame{Type: message.FrameMessage, ID: "order-2", Room: "orders", Payload: []byte(`{}`)}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if frame := receiveFrame(t, member, message.FrameMessage); frame.ID != "order-2" {
+		t.Fatalf("member received %s, want the trader's order-2", frame.ID)
+	}
+}
@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// adminPublisherID is the origin and sender of messages broadcast through the admin API.
+const adminPublisherID = "admin"
+
+// ConnectionInfo describes a connection of the hub to operators.
+type ConnectionInfo struct {
+	ID              string    `json:"id"`
+	SessionID       string    `json:"session_id"`
+	UserID          string    `json:"user_id,omitempty"`
+	RemoteIP        string    `json:"remote_ip,omitempty"`
+	Framed          bool      `json:"framed"`
+	ProtocolVersion int       `json:"protocol_version"`
+	KeepaliveClass  string    `json:"keepalive_class"`
+	Capabilities    []string  `json:"capabilities"`
+	Rooms           []string  `json:"rooms"`
+	Groups          []string  `json:"groups,omitempty"`
+	WriteQueueDepth int       `json:"write_queue_depth"`
+	WriteQueueBytes int64     `json:"write_queue_bytes"`
+	ConnectedAt     time.Time `json:"connected_at"`
+	BytesIn         uint64    `json:"bytes_in"`
+	BytesOut        uint64    `json:"bytes_out"`
+	Throttled       bool      `json:"throttled,omitempty"`
+}
+
+// RoomInfo describes a room of the hub. Rooms left without subscribers are kept until DestroyAt,
+// the end of their grace period.
+type RoomInfo struct {
+	Name        string     `json:"name"`
+	Subscribers int        `json:"subscribers"`
+	CreatedAt   time.Time  `json:"created_at"`
+	EmptySince  *time.Time `json:"empty_since,omitempty"`
+	DestroyAt   *time.Time `json:"destroy_at,omitempty"`
+}
+
+// Connections returns the hub's connections, oldest first.
+func (h *MessageHandler) Connections() []ConnectionInfo {
+	h.mu.RLock()
+	infos := make([]ConnectionInfo, 0, len(h.connections))
+	for _, conn := range h.connections {
+		info := ConnectionInfo{
+			ID:              conn.id,
+			SessionID:       conn.session,
+			UserID:          conn.identity.UserID,
+			Framed:          conn.framed,
+			ProtocolVersion: conn.protocolVersion,
+			KeepaliveClass:  conn.keepaliveClass,
+			Capabilities:    conn.capabilities.names(),
+			WriteQueueDepth: conn.queueDepth(),
+			WriteQueueBytes: conn.queueBytes(),
+			ConnectedAt:     conn.connectedAt,
+			BytesIn:         conn.bytesIn.Load(),
+			BytesOut:        conn.bytesOut.Load(),
+			Throttled:       conn.throttle.Load() != nil,
+			Groups:          conn.groupNames(),
+			Rooms:           conn.roomNames(),
+		}
+		if conn.remoteIP.IsValid() {
+			info.RemoteIP = conn.remoteIP.String()
+		}
+
+		infos = append(infos, info)
+	}
+	h.mu.RUnlock()
+
+	slices.SortFunc(infos, func(a, b ConnectionInfo) int {
+		if c := a.ConnectedAt.Compare(b.ConnectedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return infos
+}
+
+// Kick closes a connection of the hub with the evicted close code and reports whether the hub held it.
+func (h *MessageHandler) Kick(connID string) bool {
+	return h.evictConnection(connID)
+}
+
+// Rooms returns the rooms of the hub, including those in their grace period, by name.
+func (h *MessageHandler) Rooms() []RoomInfo {
+	infos := h.lifecycle.infos()
+	for name, count := range h.roomSubscribers() {
+		info, ok := infos[name]
+		if !ok {
+			info = RoomInfo{Name: name}
+		}
+		info.Subscribers = count
+		infos[name] = info
+	}
+	rooms := make([]RoomInfo, 0, len(infos))
+	for _, info := range infos {
+		rooms = append(rooms, info)
+	}
+	slices.SortFunc(rooms, func(a, b RoomInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return rooms
+}
+
+// RoomEvents returns the last room events of the hub, oldest first.
+func (h *MessageHandler) RoomEvents() []RoomEvent {
+	return h.lifecycle.events()
+}
+
+// Broadcast publishes a payload from the admin API to the room, or to every connection when room is
+// empty, and returns the message's fan-out once it has been broadcast.
+func (h *MessageHandler) Broadcast(ctx context.Context, room string, payload []byte) (PublishResult, error) {
+	md := message.NewMessageDetails(adminPublisherID, h.hubID, adminPublisherID, payload)
+	md.ID = uuid.New().String()
+	md.Room = room
+	result, err := h.publishAndWait(ctx, md)
+	if err != nil {
+		return PublishResult{}, fmt.Errorf("failed to broadcast message: %w", err)
+	}
+	return result, nil
+}
+
+// Drain stops the hub accepting connections and closes the existing ones with the drain close code,
+// spread evenly over the given duration so their clients don't reconnect elsewhere all at once. It
+// returns the number of connections being drained; they are closed in the background.
+func (h *MessageHandler) Drain(over time.Duration) int {
+	h.draining.Store(true)
+
+	h.mu.RLock()
+	connIDs := make([]string, 0, len(h.connections))
+	for connID := range h.connections {
+		connIDs = append(connIDs, connID)
+	}
+	h.mu.RUnlock()
+
+	h.logger.Info("Draining hub", zap.Int("connections", len(connIDs)), zap.Duration("over", over))
+	go h.drainConnections(h.ctx, connIDs, over)
+	return len(connIDs)
+}
+
+// drainConnections closes the connections with the drain close code, pacing the closes over the
+// duration until ctx is done.
+func (h *MessageHandler) drainConnections(ctx context.Context, connIDs []string, over time.Duration) {
+	var pause time.Duration
+	if len(connIDs) > 0 {
+		pause = over / time.Duration(len(connIDs))
+	}
+
+	var tokens []string
+	if h.handoffs != nil {
+		defer func() { h.saveHandoffSnapshot(tokens) }()
+	}
+
+	for i, connID := range connIDs {
+		if i > 0 && pause > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pause):
+			}
+		}
+
+		conn, ok := h.detach(connID)
+		if !ok {
+			continue
+		}
+		reason := h.closeReason(message.ReasonDrain)
+		if h.handoffs != nil {
+			if reason.Handoff = h.handOff(ctx, conn); reason.Handoff != "" {
+				tokens = append(tokens, reason.Handoff)
+			}
+		}
+		if err := conn.CloseWithReason(message.CloseDrain, reason); err != nil {
+			h.logger.Warn("Failed to close drained connection", zap.String("conn-id", connID), zap.Error(err))
+		}
+	}
+	h.logger.Info("Hub drained", zap.Int("connections", len(connIDs)))
+}
@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// maxAggregatedMessages bounds the messages an aggregation room collects in a window; later ones
+// are dropped until the window is delivered.
+const maxAggregatedMessages = 10000
+
+// Reducer combines the JSON payloads of the messages published to an aggregation room over a window
+// into the JSON payload of the single message delivered to the room's subscribers. Payloads of
+// different publishers are not in any particular order.
+type Reducer func(room string, payloads []json.RawMessage) (json.RawMessage, error)
+
+// CountReducer delivers the number of messages of the window, as {"count": n}.
+func CountReducer(_ string, payloads []json.RawMessage) (json.RawMessage, error) {
+	return json.RawMessage(`{"count":` + strconv.Itoa(len(payloads)) + `}`), nil
+}
+
+// ConcatReducer delivers the payloads of the window as a JSON array.
+func ConcatReducer(_ string, payloads []json.RawMessage) (json.RawMessage, error) {
+	return json.Marshal(payloads)
+}
+
+// reducers maps the reducers of the aggregate-rooms setting to their implementation.
+var reducers = map[string]Reducer{
+	config.ReducerCount:  CountReducer,
+	config.ReducerConcat: ConcatReducer,
+}
+
+// aggregation collects the messages of a room over its current window.
+type aggregation struct {
+	room   string
+	window time.Duration
+	reduce Reducer
+
+	mu       sync.Mutex
+	payloads []json.RawMessage
+}
+
+// AggregateRoom delivers the messages published to the room, on any hub, to this hub's subscribers
+// combined by reduce into one message per window. It must be called before Run.
+func (h *MessageHandler) AggregateRoom(room string, window time.Duration, reduce Reducer) {
+	if h.aggregations == nil {
+		h.aggregations = make(map[string]*aggregation)
+	}
+	h.aggregations[room] = &aggregation{room: room, window: window, reduce: reduce}
+}
+
+// aggregate takes the messages of aggregation rooms out of the batch into their room's current
+// window, forwarding those published to this hub to the other hubs, which aggregate them for their
+// own subscribers. Aggregated messages get no receipts or nacks.
+func (h *MessageHandler) aggregate(ctx context.Context, batch []message.MessageDetails) []message.MessageDetails {
+	if len(h.aggregations) == 0 {
+		return batch
+	}
+
+	kept := batch[:0]
+	for _, md := range batch {
+		a, ok := h.aggregations[md.Room]
+		if !ok || md.Kind != message.KindMessage || md.TargetID != "" {
+			kept = append(kept, md)
+			continue
+		}
+
+		h.collect(a, md)
+		h.messagesProcessed.Add(1)
+		h.forwardToRedisIfNeeded(ctx, md)
+	}
+	return kept
+}
+
+// collect adds a message to the current window of its aggregation, starting the window with its
+// first message.
+func (h *MessageHandler) collect(a *aggregation, md message.MessageDetails) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.payloads) >= maxAggregatedMessages {
+		metrics.MessagesDropped.WithLabelValues("aggregation_full").Inc()
+		return
+	}
+	payload := json.RawMessage(md.Message)
+	if !json.Valid(payload) {
+		// Messages of raw connections need not be JSON
+		payload, _ = json.Marshal(string(md.Message))
+	}
+	a.payloads = append(a.payloads, payload)
+	if len(a.payloads) == 1 {
+		time.AfterFunc(a.window, func() { h.deliverAggregate(a) })
+	}
+}
+
+// deliverAggregate delivers the messages of an aggregation's window, combined into one, to the
+// room's subscribers and starts a new window.
+func (h *MessageHandler) deliverAggregate(a *aggregation) {
+	a.mu.Lock()
+	payloads := a.payloads
+	a.payloads = nil
+	a.mu.Unlock()
+
+	payload, err := a.reduce(a.room, payloads)
+	if err != nil {
+		h.logger.Error("Failed to reduce aggregated messages", zap.String("room", a.room), zap.Int("messages", len(payloads)), zap.Error(err))
+		return
+	}
+
+	md := message.NewMessageDetails(h.hubID, h.hubID, h.hubID, payload)
+	md.ID = uuid.New().String()
+	md.Room = a.room
+	md.Local = true
+	h.stampIngest(&md)
+	delivered, _ := h.broadcastToConnections([]message.MessageDetails{md})
+	h.notifyListeners(md)
+	h.roomMetrics.broadcast(md.Room, delivered[0])
+	metrics.AggregatedMessages.Add(float64(len(payloads)))
+}
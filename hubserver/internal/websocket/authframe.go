@@ -0,0 +1,187 @@
+package websocket
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"go.uber.org/zap"
+)
+
+// authFrameLimit is the largest message accepted from a connection until it authenticates.
+const authFrameLimit = 8 << 10
+
+// pendingAuth is the state of a connection accepted without a token while it has the grace period
+// to authenticate with an auth frame.
+type pendingAuth struct {
+	// request is the upgrade request, kept for the authorizer
+	request *http.Request
+	timer   *time.Timer
+
+	mu sync.Mutex
+	// settled is set once the connection authenticated, was rejected or ran out of time
+	settled bool
+}
+
+// settle reports whether the caller is the first to settle the pending authentication.
+func (p *pendingAuth) settle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.settled {
+		return false
+	}
+	p.settled = true
+	return true
+}
+
+// acceptsAuthFrame reports whether a connection request without a token may authenticate with an
+// auth frame after the upgrade, which needs a grace period and a client speaking the hub subprotocol.
+func (h *MessageHandler) acceptsAuthFrame(r *http.Request) bool {
+	return h.authGracePeriod > 0 && slices.Contains(websocket.Subprotocols(r), message.Subprotocol)
+}
+
+// serveUnauthenticated accepts a connection without a token and serves it until it is closed. The
+// connection receives no messages and may send nothing but an auth frame until it authenticates,
+// and is closed with the unauthorized close code when the grace period ends first.
+func (h *MessageHandler) serveUnauthenticated(w http.ResponseWriter, r *http.Request, remoteIP netip.Addr, upgrade upgradeFunc) {
+	conn, err := upgrade(w, r, h, uuid.New().String(), remoteIP, auth.Identity{}, Quota{MaxMessageSize: authFrameLimit}, h.keepaliveClass(r, auth.Identity{}), nil)
+	if err != nil {
+		h.ipFilter.Release(remoteIP)
+		h.logger.Error("Failed to create and add connection", zap.Error(err))
+		return
+	}
+	conn.pendingAuth = &pendingAuth{request: r.Clone(context.Background())}
+	conn.unauthenticated.Store(true)
+	conn.protocolVersion, _ = requestedProtocol(r)
+	if err := h.addConnection(conn, auth.Identity{}, nil); err != nil {
+		h.ipFilter.Release(remoteIP)
+		h.logger.Error("Failed to create and add connection", zap.Error(err))
+		return
+	}
+	h.addRoute(conn.id)
+	h.warnDeprecatedProtocol(conn)
+	conn.pendingAuth.timer = time.AfterFunc(h.authGracePeriod, func() {
+		h.expireAuth(conn)
+	})
+
+	go h.serveConnection(conn)
+	conn.waitStream()
+}
+
+// handleUnauthenticatedFrame handles a frame from a connection that has yet to authenticate,
+// dropping anything but an auth frame.
+func (h *MessageHandler) handleUnauthenticatedFrame(ctx context.Context, conn *Connection, frame message.Frame) {
+	if frame.Type == message.FrameAuth {
+		h.authenticateConnection(ctx, conn, frame.Token)
+		return
+	}
+
+	metrics.MessagesDropped.WithLabelValues("unauthenticated").Inc()
+	h.logger.Warn("Dropping frame from unauthenticated connection", zap.String("conn-id", conn.id), zap.String("type", frame.Type))
+	reply := message.Frame{Type: message.FrameError, ID: frame.ID, Room: frame.Room, Reason: "unauthenticated"}
+	if data, err := reply.ToJSON(); err == nil {
+		h.writeControl(conn.id, data)
+	}
+}
+
+// authenticateConnection verifies the token of a connection's auth frame and, once it is authorized,
+// applies the connection's grant and confirms with an accepted auth frame. Connections that fail
+// are closed with the unauthorized close code.
+func (h *MessageHandler) authenticateConnection(ctx context.Context, conn *Connection, token string) {
+	pending := conn.pendingAuth
+	identity, err := h.authenticator.Verify(token)
+	var grant Authorization
+	if err == nil {
+		grant, err = h.authorize(pending.request, identity)
+	}
+	var evicted []redis.Session
+	if err == nil {
+		evicted, err = h.registerSession(ctx, identity, conn.id)
+	}
+	if err != nil {
+		if pending.settle() {
+			pending.timer.Stop()
+			h.rejectUnauthenticated(conn, err, message.ReasonUnauthorized)
+		}
+		return
+	}
+	if !pending.settle() {
+		// The grace period ended while the token was checked
+		h.unregisterSession(identity, conn.id)
+		return
+	}
+	pending.timer.Stop()
+
+	h.mu.Lock()
+	_, registered := h.connections[conn.id]
+	if registered {
+		conn.identity = identity
+		conn.setLogContext(h)
+	}
+	h.mu.Unlock()
+	if !registered {
+		h.unregisterSession(identity, conn.id)
+		return
+	}
+
+	// The ingest goroutine owns the limiter, and the read pump applies the new read limit before its next read
+	conn.limiter = grant.Quota.limiter()
+	if grant.Quota.MaxMessageSize > 0 {
+		conn.pendingReadLimit.Store(grant.Quota.MaxMessageSize)
+	} else {
+		conn.pendingReadLimit.Store(maxMessageSize)
+	}
+	conn.roomsMu.Lock()
+	conn.maxRooms = cmp.Or(grant.Quota.MaxRooms, h.maxRooms)
+	conn.roomsMu.Unlock()
+	h.joinInitialRooms(conn, grant.Rooms)
+	h.joinGroups(conn, grant.Groups)
+	if token := h.handoffToken(pending.request); token != "" {
+		go h.resumeHandoff(conn, token)
+	}
+	conn.unauthenticated.Store(false)
+
+	h.evictSessions(h.ctx, evicted)
+	reply := message.Frame{Type: message.FrameAuth, Status: message.AuthAccepted}
+	if data, err := reply.ToJSON(); err == nil {
+		h.writeControl(conn.id, data)
+	}
+	h.sendClientConfig(conn)
+	h.logger.Info("Connection authenticated", zap.String("conn-id", conn.id), zap.String("user-id", identity.UserID))
+}
+
+// expireAuth closes a connection that did not authenticate within the grace period.
+func (h *MessageHandler) expireAuth(conn *Connection) {
+	if !conn.pendingAuth.settle() {
+		return
+	}
+	h.rejectUnauthenticated(conn, nil, message.ReasonAuthTimeout)
+}
+
+// rejectUnauthenticated closes a connection that failed to authenticate with the unauthorized close
+// code and the given reason.
+func (h *MessageHandler) rejectUnauthenticated(conn *Connection, err error, reason string) {
+	metric := reason
+	if err != nil {
+		metric, _ = rejectReason(err)
+	}
+	metrics.ConnectionsRejected.WithLabelValues(metric).Inc()
+	h.logger.Warn("Closing unauthenticated connection", zap.String("conn-id", conn.id), zap.String("reason", metric), zap.Error(err))
+
+	if _, ok := h.detach(conn.id); !ok {
+		return
+	}
+	if err := conn.CloseWithReason(message.CloseUnauthorized, message.CloseReason{Reason: reason}); err != nil {
+		h.logger.Error("Error closing connection", zap.String("conn-id", conn.id), zap.Error(err))
+	}
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"slices"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+var (
+	errForbidden             = errors.New("connection denied by authorizer")
+	errAuthorizerUnavailable = errors.New("authorizer unavailable")
+)
+
+// Authorizer decides whether an authenticated client may connect. It runs during the handshake
+// with the upgrade request and the identity parsed from its credentials, so embedding services
+// can apply their own policy, such as directory or database lookups.
+type Authorizer interface {
+	Authorize(r *http.Request, identity auth.Identity) (Authorization, error)
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc func(r *http.Request, identity auth.Identity) (Authorization, error)
+
+// Authorize calls f(r, identity).
+func (f AuthorizerFunc) Authorize(r *http.Request, identity auth.Identity) (Authorization, error) {
+	return f(r, identity)
+}
+
+// Authorization is an Authorizer's decision: whether the connection is allowed, the rooms it is
+// subscribed to on connect, the named groups it is assigned to and the quotas it is held to.
+type Authorization struct {
+	Allow  bool
+	Rooms  []string
+	Groups []string
+	Quota  Quota
+	// Header and Cookies are set on the upgrade response, such as a session cookie or a header
+	// naming the backend that authorized the connection. They replace the hub's upgrade headers of
+	// the same name; headers of the WebSocket handshake are ignored. Connections authenticating
+	// with an auth frame are authorized after the upgrade, so they never receive them.
+	Header  http.Header
+	Cookies []*http.Cookie
+}
+
+// Quota limits what a single connection may send. Zero values leave the hub defaults in place.
+type Quota struct {
+	// MaxMessageSize is the largest message in bytes the connection may send.
+	MaxMessageSize int64
+	// MessagesPerSecond is the sustained rate of messages the connection may send, with bursts up to Burst.
+	MessagesPerSecond float64
+	Burst             int
+	// MaxRooms is the number of rooms the connection may be subscribed to at once.
+	MaxRooms int
+}
+
+// SetAuthorizer installs the Authorizer consulted for every new connection. Without one, every
+// authenticated connection is allowed with the default quotas, joining only the auto-join rooms.
+func (h *MessageHandler) SetAuthorizer(a Authorizer) {
+	h.authorizer = a
+}
+
+// authorize consults the authorizer for the connection request and adds the auto-join rooms
+// expanded for the connection to the rooms it grants.
+func (h *MessageHandler) authorize(r *http.Request, identity auth.Identity) (Authorization, error) {
+	grant := Authorization{Allow: true}
+	if h.authorizer != nil {
+		var err error
+		if grant, err = h.authorizer.Authorize(r, identity); err != nil {
+			return Authorization{}, fmt.Errorf("%w: %v", errAuthorizerUnavailable, err)
+		}
+		if !grant.Allow {
+			return Authorization{}, errForbidden
+		}
+	}
+
+	for _, room := range h.autoJoinRooms(r.Context(), r, identity) {
+		if !slices.Contains(grant.Rooms, room) {
+			grant.Rooms = append(grant.Rooms, room)
+		}
+	}
+	return grant, nil
+}
+
+// responseHeader returns the headers and cookies the authorization sets on the upgrade response.
+func (a Authorization) responseHeader() http.Header {
+	if len(a.Cookies) == 0 {
+		return a.Header
+	}
+	header := a.Header.Clone()
+	if header == nil {
+		header = make(http.Header, 1)
+	}
+	for _, cookie := range a.Cookies {
+		if v := cookie.String(); v != "" {
+			header.Add("Set-Cookie", v)
+		}
+	}
+	return header
+}
+
+// limiter returns the rate limiter enforcing the quota's message rate, or nil when it is unlimited.
+func (q Quota) limiter() *rate.Limiter {
+	if q.MessagesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(q.MessagesPerSecond), max(q.Burst, 1))
+}
+
+// upgradeResponseHeader returns the headers of an upgrade response: the hub's upgrade headers,
+// replaced by the given ones of the same name, without the WebSocket handshake headers the
+// upgrader writes itself.
+func (h *MessageHandler) upgradeResponseHeader(extra http.Header) http.Header {
+	header := make(http.Header, len(h.upgradeHeader)+len(extra)+2)
+	for name, values := range h.upgradeHeader {
+		header[name] = values
+	}
+	for name, values := range extra {
+		if config.HandshakeHeader(name) {
+			h.logger.Warn("Ignoring a WebSocket handshake header set by the authorizer", zap.String("header", name))
+			continue
+		}
+		header[textproto.CanonicalMIMEHeaderKey(name)] = values
+	}
+	return header
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"go.uber.org/zap"
+)
+
+// autoJoinRooms expands the auto-join room templates for a connection being authorized, returning
+// the rooms it joins on connect besides the ones granted by the authorizer. Rooms whose placeholders
+// have no usable value, or that the connection may not subscribe to, are skipped.
+func (h *MessageHandler) autoJoinRooms(ctx context.Context, r *http.Request, identity auth.Identity) []string {
+	if len(h.autoJoin) == 0 {
+		return nil
+	}
+
+	locale := preferredLanguage(r.Header.Get("Accept-Language"))
+	lookup := func(source, name string) string {
+		var value string
+		switch source {
+		case config.PlaceholderLanguage:
+			language, _, _ := strings.Cut(locale, "-")
+			value = strings.ToLower(language)
+		case config.PlaceholderLocale:
+			value = locale
+		case config.PlaceholderClaim:
+			value, _ = identity.Claims[name].(string)
+		case config.PlaceholderHeader:
+			value = strings.TrimSpace(r.Header.Get(name))
+		}
+		// Values come from the client, so they must not change the shape of the room name
+		if !isRoomSegment(value) {
+			return ""
+		}
+		return value
+	}
+
+	var rooms []string
+	for _, template := range h.autoJoin {
+		room, ok := template.Expand(lookup)
+		if !ok {
+			continue
+		}
+		if !h.authorizeRoom(ctx, identity, "", room, config.RoomSubscribe) {
+			h.logger.Debug("Skipping auto-join room the connection may not subscribe to", zap.String("user-id", identity.UserID), zap.String("room", room))
+			continue
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// isRoomSegment reports whether a placeholder value is a non-empty run of letters, digits,
+// dashes and underscores.
+func isRoomSegment(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
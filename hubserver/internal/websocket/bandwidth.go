@@ -0,0 +1,371 @@
+package websocket
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// bandwidthFlushInterval is how often the bytes counted on connections are added to their users' usage.
+const bandwidthFlushInterval = time.Second
+
+// Periods of the bandwidth caps.
+const (
+	periodDaily   = "daily"
+	periodMonthly = "monthly"
+)
+
+var (
+	bytesRead    = metrics.ConnectionBytes.WithLabelValues("in")
+	bytesWritten = metrics.ConnectionBytes.WithLabelValues("out")
+)
+
+// usageCounter adds bytes to the usage of a key in a cap period and returns the usage so far.
+type usageCounter interface {
+	Add(ctx context.Context, key, period string, bytes int64, ttl time.Duration) (int64, error)
+}
+
+// bandwidthCap limits the bytes a user may send and receive per UTC day or month.
+type bandwidthCap struct {
+	period string
+	limit  int64
+}
+
+// window returns the id of the cap period containing now and the time left until it ends.
+func (c bandwidthCap) window(now time.Time) (string, time.Duration) {
+	now = now.UTC()
+	if c.period == periodDaily {
+		return now.Format("2006-01-02"), time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Sub(now)
+	}
+	return now.Format("2006-01"), time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+// UserBandwidth reports the bytes a user, or client IP for anonymous connections, sent and received.
+type UserBandwidth struct {
+	Key         string `json:"key"`
+	Connections int    `json:"connections"`
+	// BytesIn and BytesOut count the bytes read from and written to the user's connections on this hub.
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+	// Daily and Monthly are the bytes counted against the user's caps in the current periods, across
+	// every hub when they share Redis, and Capped is the period of the cap the user exceeded.
+	Daily   int64  `json:"daily,omitempty"`
+	Monthly int64  `json:"monthly,omitempty"`
+	Capped  string `json:"capped,omitempty"`
+}
+
+// userUsage is the bandwidth of a user on this hub and against its caps.
+type userUsage struct {
+	in, out  uint64
+	pending  int64
+	periods  map[string]string
+	usage    map[string]int64
+	capped   string
+	lastSeen time.Time
+}
+
+// bandwidthMeter counts the bytes of every user's connections and enforces the bandwidth caps.
+type bandwidthMeter struct {
+	caps         []bandwidthCap
+	action       string
+	throttleRate int
+	counter      usageCounter
+
+	mu    sync.Mutex
+	users map[string]*userUsage
+}
+
+func newBandwidthMeter(cfg *config.Config, counter usageCounter) *bandwidthMeter {
+	m := &bandwidthMeter{
+		action:       cfg.BandwidthCapAction,
+		throttleRate: cfg.BandwidthThrottleRate,
+		counter:      counter,
+		users:        make(map[string]*userUsage),
+	}
+	if cfg.BandwidthDailyCap > 0 {
+		m.caps = append(m.caps, bandwidthCap{period: periodDaily, limit: cfg.BandwidthDailyCap})
+	}
+	if cfg.BandwidthMonthlyCap > 0 {
+		m.caps = append(m.caps, bandwidthCap{period: periodMonthly, limit: cfg.BandwidthMonthlyCap})
+	}
+	return m
+}
+
+// user returns the usage of the key, creating it when needed. m.mu must be held.
+func (m *bandwidthMeter) user(key string, now time.Time) *userUsage {
+	u, ok := m.users[key]
+	if !ok {
+		u = &userUsage{periods: make(map[string]string), usage: make(map[string]int64)}
+		m.users[key] = u
+	}
+	u.lastSeen = now
+	return u
+}
+
+// collect adds the bytes the connection transferred since it was last collected to the usage of
+// its user's key.
+func (m *bandwidthMeter) collect(conn *Connection, key string, now time.Time) {
+	in, out := conn.bytesIn.Load(), conn.bytesOut.Load()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := m.user(key, now)
+	u.in += in - conn.collectedIn
+	u.out += out - conn.collectedOut
+	u.pending += int64(in-conn.collectedIn) + int64(out-conn.collectedOut)
+	conn.collectedIn, conn.collectedOut = in, out
+}
+
+// meterBandwidth periodically adds the bytes of the hub's connections to their users' usage and
+// applies the cap action to the connections of users over a cap.
+func (h *MessageHandler) meterBandwidth(ctx context.Context) {
+	ticker := time.NewTicker(bandwidthFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.flushBandwidth(ctx, time.Now())
+		}
+	}
+}
+
+// flushBandwidth collects the bytes of every connection and checks the users' usage against the caps.
+func (h *MessageHandler) flushBandwidth(ctx context.Context, now time.Time) {
+	m := h.bandwidth
+	h.mu.RLock()
+	conns := make(map[*Connection]string, len(h.connections))
+	for _, conn := range h.connections {
+		conns[conn] = rateLimitKey(conn.identity, conn.remoteIP)
+	}
+	h.mu.RUnlock()
+
+	for conn, key := range conns {
+		m.collect(conn, key, now)
+	}
+
+	m.mu.Lock()
+	pending := make(map[string]int64)
+	for key, u := range m.users {
+		if u.pending > 0 {
+			pending[key] = u.pending
+			u.pending = 0
+		}
+		// Users without connections are forgotten once their usage no longer counts against a cap
+		if now.Sub(u.lastSeen) > 24*time.Hour && (len(m.caps) == 0 || now.Sub(u.lastSeen) > 31*24*time.Hour) {
+			delete(m.users, key)
+		}
+	}
+	m.mu.Unlock()
+
+	if len(m.caps) == 0 {
+		return
+	}
+
+	for key, bytes := range pending {
+		for _, c := range m.caps {
+			period, ttl := c.window(now)
+			total, err := m.counter.Add(ctx, key, period, bytes, ttl)
+			if err != nil {
+				h.logger.Warn("Failed to add bandwidth usage", zap.String("key", key), zap.Error(err))
+				continue
+			}
+			m.mu.Lock()
+			if u, ok := m.users[key]; ok {
+				u.periods[c.period], u.usage[c.period] = period, total
+			}
+			m.mu.Unlock()
+		}
+	}
+
+	// Notifications are sent once, when a user exceeds a cap; throttles and disconnects hold on to
+	// the user's connections until the cap period ends
+	actions := make(map[string]time.Duration)
+	m.mu.Lock()
+	for key, u := range m.users {
+		exceeded, reset := m.exceeded(u, now)
+		switch {
+		case exceeded != "" && u.capped == "":
+			metrics.BandwidthCapsExceeded.WithLabelValues(exceeded, m.action).Inc()
+			h.logger.Warn("User exceeded its bandwidth cap", zap.String("key", key), zap.String("period", exceeded), zap.String("action", m.action))
+			actions[key] = reset
+		case exceeded != "" && m.action != config.BandwidthNotify:
+			actions[key] = reset
+		case exceeded == "" && u.capped != "":
+			h.logger.Info("Bandwidth cap period of user ended", zap.String("key", key), zap.String("period", u.capped))
+			actions[key] = 0
+		}
+		u.capped = exceeded
+	}
+	m.mu.Unlock()
+
+	for conn, key := range conns {
+		if reset, ok := actions[key]; ok {
+			h.applyBandwidthCap(conn, reset)
+		}
+	}
+}
+
+// exceeded returns the period of the first cap the user's usage exceeds and the time left until
+// the period ends, or an empty period. m.mu must be held.
+func (m *bandwidthMeter) exceeded(u *userUsage, now time.Time) (string, time.Duration) {
+	for _, c := range m.caps {
+		period, left := c.window(now)
+		if u.periods[c.period] == period && u.usage[c.period] > c.limit {
+			return c.period, left
+		}
+	}
+	return "", 0
+}
+
+// applyBandwidthCap applies the cap action to a connection of a user over a cap, or lifts its
+// throttle once reset is zero because the cap period ended.
+func (h *MessageHandler) applyBandwidthCap(conn *Connection, reset time.Duration) {
+	m := h.bandwidth
+	if reset == 0 {
+		conn.throttle.Store(nil)
+		return
+	}
+
+	switch m.action {
+	case config.BandwidthNotify:
+		frame := message.Frame{Type: message.FrameError, Reason: "bandwidth_cap_exceeded"}
+		if data, err := frame.ToJSON(); err == nil {
+			h.writeControl(conn.id, data)
+		}
+	case config.BandwidthThrottle:
+		if conn.throttle.Load() == nil {
+			conn.throttle.CompareAndSwap(nil, rate.NewLimiter(rate.Limit(m.throttleRate), m.throttleRate))
+		}
+	case config.BandwidthDisconnect:
+		if _, ok := h.detach(conn.id); !ok {
+			return
+		}
+		// Reconnecting before the cap period ends would only be refused again
+		reason := message.CloseReason{Reason: message.ReasonBandwidthCap, RetryAfterMs: reset.Milliseconds()}
+		if err := conn.CloseWithReason(message.CloseEvicted, reason); err != nil {
+			h.logger.Warn("Failed to close connection over its bandwidth cap", zap.String("conn-id", conn.id), zap.Error(err))
+		}
+	}
+}
+
+// Bandwidth returns the bandwidth of the hub's users, ordered by key.
+func (h *MessageHandler) Bandwidth() []UserBandwidth {
+	m := h.bandwidth
+	now := time.Now()
+	h.mu.RLock()
+	connections := make(map[string]int)
+	for _, conn := range h.connections {
+		key := rateLimitKey(conn.identity, conn.remoteIP)
+		m.collect(conn, key, now)
+		connections[key]++
+	}
+	h.mu.RUnlock()
+
+	m.mu.Lock()
+	users := make([]UserBandwidth, 0, len(m.users))
+	for key, u := range m.users {
+		user := UserBandwidth{Key: key, Connections: connections[key], BytesIn: u.in, BytesOut: u.out, Capped: u.capped}
+		for _, c := range m.caps {
+			if period, _ := c.window(now); u.periods[c.period] == period {
+				if c.period == periodDaily {
+					user.Daily = u.usage[c.period]
+				} else {
+					user.Monthly = u.usage[c.period]
+				}
+			}
+		}
+		users = append(users, user)
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(users, func(a, b UserBandwidth) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return users
+}
+
+// countRead counts a message read from the client.
+func (c *Connection) countRead(n int) {
+	c.bytesIn.Add(uint64(n))
+	bytesRead.Add(float64(n))
+}
+
+// countWritten counts a message written to the client, waiting first for the bytes to fit the
+// throttle of a user over its bandwidth cap. It reports false if the connection closed meanwhile.
+func (c *Connection) countWritten(n int) bool {
+	c.bytesOut.Add(uint64(n))
+	bytesWritten.Add(float64(n))
+
+	limiter := c.throttle.Load()
+	if limiter == nil {
+		return true
+	}
+	for n > 0 {
+		take := min(n, limiter.Burst())
+		n -= take
+		select {
+		case <-time.After(limiter.ReserveN(time.Now(), take).Delay()):
+		case <-c.done:
+			return false
+		}
+	}
+	return true
+}
+
+// allowThrottled reports whether a message read from a throttled client fits its throttle.
+// Messages beyond the throttle are dropped rather than waited for, since the read pump must keep
+// reading to notice the client's pongs.
+func (c *Connection) allowThrottled(n int) bool {
+	limiter := c.throttle.Load()
+	return limiter == nil || limiter.AllowN(time.Now(), min(n, limiter.Burst()))
+}
+
+// memoryUsage counts bandwidth usage in process memory, for hubs running without Redis.
+type memoryUsage struct {
+	mu        sync.Mutex
+	totals    map[string]usageTotal
+	lastPrune time.Time
+}
+
+type usageTotal struct {
+	bytes   int64
+	expires time.Time
+}
+
+func newMemoryUsage() *memoryUsage {
+	return &memoryUsage{totals: make(map[string]usageTotal)}
+}
+
+func (m *memoryUsage) Add(_ context.Context, key, period string, bytes int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastPrune) > time.Minute {
+		for name, total := range m.totals {
+			if now.After(total.expires) {
+				delete(m.totals, name)
+			}
+		}
+		m.lastPrune = now
+	}
+
+	name := key + ":" + period
+	total := m.totals[name]
+	total.bytes += bytes
+	total.expires = now.Add(ttl)
+	m.totals[name] = total
+	return total.bytes, nil
+}
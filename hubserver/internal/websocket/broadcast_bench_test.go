@@ -0,0 +1,215 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	rtmetrics "runtime/metrics"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/hubtest"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// benchmarkRoom is the room the broadcast benchmarks publish to.
+const benchmarkRoom = "bench"
+
+// mutexWaitMetric is the runtime metric of the time goroutines spent blocked on sync.Mutex and
+// sync.RWMutex, reported by the benchmarks as lock contention.
+const mutexWaitMetric = "/sync/mutex/wait/total:seconds"
+
+var (
+	// Connection counts and payload sizes the broadcast benchmarks fan out to and publish. With
+	// -short, as under the race detector, only the first of each runs.
+	benchmarkConnections = []int{1000, 10000, 50000}
+	benchmarkPayloads    = []int{64, 1024, 16384}
+)
+
+// BenchmarkBroadcast measures publishing a message to a room and waiting until every connection
+// subscribed to it received it, for each connection count and payload size. Allocations are per
+// broadcast, including the fake connections' copy of each delivered message.
+func BenchmarkBroadcast(b *testing.B) {
+	for _, conns := range benchmarkSizes(benchmarkConnections) {
+		for _, size := range benchmarkSizes(benchmarkPayloads) {
+			b.Run(fmt.Sprintf("conns=%d/payload=%d", conns, size), func(b *testing.B) {
+				benchmarkBroadcast(b, "", conns, size)
+			})
+		}
+	}
+}
+
+// BenchmarkBroadcastFramed is BenchmarkBroadcast for connections that negotiated the hub
+// subprotocol, which are written message frames encoded from each message.
+func BenchmarkBroadcastFramed(b *testing.B) {
+	for _, conns := range benchmarkSizes(benchmarkConnections) {
+		for _, size := range benchmarkSizes(benchmarkPayloads) {
+			b.Run(fmt.Sprintf("conns=%d/payload=%d", conns, size), func(b *testing.B) {
+				benchmarkBroadcast(b, message.Subprotocol, conns, size)
+			})
+		}
+	}
+}
+
+// benchmarkBroadcast publishes messages of size bytes to conns connections that negotiated the
+// subprotocol, waiting until every connection received each before publishing the next.
+func benchmarkBroadcast(b *testing.B, subprotocol string, conns, size int) {
+	h := benchmarkHandler(b)
+	var received sync.WaitGroup
+	receive(b, attachBenchmarkConnections(b, h, subprotocol, conns), received.Done)
+	payload := benchmarkPayload(size)
+
+	b.ReportAllocs()
+	wait := mutexWait()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		received.Add(conns)
+		publishBenchmarkMessage(b, h, strconv.Itoa(i), payload)
+		received.Wait()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*conns), "ns/delivery")
+	b.ReportMetric((mutexWait()-wait)*1e9/float64(b.N), "mutex-wait-ns/op")
+}
+
+// BenchmarkBroadcastChurn measures broadcasts from parallel publishers while connections keep
+// connecting and disconnecting, contending for the connection registry with the broadcast workers.
+func BenchmarkBroadcastChurn(b *testing.B) {
+	for _, conns := range benchmarkSizes(benchmarkConnections) {
+		b.Run(fmt.Sprintf("conns=%d", conns), func(b *testing.B) {
+			h := benchmarkHandler(b)
+			var received atomic.Int64
+			receive(b, attachBenchmarkConnections(b, h, "", conns), func() { received.Add(1) })
+			payload := benchmarkPayload(benchmarkPayloads[0])
+			stop := churn(b, h)
+
+			var published atomic.Int64
+
+			b.ReportAllocs()
+			wait := mutexWait()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					publishBenchmarkMessage(b, h, strconv.FormatInt(published.Add(1), 10), payload)
+				}
+			})
+			b.StopTimer()
+			stop()
+			b.ReportMetric(float64(received.Load())/float64(b.N), "deliveries/op")
+			b.ReportMetric((mutexWait()-wait)*1e9/float64(b.N), "mutex-wait-ns/op")
+		})
+	}
+}
+
+// benchmarkSizes returns the sizes a benchmark runs with, only the first with -short.
+func benchmarkSizes(sizes []int) []int {
+	if testing.Short() {
+		return sizes[:1]
+	}
+	return sizes
+}
+
+// benchmarkHandler runs a message handler with the default configuration of a standalone hub until
+// the benchmark ends.
+func benchmarkHandler(b *testing.B) *MessageHandler {
+	b.Helper()
+
+	cfg := config.Default()
+	cfg.HubName = "bench-hub"
+	cfg.Broker = config.BrokerNone
+	cfg.AdminAddr = ""
+	h, err := NewMessageHandler(NewStandaloneBroker(), nil, cfg, zap.NewNop())
+	if err != nil {
+		b.Fatalf("NewMessageHandler: %v", err)
+	}
+	go h.Run()
+	b.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+// attachBenchmarkConnections serves count fake connections that negotiated the subprotocol, raw
+// ones when it is empty, subscribed to the benchmark room.
+func attachBenchmarkConnections(b *testing.B, h *MessageHandler, subprotocol string, count int) []*hubtest.Conn {
+	b.Helper()
+
+	conns := make([]*hubtest.Conn, count)
+	for i := range conns {
+		conns[i] = hubtest.NewConn(subprotocol)
+		if _, err := h.Attach(conns[i], auth.Identity{}, []string{benchmarkRoom}); err != nil {
+			b.Fatalf("Attach: %v", err)
+		}
+	}
+	return conns
+}
+
+// receive reads every message written to the connections until the benchmark ends, calling
+// received for each.
+func receive(b *testing.B, conns []*hubtest.Conn, received func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(cancel)
+
+	for _, ws := range conns {
+		go func() {
+			for {
+				if _, err := ws.Receive(ctx); err != nil {
+					return
+				}
+				received()
+			}
+		}()
+	}
+}
+
+// churn attaches and closes connections in the benchmark room until the returned function is called.
+func churn(b *testing.B, h *MessageHandler) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			ws := hubtest.NewConn("")
+			if _, err := h.Attach(ws, auth.Identity{}, []string{benchmarkRoom}); err != nil {
+				b.Errorf("Attach: %v", err)
+				return
+			}
+			_ = ws.Close()
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// benchmarkPayload returns a JSON string payload of size bytes.
+func benchmarkPayload(size int) []byte {
+	payload := bytes.Repeat([]byte("x"), size)
+	payload[0], payload[size-1] = '"', '"'
+	return payload
+}
+
+// publishBenchmarkMessage publishes a message of a benchmark to the benchmark room. Ids must be
+// unique, or the broadcast workers drop the message as a duplicate.
+func publishBenchmarkMessage(b *testing.B, h *MessageHandler, id string, payload []byte) {
+	md := message.NewMessageDetails("bench", h.hubID, "bench", payload)
+	md.ID = id
+	md.Room = benchmarkRoom
+	if err := h.Publish(context.Background(), md); err != nil {
+		b.Errorf("Publish: %v", err)
+	}
+}
+
+// mutexWait returns the total time in seconds goroutines of the process spent waiting for mutexes.
+func mutexWait() float64 {
+	sample := []rtmetrics.Sample{{Name: mutexWaitMetric}}
+	rtmetrics.Read(sample)
+	if sample[0].Value.Kind() != rtmetrics.KindFloat64 {
+		return 0
+	}
+	return sample[0].Value.Float64()
+}
@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+// Publisher sends messages to the other hub instances.
+type Publisher interface {
+	// Publish sends a message to the other hubs.
+	Publish(ctx context.Context, md *message.MessageDetails) error
+}
+
+// HubCounter is implemented by brokers that can tell how many other hubs a publish reached.
+type HubCounter interface {
+	// PublishCounted sends a message to the other hubs and returns the number of hubs it reached.
+	PublishCounted(ctx context.Context, md *message.MessageDetails) (int, error)
+}
+
+// publishCounted publishes a message and returns the number of hubs it reached, or -1 when the
+// publisher cannot tell.
+func publishCounted(ctx context.Context, p Publisher, md *message.MessageDetails) (int, error) {
+	if counter, ok := p.(HubCounter); ok {
+		return counter.PublishCounted(ctx, md)
+	}
+	return -1, p.Publish(ctx, md)
+}
+
+// Receiver receives the messages published by the other hub instances. It is the subscribing half
+// of a Broker; Subscriber names the connection a Transform writes to.
+type Receiver interface {
+	// Subscribe delivers messages published by other hubs to broadcastCh until the subscription is closed.
+	Subscribe(ctx context.Context, broadcastCh chan<- message.MessageDetails)
+	// Unsubscribe stops receiving messages from the other hubs.
+	Unsubscribe(ctx context.Context) error
+}
+
+// Broker is the cross-hub transport used to exchange messages with the other hub instances.
+// hubtest.Broker implements it in memory.
+type Broker interface {
+	Publisher
+	Receiver
+	// Close releases the transport's resources.
+	Close() error
+}
+
+// standaloneBroker is the broker of a hub running alone: publishes go nowhere and no message
+// arrives from other hubs.
+type standaloneBroker struct {
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewStandaloneBroker creates the broker of a hub that exchanges no messages with other hubs.
+func NewStandaloneBroker() Broker {
+	return &standaloneBroker{done: make(chan struct{})}
+}
+
+func (b *standaloneBroker) Subscribe(ctx context.Context, broadcastCh chan<- message.MessageDetails) {
+	select {
+	case <-ctx.Done():
+	case <-b.done:
+	}
+}
+
+func (b *standaloneBroker) Publish(ctx context.Context, md *message.MessageDetails) error {
+	return nil
+}
+
+func (b *standaloneBroker) PublishCounted(ctx context.Context, md *message.MessageDetails) (int, error) {
+	return 0, nil
+}
+
+func (b *standaloneBroker) Unsubscribe(ctx context.Context) error {
+	return nil
+}
+
+func (b *standaloneBroker) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return nil
+}
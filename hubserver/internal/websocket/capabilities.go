@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// capabilitiesParam is the query parameter of the upgrade request listing, comma-separated, the
+// features the client supports.
+const capabilitiesParam = "capabilities"
+
+// capabilitiesHeader is the upgrade response header listing the features enabled on the connection.
+const capabilitiesHeader = "Hub-Capabilities"
+
+// maxBatchMessages is the number of queued messages written to a client that accepts batches in a
+// single WebSocket message at most.
+const maxBatchMessages = 32
+
+// capabilities is a set of the features a client advertised in its handshake, which the hub only
+// uses on connections whose client supports them, so SDKs can roll them out gradually.
+type capabilities uint8
+
+const (
+	// capAcks covers delivery and read receipts and nacks of the client's messages
+	capAcks capabilities = 1 << iota
+	// capCompression covers permessage-deflate compression of the messages written to the client
+	capCompression
+	// capBinary covers message payloads sent in a codec's encoding in data
+	capBinary
+	// capBatching covers messages queued back to back written as a JSON array of frames
+	capBatching
+	// capReplay covers the replay of the history of the rooms of a handed-off connection
+	capReplay
+)
+
+// capabilityNames holds the names clients advertise the capabilities with, in announcement order.
+var capabilityNames = []struct {
+	capability capabilities
+	name       string
+}{
+	{capAcks, "acks"},
+	{capCompression, "compression"},
+	{capBinary, "binary"},
+	{capBatching, "batching"},
+	{capReplay, "replay"},
+}
+
+// legacyCapabilities are those of clients that advertise none, which predate the handshake: every
+// feature they used before it besides batching, which they cannot parse.
+const legacyCapabilities = capAcks | capCompression | capBinary | capReplay
+
+// requestedCapabilities returns the capabilities an upgrade request advertised, ignoring unknown
+// ones so newer clients can connect to older hubs, or the legacy ones when it advertised none.
+// Raw clients receive bare payloads, which cannot be batched.
+func requestedCapabilities(r *http.Request) capabilities {
+	values, ok := r.URL.Query()[capabilitiesParam]
+	if !ok {
+		return legacyCapabilities
+	}
+
+	var requested capabilities
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			for _, c := range capabilityNames {
+				if c.name == name {
+					requested |= c.capability
+				}
+			}
+		}
+	}
+	if _, framed := requestedProtocol(r); !framed {
+		requested &^= capBatching
+	}
+	return requested
+}
+
+// has reports whether the set holds the capability.
+func (c capabilities) has(capability capabilities) bool {
+	return c&capability != 0
+}
+
+// names returns the names of the capabilities of the set.
+func (c capabilities) names() []string {
+	names := make([]string, 0, len(capabilityNames))
+	for _, capability := range capabilityNames {
+		if c.has(capability.capability) {
+			names = append(names, capability.name)
+		}
+	}
+	return names
+}
+
+// String returns the names of the capabilities of the set, comma-separated.
+func (c capabilities) String() string {
+	return strings.Join(c.names(), ",")
+}
+
+// hubCapabilities returns the capabilities the hub's configuration can use at all: compression
+// once the upgrader negotiates it, and replay once handed-off connections are resumed.
+func (h *MessageHandler) hubCapabilities() capabilities {
+	supported := capAcks | capBinary | capBatching
+	if h.upgrader.EnableCompression {
+		supported |= capCompression
+	}
+	if h.handoffs != nil {
+		supported |= capReplay
+	}
+	return supported
+}
+
+// batchFrames joins encoded frames into the JSON array a client that accepts batches receives
+// them in, or returns the frame alone.
+func batchFrames(frames [][]byte) []byte {
+	if len(frames) == 1 {
+		return frames[0]
+	}
+	var batch bytes.Buffer
+	batch.WriteByte('[')
+	batch.Write(bytes.Join(frames, []byte(",")))
+	batch.WriteByte(']')
+	return batch.Bytes()
+}
@@ -0,0 +1,64 @@
+package websocket
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/chaos"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// chaosBroker delays and drops publishes to the wrapped broker.
+type chaosBroker struct {
+	Broker
+	chaos *chaos.Injector
+}
+
+func (b *chaosBroker) Publish(ctx context.Context, md *message.MessageDetails) error {
+	if !b.chaos.Publish(ctx) {
+		return nil
+	}
+	return b.Broker.Publish(ctx, md)
+}
+
+func (b *chaosBroker) PublishCounted(ctx context.Context, md *message.MessageDetails) (int, error) {
+	if !b.chaos.Publish(ctx) {
+		return 0, nil
+	}
+	return publishCounted(ctx, b.Broker, md)
+}
+
+// injectDisconnects closes randomly picked connections without a close frame until ctx is cancelled.
+func (h *MessageHandler) injectDisconnects(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		h.mu.RLock()
+		for id, conn := range h.connections {
+			if h.chaos.Disconnect() {
+				h.logger.Info("Injecting connection failure", zap.String("conn-id", id))
+				abort(conn.ws)
+			}
+		}
+		h.mu.RUnlock()
+	}
+}
+
+// abort drops the connection's transport without a close frame, or closes connections that don't
+// expose one.
+func abort(ws Conn) {
+	if nc, ok := ws.(interface{ NetConn() net.Conn }); ok {
+		_ = nc.NetConn().Close()
+		return
+	}
+	_ = ws.Close()
+}
@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+const (
+	// maxPendingChunkedMessages bounds how many chunked uploads a connection may have in flight.
+	maxPendingChunkedMessages = 4
+	// minChunkSize is the payload every chunk but the last of a message must carry at least, which
+	// bounds the number of chunks of a message by the chunked message size limit.
+	minChunkSize = 64
+	// chunkAssemblyTimeout is how long a chunked upload may go without a chunk before it is dropped.
+	chunkAssemblyTimeout = 30 * time.Second
+)
+
+// chunkAssembly collects the chunks of a message uploaded in fragments. Parts are stored as they
+// arrive, so a message announcing many chunks holds only the memory of those received.
+type chunkAssembly struct {
+	parts map[int][]byte
+	total int
+	size  int
+	// first is the first chunk received without its data; the message takes its metadata, which
+	// every other chunk must repeat
+	first message.Frame
+	// updated is when the last chunk arrived
+	updated time.Time
+}
+
+// assembleChunk adds a chunk frame to its message and returns the message frame of the reassembled
+// payload once every chunk has arrived. It is only called from the connection's ingest goroutine.
+func (c *Connection) assembleChunk(frame message.Frame, maxSize int) (message.Frame, bool, error) {
+	if maxSize <= 0 {
+		return message.Frame{}, false, errors.New("chunked messages are disabled")
+	}
+	if frame.ID == "" {
+		return message.Frame{}, false, errors.New("chunk frame missing message id")
+	}
+
+	now := time.Now()
+	for id, a := range c.assemblies {
+		if now.Sub(a.updated) > chunkAssemblyTimeout {
+			delete(c.assemblies, id)
+		}
+	}
+
+	a, ok := c.assemblies[frame.ID]
+	if !ok {
+		if frame.Total <= 0 || frame.Total > (maxSize+minChunkSize-1)/minChunkSize {
+			return message.Frame{}, false, fmt.Errorf("invalid chunk total %d", frame.Total)
+		}
+		if len(c.assemblies) >= maxPendingChunkedMessages {
+			return message.Frame{}, false, errors.New("too many pending chunked messages")
+		}
+
+		a = &chunkAssembly{parts: make(map[int][]byte), total: frame.Total, first: chunkMetadata(frame)}
+		c.assemblies[frame.ID] = a
+	}
+
+	if frame.Seq < 0 || frame.Seq >= a.total || frame.Total != a.total {
+		delete(c.assemblies, frame.ID)
+		return message.Frame{}, false, fmt.Errorf("chunk %d/%d does not match message", frame.Seq, frame.Total)
+	}
+	if !reflect.DeepEqual(chunkMetadata(frame), a.first) {
+		delete(c.assemblies, frame.ID)
+		return message.Frame{}, false, fmt.Errorf("chunk %d differs from the first chunk of the message", frame.Seq)
+	}
+	if _, ok := a.parts[frame.Seq]; ok {
+		delete(c.assemblies, frame.ID)
+		return message.Frame{}, false, fmt.Errorf("duplicate chunk %d", frame.Seq)
+	}
+	if frame.Seq < a.total-1 && len(frame.Data) < minChunkSize {
+		delete(c.assemblies, frame.ID)
+		return message.Frame{}, false, fmt.Errorf("chunk %d carries fewer than %d bytes", frame.Seq, minChunkSize)
+	}
+
+	a.size += len(frame.Data)
+	if a.size > maxSize {
+		delete(c.assemblies, frame.ID)
+		return message.Frame{}, false, fmt.Errorf("chunked message exceeds %d bytes", maxSize)
+	}
+
+	a.parts[frame.Seq] = append([]byte{}, frame.Data...)
+	a.updated = now
+	if len(a.parts) < a.total {
+		return message.Frame{}, false, nil
+	}
+
+	delete(c.assemblies, frame.ID)
+	payload := make([]byte, 0, a.size)
+	for seq := range a.total {
+		payload = append(payload, a.parts[seq]...)
+	}
+	return assembledFrame(a.first, payload), true, nil
+}
+
+// chunkMetadata returns a chunk frame without its position and data, which leaves the metadata of
+// the message it belongs to.
+func chunkMetadata(frame message.Frame) message.Frame {
+	frame.Seq, frame.Data = 0, nil
+	return frame
+}
+
+// splitMessage encodes a message as sequence-numbered chunk frames carrying at most chunkSize bytes of payload each.
+func splitMessage(md *message.MessageDetails, chunkSize int) ([][]byte, error) {
+	total := (len(md.Message) + chunkSize - 1) / chunkSize
+	frames := make([][]byte, 0, total)
+
+	for seq := 0; seq < total; seq++ {
+		start := seq * chunkSize
+		end := min(start+chunkSize, len(md.Message))
+
+		frame := message.Frame{
+			Type:        message.FrameChunk,
+			ID:          md.ID,
+			OriginID:    md.OriginID,
+			Room:        md.Room,
+			Receipt:     md.Receipt,
+			Ephemeral:   md.Ephemeral,
+			ContentType: md.ContentType,
+			Cursor:      md.Cursor,
+			RoomSeq:     md.RoomSeq,
+			Sequence:    md.Sequence,
+			Key:         md.Key,
+			Seq:         seq,
+			Total:       total,
+			Data:        md.Message[start:end],
+		}
+
+		data, err := frame.ToJSON()
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, data)
+	}
+
+	return frames, nil
+}
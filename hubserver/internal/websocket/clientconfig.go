@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// errClientConfigObject is returned for client configurations that are not a JSON object.
+var errClientConfigObject = errors.New("client configuration must be a JSON object")
+
+// ConfigTarget selects the connections of the hub a client configuration is pushed to: those with
+// one of the ids, in one of the groups or of one of the users. An empty target selects them all.
+type ConfigTarget struct {
+	Connections []string `json:"connections,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	Users       []string `json:"users,omitempty"`
+}
+
+// empty reports whether the target selects every connection.
+func (t ConfigTarget) empty() bool {
+	return len(t.Connections) == 0 && len(t.Groups) == 0 && len(t.Users) == 0
+}
+
+// selects reports whether the target selects the connection.
+func (t ConfigTarget) selects(conn *Connection) bool {
+	if t.empty() {
+		return true
+	}
+	for _, id := range t.Connections {
+		if conn.id == id {
+			return true
+		}
+	}
+	for _, user := range t.Users {
+		if conn.identity.UserID == user {
+			return true
+		}
+	}
+	for _, group := range t.Groups {
+		if conn.inGroup(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientConfig returns the client configuration connections receive when they connect, or nil when
+// none is set.
+func (h *MessageHandler) ClientConfig() json.RawMessage {
+	if config := h.clientConfig.Load(); config != nil {
+		return *config
+	}
+	return nil
+}
+
+// SetClientConfig replaces the client configuration, a JSON object of feature flags, poll intervals
+// or UI hints the hub holds no opinion on, and pushes it to every framed connection of the hub.
+// Connections established later receive it once they are authenticated. A nil configuration stops
+// sending one to new connections and leaves established ones with the last they received. Each hub
+// holds its own configuration, like the rest of the admin API's settings.
+func (h *MessageHandler) SetClientConfig(config json.RawMessage) (int, error) {
+	if config == nil {
+		h.clientConfig.Store(nil)
+		h.logger.Info("Client configuration cleared")
+		return 0, nil
+	}
+	if err := checkClientConfig(config); err != nil {
+		return 0, err
+	}
+
+	h.clientConfig.Store(&config)
+	pushed := h.pushClientConfig(config, ConfigTarget{})
+	h.logger.Info("Client configuration set", zap.Int("connections", pushed))
+	return pushed, nil
+}
+
+// PushClientConfig pushes a client configuration to the connections of the hub the target selects,
+// without replacing the one new connections receive, and returns the number of connections it was
+// sent to. It lets a configuration be tried on some clients before it is set for all of them.
+func (h *MessageHandler) PushClientConfig(config json.RawMessage, target ConfigTarget) (int, error) {
+	if err := checkClientConfig(config); err != nil {
+		return 0, err
+	}
+
+	pushed := h.pushClientConfig(config, target)
+	h.logger.Info("Client configuration pushed", zap.Strings("connections", target.Connections),
+		zap.Strings("groups", target.Groups), zap.Strings("users", target.Users), zap.Int("recipients", pushed))
+	return pushed, nil
+}
+
+// checkClientConfig returns an error unless the configuration is a JSON object.
+func checkClientConfig(config json.RawMessage) error {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(config, &object); err != nil || object == nil {
+		return errClientConfigObject
+	}
+	return nil
+}
+
+// pushClientConfig sends a config frame to the authenticated framed connections the target selects.
+func (h *MessageHandler) pushClientConfig(config json.RawMessage, target ConfigTarget) int {
+	data, err := clientConfigFrame(h.hubID, config)
+	if err != nil {
+		h.logger.Error("Failed to marshal config frame", zap.Error(err))
+		return 0
+	}
+
+	h.mu.RLock()
+	recipients := make([]*Connection, 0, len(h.connections))
+	for _, conn := range h.connections {
+		if conn.framed && !conn.unauthenticated.Load() && target.selects(conn) {
+			recipients = append(recipients, conn)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, conn := range recipients {
+		h.writeControl(conn.id, data)
+	}
+	return len(recipients)
+}
+
+// sendClientConfig sends the client configuration to a connection that was just established or
+// authenticated, when one is set.
+func (h *MessageHandler) sendClientConfig(conn *Connection) {
+	config := h.ClientConfig()
+	if config == nil || !conn.framed {
+		return
+	}
+
+	data, err := clientConfigFrame(h.hubID, config)
+	if err != nil {
+		conn.log().Error("Failed to marshal config frame", zap.Error(err))
+		return
+	}
+	h.writeControl(conn.id, data)
+}
+
+// clientConfigFrame encodes the config frame carrying a client configuration.
+func clientConfigFrame(hubID string, config json.RawMessage) ([]byte, error) {
+	frame := message.Frame{Type: message.FrameConfig, HubID: hubID, Payload: config}
+	return frame.ToJSON()
+}
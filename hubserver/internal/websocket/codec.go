@@ -0,0 +1,40 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+// framePayload returns the JSON payload of a message frame and the bytes the client sent for it.
+// Frames whose content type has a registered codec may carry the payload in the codec's encoding
+// in data instead of payload, which is decoded to JSON.
+func framePayload(ctx context.Context, frame message.Frame) ([]byte, []byte, error) {
+	if len(frame.Data) == 0 {
+		return frame.Payload, frame.Payload, nil
+	}
+
+	codec, ok := message.LookupCodec(frame.ContentType)
+	if !ok {
+		return nil, nil, fmt.Errorf("no codec registered for content type %q", frame.ContentType)
+	}
+	payload, err := codec.Decode(ctx, frame.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s payload: %w", frame.ContentType, err)
+	}
+	return payload, frame.Data, nil
+}
+
+// assembledFrame returns the metadata of a chunked message as the message frame of its reassembled
+// bytes. Chunks of a content type with a registered codec carry the payload in the
+// codec's encoding, which framePayload decodes as for a message frame; others carry the JSON payload.
+func assembledFrame(frame message.Frame, assembled []byte) message.Frame {
+	frame.Type, frame.Seq, frame.Total = message.FrameMessage, 0, 0
+	if _, ok := message.LookupCodec(frame.ContentType); ok {
+		frame.Payload, frame.Data = nil, assembled
+	} else {
+		frame.Payload, frame.Data = assembled, nil
+	}
+	return frame
+}
@@ -1,167 +1,665 @@
 package websocket
 
 import (
+	"cmp"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/chaos"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/filter"
 	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
-const (
-	writeWait      = 1 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
-)
+const maxMessageSize = 512
+
+// Conn is the WebSocket connection a Connection reads from and writes to. *websocket.Conn
+// implements it, and hubtest.Conn fakes it in memory.
+type Conn interface {
+	Subprotocol() string
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	ReadMessage() (messageType int, p []byte, err error)
+	SetWriteDeadline(t time.Time) error
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	Close() error
+}
 
 // Connection represents the WebSocket connection.
 type Connection struct {
-	id string
-	ws *websocket.Conn
+	id       string
+	ws       Conn
+	remoteIP netip.Addr
+	identity auth.Identity
+	// session identifies the client's connections across reconnects, the connection's id unless
+	// the client continues a session
+	session string
+
+	// connectedAt is when the connection was established, and lastActive when the client last sent
+	// a message, in unix nanoseconds; pongs and other control frames do not count
+	connectedAt time.Time
+	lastActive  atomic.Int64
+
+	// stream is the HTTP/2 stream carrying the connection when it was opened with extended CONNECT
+	stream *h2Stream
 
-	// Buffered read and write channel to hold messages
-	readCh  chan []byte
+	// framed is set when the client negotiated the hub subprotocol and exchanges JSON frames.
+	framed bool
+
+	// Buffered read and write channel to hold messages. Each channel is closed by its producer only:
+	// the read pump closes readCh when it stops, while writeCh has many producers and is never
+	// closed; the write pump stops when done is closed instead.
+	readCh  chan inbound
 	writeCh chan message.MessageDetails
+	// readAt is when the message being handled was read, set by the goroutine handling them
+	readAt int64
+
+	// queueMu guards the admission of messages to writeCh, which holds up to queueSize messages
+	// besides the ephemeralShed oldest of its ephemeralQueued ephemeral messages, shed while queued,
+	// and the queuedBytes referenced by its queued messages, until queueReleased drops them
+	queueMu         sync.Mutex
+	queueSize       int
+	ephemeralQueued int
+	ephemeralShed   int
+	queuedBytes     int64
+	queueReleased   bool
+	memory          *queueMemory
+
+	// Buffered channel holding encoded control frames addressed to this connection
+	controlCh chan []byte
+
+	// flow holds the credit granted by the client for message delivery
+	flow   flowControl
+	flowMu sync.Mutex
+
+	// chunkSize is the payload size above which messages are delivered to framed clients in chunks
+	chunkSize int
+	// assemblies holds chunked uploads being reassembled, keyed by message id
+	assemblies map[string]*chunkAssembly
+
+	// rooms holds the rooms the connection is subscribed to, at most maxRooms when it is set, with
+	// the sequence number of the last message of each room queued for the connection
+	rooms    map[string]uint64
+	maxRooms int
+	roomsMu  sync.RWMutex
+	// userRooms counts the rooms against the limit of the rooms of the connection's user, and
+	// lifecycle the room's subscribers on the hub, until roomsReleased once the connection is
+	// removed from the hub
+	userRooms     *userRooms
+	lifecycle     *roomLifecycle
+	roomsReleased bool
+	// cursors holds the history cursor of the last message of each room written to the client, and
+	// held the messages of rooms held back while a handoff replays their history
+	cursors map[string]string
+	held    map[string][]message.MessageDetails
+	// filters holds the filters of the rooms the connection joined with one, selecting the room's
+	// messages it receives
+	filters map[string]*filter.Filter
+
+	// readLimit is the largest message accepted from the client and limiter enforces its message rate;
+	// a limit stored in pendingReadLimit replaces readLimit before the read pump's next read
+	readLimit        int64
+	pendingReadLimit atomic.Int64
+	limiter          *rate.Limiter
+
+	// pendingAuth is set on connections accepted without a token until they authenticate with an auth
+	// frame, and unauthenticated keeps them out of deliveries meanwhile
+	pendingAuth     *pendingAuth
+	unauthenticated atomic.Bool
+
+	// bytesIn and bytesOut count the bytes read from and written to the client, of which the
+	// bandwidth meter collected the collected counts into the user's usage; throttle limits the
+	// connection's bytes per second while its user is over a bandwidth cap
+	bytesIn      atomic.Uint64
+	bytesOut     atomic.Uint64
+	collectedIn  uint64
+	collectedOut uint64
+	throttle     atomic.Pointer[rate.Limiter]
+
+	// groups holds the named groups the connection was assigned to by the authorizer or the admin
+	// API, and rateOverride limits its message rate in place of limiter while a group sets one
+	groupsMu     sync.RWMutex
+	groups       map[string]bool
+	rateOverride atomic.Pointer[rate.Limiter]
+
+	// patches holds the payloads state room messages are sent to the client as JSON Patches
+	// against, nil unless it asked for them
+	patches *statePatches
+
+	// receipts holds the origins of the messages asking for receipts written to the client, which
+	// its acks are relayed to
+	receipts receiptOrigins
 
-	logger *zap.Logger
-	closed bool
-	mu     sync.Mutex
+	// echo is the echo policy of the messages published on the connection, empty for the default
+	echo string
+
+	// transforms rewrite outbound payloads for this subscriber and language is the client's preferred language
+	transforms []Transform
+	language   string
+	// header holds the headers of the upgrade request for enrichers, nil without any
+	header http.Header
+
+	// chaos stalls writes when fault injection is enabled
+	chaos *chaos.Injector
+	// tracePipeline logs the pipeline timing of every message written to the client
+	tracePipeline bool
+
+	// writeTimeout bounds each write; the client is pinged at the ping interval of its keepalive
+	// class and considered gone once more pings in a row than the class allows went unanswered
+	writeTimeout   time.Duration
+	keepalive      *keepaliveClasses
+	keepaliveClass string
+	missedPongs    atomic.Int32
+
+	// capabilities holds the features the client advertised in its handshake that the hub enabled
+	capabilities capabilities
+
+	// protocolVersion is the hub.v1 protocol version the client announced when it connected
+	protocolVersion int
+
+	// logger carries the connection's conn-id, user-id, remote-addr and hub-id fields, replaced
+	// once a connection accepted without a token authenticates
+	logger atomic.Pointer[zap.Logger]
+
+	// done is closed by the first call to Close or CloseWithReason
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
 }
 
-// Upgrader to upgrade HTTP connections to WebSocket connections
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// newUpgrader creates the upgrader of HTTP connections to WebSocket connections with the buffer
+// sizes and compression of the configuration. With a write buffer pool, a connection holds a
+// write buffer only while it writes, which at tens of thousands of mostly idle connections saves
+// most of their buffer memory.
+func newUpgrader(cfg *config.Config) websocket.Upgrader {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    cfg.WSReadBufferSize,
+		WriteBufferSize:   cfg.WSWriteBufferSize,
+		EnableCompression: cfg.WSCompression,
+		Subprotocols:      []string{message.Subprotocol},
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+	if cfg.WSWriteBufferPool {
+		upgrader.WriteBufferPool = &sync.Pool{}
+	}
+	return upgrader
 }
 
-// Upgrade upgrades an HTTP connection to a WebSocket connection and assigns a unique id to the connection.
-func Upgrade(w http.ResponseWriter, r *http.Request, h *MessageHandler) (*Connection, error) {
+// Upgrade upgrades an HTTP connection from remoteIP to a WebSocket connection of the identity with the given
+// unique id, held to the given quota and kept alive as the given keepalive class. The upgrade
+// response carries the hub's upgrade headers and the given headers, which replace those of the same name.
+func Upgrade(w http.ResponseWriter, r *http.Request, h *MessageHandler, id string, remoteIP netip.Addr, identity auth.Identity, quota Quota, keepaliveClass string, header http.Header) (*Connection, error) {
 	logger := h.logger
-	ws, err := upgrader.Upgrade(w, r, nil)
+
+	var stream *h2Stream
+	if isExtendedConnect(r) {
+		var err error
+		if w, r, stream, err = h2Upgrade(w, r); err != nil {
+			logger.Error("Failed to prepare HTTP/2 WebSocket stream", zap.Error(err))
+			return nil, fmt.Errorf("failed to prepare HTTP/2 WebSocket stream: %w", err)
+		}
+	}
+
+	enabled := requestedCapabilities(r) & h.hubCapabilities()
+	session := cmp.Or(requestedSession(r), id)
+	header = h.upgradeResponseHeader(header)
+	header.Set(capabilitiesHeader, enabled.String())
+	header.Set(sessionHeader, session)
+	ws, err := h.upgrader.Upgrade(&retryHijacker{ResponseWriter: w, timeout: h.writeTimeout, retries: h.writeRetries}, r, header)
 	if err != nil {
 		logger.Error("Failed to upgrade to WebSocket connection", zap.Error(err))
 		return nil, fmt.Errorf("failed to upgrade to WebSocket connection: %w", err)
 	}
+	ws.EnableWriteCompression(enabled.has(capCompression))
+
+	conn := newUpgradedConnection(h, r, id, ws, remoteIP, identity, quota, keepaliveClass, enabled, session)
+	conn.stream = stream
+	conn.start(h)
+	return conn, nil
+}
 
+// newUpgradedConnection creates the Connection of an upgrade request over its established
+// connection, with the capabilities enabled for it and the settings the request asked for.
+func newUpgradedConnection(h *MessageHandler, r *http.Request, id string, ws Conn, remoteIP netip.Addr, identity auth.Identity, quota Quota, keepaliveClass string, enabled capabilities, session string) *Connection {
+	conn := newConnection(h, id, ws, quota, preferredLanguage(r.Header.Get("Accept-Language")))
+	conn.capabilities = enabled
+	conn.session = session
+	conn.keepaliveClass = keepaliveClass
+	conn.patches = h.newStatePatches(r, conn.framed)
+	conn.echo = h.requestedEcho(r)
+	conn.remoteIP = remoteIP
+	conn.identity = identity
+	conn.setLogContext(h)
+	if len(h.enrichers) > 0 {
+		conn.header = r.Header.Clone()
+	}
+	return conn
+}
+
+// newConnection creates a Connection over an established WebSocket connection, held to the given quota.
+func newConnection(h *MessageHandler, id string, ws Conn, quota Quota, language string) *Connection {
 	conn := &Connection{
-		id: uuid.New().String(),
-		ws: ws,
+		id:          id,
+		ws:          ws,
+		session:     id,
+		framed:      ws.Subprotocol() == message.Subprotocol,
+		connectedAt: time.Now(),
+
+		readCh:    make(chan inbound, h.readBufferSize),
+		writeCh:   make(chan message.MessageDetails, 2*h.writeBufferSize),
+		queueSize: h.writeBufferSize,
+		memory:    h.queueMemory,
+		controlCh: make(chan []byte, 64),
+		flow:      flowControl{granted: make(chan struct{}, 1)},
+
+		chunkSize:  h.chunkSize,
+		assemblies: make(map[string]*chunkAssembly),
+		rooms:      make(map[string]uint64),
+		cursors:    make(map[string]string),
+		held:       make(map[string][]message.MessageDetails),
+		filters:    make(map[string]*filter.Filter),
+		groups:     make(map[string]bool),
+		maxRooms:   cmp.Or(quota.MaxRooms, h.maxRooms),
+		userRooms:  h.userRooms,
+		lifecycle:  h.lifecycle,
+		readLimit:  maxMessageSize,
+		limiter:    quota.limiter(),
+		transforms: h.transforms,
+		language:   language,
+		chaos:      h.chaos,
 
-		readCh:  make(chan []byte, 256),
-		writeCh: make(chan message.MessageDetails, 256),
-		logger:  logger,
+		tracePipeline: h.tracePipeline,
+		capabilities:  legacyCapabilities & h.hubCapabilities(),
+
+		writeTimeout:   h.writeTimeout,
+		keepalive:      h.keepalive,
+		keepaliveClass: config.DefaultKeepaliveClass,
+		done:           make(chan struct{}),
 	}
+	conn.setLogContext(h)
+	conn.lastActive.Store(conn.connectedAt.UnixNano())
 
-	go conn.readPump(h)
-	go conn.writePump(h)
+	if quota.MaxMessageSize > 0 {
+		conn.readLimit = quota.MaxMessageSize
+	}
+	return conn
+}
 
-	return conn, nil
+// start starts the connection's read and write pumps.
+func (c *Connection) start(h *MessageHandler) {
+	go c.readPump(h)
+	go c.writePump(h)
 }
 
-// readPump handles reading messages from the WebSocket connection
+// setLogContext derives the connection's logger from the handler's with the fields identifying the
+// connection, so a single connection's lifecycle can be followed by grepping its conn-id, and a
+// client's across reconnects by grepping its session-id.
+func (c *Connection) setLogContext(h *MessageHandler) {
+	fields := []zap.Field{zap.String("conn-id", c.id), zap.String("session-id", c.session)}
+	if !c.identity.IsAnonymous() {
+		fields = append(fields, zap.String("user-id", c.identity.UserID))
+	}
+	if c.remoteIP.IsValid() {
+		fields = append(fields, zap.String("remote-addr", c.remoteIP.String()))
+	}
+	c.logger.Store(h.logger.With(append(fields, zap.String("hub-id", h.hubID))...))
+}
+
+// log returns the connection's logger.
+func (c *Connection) log() *zap.Logger {
+	return c.logger.Load()
+}
+
+// readPump handles reading messages from the WebSocket connection. It owns the read channel and
+// closes it once reading stops, which ends the connection's ingest goroutine.
 func (c *Connection) readPump(h *MessageHandler) {
 	defer func() {
-		h.remove <- c.id
+		close(c.readCh)
+		h.requestRemoval(c.id)
 	}()
 
-	c.ws.SetReadLimit(maxMessageSize)
-	err := c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetReadLimit(c.readLimit)
+	err := c.ws.SetReadDeadline(time.Now().Add(c.pongWait()))
 	if err != nil {
-		c.logger.Error("Error setting read deadline", zap.String("conn-id", c.id), zap.Error(err))
+		c.log().Error("Error setting read deadline", zap.Error(err))
 		return
 	}
 
 	c.ws.SetPongHandler(func(string) error {
-		err := c.ws.SetReadDeadline(time.Now().Add(pongWait))
+		c.missedPongs.Store(0)
+		err := c.ws.SetReadDeadline(time.Now().Add(c.pongWait()))
 		if err != nil {
-			c.logger.Error("Error extending read deadline", zap.String("conn-id", c.id), zap.Error(err))
+			c.log().Error("Error extending read deadline", zap.Error(err))
 			return err
 		}
 		return nil
 	})
 
+	supervise("read-pump", pumpRestarts, c.log(), c.readLoop)
+}
+
+// readLoop reads messages from the WebSocket connection into the read channel until reading fails.
+func (c *Connection) readLoop() {
 	for {
+		if limit := c.pendingReadLimit.Swap(0); limit > 0 {
+			c.ws.SetReadLimit(limit)
+		}
 		_, message, err := c.ws.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				c.logger.Error("Unexpected close error", zap.String("conn-id", c.id), zap.Error(err))
+				c.log().Error("Unexpected close error", zap.Error(err))
 			} else {
-				c.logger.Error("Error reading message", zap.String("conn-id", c.id), zap.Error(err))
+				c.log().Error("Error reading message", zap.Error(err))
 			}
 			return
 		}
-		c.readCh <- message
+		c.countRead(len(message))
+		now := time.Now().UnixNano()
+		c.lastActive.Store(now)
+		select {
+		case c.readCh <- inbound{data: message, readAt: now}:
+		case <-c.done:
+			return
+		}
 	}
 }
 
 // writePump handles writing messages to the WebSocket connection
 func (c *Connection) writePump(h *MessageHandler) {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.nextPing())
 	defer func() {
 		ticker.Stop()
-		h.remove <- c.id
+		h.requestRemoval(c.id)
 	}()
 
+	supervise("write-pump", pumpRestarts, c.log(), func() {
+		c.writeLoop(ticker)
+	})
+}
+
+// writeLoop writes queued messages, control frames and pings to the WebSocket connection until writing
+// fails or the connection is closed. Messages are only taken from the write channel while the client has credit.
+func (c *Connection) writeLoop(ticker *time.Ticker) {
 	for {
 		select {
-		case md, ok := <-c.writeCh:
-			if !ok {
-				err := c.ws.WriteMessage(websocket.CloseMessage, []byte{})
-				if err != nil {
-					c.logger.Error("Error closing connection", zap.String("conn-id", c.id), zap.Error(err))
-				}
+		case <-c.done:
+			return
+
+		case md := <-c.deliveries():
+			if !c.writeDeliveries(md) {
 				return
 			}
 
-			if err := c.ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
-				c.logger.Error("Error setting write deadline", zap.String("conn-id", c.id), zap.Error(err))
+		case <-c.flow.granted:
+
+		case frame := <-c.controlCh:
+			if err := c.ws.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+				c.log().Error("Error setting write deadline for control frame", zap.Error(err))
 				return
 			}
 
-			if err := c.ws.WriteMessage(websocket.TextMessage, md.Message); err != nil {
-				c.logger.Error("Error sending message to the client", zap.String("conn-id", c.id), zap.Error(err))
+			if err := c.ws.WriteMessage(websocket.TextMessage, frame); err != nil {
+				c.log().Error("Error sending control frame to the client", zap.Error(err))
+				return
+			}
+			if !c.countWritten(len(frame)) {
 				return
 			}
 
 		case <-ticker.C:
-			if err := c.ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
-				c.logger.Error("Error setting write deadline for ping message", zap.String("conn-id", c.id), zap.Error(err))
+			if c.halfOpen() {
+				c.log().Warn("Client stopped answering pings, closing half-open connection", zap.String("keepalive-class", c.keepaliveClass), zap.Int("missed-pongs", c.keepaliveSettings().MaxMissedPongs))
+				return
+			}
+			ticker.Reset(c.nextPing())
+
+			if err := c.ws.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+				c.log().Error("Error setting write deadline for ping message", zap.Error(err))
 				return
 			}
 
 			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
-				c.logger.Error("Error pinging the client", zap.String("conn-id", c.id), zap.Error(err))
+				c.log().Error("Error pinging the client", zap.Error(err))
 				return
 			}
 		}
 	}
 }
 
-// Close closes the WebSocket connection and the related channels.
-func (c *Connection) Close() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// written is a message taken from the write queue and encoded for the client, with the time it
+// was taken in Unix nanoseconds. buf holds the frame encoded from the message's shared frame.
+type written struct {
+	md       message.MessageDetails
+	frames   [][]byte
+	buf      []byte
+	dequeued int64
+}
+
+// maxPooledBuffer is the capacity above which the frame buffers of written messages are dropped
+// rather than kept in writeBatches, so a few large messages do not pin their memory.
+const maxPooledBuffer = 64 << 10
+
+// writeBatches holds the batches write pumps encode messages in. Batches are shared by all the
+// connections and reused, with the frame buffers of their messages, from one write to the next, so
+// writing a message allocates neither its copy of the envelope nor its frame.
+var writeBatches = sync.Pool{
+	New: func() any { return &writeBatch{} },
+}
+
+// writeBatch holds the messages written to the client in one WebSocket message.
+type writeBatch struct {
+	written []written
+	frames  [][]byte
+}
+
+// next returns the entry the next message of the batch is encoded in.
+func (b *writeBatch) next() *written {
+	if len(b.written) < cap(b.written) {
+		b.written = b.written[:len(b.written)+1]
+	} else {
+		b.written = append(b.written, written{})
+	}
+	w := &b.written[len(b.written)-1]
+	w.frames = w.frames[:0]
+	return w
+}
+
+// drop removes the last entry, taken for a message that is not written after all.
+func (b *writeBatch) drop() {
+	b.written = b.written[:len(b.written)-1]
+}
+
+// release returns the batch to writeBatches, dropping its references to the messages written.
+func (b *writeBatch) release() {
+	for i := range b.written {
+		w := &b.written[i]
+		clear(w.frames)
+		w.md, w.frames, w.dequeued = message.MessageDetails{}, w.frames[:0], 0
+		if cap(w.buf) > maxPooledBuffer {
+			w.buf = nil
+		}
+	}
+	clear(b.frames)
+	b.written, b.frames = b.written[:0], b.frames[:0]
+	writeBatches.Put(b)
+}
+
+// writeDeliveries writes a message taken from the write queue to the client and, for clients with
+// the batching capability, the messages queued behind it in the same WebSocket message, up to
+// maxBatchMessages and the client's credit. It reports false once the connection must stop.
+func (c *Connection) writeDeliveries(md message.MessageDetails) bool {
+	b := writeBatches.Get().(*writeBatch)
+	defer b.release()
+	for {
+		if c.prepare(b.next(), md) {
+			c.spendCredit()
+		} else {
+			b.drop()
+		}
+		if !c.capabilities.has(capBatching) || len(b.written) >= maxBatchMessages {
+			break
+		}
+		next, ok := c.nextDelivery()
+		if !ok {
+			break
+		}
+		md = next
+	}
+	batch := b.written
+	if len(batch) == 0 {
+		return true
+	}
+
+	c.chaos.StallWrite()
+	if err := c.ws.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+		c.log().Error("Error setting write deadline", zap.Error(err))
+		return false
+	}
+
+	var messages [][]byte
+	if c.capabilities.has(capBatching) {
+		for _, w := range batch {
+			b.frames = append(b.frames, w.frames...)
+		}
+		messages = [][]byte{batchFrames(b.frames)}
+	} else {
+		messages = batch[0].frames
+	}
+
+	start := time.Now()
+	for _, data := range messages {
+		if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+			c.log().Error("Error sending message to the client", zap.Error(err))
+			return false
+		}
+		if !c.countWritten(len(data)) {
+			return false
+		}
+	}
+	write := time.Since(start)
+	for i := range batch {
+		stageWrite.Observe(write.Seconds())
+		c.traceWrite(&batch[i].md, batch[i].dequeued, write)
+		if batch[i].md.Cursor != "" {
+			c.wroteCursor(batch[i].md.Room, batch[i].md.Cursor)
+		}
+	}
+	return true
+}
+
+// nextDelivery takes the next message from the write queue without waiting, if the client's
+// credit allows one.
+func (c *Connection) nextDelivery() (message.MessageDetails, bool) {
+	select {
+	case md := <-c.deliveries():
+		return md, true
+	default:
+		return message.MessageDetails{}, false
+	}
+}
 
-	if c.closed {
+// prepare encodes a message taken from the write queue for the client into w. It reports false
+// for messages shed or expired while queued, dropped by a transform or failing to encode.
+func (c *Connection) prepare(w *written, md message.MessageDetails) bool {
+	if c.dequeued(md) {
+		return false
+	}
+	dequeued := time.Now().UnixNano()
+	observeStage(stageWriteWait, md.Timing.FannedOut, dequeued)
+	// Prune messages that expired while queued behind a slow client rather than flood it with them.
+	if md.Expired(time.Now()) {
+		metrics.ExpiredMessages.Inc()
+		return false
+	}
+
+	if !c.transform(&md) {
+		return false
+	}
+
+	w.md, w.dequeued = md, dequeued
+	if err := c.encode(w); err != nil {
+		c.log().Error("Error encoding message for the client", zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// encode sets the frames written to the client for the message of w: JSON frames for clients
+// that negotiated the hub subprotocol, carrying a JSON Patch for state room messages when the
+// client asked for them or split into chunks when the payload exceeds the chunk size, and the
+// raw payload otherwise. Broadcasts whose payload was neither transformed nor patched for the
+// client are written the frame shared by the connections they were queued for.
+func (c *Connection) encode(w *written) error {
+	md := &w.md
+	if !c.framed {
+		w.frames = append(w.frames, md.Message)
 		return nil
 	}
 
-	close(c.writeCh)
-	close(c.readCh)
-	err := c.ws.Close()
-	if err != nil {
-		c.logger.Error("Error closing connection", zap.String("conn-id", c.id), zap.Error(err))
-		return fmt.Errorf("error closing connection: %w", err)
+	c.receipts.record(md)
+	chunked := c.chunkSize > 0 && len(md.Message) > c.chunkSize
+	if md.Frame != nil && len(c.transforms) == 0 && !c.patches.tracks(md) && !chunked {
+		buf, err := md.Frame.AppendTo(w.buf[:0], md)
+		if err != nil {
+			return err
+		}
+		w.buf = buf
+		w.frames = append(w.frames, buf)
+		return nil
 	}
 
-	c.closed = true
+	frame := message.NewMessageFrame(md)
+	if !c.patches.patch(&frame, c.chunkSize) && chunked {
+		frames, err := splitMessage(md, c.chunkSize)
+		w.frames = append(w.frames, frames...)
+		return err
+	}
+	data, err := frame.ToJSON()
+	if err != nil {
+		return err
+	}
+	w.frames = append(w.frames, data)
 	return nil
 }
+
+// Close sends an empty close frame and closes the WebSocket connection, stopping its pumps.
+// It is safe to call concurrently and more than once.
+func (c *Connection) Close() error {
+	return c.close([]byte{})
+}
+
+// CloseWithReason sends a close frame with the given code and reason payload before closing the connection.
+func (c *Connection) CloseWithReason(code int, reason message.CloseReason) error {
+	return c.close(websocket.FormatCloseMessage(code, string(reason.ToJSON())))
+}
+
+// close closes the connection once. Closing done stops the write pump, and closing the socket
+// makes the read pump's pending read fail so it stops and closes the read channel.
+func (c *Connection) close(closeFrame []byte) error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		if err := c.ws.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(c.writeTimeout)); err != nil {
+			c.log().Warn("Error sending close frame", zap.Error(err))
+		}
+
+		if err := c.ws.Close(); err != nil {
+			c.log().Error("Error closing connection", zap.Error(err))
+			c.closeErr = fmt.Errorf("error closing connection: %w", err)
+		}
+	})
+	return c.closeErr
+}
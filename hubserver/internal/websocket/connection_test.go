@@ -0,0 +1,297 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/hubtest"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		HubName:                   "test-hub",
+		PubSubChannelName:         "test-channel",
+		BroadcastWorkers:          4,
+		BroadcastBatchSize:        8,
+		BroadcastBufferSize:       64,
+		RemoveBufferSize:          16,
+		ReadBufferSize:            4,
+		WriteBufferSize:           4,
+		DuplicateConnectionPolicy: config.PolicyAllowMultiple,
+		MaxConnectionsPerUser:     1,
+		WriteTimeout:              time.Second,
+		WriteRetries:              1,
+		PingInterval:              50 * time.Millisecond,
+		MaxMissedPongs:            2,
+		ReplayWindow:              time.Second,
+		MaxChunkedMessageSize:     1 << 20,
+	}
+}
+
+// startHub runs a message handler behind a test server and returns it with the server's WebSocket URL.
+func startHub(t *testing.T) (*MessageHandler, string) {
+	t.Helper()
+	return startHubWith(t, testConfig())
+}
+
+// startHubWith runs a message handler of the config behind a test server, as startHub does.
+func startHubWith(t *testing.T, cfg *config.Config) (*MessageHandler, string) {
+	t.Helper()
+
+	h, err := NewMessageHandler(hubtest.NewBroker("test-channel", "test-hub"), nil, cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewMessageHandler: %v", err)
+	}
+	go h.Run()
+
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return h, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dial(t *testing.T, url string, subprotocols ...string) *websocket.Conn {
+	t.Helper()
+
+	dialer := websocket.Dialer{Subprotocols: subprotocols, HandshakeTimeout: 5 * time.Second}
+	ws, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	return ws
+}
+
+// connections returns a snapshot of the handler's connections.
+func connections(h *MessageHandler) []*Connection {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	conns := make([]*Connection, 0, len(h.connections))
+	for _, conn := range h.connections {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// waitFor polls cond until it holds or the deadline passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConnectionCloseIsIdempotent(t *testing.T) {
+	h, url := startHub(t)
+	ws := dial(t, url)
+	defer ws.Close()
+
+	waitFor(t, "connection", func() bool { return len(connections(h)) == 1 })
+	conn := connections(h)[0]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				_ = conn.Close()
+			} else {
+				_ = conn.CloseWithReason(message.CloseShutdown, h.closeReason(message.ReasonShutdown))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case <-conn.done:
+	default:
+		t.Fatal("connection not marked done after Close")
+	}
+
+	// The read pump closes the read channel once the socket is gone, ending the ingest goroutine.
+	waitFor(t, "read channel to close", func() bool {
+		select {
+		case _, ok := <-conn.readCh:
+			return !ok
+		default:
+			return false
+		}
+	})
+	waitFor(t, "connection removal", func() bool { return len(connections(h)) == 0 })
+}
+
+func TestConnectCloseBroadcastInterleavings(t *testing.T) {
+	h, url := startHub(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	// Broadcast continuously while connections come and go.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ctx.Err() == nil; i++ {
+			md := message.NewMessageDetails("origin", h.hubID, "origin", []byte(fmt.Sprintf(`{"n":%d}`, i)))
+			md.ID = fmt.Sprint(i)
+			h.ingest(md)
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	// Close connections from the server side at random points of their lifetime.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			for _, conn := range connections(h) {
+				h.remove <- conn.id
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var clients sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		clients.Add(1)
+		go func(i int) {
+			defer clients.Done()
+			for j := 0; j < 5; j++ {
+				subprotocols := []string{}
+				if i%2 == 0 {
+					subprotocols = append(subprotocols, message.Subprotocol)
+				}
+				ws := dial(t, url, subprotocols...)
+
+				_ = ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"message","payload":"hi"}`))
+				_ = ws.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+				_, _, _ = ws.ReadMessage()
+
+				// Close from the client side half of the time, leaving the rest to the server.
+				if j%2 == 0 {
+					_ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				}
+				_ = ws.Close()
+			}
+		}(i)
+	}
+	clients.Wait()
+
+	cancel()
+	wg.Wait()
+	waitFor(t, "connections to drain", func() bool { return len(connections(h)) == 0 })
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestSignedURLsAuthenticateConnections(t *testing.T) {
+	cfg := testConfig()
+	cfg.AuthRequired = true
+	cfg.AuthURLSigningSecret = "url-signing-secret"
+	cfg.AuthURLMaxTTL = time.Hour
+	h, hubURL := startHubWith(t, cfg)
+
+	sign := func(params string, expires time.Time) string {
+		signed, err := auth.SignURL([]byte(cfg.AuthURLSigningSecret), hubURL+"?"+params, expires)
+		if err != nil {
+			t.Fatalf("SignURL: %v", err)
+		}
+		return signed
+	}
+	signed := sign("sub=alice&roles=support", time.Now().Add(time.Minute))
+	ws := dial(t, signed, message.Subprotocol)
+	defer ws.Close()
+	waitFor(t, "connection", func() bool { return len(connections(h)) == 1 })
+	if identity := connections(h)[0].identity; identity.UserID != "alice" || len(identity.Roles) != 1 || identity.Roles[0] != "support" {
+		t.Fatalf("signed URL authenticated %+v", identity)
+	}
+
+	for name, rawURL := range map[string]string{
+		"tampered":           strings.Replace(signed, "roles=support", "roles=admin", 1),
+		"expired":            sign("sub=alice", time.Now().Add(-time.Second)),
+		"beyond the max TTL": sign("sub=alice", time.Now().Add(2*time.Hour)),
+		"unsigned":           hubURL + "?sub=alice",
+	} {
+		dialer := websocket.Dialer{Subprotocols: []string{message.Subprotocol}, HandshakeTimeout: 5 * time.Second}
+		ws, resp, err := dialer.Dial(rawURL, nil)
+		if err == nil {
+			_ = ws.Close()
+			t.Fatalf("%s URL connected", name)
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%s URL rejected with %v, want 401", name, resp)
+		}
+	}
+}
+
+func TestHandlerCloseDuringUpgrades(t *testing.T) {
+	h, url := startHub(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dialer := websocket.Dialer{HandshakeTimeout: time.Second}
+			ws, _, err := dialer.Dial(url, nil)
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+			_ = ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			_, _, _ = ws.ReadMessage()
+		}()
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	wg.Wait()
+
+	if conns := connections(h); len(conns) != 0 {
+		t.Fatalf("%d connections registered after Close", len(conns))
+	}
+}
+
+func TestFlowControlledConnectionStopsOnClose(t *testing.T) {
+	h, url := startHub(t)
+	ws := dial(t, url, message.Subprotocol)
+	defer ws.Close()
+
+	waitFor(t, "connection", func() bool { return len(connections(h)) == 1 })
+	conn := connections(h)[0]
+
+	// Exhaust the client's credit so the write pump stops taking messages, then close it.
+	conn.grantCredit(1)
+	for i := 0; i < cap(conn.writeCh); i++ {
+		md := message.NewMessageDetails("origin", h.hubID, "origin", []byte(`"queued"`))
+		h.ingest(md)
+	}
+	waitFor(t, "delivery to pause", func() bool { return conn.deliveries() == nil })
+
+	h.remove <- conn.id
+	waitFor(t, "connection removal", func() bool { return len(connections(h)) == 0 })
+	select {
+	case <-conn.done:
+	case <-time.After(time.Second):
+		t.Fatal("paused connection was not closed")
+	}
+}
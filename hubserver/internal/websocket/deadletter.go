@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"go.uber.org/zap"
+)
+
+// ErrDeadLettersDisabled is returned by the dead-letter operations of a hub without a dead-letter stream.
+var ErrDeadLettersDisabled = errors.New("dead letters are disabled")
+
+// DeadLetters returns up to count of the most recent envelopes from other hubs that the hubs
+// sharing the dead-letter stream could not decode or verify, newest first.
+func (h *MessageHandler) DeadLetters(ctx context.Context, count int64) ([]redis.DeadLetter, error) {
+	if h.deadLetters == nil {
+		return nil, ErrDeadLettersDisabled
+	}
+	return h.deadLetters.List(ctx, count)
+}
+
+// ReplayDeadLetter delivers an envelope this hub dead-lettered to it again and removes it from the
+// stream, reporting false when there is no such dead letter. Envelopes that still fail are
+// dead-lettered again under a new id; those recorded by other hubs are replayed on them.
+func (h *MessageHandler) ReplayDeadLetter(ctx context.Context, id string) (bool, error) {
+	if h.deadLetters == nil {
+		return false, ErrDeadLettersDisabled
+	}
+
+	replayed, err := h.deadLetters.Replay(ctx, id)
+	if replayed {
+		h.logger.Info("Replayed dead letter", zap.String("id", id))
+	}
+	return replayed, err
+}
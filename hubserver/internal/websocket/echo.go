@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// echo reflects a message published to the echo room back to its sender only, as a message frame
+// stamped with the hub that echoed it and the time it did as ingested_at. Echoes skip room access
+// control, payload policies and fan-out, and are never seen by other connections or hubs.
+func (h *MessageHandler) echo(conn *Connection, frame message.Frame) {
+	reply := message.Frame{
+		Type:        message.FrameMessage,
+		ID:          frame.ID,
+		OriginID:    conn.id,
+		HubID:       h.hubID,
+		Room:        message.EchoRoom,
+		Payload:     frame.Payload,
+		Data:        frame.Data,
+		ContentType: frame.ContentType,
+		IngestedAt:  time.Now().UnixMilli(),
+	}
+	data, err := reply.ToJSON()
+	if err != nil {
+		conn.log().Warn("Failed to encode echo", zap.String("id", frame.ID), zap.Error(err))
+		return
+	}
+	metrics.Echoes.Inc()
+	h.writeControl(conn.id, data)
+}
@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"go.uber.org/zap"
+)
+
+// envelopeKeys returns the provider of the envelope encryption keys of the configuration, or nil
+// when envelopes are not encrypted.
+func envelopeKeys(cfg *config.Config) redis.KeyProvider {
+	switch {
+	case cfg.EnvelopeKeysFile != "":
+		return redis.FileKeys(cfg.EnvelopeKeysFile)
+	case cfg.EnvelopeKeysEnv != "":
+		return redis.EnvKeys(cfg.EnvelopeKeysEnv)
+	default:
+		return nil
+	}
+}
+
+// EncryptEnvelopes encrypts the payloads of the envelopes the hub publishes to Redis, including
+// those mirrored to a secondary region, with the provider's keys, refreshed every refresh until the
+// handler is closed. Envelopes received unencrypted are dropped unless acceptPlaintext is set. It
+// must be called before the handler runs.
+func (h *MessageHandler) EncryptEnvelopes(provider redis.KeyProvider, refresh time.Duration, acceptPlaintext bool) error {
+	if len(h.redisPubSubs) == 0 {
+		return errors.New("envelope encryption needs the redis broker")
+	}
+
+	keyring, err := redis.NewKeyring(h.ctx, provider, refresh, h.logger)
+	if err != nil {
+		return fmt.Errorf("failed to load envelope encryption keys: %w", err)
+	}
+	for _, ps := range h.redisPubSubs {
+		ps.EncryptWith(keyring, acceptPlaintext)
+	}
+	h.logger.Info("Envelope encryption enabled", zap.Duration("refresh", refresh), zap.Bool("accept-plaintext", acceptPlaintext))
+	return nil
+}
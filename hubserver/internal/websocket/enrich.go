@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// Annotations added by the built-in enrichers.
+const (
+	annotationHub         = "hub"
+	annotationGeo         = "geo"
+	annotationDisplayName = "display_name"
+)
+
+// maxDisplayNames bounds the display names cached by a display name enricher.
+const maxDisplayNames = 10000
+
+// Sender describes the publisher of a message being enriched: the connection it was sent on, or
+// the HTTP publish request.
+type Sender struct {
+	ConnID   string
+	Identity auth.Identity
+	RemoteIP netip.Addr
+	// Header holds the headers of the upgrade or publish request when enrichers are installed.
+	Header http.Header
+	Room   string
+}
+
+// Enricher adds server-side fields about the publisher to the annotations of a message before it
+// is fanned out, such as their display name or location. Enrichers run in the order they were
+// added, each seeing the annotations of the previous ones; one that fails leaves the message to
+// the others.
+type Enricher func(ctx context.Context, sender Sender, annotations map[string]string) error
+
+// AddEnricher registers an enricher run on every message published to this hub by a connection or
+// over HTTP. Messages received from other hubs keep the annotations of the hub they were published to.
+func (h *MessageHandler) AddEnricher(e Enricher) {
+	h.enrichers = append(h.enrichers, e)
+}
+
+// annotate runs the enrichers on a message published to this hub.
+func (h *MessageHandler) annotate(ctx context.Context, sender Sender, md *message.MessageDetails) {
+	if len(h.enrichers) == 0 {
+		return
+	}
+
+	annotations := make(map[string]string)
+	for _, enrich := range h.enrichers {
+		if err := enrich(ctx, sender, annotations); err != nil {
+			h.logger.Warn("Enricher failed", zap.String("id", md.ID), zap.String("conn-id", sender.ConnID), zap.Error(err))
+		}
+	}
+	if len(annotations) > 0 {
+		md.Annotations = annotations
+	}
+}
+
+// sender describes the connection as the publisher of its messages to a room.
+func (c *Connection) sender(room string) Sender {
+	return Sender{ConnID: c.id, Identity: c.identity, RemoteIP: c.remoteIP, Header: c.header, Room: room}
+}
+
+// AnnotateHub returns an enricher annotating messages with the name of the hub they were published to.
+func AnnotateHub(hubID string) Enricher {
+	return func(_ context.Context, _ Sender, annotations map[string]string) error {
+		annotations[annotationHub] = hubID
+		return nil
+	}
+}
+
+// AnnotateGeo returns an enricher annotating messages with the location a CDN or load balancer in
+// front of the hub reports in a request header, such as CF-IPCountry.
+func AnnotateGeo(header string) Enricher {
+	return func(_ context.Context, sender Sender, annotations map[string]string) error {
+		if value := sender.Header.Get(header); value != "" {
+			annotations[annotationGeo] = value
+		}
+		return nil
+	}
+}
+
+// AnnotateDisplayName returns an enricher annotating messages of authenticated publishers with the
+// display name lookup returns for their user id, cached for ttl. Users without a display name are
+// not annotated.
+func AnnotateDisplayName(lookup func(ctx context.Context, userID string) (string, error), ttl time.Duration) Enricher {
+	cache := &displayNames{lookup: lookup, ttl: ttl, names: make(map[string]displayName)}
+	return func(ctx context.Context, sender Sender, annotations map[string]string) error {
+		if sender.Identity.IsAnonymous() {
+			return nil
+		}
+		name, err := cache.get(ctx, sender.Identity.UserID, time.Now())
+		if err != nil {
+			return err
+		}
+		if name != "" {
+			annotations[annotationDisplayName] = name
+		}
+		return nil
+	}
+}
+
+// displayNames caches the display names of users.
+type displayNames struct {
+	lookup func(ctx context.Context, userID string) (string, error)
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	names map[string]displayName
+}
+
+type displayName struct {
+	name    string
+	expires time.Time
+}
+
+func (d *displayNames) get(ctx context.Context, userID string, now time.Time) (string, error) {
+	d.mu.Lock()
+	cached, ok := d.names[userID]
+	d.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.name, nil
+	}
+
+	name, err := d.lookup(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.names) >= maxDisplayNames {
+		clear(d.names)
+	}
+	d.names[userID] = displayName{name: name, expires: now.Add(d.ttl)}
+	return name, nil
+}
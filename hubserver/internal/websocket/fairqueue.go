@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"sync"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+// fairQueue holds the messages the broadcast workers took off the broadcast queue in a sub-queue
+// per sender, and hands them out in batches taking one message of each sender in turn, so a
+// prolific sender cannot hold up the messages of the others queued behind its own. Each sender's
+// messages keep their order.
+type fairQueue struct {
+	mu       sync.Mutex
+	capacity int
+	queued   int
+	senders  map[string][]message.MessageDetails
+	// turns holds the senders with queued messages in the order they are served
+	turns []string
+}
+
+// newFairQueue returns a fair queue taking up to capacity messages off the broadcast queue, or nil
+// when fair queuing is disabled.
+func newFairQueue(enabled bool, capacity int) *fairQueue {
+	if !enabled {
+		return nil
+	}
+	return &fairQueue{capacity: capacity, senders: make(map[string][]message.MessageDetails)}
+}
+
+// fill moves the messages waiting in the broadcast queue to their sender's sub-queue, until either
+// is empty or the fair queue is full.
+func (q *fairQueue) fill(broadcastCh chan message.MessageDetails) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.queued < q.capacity {
+		select {
+		case md := <-broadcastCh:
+			q.push(md)
+		default:
+			return
+		}
+	}
+}
+
+// add queues a message taken off the broadcast queue in its sender's sub-queue.
+func (q *fairQueue) add(md message.MessageDetails) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.push(md)
+}
+
+func (q *fairQueue) push(md message.MessageDetails) {
+	sender := fairSender(md)
+	queue, ok := q.senders[sender]
+	if !ok {
+		q.turns = append(q.turns, sender)
+	}
+	q.senders[sender] = append(queue, md)
+	q.queued++
+}
+
+// fairSender returns the sender a message is queued under: the connection that published it, named
+// by its hub and origin as the SenderID of every message received from the broker is the channel
+// it came in on.
+func fairSender(md message.MessageDetails) string {
+	return md.HubID + "/" + md.OriginID
+}
+
+// next takes a batch of up to max messages, one of each sender in turn. Senders left with queued
+// messages take their next turn after those that had none in this batch.
+func (q *fairQueue) next(max int) []message.MessageDetails {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.queued == 0 {
+		return nil
+	}
+	batch := make([]message.MessageDetails, 0, min(max, q.queued))
+	for len(batch) < max && len(q.turns) > 0 {
+		sender := q.turns[0]
+		q.turns = q.turns[1:]
+		queue := q.senders[sender]
+		batch = append(batch, queue[0])
+		queue[0] = message.MessageDetails{}
+		if queue = queue[1:]; len(queue) > 0 {
+			q.senders[sender] = queue
+			q.turns = append(q.turns, sender)
+		} else {
+			delete(q.senders, sender)
+		}
+	}
+	q.queued -= len(batch)
+	return batch
+}
+
+// len returns the number of messages the fair queue holds.
+func (q *fairQueue) len() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued
+}
+
+// fairBroadcastWorker processes messages from the broadcast channel through the fair queue, like
+// broadcastWorker: it waits for a message only once the fair queue is empty, and keeps
+// broadcasting after the handler closes until both are empty.
+func (h *MessageHandler) fairBroadcastWorker() {
+	stopping := false
+	for {
+		h.fair.fill(h.broadcastCh)
+		if batch := h.fair.next(h.broadcastBatchSize); len(batch) > 0 {
+			h.broadcastBatch(h.newBatch(batch...).messages)
+			continue
+		}
+		if stopping {
+			return
+		}
+
+		select {
+		case md := <-h.broadcastCh:
+			h.fair.add(md)
+		case <-h.stopBroadcast:
+			stopping = true
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package websocket
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+func TestFairQueueTakesTurnsBetweenTheConnectionsOfOtherHubs(t *testing.T) {
+	q := newFairQueue(true, 16)
+	// Every message of the other hubs comes in on the channel, its sender
+	for _, id := range []string{"bulk-1", "bulk-2", "bulk-3", "chat-1"} {
+		md := message.NewMessageDetails("bulk-publisher", "hub-2", "test-channel", nil)
+		if id == "chat-1" {
+			md.OriginID = "chat-user"
+		}
+		md.ID = id
+		q.add(md)
+	}
+
+	var ids []string
+	for _, md := range q.next(2) {
+		ids = append(ids, md.ID)
+	}
+	if want := []string{"bulk-1", "chat-1"}; !slices.Equal(ids, want) {
+		t.Fatalf("first batch is %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"slices"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// maxHops bounds the hubs a message may be published to a broker by, catching loops through hubs
+// that don't record their hops.
+const maxHops = 16
+
+// stampHop records the hub in the hops of a message it publishes to the broker.
+func (h *MessageHandler) stampHop(md *message.MessageDetails) {
+	md.Hops = append(slices.Clip(md.Hops), h.hubID)
+}
+
+// looped reports whether a message received from the broker has already been through this hub,
+// which happens when hubs are misconfigured with overlapping channels or relay each other's
+// traffic, so it ping-pongs between them instead of being delivered once.
+func (h *MessageHandler) looped(md message.MessageDetails) bool {
+	if !md.IsFromPubSub(h.pubSubChannel) {
+		return false
+	}
+
+	var reason string
+	switch {
+	case md.HubID == h.hubID || slices.Contains(md.Hops, h.hubID):
+		reason = "revisit"
+	case len(md.Hops) >= maxHops:
+		reason = "max_hops"
+	default:
+		return false
+	}
+	metrics.BroadcastLoops.WithLabelValues(reason).Inc()
+	h.logger.Warn("Dropping message that looped back to the hub", zap.String("id", md.ID), zap.String("hub-id", md.HubID),
+		zap.Strings("hops", md.Hops), zap.String("reason", reason))
+	return true
+}
@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"encoding/json"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/filter"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// setFilter selects the messages of a room the connection receives with the filter, or all of
+// them when it is nil. Joining a room again replaces its filter.
+func (c *Connection) setFilter(room string, f *filter.Filter) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	if _, ok := c.rooms[room]; !ok {
+		return
+	}
+	if f == nil {
+		delete(c.filters, room)
+		return
+	}
+	c.filters[room] = f
+}
+
+// subscription reports whether the connection receives messages published to the room, with the
+// filter selecting them if it joined the room with one. Every connection receives messages
+// published without a room, unfiltered.
+func (c *Connection) subscription(room string) (bool, *filter.Filter) {
+	if room == "" {
+		return true, nil
+	}
+
+	c.roomsMu.RLock()
+	defer c.roomsMu.RUnlock()
+
+	if _, ok := c.rooms[room]; !ok {
+		return false, nil
+	}
+	return true, c.filters[room]
+}
+
+// compileFilter compiles the filter of a join frame, telling framed clients with an error frame
+// when it is invalid. Joins without a filter compile to nil.
+func (h *MessageHandler) compileFilter(conn *Connection, frame message.Frame) (*filter.Filter, bool) {
+	if frame.Filter == "" {
+		return nil, true
+	}
+
+	f, err := filter.Compile(frame.Filter)
+	if err != nil {
+		metrics.MessagesDropped.WithLabelValues("bad_filter").Inc()
+		h.logger.Warn("Invalid room filter", zap.String("conn-id", conn.id), zap.String("room", frame.Room), zap.Error(err))
+		if conn.framed {
+			reply := message.Frame{Type: message.FrameError, Room: frame.Room, Reason: "bad_filter"}
+			if data, err := reply.ToJSON(); err == nil {
+				h.writeControl(conn.id, data)
+			}
+		}
+		return nil, false
+	}
+	return f, true
+}
+
+// batchPayloads holds the payloads of a batch's messages decoded for the filters of the
+// connections they are fanned out to, each decoded once, when a filter first needs it.
+type batchPayloads struct {
+	batch   []message.MessageDetails
+	values  []any
+	decoded []bool
+}
+
+func newBatchPayloads(batch []message.MessageDetails) *batchPayloads {
+	return &batchPayloads{batch: batch}
+}
+
+// get returns the decoded payload of the batch's i-th message. Payloads that are not JSON are
+// strings, as clients receive them.
+func (p *batchPayloads) get(i int) any {
+	if p.values == nil {
+		p.values = make([]any, len(p.batch))
+		p.decoded = make([]bool, len(p.batch))
+	}
+	if !p.decoded[i] {
+		var value any
+		if err := json.Unmarshal(p.batch[i].Message, &value); err != nil {
+			value = string(p.batch[i].Message)
+		}
+		p.values[i] = value
+		p.decoded[i] = true
+	}
+	return p.values[i]
+}
@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// maxCredit bounds the credit a connection may accumulate, so a misbehaving client cannot overflow it.
+const maxCredit = 1 << 20
+
+// flowControl tracks the messages a client has allowed the hub to deliver. Connections start
+// without flow control; once the client grants credit, every delivered message spends one credit
+// and delivery pauses at zero, leaving messages queued on the write channel until the client
+// grants more.
+type flowControl struct {
+	enabled bool
+	credit  int
+
+	// granted wakes the write pump when credit arrives while delivery is paused.
+	granted chan struct{}
+}
+
+// grantCredit adds credit granted by the client and resumes delivery if it was paused.
+func (c *Connection) grantCredit(n int) {
+	c.flowMu.Lock()
+	c.flow.enabled = true
+	c.flow.credit = min(c.flow.credit+n, maxCredit)
+	c.flowMu.Unlock()
+
+	c.wakeWritePump()
+}
+
+func (c *Connection) wakeWritePump() {
+	select {
+	case c.flow.granted <- struct{}{}:
+	default:
+	}
+}
+
+// deliveries returns the channel the write pump takes messages from: the write channel, or nil
+// while the client has no credit left.
+func (c *Connection) deliveries() <-chan message.MessageDetails {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	if c.flow.enabled && c.flow.credit <= 0 {
+		return nil
+	}
+	return c.writeCh
+}
+
+// spendCredit accounts for a message delivered to the client.
+func (c *Connection) spendCredit() {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	if c.flow.enabled && c.flow.credit > 0 {
+		c.flow.credit--
+	}
+}
+
+// handleCreditFrame applies a credit frame received from the connection.
+func (h *MessageHandler) handleCreditFrame(conn *Connection, frame message.Frame) {
+	if frame.Count <= 0 {
+		h.logger.Warn("Credit frame without credit", zap.String("conn-id", conn.id), zap.Int("count", frame.Count))
+		return
+	}
+	conn.grantCredit(frame.Count)
+}
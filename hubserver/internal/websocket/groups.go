@@ -0,0 +1,263 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// errGroupRequired is returned by the group operations given an empty group name.
+var errGroupRequired = errors.New("group name is required")
+
+// GroupRate is the message rate that replaces the quota of the connections of a group, with bursts
+// up to Burst.
+type GroupRate struct {
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+// GroupInfo describes a connection group of the hub.
+type GroupInfo struct {
+	Name        string     `json:"name"`
+	Connections int        `json:"connections"`
+	Rate        *GroupRate `json:"rate,omitempty"`
+}
+
+// connectionGroups holds the message rates set for connection groups. Members are recorded on
+// their connections, so groups are left as their connections close.
+type connectionGroups struct {
+	mu    sync.RWMutex
+	rates map[string]GroupRate
+}
+
+// groupNames returns the groups the connection belongs to, by name.
+func (c *Connection) groupNames() []string {
+	c.groupsMu.RLock()
+	defer c.groupsMu.RUnlock()
+
+	names := make([]string, 0, len(c.groups))
+	for group := range c.groups {
+		names = append(names, group)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// inGroup reports whether the connection belongs to the group.
+func (c *Connection) inGroup(group string) bool {
+	c.groupsMu.RLock()
+	defer c.groupsMu.RUnlock()
+	return c.groups[group]
+}
+
+// joinGroups adds the connection to the groups, holding it to the message rate set for them.
+func (h *MessageHandler) joinGroups(conn *Connection, groups []string) {
+	if len(groups) == 0 {
+		return
+	}
+
+	conn.groupsMu.Lock()
+	for _, group := range groups {
+		if group != "" {
+			conn.groups[group] = true
+		}
+	}
+	conn.groupsMu.Unlock()
+	h.applyGroupRate(conn)
+}
+
+// applyGroupRate holds the connection to the lowest message rate set for its groups in place of
+// its quota, or back to its quota when none of them has one. The limiter is kept while the rate is
+// unchanged so its bucket isn't refilled.
+func (h *MessageHandler) applyGroupRate(conn *Connection) {
+	var lowest *GroupRate
+	h.groups.mu.RLock()
+	conn.groupsMu.RLock()
+	for group := range conn.groups {
+		if r, ok := h.groups.rates[group]; ok && (lowest == nil || r.MessagesPerSecond < lowest.MessagesPerSecond) {
+			lowest = &r
+		}
+	}
+	conn.groupsMu.RUnlock()
+	h.groups.mu.RUnlock()
+
+	if lowest == nil {
+		conn.rateOverride.Store(nil)
+		return
+	}
+	limit, burst := rate.Limit(lowest.MessagesPerSecond), max(lowest.Burst, 1)
+	if current := conn.rateOverride.Load(); current != nil && current.Limit() == limit && current.Burst() == burst {
+		return
+	}
+	conn.rateOverride.Store(rate.NewLimiter(limit, burst))
+}
+
+// groupMembers returns the connections of the hub that belong to the group.
+func (h *MessageHandler) groupMembers(group string) []*Connection {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var members []*Connection
+	for _, conn := range h.connections {
+		if conn.inGroup(group) {
+			members = append(members, conn)
+		}
+	}
+	return members
+}
+
+// Groups returns the groups with connections on the hub or a message rate set, by name.
+func (h *MessageHandler) Groups() []GroupInfo {
+	counts := make(map[string]int)
+	h.mu.RLock()
+	for _, conn := range h.connections {
+		for _, group := range conn.groupNames() {
+			counts[group]++
+		}
+	}
+	h.mu.RUnlock()
+
+	h.groups.mu.RLock()
+	rates := make(map[string]GroupRate, len(h.groups.rates))
+	for group, r := range h.groups.rates {
+		rates[group] = r
+		if _, ok := counts[group]; !ok {
+			counts[group] = 0
+		}
+	}
+	h.groups.mu.RUnlock()
+
+	groups := make([]GroupInfo, 0, len(counts))
+	for name, count := range counts {
+		info := GroupInfo{Name: name, Connections: count}
+		if r, ok := rates[name]; ok {
+			info.Rate = &r
+		}
+		groups = append(groups, info)
+	}
+	slices.SortFunc(groups, func(a, b GroupInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return groups
+}
+
+// AddToGroup adds the connections of the hub with the given ids to the group and returns the number
+// of them the hub held. Groups are local to the hub, like the connections they hold.
+func (h *MessageHandler) AddToGroup(group string, connIDs []string) (int, error) {
+	if group == "" {
+		return 0, errGroupRequired
+	}
+
+	added := 0
+	for _, connID := range connIDs {
+		h.mu.RLock()
+		conn, ok := h.connections[connID]
+		h.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		h.joinGroups(conn, []string{group})
+		added++
+	}
+	return added, nil
+}
+
+// RemoveFromGroup removes a connection of the hub from the group and reports whether it belonged to it.
+func (h *MessageHandler) RemoveFromGroup(group, connID string) bool {
+	h.mu.RLock()
+	conn, ok := h.connections[connID]
+	h.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	conn.groupsMu.Lock()
+	member := conn.groups[group]
+	delete(conn.groups, group)
+	conn.groupsMu.Unlock()
+	if member {
+		h.applyGroupRate(conn)
+	}
+	return member
+}
+
+// BroadcastToGroup sends a payload from the admin API to every authenticated connection of the
+// group, outside of any room, and returns the number of connections it was queued for. Group
+// messages are not published to the other hubs.
+func (h *MessageHandler) BroadcastToGroup(group string, payload []byte) (PublishResult, error) {
+	if group == "" {
+		return PublishResult{}, errGroupRequired
+	}
+
+	md := message.NewMessageDetails(adminPublisherID, h.hubID, adminPublisherID, payload)
+	md.ID = uuid.New().String()
+	md.Local = true
+
+	result := PublishResult{ID: md.ID}
+	for _, conn := range h.groupMembers(group) {
+		if conn.unauthenticated.Load() {
+			continue
+		}
+		if conn.enqueue(md) {
+			result.LocalRecipients++
+		}
+	}
+	h.logger.Info("Broadcast to group", zap.String("group", group), zap.String("id", md.ID), zap.Int("recipients", result.LocalRecipients))
+	return result, nil
+}
+
+// KickGroup closes every connection of the group with the evicted close code and returns the
+// number of connections closed.
+func (h *MessageHandler) KickGroup(group string) int {
+	kicked := 0
+	for _, conn := range h.groupMembers(group) {
+		if h.evictConnection(conn.id) {
+			kicked++
+		}
+	}
+	h.logger.Info("Kicked group", zap.String("group", group), zap.Int("connections", kicked))
+	return kicked
+}
+
+// SetGroupRate holds the connections of the group, including those joining it later, to the
+// message rate in place of their quota, or back to their quota when r is nil. Connections in
+// several groups with a rate are held to the lowest.
+func (h *MessageHandler) SetGroupRate(group string, r *GroupRate) error {
+	if group == "" {
+		return errGroupRequired
+	}
+	if r != nil && (r.MessagesPerSecond <= 0 || r.Burst < 0) {
+		return fmt.Errorf("group rate must be positive with a non-negative burst, got %g messages per second and a burst of %d",
+			r.MessagesPerSecond, r.Burst)
+	}
+
+	h.groups.mu.Lock()
+	if r == nil {
+		delete(h.groups.rates, group)
+	} else {
+		if h.groups.rates == nil {
+			h.groups.rates = make(map[string]GroupRate)
+		}
+		h.groups.rates[group] = *r
+	}
+	h.groups.mu.Unlock()
+
+	members := h.groupMembers(group)
+	for _, conn := range members {
+		h.applyGroupRate(conn)
+	}
+	if r == nil {
+		h.logger.Info("Group rate cleared", zap.String("group", group), zap.Int("connections", len(members)))
+	} else {
+		h.logger.Info("Group rate set", zap.String("group", group), zap.Float64("messages-per-second", r.MessagesPerSecond),
+			zap.Int("burst", r.Burst), zap.Int("connections", len(members)))
+	}
+	return nil
+}
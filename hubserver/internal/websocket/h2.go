@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// isExtendedConnect reports whether the request opens a WebSocket over an HTTP/2 stream using
+// the extended CONNECT method of RFC 8441.
+func isExtendedConnect(r *http.Request) bool {
+	return r.ProtoMajor == 2 && r.Method == http.MethodConnect && r.Header.Get(":protocol") == "websocket"
+}
+
+// h2Upgrade adapts an RFC 8441 extended CONNECT request to the HTTP/1.1 upgrade handshake the
+// upgrader understands. The returned writer hijacks to a stream carrying the WebSocket frames
+// over the request and response bodies.
+func h2Upgrade(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, *h2Stream, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, nil, err
+	}
+
+	upgrade := r.Clone(r.Context())
+	upgrade.Method = http.MethodGet
+	upgrade.Header.Del(":protocol")
+	upgrade.Header.Set("Connection", "Upgrade")
+	upgrade.Header.Set("Upgrade", "websocket")
+	upgrade.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+
+	stream := &h2Stream{
+		w:          w,
+		rc:         http.NewResponseController(w),
+		body:       r.Body,
+		remoteAddr: h2Addr(r.RemoteAddr),
+		done:       make(chan struct{}),
+	}
+	return &h2Hijacker{ResponseWriter: w, stream: stream}, upgrade, stream, nil
+}
+
+// h2Hijacker hands the upgrader the HTTP/2 stream in place of a hijacked TCP connection.
+type h2Hijacker struct {
+	http.ResponseWriter
+	stream *h2Stream
+}
+
+func (h *h2Hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.stream, bufio.NewReadWriter(bufio.NewReader(h.stream), bufio.NewWriter(h.stream)), nil
+}
+
+// h2Stream is a net.Conn over an HTTP/2 extended CONNECT stream. The first write carries the
+// upgrader's HTTP/1.1 101 response, which is translated into the stream's 200 response headers.
+type h2Stream struct {
+	w          http.ResponseWriter
+	rc         *http.ResponseController
+	body       io.ReadCloser
+	remoteAddr h2Addr
+
+	headerSent bool
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func (s *h2Stream) Read(p []byte) (int, error) {
+	return s.body.Read(p)
+}
+
+func (s *h2Stream) Write(p []byte) (int, error) {
+	if !s.headerSent {
+		s.headerSent = true
+		if err := s.writeHeader(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	n, err := s.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, s.rc.Flush()
+}
+
+// writeHeader copies the headers of the upgrader's handshake response that apply to HTTP/2, such
+// as the negotiated subprotocol, and sends the 200 response opening the stream.
+func (s *h2Stream) writeHeader(handshake []byte) error {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(handshake)))
+	if _, err := reader.ReadLine(); err != nil {
+		return err
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	for name, values := range header {
+		switch name {
+		case "Upgrade", "Connection", "Sec-Websocket-Accept":
+			continue
+		}
+		for _, value := range values {
+			s.w.Header().Add(name, value)
+		}
+	}
+	s.w.WriteHeader(http.StatusOK)
+	return s.rc.Flush()
+}
+
+func (s *h2Stream) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return s.body.Close()
+}
+
+func (s *h2Stream) LocalAddr() net.Addr {
+	return h2Addr("")
+}
+
+func (s *h2Stream) RemoteAddr() net.Addr {
+	return s.remoteAddr
+}
+
+func (s *h2Stream) SetDeadline(t time.Time) error {
+	if err := s.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return s.SetWriteDeadline(t)
+}
+
+func (s *h2Stream) SetReadDeadline(t time.Time) error {
+	return ignoreNotSupported(s.rc.SetReadDeadline(t))
+}
+
+func (s *h2Stream) SetWriteDeadline(t time.Time) error {
+	return ignoreNotSupported(s.rc.SetWriteDeadline(t))
+}
+
+// ignoreNotSupported treats deadlines the response writer cannot enforce as best effort; the
+// ping and pong handling still closes dead connections.
+func ignoreNotSupported(err error) error {
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+// h2Addr is the address of the peer of an HTTP/2 stream.
+type h2Addr string
+
+func (a h2Addr) Network() string { return "h2" }
+func (a h2Addr) String() string  { return string(a) }
+
+// waitStream blocks until the connection's HTTP/2 stream is closed. The stream only lives while
+// its handler runs, unlike hijacked HTTP/1.1 connections.
+func (c *Connection) waitStream() {
+	if c.stream != nil {
+		<-c.stream.done
+	}
+}
+
+// CloseHTTP2Connections closes the connections carried over HTTP/2 streams. HTTP server shutdown
+// waits for their handlers to return, so it must run when the server starts shutting down.
+func (h *MessageHandler) CloseHTTP2Connections() {
+	h.mu.RLock()
+	var connIDs []string
+	for connID, conn := range h.connections {
+		if conn.stream != nil {
+			connIDs = append(connIDs, connID)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, connID := range connIDs {
+		conn, ok := h.detach(connID)
+		if !ok {
+			continue
+		}
+		if err := conn.CloseWithReason(message.CloseShutdown, h.closeReason(message.ReasonShutdown)); err != nil {
+			h.logger.Warn("Failed to close HTTP/2 connection", zap.String("conn-id", connID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"go.uber.org/zap"
+)
+
+// handoffParam is the query parameter of the upgrade request carrying the handoff token a draining
+// hub sent the client in its close reason.
+const handoffParam = "handoff"
+
+const (
+	// reconnectionPollInterval is how often a restarted hub checks which of the clients it handed
+	// off before restarting have reconnected.
+	reconnectionPollInterval = 5 * time.Second
+	// snapshotSaveTimeout bounds saving the handoff snapshot of a drain.
+	snapshotSaveTimeout = 5 * time.Second
+)
+
+// handoffToken returns the handoff token of an upgrade request, or "" when handoffs are disabled.
+func (h *MessageHandler) handoffToken(r *http.Request) string {
+	if h.handoffs == nil {
+		return ""
+	}
+	return r.URL.Query().Get(handoffParam)
+}
+
+// handOff saves the state of a connection being drained and returns the token its client resumes
+// it with, or "" when the connection has no rooms to resume or the state could not be saved.
+func (h *MessageHandler) handOff(ctx context.Context, conn *Connection) string {
+	state := conn.handoffState()
+	if len(state.Rooms) == 0 {
+		return ""
+	}
+
+	token := uuid.New().String()
+	if err := h.handoffs.Save(ctx, token, state, h.handoffTTL); err != nil {
+		h.logger.Error("Failed to hand off drained connection", zap.String("conn-id", conn.id), zap.Error(err))
+		return ""
+	}
+	metrics.Handoffs.WithLabelValues("saved").Inc()
+	return token
+}
+
+// saveHandoffSnapshot records the tokens of the connections handed off by a drain, for the hub to
+// await their clients once it restarts.
+func (h *MessageHandler) saveHandoffSnapshot(tokens []string) {
+	if len(tokens) == 0 {
+		return
+	}
+	// The drain may have been cut short by the shutdown it precedes
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotSaveTimeout)
+	defer cancel()
+	if err := h.handoffs.SaveSnapshot(ctx, h.hubID, tokens, h.handoffTTL); err != nil {
+		h.logger.Error("Failed to save handoff snapshot", zap.Error(err))
+	}
+}
+
+// awaitReconnections loads the snapshot of the connections the hub handed off before it restarted
+// and reports how many of their clients are yet to reconnect, to any hub, until all have or their
+// handoffs expired. Operators tell a restart from churn by the awaiting connections it shows.
+func (h *MessageHandler) awaitReconnections(ctx context.Context) {
+	tokens, err := h.handoffs.TakeSnapshot(ctx, h.hubID)
+	if err != nil {
+		h.logger.Error("Failed to load handoff snapshot", zap.Error(err))
+		return
+	}
+	if len(tokens) == 0 {
+		return
+	}
+
+	expected := len(tokens)
+	h.awaitingReconnection.Store(int64(expected))
+	metrics.AwaitingReconnection.Set(float64(expected))
+	h.logger.Info("Awaiting clients handed off before restart", zap.Int("connections", expected))
+	defer func() {
+		h.awaitingReconnection.Store(0)
+		metrics.AwaitingReconnection.Set(0)
+		h.logger.Info("Stopped awaiting handed-off clients", zap.Int("expected", expected), zap.Int("missing", len(tokens)))
+	}()
+
+	ticker := time.NewTicker(reconnectionPollInterval)
+	defer ticker.Stop()
+	deadline := time.After(h.handoffTTL)
+	for len(tokens) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+
+		pending, err := h.handoffs.Pending(ctx, tokens)
+		if err != nil {
+			h.logger.Warn("Failed to check handed-off clients", zap.Error(err))
+			continue
+		}
+		tokens = pending
+		h.awaitingReconnection.Store(int64(len(tokens)))
+		metrics.AwaitingReconnection.Set(float64(len(tokens)))
+	}
+}
+
+// handoffState returns the rooms of the connection with the cursor of the last message of each
+// written to the client.
+func (c *Connection) handoffState() redis.HandoffState {
+	c.roomsMu.RLock()
+	defer c.roomsMu.RUnlock()
+
+	state := redis.HandoffState{UserID: c.identity.UserID, Rooms: make([]redis.HandoffRoom, 0, len(c.rooms))}
+	for room := range c.rooms {
+		state.Rooms = append(state.Rooms, redis.HandoffRoom{Room: room, Cursor: c.cursors[room]})
+	}
+	return state
+}
+
+// wroteCursor records the history cursor of a room message written to the client. Broadcast
+// workers may queue a room's messages out of history order, so the latest cursor is kept.
+func (c *Connection) wroteCursor(room, cursor string) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	if _, ok := c.rooms[room]; !ok {
+		return
+	}
+	if last := c.cursors[room]; last == "" || redis.CursorBefore(last, cursor) {
+		c.cursors[room] = cursor
+	}
+}
+
+// resumeHandoff resumes the state a draining hub handed off under the token on a new connection of
+// the same user: it joins the connection to the rooms it may still subscribe to and replays the
+// history of each after the last message the previous connection wrote.
+func (h *MessageHandler) resumeHandoff(conn *Connection, token string) {
+	state, ok, err := h.handoffs.Take(h.ctx, token)
+	if err != nil {
+		h.logger.Error("Failed to resume handoff", zap.String("conn-id", conn.id), zap.Error(err))
+		return
+	}
+	if !ok || state.UserID != conn.identity.UserID {
+		metrics.Handoffs.WithLabelValues("unknown").Inc()
+		return
+	}
+
+	for _, handoff := range state.Rooms {
+		if !h.authorizeRoom(h.ctx, conn.identity, conn.id, handoff.Room, config.RoomSubscribe) {
+			continue
+		}
+		if !h.replayRoom(conn, handoff) {
+			continue
+		}
+		h.advertiseRoom(handoff.Room)
+		h.sendRoomState(h.ctx, conn, handoff.Room)
+	}
+	metrics.Handoffs.WithLabelValues("resumed").Inc()
+	h.logger.Info("Resumed handed-off connection", zap.String("conn-id", conn.id), zap.Int("rooms", len(state.Rooms)))
+}
+
+// replayRoom joins the connection to a handed-off room and queues the room's history after the
+// handoff cursor ahead of the live messages published meanwhile, which are held back until then,
+// when the client has the replay capability. It reports whether the connection joined the room.
+func (h *MessageHandler) replayRoom(conn *Connection, handoff redis.HandoffRoom) bool {
+	replay := conn.capabilities.has(capReplay) && handoff.Cursor != "" && h.history != nil && h.history.Enabled(handoff.Room)
+	if err := conn.joinHeld(handoff.Room, replay); err != nil {
+		h.logger.Warn("Skipping handed-off room", zap.String("conn-id", conn.id), zap.String("room", handoff.Room), zap.Error(err))
+		h.rejectJoin(conn, handoff.Room, err)
+		return false
+	}
+	if !replay {
+		return true
+	}
+
+	entries, err := h.history.Range(h.ctx, handoff.Room, handoff.Cursor, maxHistoryLimit)
+	if err != nil {
+		h.logger.Error("Failed to read handed-off room history", zap.String("conn-id", conn.id), zap.String("room", handoff.Room), zap.Error(err))
+	}
+	missed := make([]message.MessageDetails, 0, len(entries))
+	for _, entry := range entries {
+		md := entry.Message
+		md.Cursor = entry.Cursor
+		missed = append(missed, md)
+	}
+	conn.release(handoff.Room, missed)
+	return true
+}
+
+// release queues the missed messages of a held room followed by the messages held back while they
+// were read, skipping held messages the missed ones already cover.
+func (c *Connection) release(room string, missed []message.MessageDetails) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	held, ok := c.held[room]
+	if !ok {
+		return
+	}
+	delete(c.held, room)
+
+	var last string
+	for _, md := range missed {
+		c.enqueueLocked(md)
+		last = md.Cursor
+	}
+	for _, md := range held {
+		if last != "" && md.Cursor != "" && !redis.CursorBefore(last, md.Cursor) {
+			continue
+		}
+		c.enqueueLocked(md)
+	}
+}
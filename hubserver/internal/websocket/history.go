@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// historyMessage is a message returned by the history API, with the cursor to resume after it.
+type historyMessage struct {
+	Cursor string `json:"cursor"`
+	message.Frame
+}
+
+// historyResponse is the body returned by the history API. Next is the cursor of the last message
+// returned, to pass as after for the following page.
+type historyResponse struct {
+	Messages []historyMessage `json:"messages"`
+	Next     string           `json:"next,omitempty"`
+}
+
+// recordHistory appends messages published to rooms with history enabled and sets their cursor
+// before they are delivered, so clients can resume the history after the last message they saw.
+// Only the hub that received a message records it, so every message is kept once; the cursor
+// travels to the other hubs in the envelope.
+func (h *MessageHandler) recordHistory(ctx context.Context, md *message.MessageDetails) {
+	if h.history == nil || md.Room == "" || md.Ephemeral || md.IsFromPubSub(h.pubSubChannel) || !h.history.Enabled(md.Room) {
+		return
+	}
+
+	cursor, err := h.history.Append(ctx, md)
+	if err != nil {
+		h.logger.Error("Failed to record room history", zap.String("room", md.Room), zap.String("id", md.ID), zap.Error(err))
+		return
+	}
+	md.Cursor = cursor
+	h.touchRoom(md.Room)
+}
+
+// ServeRoomHistory serves GET /rooms/:room/messages?after=<cursor>&limit=N, returning the room's
+// retained messages after the cursor so late joiners can backfill what they missed.
+func (h *MessageHandler) ServeRoomHistory(w http.ResponseWriter, r *http.Request, room string) {
+	remoteIP, ok := h.admitRequest(w, r)
+	if !ok {
+		return
+	}
+	defer h.ipFilter.Release(remoteIP)
+
+	identity, err := h.authenticator.Authenticate(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if !h.authorizeRoom(r.Context(), identity, "", room, config.RoomSubscribe) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if h.history == nil || !h.history.Enabled(room) {
+		http.Error(w, "room has no history", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	after := query.Get("after")
+	if after != "" && !redis.ValidCursor(after) {
+		http.Error(w, "invalid after cursor", http.StatusBadRequest)
+		return
+	}
+
+	limit := int64(defaultHistoryLimit)
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxHistoryLimit)
+	}
+
+	entries, err := h.history.Range(r.Context(), room, after, limit)
+	if err != nil {
+		h.logger.Error("Failed to read room history", zap.String("room", room), zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := h.historyResponse(reader(r, identity), entries)
+	// Clients authenticate with a bearer token rather than cookies, so web apps on any origin may read the history.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Warn("Failed to write room history", zap.String("room", room), zap.Error(err))
+	}
+}
+
+// historyResponse returns the page of history entries as the subscriber receives them over its
+// connections. Next moves past messages a transform left out, so they are not read again.
+func (h *MessageHandler) historyResponse(sub Subscriber, entries []redis.HistoryEntry) historyResponse {
+	response := historyResponse{Messages: make([]historyMessage, 0, len(entries))}
+	for _, entry := range entries {
+		response.Next = entry.Cursor
+		if !h.transformRead(sub, &entry.Message) {
+			continue
+		}
+		response.Messages = append(response.Messages, historyMessage{Cursor: entry.Cursor, Frame: message.NewMessageFrame(&entry.Message)})
+	}
+	return response
+}
@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// reapIdleConnections periodically closes the connections whose client sent no message for the
+// idle timeout, such as those of abandoned browser tabs, which keep answering pings. They are closed
+// with CloseIdle, which clients take as a cue to reconnect on demand rather than right away.
+func (h *MessageHandler) reapIdleConnections(ctx context.Context) {
+	ticker := time.NewTicker(max(h.idleTimeout/4, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, conn := range h.idleConnections(time.Now().Add(-h.idleTimeout)) {
+			h.closeIdle(conn)
+		}
+	}
+}
+
+// idleConnections returns the authenticated connections whose client last sent a message before
+// the cutoff. Connections awaiting an auth frame are left to the auth grace period.
+func (h *MessageHandler) idleConnections(cutoff time.Time) []*Connection {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var idle []*Connection
+	for _, conn := range h.connections {
+		if conn.unauthenticated.Load() {
+			continue
+		}
+		if conn.lastActive.Load() < cutoff.UnixNano() {
+			idle = append(idle, conn)
+		}
+	}
+	return idle
+}
+
+// closeIdle closes an idle connection.
+func (h *MessageHandler) closeIdle(conn *Connection) {
+	if _, ok := h.detach(conn.id); !ok {
+		return
+	}
+
+	metrics.IdleConnectionsClosed.Inc()
+	idleFor := time.Since(time.Unix(0, conn.lastActive.Load()))
+	if err := conn.CloseWithReason(message.CloseIdle, h.closeReason(message.ReasonIdle)); err != nil {
+		conn.log().Warn("Failed to close idle connection", zap.Error(err))
+		return
+	}
+	conn.log().Info("Closed idle connection", zap.Duration("idle", idleFor.Round(time.Second)))
+}
@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync/atomic"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"go.uber.org/zap"
+)
+
+// keepaliveClassField names the token claim and, for connections whose token has none, the query
+// parameter choosing the connection's keepalive class.
+const keepaliveClassField = "keepalive_class"
+
+// keepaliveClasses holds the keepalive of every connection class. Connections look their class up
+// at each ping, so replacing the classes applies to established connections from their next ping.
+type keepaliveClasses struct {
+	defaults config.KeepaliveClass
+	classes  atomic.Pointer[map[string]config.KeepaliveClass]
+}
+
+func newKeepaliveClasses(defaults config.KeepaliveClass, classes map[string]config.KeepaliveClass) *keepaliveClasses {
+	k := &keepaliveClasses{defaults: defaults}
+	k.classes.Store(&classes)
+	return k
+}
+
+// lookup returns the keepalive of the class, or of the default class when it is not defined.
+func (k *keepaliveClasses) lookup(name string) config.KeepaliveClass {
+	classes := *k.classes.Load()
+	if class, ok := classes[name]; ok {
+		return class
+	}
+	return classes[config.DefaultKeepaliveClass]
+}
+
+// has reports whether the class is defined.
+func (k *keepaliveClasses) has(name string) bool {
+	_, ok := (*k.classes.Load())[name]
+	return ok
+}
+
+// keepaliveClass returns the keepalive class a connection request chose with its token's claim or,
+// without one, its query parameter. Unknown classes fall back to the default class.
+func (h *MessageHandler) keepaliveClass(r *http.Request, identity auth.Identity) string {
+	name, _ := identity.Claims[keepaliveClassField].(string)
+	if name == "" {
+		name = r.URL.Query().Get(keepaliveClassField)
+	}
+	if name == "" {
+		return config.DefaultKeepaliveClass
+	}
+	if !h.keepalive.has(name) {
+		h.logger.Warn("Unknown keepalive class, using the default", zap.String("class", name))
+		return config.DefaultKeepaliveClass
+	}
+	return name
+}
+
+// KeepaliveClasses returns the keepalive of every connection class as class=ping-interval:max-missed-pongs
+// specs, sorted by class.
+func (h *MessageHandler) KeepaliveClasses() []string {
+	classes := *h.keepalive.classes.Load()
+	specs := make([]string, 0, len(classes))
+	for name, class := range classes {
+		specs = append(specs, name+"="+class.String())
+	}
+	slices.Sort(specs)
+	return specs
+}
+
+// SetKeepaliveClasses replaces the connection classes with the given keepalive-classes specs.
+// Established connections pick up the keepalive of their class from their next ping; those of
+// classes no longer defined fall back to the default class, which reverts to ping-interval and
+// max-missed-pongs unless a spec overrides it.
+func (h *MessageHandler) SetKeepaliveClasses(specs []string) error {
+	classes, err := config.ParseKeepaliveClasses(specs, h.keepalive.defaults)
+	if err != nil {
+		return fmt.Errorf("invalid keepalive classes: %w", err)
+	}
+
+	h.keepalive.classes.Store(&classes)
+	h.logger.Info("Keepalive classes updated", zap.Strings("classes", h.KeepaliveClasses()))
+	return nil
+}
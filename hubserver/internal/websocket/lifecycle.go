@@ -0,0 +1,293 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/push"
+	"go.uber.org/zap"
+)
+
+// Types of room events.
+const (
+	// RoomCreated is emitted when the first subscriber of a room joins it on the hub
+	RoomCreated = "room.created"
+	// RoomDestroyed is emitted when a room was left without subscribers on the hub for the grace period
+	RoomDestroyed = "room.destroyed"
+)
+
+// maxRoomEvents is the number of recent room events the hub keeps for the admin API.
+const maxRoomEvents = 256
+
+// RoomEvent reports a room created or destroyed on the hub. Rooms live on each hub separately: a
+// room with subscribers on several hubs is created and destroyed on each of them.
+type RoomEvent struct {
+	ID    string    `json:"id"`
+	Type  string    `json:"type"`
+	Room  string    `json:"room"`
+	HubID string    `json:"hub_id"`
+	At    time.Time `json:"at"`
+}
+
+// RoomHook is called with every room event of the hub, in order, from a single goroutine. A slow
+// hook delays the events after it but never the connections.
+type RoomHook func(RoomEvent)
+
+// OnRoomEvent registers a hook called with the room events of the hub. Hooks must be registered
+// before the hub runs.
+func (h *MessageHandler) OnRoomEvent(hook RoomHook) {
+	h.roomHooks = append(h.roomHooks, hook)
+}
+
+// liveRoom is a room with subscribers on the hub, or in its grace period since emptySince.
+type liveRoom struct {
+	subscribers int
+	createdAt   time.Time
+	emptySince  time.Time
+	// epoch tells the grace timer of the room's last emptying apart from earlier ones that fired
+	// while the room was rejoined
+	epoch uint64
+	timer *time.Timer
+}
+
+// roomLifecycle counts the connections of the hub subscribed to each room, as userRooms does, and
+// emits the events of rooms created by their first subscriber and destroyed once they stayed
+// empty for the grace period.
+type roomLifecycle struct {
+	hubID string
+	grace time.Duration
+
+	mu    sync.Mutex
+	rooms map[string]*liveRoom
+	// pending holds the events not dispatched yet and recent the last maxRoomEvents dispatched;
+	// wake tells the dispatcher there are pending events
+	pending []RoomEvent
+	recent  []RoomEvent
+	wake    chan struct{}
+	// touched holds the rooms messages were recorded for since their expiry was last refreshed
+	touched map[string]struct{}
+}
+
+func newRoomLifecycle(hubID string, grace time.Duration) *roomLifecycle {
+	return &roomLifecycle{
+		hubID:   hubID,
+		grace:   grace,
+		rooms:   make(map[string]*liveRoom),
+		wake:    make(chan struct{}, 1),
+		touched: make(map[string]struct{}),
+	}
+}
+
+// joined counts a connection subscribed to the room, creating it unless it is in its grace period.
+func (l *roomLifecycle) joined(room string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.rooms[room]
+	if !ok {
+		now := time.Now()
+		r = &liveRoom{createdAt: now}
+		l.rooms[room] = r
+		l.emitLocked(RoomCreated, room, now)
+	}
+	r.subscribers++
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+		r.emptySince = time.Time{}
+	}
+}
+
+// left uncounts a connection subscribed to the room and destroys the room once the grace period
+// elapsed after its last subscriber left, unless it is rejoined in the meantime.
+func (l *roomLifecycle) left(room string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.rooms[room]
+	if !ok {
+		return
+	}
+	if r.subscribers--; r.subscribers > 0 {
+		return
+	}
+	now := time.Now()
+	if l.grace <= 0 {
+		delete(l.rooms, room)
+		l.emitLocked(RoomDestroyed, room, now)
+		return
+	}
+	r.emptySince = now
+	r.epoch++
+	epoch := r.epoch
+	r.timer = time.AfterFunc(l.grace, func() { l.expire(room, r, epoch) })
+}
+
+// expire destroys a room whose grace period elapsed, unless it was rejoined since.
+func (l *roomLifecycle) expire(room string, r *liveRoom, epoch uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rooms[room] != r || r.subscribers > 0 || r.epoch != epoch {
+		return
+	}
+	delete(l.rooms, room)
+	l.emitLocked(RoomDestroyed, room, time.Now())
+}
+
+// emitLocked queues an event for the dispatcher. l.mu must be held.
+func (l *roomLifecycle) emitLocked(eventType, room string, at time.Time) {
+	l.pending = append(l.pending, RoomEvent{ID: uuid.New().String(), Type: eventType, Room: room, HubID: l.hubID, At: at})
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run dispatches the events, in order, until ctx is done, so hooks and webhooks never run under
+// the locks of the hub's connections.
+func (l *roomLifecycle) run(ctx context.Context, dispatch func(RoomEvent)) {
+	for {
+		select {
+		case <-l.wake:
+		case <-ctx.Done():
+			return
+		}
+
+		l.mu.Lock()
+		events := l.pending
+		l.pending = nil
+		l.recent = append(l.recent, events...)
+		if extra := len(l.recent) - maxRoomEvents; extra > 0 {
+			l.recent = slices.Delete(l.recent, 0, extra)
+		}
+		l.mu.Unlock()
+
+		for _, event := range events {
+			dispatch(event)
+		}
+	}
+}
+
+// events returns the last room events dispatched, oldest first.
+func (l *roomLifecycle) events() []RoomEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.recent)
+}
+
+// infos describes the rooms of the hub, including those in their grace period, by name.
+func (l *roomLifecycle) infos() map[string]RoomInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	infos := make(map[string]RoomInfo, len(l.rooms))
+	for name, r := range l.rooms {
+		info := RoomInfo{Name: name, Subscribers: r.subscribers, CreatedAt: r.createdAt}
+		if r.timer != nil {
+			emptySince, destroyAt := r.emptySince, r.emptySince.Add(l.grace)
+			info.EmptySince, info.DestroyAt = &emptySince, &destroyAt
+		}
+		infos[name] = info
+	}
+	return infos
+}
+
+// touch records that a message was recorded for the room, keeping its keys from expiring.
+func (l *roomLifecycle) touch(room string) {
+	l.mu.Lock()
+	l.touched[room] = struct{}{}
+	l.mu.Unlock()
+}
+
+// touchRoom keeps the keys of a room a message was recorded for from expiring, when rooms expire.
+func (h *MessageHandler) touchRoom(room string) {
+	if h.roomExpiry != nil {
+		h.lifecycle.touch(room)
+	}
+}
+
+// used returns the rooms of the hub, including those in their grace period, and the rooms touched
+// since the last call.
+func (l *roomLifecycle) used() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rooms := make([]string, 0, len(l.rooms)+len(l.touched))
+	for room := range l.rooms {
+		rooms = append(rooms, room)
+	}
+	for room := range l.touched {
+		if _, ok := l.rooms[room]; !ok {
+			rooms = append(rooms, room)
+		}
+	}
+	clear(l.touched)
+	return rooms
+}
+
+// dispatchRoomEvent calls the room hooks with an event and delivers it to the room events webhook.
+func (h *MessageHandler) dispatchRoomEvent(event RoomEvent) {
+	for _, hook := range h.roomHooks {
+		hook(event)
+	}
+	if event.Type == RoomCreated {
+		h.refreshRoomExpiry(h.ctx, []string{event.Room})
+	}
+	if h.roomWebhook == nil {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		h.logger.Error("Failed to encode room event", zap.String("room", event.Room), zap.Error(err))
+		return
+	}
+	h.push.Deliver(*h.roomWebhook, event.ID, body)
+}
+
+// refreshRoomExpiry extends the expiry of the keys of the rooms keeping history or state.
+func (h *MessageHandler) refreshRoomExpiry(ctx context.Context, rooms []string) {
+	if h.roomExpiry == nil {
+		return
+	}
+
+	rooms = slices.DeleteFunc(rooms, func(room string) bool {
+		return !(h.history != nil && h.history.Enabled(room)) && !(h.state != nil && h.state.Enabled(room))
+	})
+	if err := h.roomExpiry.Refresh(ctx, rooms); err != nil {
+		h.logger.Error("Failed to refresh the expiry of rooms", zap.Error(err))
+	}
+}
+
+// expireRooms refreshes the expiry of the rooms used on the hub three times per room TTL until ctx
+// is done, so the keys of rooms expire only once no hub used them for the TTL.
+func (h *MessageHandler) expireRooms(ctx context.Context) {
+	ticker := time.NewTicker(h.roomExpiry.TTL() / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.refreshRoomExpiry(ctx, h.lifecycle.used())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// newRoomWebhook returns the subscription of the room events webhook, nil when the URL is empty.
+func newRoomWebhook(callbackURL, secret string) (*push.Subscription, error) {
+	if callbackURL == "" {
+		return nil, nil
+	}
+	sub, err := push.NewWebhook(callbackURL, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
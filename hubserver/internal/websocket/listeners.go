@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"context"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// listener receives the messages of a room inside the hub's process, alongside its connections.
+type listener struct {
+	room string
+	ch   chan message.MessageDetails
+}
+
+// Publish broadcasts a message published from inside the hub's process to the hub's connections and
+// the other hubs, waiting for room on the broadcast queue until ctx is done or the handler is closed.
+func (h *MessageHandler) Publish(ctx context.Context, md message.MessageDetails) error {
+	select {
+	case <-h.stopped:
+		return errHandlerClosed
+	default:
+	}
+
+	select {
+	case h.broadcastCh <- md:
+		return nil
+	case <-h.stopped:
+		return errHandlerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Subscribe returns a channel receiving the messages broadcast to a room, or to every room when room
+// is empty, and a function ending the subscription, which closes the channel. Messages that do not
+// fit in the channel's buffer are dropped, as they would be for a slow connection.
+func (h *MessageHandler) Subscribe(room string, buffer int) (<-chan message.MessageDetails, func()) {
+	l := &listener{room: room, ch: make(chan message.MessageDetails, buffer)}
+
+	h.listenersMu.Lock()
+	h.listeners[l] = struct{}{}
+	h.listenersMu.Unlock()
+
+	unsubscribe := func() {
+		h.listenersMu.Lock()
+		defer h.listenersMu.Unlock()
+
+		if _, ok := h.listeners[l]; ok {
+			delete(h.listeners, l)
+			close(l.ch)
+		}
+	}
+	return l.ch, unsubscribe
+}
+
+// notifyListeners queues a broadcast message for the listeners of its room.
+func (h *MessageHandler) notifyListeners(md message.MessageDetails) {
+	h.listenersMu.RLock()
+	defer h.listenersMu.RUnlock()
+
+	for l := range h.listeners {
+		if l.room != "" && l.room != md.Room {
+			continue
+		}
+
+		select {
+		case l.ch <- md:
+		default:
+			h.logger.Warn("Listener channel is full, dropping message", zap.String("room", md.Room), zap.String("id", md.ID))
+		}
+	}
+}
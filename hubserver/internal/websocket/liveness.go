@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+)
+
+// retryHijacker hands the upgrader a connection whose writes survive transient write timeouts.
+type retryHijacker struct {
+	http.ResponseWriter
+	timeout time.Duration
+	retries int
+}
+
+func (h *retryHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &retryConn{Conn: conn, timeout: h.timeout, retries: h.retries}, brw, nil
+}
+
+// retryConn resumes writes interrupted by their deadline, extending it with exponential backoff up
+// to retries times, so a connection stalled briefly on a flaky mobile network is not torn down.
+// The WebSocket library treats every write error as fatal, so the retry has to happen beneath it.
+type retryConn struct {
+	net.Conn
+	timeout time.Duration
+	retries int
+}
+
+func (c *retryConn) Write(p []byte) (int, error) {
+	written := 0
+	backoff := c.timeout
+	for attempt := 0; ; attempt++ {
+		n, err := c.Conn.Write(p[written:])
+		written += n
+
+		var netErr net.Error
+		if err == nil || attempt >= c.retries || !errors.As(err, &netErr) || !netErr.Timeout() {
+			return written, err
+		}
+
+		metrics.WriteRetries.Inc()
+		backoff *= 2
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(backoff)); err != nil {
+			return written, err
+		}
+	}
+}
+
+// keepaliveSettings returns the current keepalive of the connection's class.
+func (c *Connection) keepaliveSettings() config.KeepaliveClass {
+	return c.keepalive.lookup(c.keepaliveClass)
+}
+
+// nextPing returns the delay until the connection is pinged again, jittered by up to 10% either way
+// so connections opened together don't ping in lockstep.
+func (c *Connection) nextPing() time.Duration {
+	interval := c.keepaliveSettings().PingInterval
+	spread := interval / 5
+	if spread <= 0 {
+		return interval
+	}
+	return interval - spread/2 + rand.N(spread)
+}
+
+// pongWait returns how long the connection may stay silent before reads time out. It outlasts
+// the class's max missed pongs unanswered pings, so half-open connections are normally detected by
+// the write pump first.
+func (c *Connection) pongWait() time.Duration {
+	keepalive := c.keepaliveSettings()
+	return time.Duration(keepalive.MaxMissedPongs+1)*keepalive.PingInterval*11/10 + c.writeTimeout
+}
+
+// halfOpen records a ping about to be sent and reports whether too many earlier pings went
+// unanswered, meaning the client is gone without the TCP connection having been closed.
+func (c *Connection) halfOpen() bool {
+	if int(c.missedPongs.Add(1)) > c.keepaliveSettings().MaxMissedPongs {
+		metrics.HalfOpenConnections.Inc()
+		return true
+	}
+	return false
+}
@@ -2,106 +2,1072 @@ package websocket
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"net/http"
+	"net/netip"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/chaos"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/clock"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/ipfilter"
 	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/push"
 	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
 	"go.uber.org/zap"
 )
 
+// subscriptionCloseTimeout bounds the wait for the broker subscription to end once the broker is closed.
+const subscriptionCloseTimeout = 5 * time.Second
+
+// errHandlerClosed is returned for connections whose upgrade completes after the handler was
+// closed, and for messages published once it stopped broadcasting.
+var errHandlerClosed = errors.New("message handler is closed")
+
+// errDraining is returned for connection attempts made while the hub is draining.
+var errDraining = errors.New("hub is draining")
+
 // MessageHandler manages all active WebSocket connections and message broadcasting.
 type MessageHandler struct {
-	connections      map[string]*Connection
-	mu               sync.RWMutex
-	broadcastCh      chan message.MessageDetails
-	remove           chan string
-	redisPubSub      *redis.PubSub
-	pubSubChannel    string
-	hubID            string
-	broadcastWorkers int
-	logger           *zap.Logger
+	connections        map[string]*Connection
+	mu                 sync.RWMutex
+	broadcastCh        chan message.MessageDetails
+	remove             chan string
+	broker             Broker
+	pubSubChannel      string
+	hubID              string
+	zone               string
+	broadcastWorkers   int
+	broadcastBatchSize int
+	fair               *fairQueue
+	deliveryReceipts   bool
+	ephemeralTTL       time.Duration
+	chunkSize          int
+	readBufferSize     int
+	writeBufferSize    int
+	queueMemory        *queueMemory
+	maxChunkedSize     int
+	writeTimeout       time.Duration
+	writeRetries       int
+	keepalive          *keepaliveClasses
+	retryAfter         time.Duration
+	alternateHub       string
+	ipFilter           *ipfilter.Filter
+	upgrades           *upgradeLimiter
+	upgrader           websocket.Upgrader
+	upgradeHeader      http.Header
+	maxRooms           int
+	userRooms          *userRooms
+	authenticator      *auth.Authenticator
+	authGracePeriod    time.Duration
+	idleTimeout        time.Duration
+	protocol           protocolPolicy
+	sessions           *redis.SessionRegistry
+	userLimiter        keyLimiter
+	authorizer         Authorizer
+	roomAuthorizer     RoomAuthorizer
+	replay             *replayGuard
+	rpc                *rpcCalls
+	bandwidth          *bandwidthMeter
+	clock              clock.Clock
+	hlc                *clock.HLC
+	hlcMaxDrift        time.Duration
+	scheduler          *redis.Scheduler
+	history            *redis.History
+	state              *redis.RoomState
+	sequences          *roomSequencer
+	zones              *redis.ZoneDirectory
+	routes             *redis.RouteTable
+	deadLetters        *redis.DeadLetters
+	redisPubSubs       []*redis.PubSub
+	peers              *peerHealth
+	heartbeatInterval  time.Duration
+	payloads           *payloadGuard
+	aggregations       map[string]*aggregation
+	routing            atomic.Pointer[[]config.RoutingRule]
+	clientConfig       atomic.Pointer[json.RawMessage]
+	groups             connectionGroups
+	statePatches       bool
+	tracePipeline      bool
+	autoJoin           []config.RoomTemplate
+	handoffs           *redis.Handoffs
+	handoffTTL         time.Duration
+	spill              *spillover
+	scheduleInterval   time.Duration
+	transforms         []Transform
+	enrichers          []Enricher
+	push               *push.Dispatcher
+	lifecycle          *roomLifecycle
+	roomHooks          []RoomHook
+	roomWebhook        *push.Subscription
+	roomExpiry         *redis.RoomExpiry
+	roomMetrics        *roomMetrics
+	listeners          map[*listener]struct{}
+	listenersMu        sync.RWMutex
+	publishResults     map[string]chan PublishResult
+	publishResultsMu   sync.Mutex
+	chaos              *chaos.Injector
+	logger             *zap.Logger
+
+	// ctx is cancelled when the handler is closed to stop its background loops
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// lifecycleMu orders Run starting the broadcast workers and the broker subscription against
+	// Close waiting for them
+	lifecycleMu sync.Mutex
+	started     bool
+	closed      bool
+	closeOnce   sync.Once
+	closeErr    error
+	// workers counts the running broadcast workers, which drain the broadcast queue and return once
+	// stopBroadcast is closed; stopped is closed after they returned, releasing the producers still
+	// waiting for room in the queue
+	workers       sync.WaitGroup
+	stopBroadcast chan struct{}
+	stopped       chan struct{}
+	// subscribed is closed once the broker subscription, whose context stopSubscribe cancels, returned
+	subscribed    chan struct{}
+	stopSubscribe context.CancelFunc
+
+	messagesProcessed atomic.Uint64
+
+	// draining is set once the hub was asked to drain and stops accepting connections
+	draining atomic.Bool
+	// awaitingReconnection is the number of clients handed off before the hub restarted that are yet
+	// to reconnect
+	awaitingReconnection atomic.Int64
 }
 
-func NewMessageHandler(redisClient *redis.Client, pubSubChannel, hubID string, broadcastWorkers int, logger *zap.Logger) (*MessageHandler, error) {
-	broadcastCh := make(chan message.MessageDetails, 1024) // Increased buffer size to handle bursts
+func NewMessageHandler(broker Broker, redisClient *redis.Client, cfg *config.Config, logger *zap.Logger) (*MessageHandler, error) {
+	broadcastCh := make(chan message.MessageDetails, cfg.BroadcastBufferSize)
 
+	ipFilter, err := ipfilter.NewFilter(cfg.IPAllowlistFile, cfg.IPDenylistFile, cfg.TrustedProxies, cfg.MaxConnectionsPerIP, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IP filter: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	handler := &MessageHandler{
-		connections:      make(map[string]*Connection),
-		broadcastCh:      broadcastCh,
-		remove:           make(chan string, 256),
-		redisPubSub:      redis.NewPubSub(redisClient, pubSubChannel, hubID, broadcastCh, logger),
-		pubSubChannel:    pubSubChannel,
-		hubID:            hubID,
-		broadcastWorkers: broadcastWorkers,
-		logger:           logger,
+		connections:        make(map[string]*Connection),
+		listeners:          make(map[*listener]struct{}),
+		publishResults:     make(map[string]chan PublishResult),
+		broadcastCh:        broadcastCh,
+		remove:             make(chan string, cfg.RemoveBufferSize),
+		broker:             broker,
+		pubSubChannel:      cfg.PubSubChannelName,
+		hubID:              cfg.HubName,
+		zone:               cfg.Zone,
+		broadcastWorkers:   cfg.BroadcastWorkers,
+		broadcastBatchSize: cfg.BroadcastBatchSize,
+		fair:               newFairQueue(cfg.FairBroadcast, cfg.BroadcastBufferSize),
+		deliveryReceipts:   cfg.DeliveryReceipts,
+		ephemeralTTL:       cfg.EphemeralTTL,
+		chunkSize:          cfg.ChunkSize,
+		readBufferSize:     cfg.ReadBufferSize,
+		writeBufferSize:    cfg.WriteBufferSize,
+		queueMemory:        newQueueMemory(cfg.MaxQueuedBytes),
+		maxChunkedSize:     cfg.MaxChunkedMessageSize,
+		writeTimeout:       cfg.WriteTimeout,
+		writeRetries:       cfg.WriteRetries,
+		retryAfter:         cfg.ReconnectRetryAfter,
+		upgrades:           newUpgradeLimiter(cfg.UpgradeRate, cfg.UpgradeBurst, cfg.UpgradeRatePerIP, cfg.UpgradeBurstPerIP),
+		alternateHub:       cfg.ReconnectAlternateHub,
+		ipFilter:           ipFilter,
+		authenticator:      auth.NewAuthenticator(cfg.AuthJWTSecret, cfg.AuthURLSigningSecret, cfg.AuthURLMaxTTL, cfg.AuthRequired),
+		authGracePeriod:    cfg.AuthGracePeriod,
+		idleTimeout:        cfg.IdleTimeout,
+		rpc:                newRPCCalls(cfg.RPCTimeout, cfg.RPCMaxPending, newMemoryNonces()),
+		clock:              clock.System,
+		hlcMaxDrift:        cfg.HLCMaxDrift,
+		scheduleInterval:   cfg.ScheduleInterval,
+		roomMetrics:        newRoomMetrics(cfg.RoomMetrics, cfg.RoomMetricsLimit),
+		tracePipeline:      cfg.TracePipeline,
+		upgrader:           newUpgrader(cfg),
+		maxRooms:           cfg.MaxRoomsPerConnection,
+		userRooms:          newUserRooms(cfg.MaxRoomsPerUser),
+		lifecycle:          newRoomLifecycle(cfg.HubName, cfg.RoomGracePeriod),
+		logger:             logger,
+		ctx:                ctx,
+		cancel:             cancel,
+		stopBroadcast:      make(chan struct{}),
+		stopped:            make(chan struct{}),
+		subscribed:         make(chan struct{}),
+	}
+
+	keepalive, err := cfg.KeepaliveClasses()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	handler.keepalive = newKeepaliveClasses(config.KeepaliveClass{PingInterval: cfg.PingInterval, MaxMissedPongs: cfg.MaxMissedPongs}, keepalive)
+
+	routing, err := cfg.RoutingRules()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	handler.routing.Store(&routing)
+
+	if handler.upgradeHeader, err = cfg.UpgradeHeaders(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if len(cfg.RedactFields) > 0 {
+		rules, err := ParseRedactionRules(cfg.RedactFields)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		handler.AddTransform(RedactFields(rules))
+	}
+
+	for _, enricher := range cfg.Enrichers {
+		switch enricher {
+		case config.EnricherHub:
+			handler.AddEnricher(AnnotateHub(cfg.HubName))
+		case config.EnricherGeo:
+			handler.AddEnricher(AnnotateGeo(cfg.GeoHeader))
+		case config.EnricherDisplayName:
+			handler.AddEnricher(AnnotateDisplayName(redis.NewDisplayNames(redisClient, cfg.DisplayNamesKey).Get, cfg.DisplayNameCacheTTL))
+		}
+	}
+
+	if cfg.DuplicateConnectionPolicy != config.PolicyAllowMultiple {
+		handler.sessions = redis.NewSessionRegistry(redisClient, cfg.HubName, cfg.DuplicateConnectionPolicy, cfg.MaxConnectionsPerUser, logger)
+	}
+
+	if len(cfg.RoomACLs) > 0 || cfg.RoomACLsFromRedis {
+		acls, err := cfg.RoomAccess()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		authorizer := &aclAuthorizer{acls: acls}
+		if cfg.RoomACLsFromRedis {
+			authorizer.store = redis.NewRoomACLStore(redisClient, cfg.RoomACLCacheTTL, logger)
+		}
+		handler.roomAuthorizer = authorizer
+	}
+
+	if cfg.UserMessageRate > 0 {
+		handler.userLimiter = newMemoryLimiter(cfg.UserMessageRate, cfg.UserMessageBurst)
+		if redisClient != nil {
+			handler.userLimiter = redis.NewRateLimiter(redisClient, cfg.UserMessageRate, cfg.UserMessageBurst)
+		}
+	}
+
+	if redisClient != nil {
+		handler.rpc.claims = redis.NewNonceStore(redisClient, logger)
+	}
+
+	cutoff, err := cfg.ProtocolCutoffTime()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	handler.protocol = protocolPolicy{min: cfg.MinProtocolVersion, deprecateBelow: cfg.DeprecateProtocolBelow, cutoff: cutoff}
+
+	if cfg.HLCTimestamps {
+		handler.hlc = clock.NewHLC(handler.clock, cfg.HLCMaxDrift)
+	}
+
+	handler.bandwidth = newBandwidthMeter(cfg, newMemoryUsage())
+	if redisClient != nil {
+		handler.bandwidth.counter = redis.NewBandwidthUsage(redisClient)
+	}
+
+	if len(cfg.ReplayProtectedRooms) > 0 {
+		handler.replay = &replayGuard{
+			secret: []byte(cfg.PublishSigningSecret),
+			window: cfg.ReplayWindow,
+			rooms:  cfg.ReplayProtectedRooms,
+			nonces: newMemoryNonces(),
+		}
+		if redisClient != nil {
+			handler.replay.nonces = redis.NewNonceStore(redisClient, logger)
+		}
+	}
+
+	if len(cfg.RoomPayloadPolicy) > 0 {
+		policies, err := cfg.RoomPayloadPolicies()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		handler.payloads = &payloadGuard{policies: policies}
+	}
+
+	if len(cfg.AggregateRoom) > 0 {
+		aggregations, err := cfg.AggregateRooms()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		for room, aggregation := range aggregations {
+			handler.AggregateRoom(room, aggregation.Window, reducers[aggregation.Reducer])
+		}
+	}
+
+	if len(cfg.RoomHistory) > 0 {
+		policies, err := cfg.RoomRetention()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		handler.history = redis.NewHistory(redisClient, policies, logger)
+	}
+
+	if cfg.MTLSAddr != "" {
+		roles, err := cfg.MTLSUserRoles()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		handler.authenticator.TrustClientCertificates(certificateUser(cfg.MTLSUserField), roles)
+	}
+
+	if cfg.DrainHandoffTTL > 0 && redisClient != nil {
+		handler.handoffs = redis.NewHandoffs(redisClient)
+		handler.handoffTTL = cfg.DrainHandoffTTL
+	}
+
+	if len(cfg.AutoJoinRoom) > 0 {
+		if handler.autoJoin, err = cfg.AutoJoinRooms(); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	if len(cfg.StateRooms) > 0 {
+		handler.state = redis.NewRoomState(redisClient, cfg.StateRooms, logger)
+		handler.statePatches = cfg.StatePatches
+	}
+
+	if len(cfg.SequencedRoom) > 0 {
+		issuers, err := cfg.SequencedRooms()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		handler.sequences = &roomSequencer{issuers: issuers, local: make(map[string]uint64)}
+		if redisClient != nil {
+			handler.sequences.redis = redis.NewRoomSequences(redisClient)
+		}
+	}
+
+	if cfg.SpillDir != "" {
+		if handler.spill, err = newSpillover(cfg.SpillDir, cfg.SpillSegmentSize, cfg.SpillMaxSize); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	if cfg.ScheduleInterval > 0 {
+		handler.scheduler = redis.NewScheduler(redisClient, cfg.ScheduleKey, logger)
+	}
+
+	if cfg.RedisShards > 1 {
+		if ps, ok := broker.(*redis.PubSub); ok {
+			ps.ShardRooms(cfg.RedisShards)
+		}
+	}
+
+	if cfg.ZoneAwareRouting {
+		handler.zones = redis.NewZoneDirectory(redisClient, cfg.Zone, cfg.ZoneRefresh, logger)
+		if ps, ok := broker.(*redis.PubSub); ok {
+			ps.RouteByZone(handler.zones)
+		}
+	}
+
+	if cfg.TargetedRouting {
+		handler.routes = redis.NewRouteTable(redisClient, cfg.HubName)
+		if ps, ok := broker.(*redis.PubSub); ok {
+			ps.RouteTargets(handler.routes)
+		}
+	}
+
+	handler.push = push.NewDispatcher(push.Options{
+		Workers:     cfg.PushWorkers,
+		QueueSize:   cfg.PushQueueSize,
+		Timeout:     cfg.PushTimeout,
+		MaxAttempts: cfg.PushMaxAttempts,
+		Backoff:     cfg.PushBackoff,
+		MaxBackoff:  cfg.PushMaxBackoff,
+	}, logger)
+
+	if handler.roomWebhook, err = newRoomWebhook(cfg.RoomEventsURL, cfg.RoomEventsSecret); err != nil {
+		cancel()
+		return nil, err
+	}
+	if cfg.RoomTTL > 0 && redisClient != nil && (handler.history != nil || handler.state != nil) {
+		handler.roomExpiry = redis.NewRoomExpiry(redisClient, cfg.RoomTTL)
+	}
+
+	if cfg.DeadLetterStream != "" && redisClient != nil {
+		handler.deadLetters = redis.NewDeadLetters(redisClient, cfg.DeadLetterStream, cfg.DeadLetterMaxLen, cfg.HubName)
+		if ps, ok := broker.(*redis.PubSub); ok {
+			ps.DeadLetterTo(handler.deadLetters)
+		}
+	}
+
+	// Mirrored envelopes are signed like the others, so the passive cluster can verify them
+	if ps, ok := broker.(*redis.PubSub); ok {
+		handler.redisPubSubs = append(handler.redisPubSubs, ps)
+	}
+	if cfg.MirrorRedisAddr != "" {
+		mirror := newMirrorBroker(handler.broker, cfg, logger)
+		if ps, ok := mirror.mirror.(*redis.PubSub); ok {
+			handler.redisPubSubs = append(handler.redisPubSubs, ps)
+		}
+		handler.broker = mirror
+	}
+
+	if cfg.PeerHeartbeatInterval > 0 {
+		handler.peers = &peerHealth{peers: make(map[string]*peerState)}
+		handler.heartbeatInterval = cfg.PeerHeartbeatInterval
+	}
+
+	if provider := envelopeKeys(cfg); provider != nil {
+		if err := handler.EncryptEnvelopes(provider, cfg.EnvelopeKeysRefresh, cfg.EnvelopeAcceptPlaintext); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	if len(cfg.EnvelopeSigningSecrets) > 0 {
+		handler.broker = newSigningBroker(handler.broker, cfg.EnvelopeSigningSecrets, handler.deadLetters, logger)
+	}
+
+	faults := chaos.Faults{
+		PublishDelay:       cfg.ChaosPublishDelay,
+		PublishDropRate:    cfg.ChaosPublishDropRate,
+		WriteStall:         cfg.ChaosWriteStall,
+		WriteStallRate:     cfg.ChaosWriteStallRate,
+		DisconnectRate:     cfg.ChaosDisconnectRate,
+		DisconnectInterval: cfg.ChaosDisconnectInterval,
+	}
+	if faults.Enabled() {
+		logger.Warn("Fault injection is enabled", zap.Any("faults", faults))
+		handler.chaos = chaos.NewInjector(faults, logger)
+		handler.broker = &chaosBroker{Broker: handler.broker, chaos: handler.chaos}
 	}
 
 	return handler, nil
 }
 
+// upgradeFunc upgrades a connection request the hub admitted to a connection of the identity, as
+// Upgrade does for WebSocket connections.
+type upgradeFunc func(w http.ResponseWriter, r *http.Request, h *MessageHandler, id string, remoteIP netip.Addr, identity auth.Identity, quota Quota, keepaliveClass string, header http.Header) (*Connection, error)
+
 // ServeHTTP handles HTTP requests and upgrades them to WebSocket connections.
 func (h *MessageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	conn, err := h.createAndAddConnection(w, r)
+	h.serve(w, r, Upgrade)
+}
+
+// serve admits, authenticates and authorizes a connection request, upgrades it with upgrade and
+// serves the connection until it is closed.
+func (h *MessageHandler) serve(w http.ResponseWriter, r *http.Request, upgrade upgradeFunc) {
+	if h.draining.Load() {
+		h.reject(w, r, errDraining)
+		return
+	}
+	if err := h.checkProtocol(w, r); err != nil {
+		h.reject(w, r, err)
+		return
+	}
+
+	remoteIP, err := h.admit(r)
 	if err != nil {
+		h.reject(w, r, err)
+		return
+	}
+
+	identity, err := h.authenticator.Authenticate(r)
+	if err != nil {
+		if errors.Is(err, auth.ErrMissingToken) && h.acceptsAuthFrame(r) {
+			h.serveUnauthenticated(w, r, remoteIP, upgrade)
+			return
+		}
+		h.ipFilter.Release(remoteIP)
+		h.reject(w, r, err)
+		return
+	}
+
+	grant, err := h.authorize(r, identity)
+	if err != nil {
+		h.ipFilter.Release(remoteIP)
+		h.reject(w, r, err)
+		return
+	}
+
+	connID := uuid.New().String()
+	evicted, err := h.registerSession(r.Context(), identity, connID)
+	if err != nil {
+		h.ipFilter.Release(remoteIP)
+		h.reject(w, r, err)
+		return
+	}
+
+	conn, err := h.createAndAddConnection(w, r, upgrade, connID, remoteIP, identity, grant)
+	if err != nil {
+		h.ipFilter.Release(remoteIP)
+		h.unregisterSession(identity, connID)
 		h.logger.Error("Failed to create and add connection", zap.Error(err))
 		return
 	}
-	go h.handleIncomingMessages(conn)
+
+	h.evictSessions(h.ctx, evicted)
+	if token := h.handoffToken(r); token != "" {
+		go h.resumeHandoff(conn, token)
+	}
+	go h.serveConnection(conn)
+	conn.waitStream()
+}
+
+// serveConnection handles the messages read from a connection until its read channel is closed,
+// then removes it.
+func (h *MessageHandler) serveConnection(conn *Connection) {
+	defer h.requestRemoval(conn.id)
+	supervise("ingest", pumpRestarts, conn.log(), func() {
+		h.handleIncomingMessages(conn)
+	})
+}
+
+// ReloadIPFilter re-reads the IP allow and deny lists.
+func (h *MessageHandler) ReloadIPFilter() error {
+	return h.ipFilter.Reload()
+}
+
+// admit checks the client address against the IP filter and reserves a connection slot for it.
+func (h *MessageHandler) admit(r *http.Request) (netip.Addr, error) {
+	remoteIP, err := h.ipFilter.ClientAddr(r)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	if err := h.upgrades.allow(remoteIP); err != nil {
+		return netip.Addr{}, err
+	}
+	if err := h.ipFilter.Acquire(remoteIP); err != nil {
+		return netip.Addr{}, err
+	}
+	return remoteIP, nil
 }
 
-// createAndAddConnection adds a new WebSocket connection to the map and starts handling its messages.
-func (h *MessageHandler) createAndAddConnection(w http.ResponseWriter, r *http.Request) (*Connection, error) {
-	conn, err := Upgrade(w, r, h)
+// admitRequest checks the client address of an HTTP API request against the IP filter and reserves
+// a connection slot for it while the request is served, as for WebSocket connections. It writes the
+// rejection and reports false when the request is not admitted; admitted addresses are released
+// once the request is served.
+func (h *MessageHandler) admitRequest(w http.ResponseWriter, r *http.Request) (netip.Addr, bool) {
+	remoteIP, err := h.ipFilter.ClientAddr(r)
+	if err == nil {
+		err = h.ipFilter.Acquire(remoteIP)
+	}
+	if err != nil {
+		h.reject(w, r, err)
+		return netip.Addr{}, false
+	}
+	return remoteIP, true
+}
+
+// reject writes an HTTP error for a connection attempt that failed admission and records the rejection.
+func (h *MessageHandler) reject(w http.ResponseWriter, r *http.Request, err error) {
+	reason, status := rejectReason(err)
+	metrics.ConnectionsRejected.WithLabelValues(reason).Inc()
+	h.logger.Warn("Rejected connection attempt", zap.String("remote-addr", r.RemoteAddr), zap.String("reason", reason), zap.Error(err))
+	if errors.Is(err, errUpgradeRateLimited) || errors.Is(err, errIPUpgradeRateLimited) {
+		h.setRetryAfter(w)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// rejectReason returns the metric reason and HTTP status of a connection attempt that failed admission.
+func rejectReason(err error) (string, int) {
+	reason, status := "invalid_address", http.StatusBadRequest
+	switch {
+	case errors.Is(err, ipfilter.ErrDenied):
+		reason, status = "denylisted", http.StatusForbidden
+	case errors.Is(err, ipfilter.ErrNotAllowed):
+		reason, status = "not_allowlisted", http.StatusForbidden
+	case errors.Is(err, ipfilter.ErrLimitExceeded):
+		reason, status = "ip_limit", http.StatusTooManyRequests
+	case errors.Is(err, errUpgradeRateLimited):
+		reason, status = "upgrade_rate_limited", http.StatusTooManyRequests
+	case errors.Is(err, errIPUpgradeRateLimited):
+		reason, status = "ip_upgrade_rate_limited", http.StatusTooManyRequests
+	case errors.Is(err, auth.ErrMissingToken), errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrInvalidCertificate):
+		reason, status = "unauthorized", http.StatusUnauthorized
+	case errors.Is(err, errDuplicateSession):
+		reason, status = "duplicate_session", http.StatusConflict
+	case errors.Is(err, errSessionRegistry):
+		reason, status = "session_registry_unavailable", http.StatusServiceUnavailable
+	case errors.Is(err, errForbidden):
+		reason, status = "forbidden", http.StatusForbidden
+	case errors.Is(err, errAuthorizerUnavailable):
+		reason, status = "authorizer_unavailable", http.StatusServiceUnavailable
+	case errors.Is(err, errDraining):
+		reason, status = "draining", http.StatusServiceUnavailable
+	case errors.Is(err, errUnsupportedProtocol):
+		reason, status = "unsupported_protocol", http.StatusUpgradeRequired
+	}
+	return reason, status
+}
+
+// createAndAddConnection adds a new connection upgraded with upgrade to the map, subscribed to the
+// rooms it was granted, and starts handling its messages.
+func (h *MessageHandler) createAndAddConnection(w http.ResponseWriter, r *http.Request, upgrade upgradeFunc, connID string, remoteIP netip.Addr, identity auth.Identity, grant Authorization) (*Connection, error) {
+	conn, err := upgrade(w, r, h, connID, remoteIP, identity, grant.Quota, h.keepaliveClass(r, identity), grant.responseHeader())
 	if err != nil {
 		return nil, fmt.Errorf("error creating websocket connection: %w", err)
 	}
+	conn.protocolVersion, _ = requestedProtocol(r)
+	h.joinGroups(conn, grant.Groups)
+	if err := h.addConnection(conn, identity, grant.Rooms); err != nil {
+		return nil, err
+	}
+	h.addRoute(conn.id)
+	h.warnDeprecatedProtocol(conn)
+	h.sendClientConfig(conn)
+	return conn, nil
+}
+
+// Attach serves a WebSocket connection established and authenticated outside the handler, such
+// as a hubtest.Conn, as the given identity subscribed to rooms. It skips admission, authorization
+// and session registration, which apply to upgrade requests only.
+func (h *MessageHandler) Attach(ws Conn, identity auth.Identity, rooms []string) (*Connection, error) {
+	conn := newConnection(h, uuid.New().String(), ws, Quota{}, "")
+	conn.identity = identity
+	conn.setLogContext(h)
+	conn.start(h)
+	if err := h.addConnection(conn, identity, rooms); err != nil {
+		return nil, err
+	}
+	h.addRoute(conn.id)
+	h.sendClientConfig(conn)
+	go h.serveConnection(conn)
+	return conn, nil
+}
+
+// addConnection adds a started connection to the map as the given identity, subscribed to rooms.
+func (h *MessageHandler) addConnection(conn *Connection, identity auth.Identity, rooms []string) error {
+	conn.identity = identity
+	h.joinInitialRooms(conn, rooms)
 
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	if h.connections == nil {
+		_ = conn.CloseWithReason(message.CloseShutdown, h.closeReason(message.ReasonShutdown))
+		return errHandlerClosed
+	}
 	if _, exists := h.connections[conn.id]; exists {
-		return nil, fmt.Errorf("connection already registered")
+		_ = conn.Close()
+		return fmt.Errorf("connection already registered")
 	}
 
 	h.connections[conn.id] = conn
-	return conn, nil
+	return nil
+}
+
+// joinInitialRooms subscribes a connection to the rooms it was granted.
+func (h *MessageHandler) joinInitialRooms(conn *Connection, rooms []string) {
+	for _, room := range rooms {
+		if err := conn.join(room); err != nil {
+			h.logger.Warn("Skipping initial room", zap.String("conn-id", conn.id), zap.String("room", room), zap.Error(err))
+			h.rejectJoin(conn, room, err)
+			continue
+		}
+		h.advertiseRoom(room)
+		h.sendRoomState(context.Background(), conn, room)
+	}
 }
 
 // handleIncomingMessages handles messages read from the connection's read channel.
 func (h *MessageHandler) handleIncomingMessages(conn *Connection) {
-	defer func() {
-		h.remove <- conn.id
-	}()
+	ctx := context.Background()
+	for in := range conn.readCh {
+		msg := in.data
+		conn.readAt = in.readAt
+		limiter := conn.limiter
+		if override := conn.rateOverride.Load(); override != nil {
+			limiter = override
+		}
+		if limiter != nil && !limiter.Allow() {
+			metrics.MessagesDropped.WithLabelValues("rate_limited").Inc()
+			conn.log().Warn("Connection exceeded its message rate, dropping message")
+			continue
+		}
+		if !h.allowUserMessage(ctx, conn.id, rateLimitKey(conn.identity, conn.remoteIP)) {
+			continue
+		}
+		if !conn.allowThrottled(len(msg)) {
+			metrics.MessagesDropped.WithLabelValues("bandwidth_throttled").Inc()
+			continue
+		}
+
+		if conn.framed {
+			h.handleFrame(ctx, conn, msg)
+			continue
+		}
+
+		// Raw clients cannot sign their publishes.
+		if !h.verifyPublish(ctx, conn, message.Frame{}, "", msg) || !h.acceptPayload(conn, "", "", "", msg) {
+			continue
+		}
 
-	for msg := range conn.readCh {
 		md := message.NewMessageDetails(conn.id, h.hubID, conn.id, msg)
-		h.broadcastCh <- md
+		md.ID = uuid.New().String()
+		md.Timing.Read = conn.readAt
+		conn.setEcho(&md, "")
+		h.annotate(ctx, conn.sender(""), &md)
+		h.ingest(md)
+	}
+
+	conn.log().Error("Read channel closed for the connection")
+}
+
+// handleFrame decodes a JSON frame received from a connection that negotiated the hub subprotocol.
+func (h *MessageHandler) handleFrame(ctx context.Context, conn *Connection, data []byte) {
+	var frame message.Frame
+	if err := frame.FromJSON(data); err != nil {
+		h.logger.Warn("Failed to decode frame", zap.String("conn-id", conn.id), zap.Error(err))
+		return
+	}
+
+	if conn.unauthenticated.Load() {
+		h.handleUnauthenticatedFrame(ctx, conn, frame)
+		return
+	}
+
+	switch frame.Type {
+	case message.FrameMessage:
+		h.handleMessageFrame(ctx, conn, frame)
+	case message.FrameChunk:
+		assembled, complete, err := conn.assembleChunk(frame, h.maxChunkedSize)
+		if err != nil {
+			h.logger.Warn("Dropping chunked message", zap.String("conn-id", conn.id), zap.String("id", frame.ID), zap.Error(err))
+			return
+		}
+		if complete {
+			h.handleMessageFrame(ctx, conn, assembled)
+		}
+	case message.FrameAck:
+		h.relayAck(ctx, conn, frame)
+	case message.FrameJoin, message.FrameLeave:
+		h.handleRoomFrame(ctx, conn, frame)
+	case message.FrameCredit:
+		h.handleCreditFrame(conn, frame)
+	case message.FrameRequest:
+		h.handleRequestFrame(ctx, conn, frame)
+	case message.FrameReply:
+		h.handleReplyFrame(ctx, conn, frame)
+	case message.FrameWhoami:
+		h.whoami(conn, frame)
+	default:
+		h.logger.Warn("Unsupported frame type", zap.String("conn-id", conn.id), zap.String("type", frame.Type))
+	}
+}
+
+// handleMessageFrame publishes a message frame received from a connection, or the message frame of
+// chunks reassembled, once the hub accepted it.
+func (h *MessageHandler) handleMessageFrame(ctx context.Context, conn *Connection, frame message.Frame) {
+	if frame.Room == message.EchoRoom {
+		h.echo(conn, frame)
+		return
+	}
+	if len(frame.Data) > 0 && !conn.capabilities.has(capBinary) {
+		metrics.MessagesDropped.WithLabelValues("binary_disabled").Inc()
+		h.logger.Warn("Dropping binary message from a client without the binary capability", zap.String("conn-id", conn.id), zap.String("id", frame.ID))
+		return
+	}
+	payload, sent, err := framePayload(ctx, frame)
+	if err != nil {
+		metrics.MessagesDropped.WithLabelValues("codec_error").Inc()
+		h.logger.Warn("Dropping message with undecodable payload", zap.String("conn-id", conn.id), zap.String("id", frame.ID), zap.Error(err))
+		return
+	}
+	// The publisher signed, and may publish to, the room it named as well as the one it is routed to
+	named := frame.Room
+	if !h.routeFrame(conn, &frame) {
+		return
+	}
+	if named != "" && named != frame.Room && !h.authorizeRoom(ctx, conn.identity, conn.id, named, config.RoomPublish) {
+		return
+	}
+	if !h.authorizeRoom(ctx, conn.identity, conn.id, frame.Room, config.RoomPublish) || !h.verifyPublish(ctx, conn, frame, named, sent) ||
+		!h.acceptPayload(conn, frame.ID, frame.Room, frame.ContentType, sent) {
+		return
 	}
 
-	h.logger.Error("Read channel closed for the connection", zap.String("conn-id", conn.id))
+	h.publishFrame(ctx, conn, frame, payload)
+}
+
+// publishFrame publishes the payload of a message frame, or of the chunks of a message, received
+// from a connection and accepted by the hub.
+func (h *MessageHandler) publishFrame(ctx context.Context, conn *Connection, frame message.Frame, payload []byte) {
+	md := message.NewMessageDetails(conn.id, h.hubID, conn.id, payload)
+	md.ID = frame.ID
+	if md.ID == "" {
+		md.ID = uuid.New().String()
+	}
+	md.Receipt = frame.Receipt && h.deliveryReceipts && conn.capabilities.has(capAcks)
+	md.Nack = frame.Nack && conn.capabilities.has(capAcks)
+	md.Ephemeral = frame.Ephemeral
+	md.Local = frame.Local
+	md.Room = frame.Room
+	md.ContentType = frame.ContentType
+	md.Key = frame.Key
+	md.ExpiresAt = h.expiresAt(frame)
+	md.Timing.Read = conn.readAt
+	conn.setEcho(&md, frame.Echo)
+	h.annotate(ctx, conn.sender(frame.Room), &md)
+	h.submit(ctx, md, frame.DeliverAt)
 }
 
-// broadcastWorker processes messages from the broadcast channel.
+// expiresAt returns the expiry of a message published with the frame: its ttl in milliseconds, or
+// the ephemeral TTL for ephemeral messages without one, counted from its scheduled delivery time.
+// Messages without a TTL never expire.
+func (h *MessageHandler) expiresAt(frame message.Frame) int64 {
+	ttl := time.Duration(frame.TTL) * time.Millisecond
+	if ttl <= 0 && frame.Ephemeral {
+		ttl = h.ephemeralTTL
+	}
+	if ttl <= 0 {
+		return 0
+	}
+
+	from := time.Now()
+	if frame.DeliverAt != nil && frame.DeliverAt.After(from) {
+		from = *frame.DeliverAt
+	}
+	return from.Add(ttl).UnixMilli()
+}
+
+// ingest queues a message received from a local connection for broadcasting. Ephemeral
+// messages are dropped instead of queued once the broadcast channel is under pressure.
+func (h *MessageHandler) ingest(md message.MessageDetails) {
+	md.Timing.Queued = time.Now().UnixNano()
+	observeStage(stageRead, md.Timing.Read, md.Timing.Queued)
+	if !md.Ephemeral {
+		h.queueBroadcast(md)
+		return
+	}
+
+	if hasEphemeralHeadroom(h.broadcastCh) {
+		select {
+		case h.broadcastCh <- md:
+			return
+		default:
+		}
+	}
+	h.logger.Debug("Broadcast channel under pressure, dropping ephemeral message", zap.String("senderID", md.SenderID))
+}
+
+// waitBroadcast queues a message for broadcasting, waiting for room in the broadcast queue. Once
+// the broadcast workers stopped, the message is dropped instead.
+func (h *MessageHandler) waitBroadcast(md message.MessageDetails) {
+	select {
+	case h.broadcastCh <- md:
+	case <-h.stopped:
+		h.logger.Debug("Message handler is closed, dropping message", zap.String("id", md.ID))
+	}
+}
+
+// hasEphemeralHeadroom reports whether a queue has enough free capacity to accept an ephemeral message.
+// The last quarter of every queue is reserved for regular messages.
+func hasEphemeralHeadroom[T any](ch chan T) bool {
+	return len(ch) < cap(ch)*3/4
+}
+
+// broadcastWorker processes messages from the broadcast channel. Messages queued up by a burst are
+// taken in batches and fanned out to the connections in a single pass. Once the handler closes, the
+// worker broadcasts the messages still queued and returns.
 func (h *MessageHandler) broadcastWorker() {
+	for {
+		select {
+		case md := <-h.broadcastCh:
+			h.broadcast(md)
+		case <-h.stopBroadcast:
+			for {
+				select {
+				case md := <-h.broadcastCh:
+					h.broadcast(md)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// broadcast takes a batch of queued messages, starting with md, and delivers it to the connections,
+// listeners and other hubs.
+func (h *MessageHandler) broadcast(md message.MessageDetails) {
+	h.broadcastBatch(h.collectBatch(md))
+}
+
+// broadcastBatch delivers a batch of messages to the connections, listeners and other hubs.
+func (h *MessageHandler) broadcastBatch(batch []message.MessageDetails) {
 	ctx := context.Background()
+	if len(batch) == 0 {
+		return
+	}
 
-	for md := range h.broadcastCh {
+	metrics.BroadcastBatchSize.Observe(float64(len(batch)))
+	batched := time.Now().UnixNano()
+	h.stampSequences(ctx, batch)
+	for i := range batch {
+		batch[i].Timing.Batched = batched
+		observeStage(stageIngestWait, batch[i].Timing.Queued, batched)
+		h.stampIngest(&batch[i])
+		h.recordHistory(ctx, &batch[i])
+		h.recordState(ctx, &batch[i])
+	}
+	if batch = h.aggregate(ctx, batch); len(batch) == 0 {
+		return
+	}
+	fannedOut := time.Now().UnixNano()
+	observeStage(stageRecord, batched, fannedOut)
+	for i := range batch {
+		batch[i].Timing.FannedOut = fannedOut
+	}
+	delivered, shed := h.broadcastToConnections(batch)
+	observeStage(stageFanOut, fannedOut, time.Now().UnixNano())
+	for i, md := range batch {
 		h.logger.Info("Received message from broadcastCh", zap.String("senderID", md.SenderID))
-		h.broadcastToConnections(md)
-		h.forwardToRedisIfNeeded(ctx, md)
+		h.messagesProcessed.Add(1)
+		h.sendDeliveryReceipt(ctx, md, delivered[i])
+		h.notifyListeners(md)
+		h.roomMetrics.broadcast(md.Room, delivered[i])
+		h.push.Push(md)
+		forwarded, hubs := h.forwardToRedisIfNeeded(ctx, md)
+		h.reportPublish(md, delivered[i], forwarded, hubs)
+		h.sendNack(ctx, md, delivered[i], shed[i], forwarded, hubs)
 	}
 }
 
-func (h *MessageHandler) broadcastToConnections(md message.MessageDetails) {
+// batchKey identifies a message for deduplication within a batch.
+type batchKey struct {
+	hubID, originID, id string
+}
+
+// batchCollector collects the messages of a batch. Control, evict, request and reply envelopes are
+// applied immediately and messages already in the batch are dropped, which happens when a broker
+// redelivers during a burst, as are messages that looped back to the hub.
+type batchCollector struct {
+	h        *MessageHandler
+	messages []message.MessageDetails
+	seen     map[batchKey]struct{}
+}
+
+// newBatch returns a collector of a batch holding the queued messages.
+func (h *MessageHandler) newBatch(queued ...message.MessageDetails) *batchCollector {
+	b := &batchCollector{
+		h:        h,
+		messages: make([]message.MessageDetails, 0, max(h.broadcastBatchSize, len(queued))),
+		seen:     make(map[batchKey]struct{}, h.broadcastBatchSize),
+	}
+	for _, md := range queued {
+		b.add(md)
+	}
+	return b
+}
+
+// add adds a queued message to the batch.
+func (b *batchCollector) add(md message.MessageDetails) {
+	h := b.h
+	if h.looped(md) {
+		return
+	}
+	switch md.Kind {
+	case message.KindControl:
+		h.writeControl(md.TargetID, md.Message)
+		return
+	case message.KindEvict:
+		h.evictConnection(md.TargetID)
+		return
+	case message.KindRequest:
+		h.claimRequest(md)
+		return
+	case message.KindReply:
+		h.routeReply(md)
+		return
+	case message.KindHeartbeat:
+		h.receiveHeartbeat(md)
+		return
+	}
+
+	if md.ID != "" {
+		key := batchKey{md.HubID, md.OriginID, md.ID}
+		if _, ok := b.seen[key]; ok {
+			metrics.MessagesDropped.WithLabelValues("duplicate").Inc()
+			return
+		}
+		b.seen[key] = struct{}{}
+	}
+	b.messages = append(b.messages, md)
+}
+
+// collectBatch drains up to broadcastBatchSize queued messages, starting with first, without
+// waiting for more to arrive.
+func (h *MessageHandler) collectBatch(first message.MessageDetails) []message.MessageDetails {
+	b := h.newBatch(first)
+	for drained := 1; drained < h.broadcastBatchSize; drained++ {
+		select {
+		case md, ok := <-h.broadcastCh:
+			if !ok {
+				return b.messages
+			}
+			b.add(md)
+		default:
+			return b.messages
+		}
+	}
+	return b.messages
+}
+
+// broadcastToConnections queues each message of the batch on every eligible connection, holding
+// the registry lock once for the whole batch, and returns the number of connections each message
+// was queued for and shed by.
+func (h *MessageHandler) broadcastToConnections(batch []message.MessageDetails) ([]int, []int) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
+	delivered := make([]int, len(batch))
+	shed := make([]int, len(batch))
+	payloads := newBatchPayloads(batch)
+	frames := make([]message.SharedFrame, len(batch))
 	for id, conn := range h.connections {
-		if md.ShouldBroadcastToClient(id) {
-			select {
-			case conn.writeCh <- md:
-			default:
+		for i, md := range batch {
+			if !md.ShouldBroadcastToClient(id, conn.identity.UserID) || conn.unauthenticated.Load() {
+				continue
+			}
+			subscribed, f := conn.subscription(md.Room)
+			if !subscribed {
+				continue
+			}
+			if f != nil && !f.Match(payloads.get(i)) {
+				metrics.MessagesFiltered.Inc()
+				continue
+			}
+			md.Frame = &frames[i]
+			if conn.enqueue(md) {
+				delivered[i]++
+				continue
+			}
+			shed[i]++
+			if !md.Ephemeral {
+				h.roomMetrics.dropped(md.Room)
 				h.logger.Warn("Write channel is full, dropping message",
 					zap.String("connID", id),
 					zap.String("senderID", md.SenderID),
@@ -109,76 +1075,263 @@ func (h *MessageHandler) broadcastToConnections(md message.MessageDetails) {
 			}
 		}
 	}
+	return delivered, shed
 }
 
-func (h *MessageHandler) forwardToRedisIfNeeded(ctx context.Context, md message.MessageDetails) {
-	if !md.IsFromPubSub(h.pubSubChannel) {
-		if err := h.redisPubSub.Publish(ctx, &md); err != nil {
-			h.logger.Error("Failed to publish message to Redis", zap.Error(err))
-		}
+// forwardToRedisIfNeeded publishes a message received from a local sender to the other hubs. It
+// reports whether the message was published and the number of hubs it reached, -1 when the broker
+// cannot tell.
+func (h *MessageHandler) forwardToRedisIfNeeded(ctx context.Context, md message.MessageDetails) (bool, int) {
+	if md.Local || md.IsFromPubSub(h.pubSubChannel) {
+		return false, 0
 	}
+
+	md.Zone = h.zone
+	h.stampHop(&md)
+	start := time.Now()
+	hubs, err := publishCounted(ctx, h.broker, &md)
+	stagePublish.Observe(time.Since(start).Seconds())
+	if err != nil {
+		h.logger.Error("Failed to publish message to broker", zap.Error(err))
+		return false, 0
+	}
+	return true, hubs
 }
 
 // Run starts the message handler's main loop.
 func (h *MessageHandler) Run() {
-	ctx := context.Background()
-	go h.redisPubSub.Subscribe(ctx)
+	h.lifecycleMu.Lock()
+	if h.closed || h.started {
+		h.lifecycleMu.Unlock()
+		return
+	}
+	h.started = true
+
+	// The subscription outlives ctx: messages from other hubs keep arriving until the broadcast
+	// workers drained the queue and the handler unsubscribed
+	ctx, stopSubscribe := context.WithCancel(context.Background())
+	h.stopSubscribe = stopSubscribe
+	if h.spill != nil {
+		inbound := make(chan message.MessageDetails, brokerInboundBuffer)
+		go h.forwardInbound(inbound)
+		go h.drainSpill(h.ctx)
+		go func() {
+			defer close(h.subscribed)
+			defer close(inbound)
+			h.broker.Subscribe(ctx, inbound)
+		}()
+	} else {
+		go func() {
+			defer close(h.subscribed)
+			h.broker.Subscribe(ctx, h.broadcastCh)
+		}()
+	}
 
 	// Start multiple workers for broadcasting messages.
 	for i := 0; i < h.broadcastWorkers; i++ {
-		go h.broadcastWorker()
+		h.workers.Add(1)
+		go func() {
+			defer h.workers.Done()
+			worker := h.broadcastWorker
+			if h.fair != nil {
+				worker = h.fairBroadcastWorker
+			}
+			supervise("broadcast-worker", unlimitedRestarts, h.logger, worker)
+		}()
+	}
+	h.lifecycleMu.Unlock()
+
+	if h.sessions != nil {
+		go h.sessions.KeepAlive(h.ctx)
+	}
+	if h.handoffs != nil {
+		go h.awaitReconnections(h.ctx)
+	}
+	if h.idleTimeout > 0 {
+		go h.reapIdleConnections(h.ctx)
+	}
+	if h.scheduler != nil {
+		go h.runScheduler()
+	}
+	if interval := h.chaos.DisconnectInterval(); interval > 0 {
+		go h.injectDisconnects(h.ctx, interval)
+	}
+	go h.reportBufferMetrics(h.ctx)
+	if h.queueMemory != nil {
+		go h.enforceQueueMemory(h.ctx)
+	}
+	if h.peers != nil {
+		go h.sendHeartbeats(h.ctx, h.heartbeatInterval)
+	}
+	go h.meterBandwidth(h.ctx)
+	go h.push.Run(h.ctx)
+	go h.lifecycle.run(h.ctx, h.dispatchRoomEvent)
+	if h.roomExpiry != nil {
+		go h.expireRooms(h.ctx)
+	}
+	if h.zones != nil {
+		go h.advertiseRooms(h.ctx)
+	}
+	if h.routes != nil {
+		go h.refreshRoutes(h.ctx, redis.RouteRefresh)
+	}
+
+	// Handle connection removals until the handler is closed, which closes every connection itself
+	for {
+		select {
+		case connID := <-h.remove:
+			h.closeAndRemoveConnection(connID)
+		case <-h.ctx.Done():
+			return
+		}
 	}
+}
 
-	// Handle connection removals in a range loop
-	for connID := range h.remove {
-		h.closeAndRemoveConnection(connID)
+// requestRemoval asks Run to close and remove a connection, unless the handler is closed.
+func (h *MessageHandler) requestRemoval(connID string) {
+	select {
+	case h.remove <- connID:
+	case <-h.ctx.Done():
 	}
 }
 
 // closeAndRemoveConnection removes a WebSocket connection from the map.
 func (h *MessageHandler) closeAndRemoveConnection(connID string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	conn, ok := h.detach(connID)
+	if !ok {
+		h.logger.Info("Connection already closed", zap.String("conn-id", connID))
+		return
+	}
+
+	if err := conn.Close(); err != nil {
+		conn.log().Error("Error closing connection", zap.Error(err))
+		return
+	}
+	conn.log().Info("Connection closed successfully")
+}
 
-	if conn, ok := h.connections[connID]; ok {
+// detach removes a connection from the map and releases its IP slot and user session.
+// Once detached, no broadcast can reach the connection so it can be closed without holding the lock.
+func (h *MessageHandler) detach(connID string) (*Connection, bool) {
+	h.mu.Lock()
+	conn, ok := h.connections[connID]
+	if ok {
 		delete(h.connections, connID)
-		err := conn.Close()
-		if err != nil {
-			h.logger.Error("Error closing connection", zap.String("conn-id", connID), zap.Error(err))
-			return
-		}
-		h.logger.Info("Connection closed successfully", zap.String("conn-id", connID))
-	} else {
-		h.logger.Info("Connection already closed", zap.String("conn-id", connID))
 	}
+	h.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	h.bandwidth.collect(conn, rateLimitKey(conn.identity, conn.remoteIP), time.Now())
+	h.ipFilter.Release(conn.remoteIP)
+	h.unregisterSession(conn.identity, conn.id)
+	h.removeRoute(conn.id)
+	conn.releaseRooms()
+	conn.releaseQueue()
+	return conn, true
 }
 
 // Close cleans up resources used by the message handler.
 func (h *MessageHandler) Close() error {
+	h.closeOnce.Do(func() {
+		h.closeErr = h.shutdown()
+	})
+	return h.closeErr
+}
+
+// shutdown stops the handler in dependency order: connections are closed so no client publishes
+// anymore, the background loops stop, the broadcast workers deliver and publish the messages still
+// queued, and only then does the hub unsubscribe from the other hubs and close the broker. It
+// returns every error met on the way, joined.
+func (h *MessageHandler) shutdown() error {
+	h.lifecycleMu.Lock()
+	h.closed = true
+	started := h.started
+	h.lifecycleMu.Unlock()
+
 	h.closeAndRemoveAllConnections()
+	h.cancel()
 
-	if err := h.redisPubSub.Unsubscribe(context.Background()); err != nil {
-		h.logger.Error("Failed to unsubscribe from Redis pub-sub channel", zap.Error(err))
+	close(h.stopBroadcast)
+	h.workers.Wait()
+	close(h.stopped)
+	h.logger.Info("Broadcast workers drained")
+
+	var errs []error
+	if err := h.broker.Unsubscribe(context.Background()); err != nil {
+		h.logger.Error("Failed to unsubscribe from broker", zap.Error(err))
+		errs = append(errs, fmt.Errorf("failed to unsubscribe from broker: %w", err))
+	}
+	if err := h.broker.Close(); err != nil {
+		h.logger.Error("Failed to close broker connection", zap.Error(err))
+		errs = append(errs, fmt.Errorf("failed to close broker connection: %w", err))
+	}
+	if started {
+		h.stopSubscribe()
+		if err := h.awaitSubscription(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	if err := h.redisPubSub.Close(); err != nil {
-		h.logger.Error("Failed to close Redis pub-sub connection", zap.Error(err))
-		return fmt.Errorf("failed to close Redis pub-sub connection: %w", err)
+	if h.spill != nil {
+		if err := h.spill.queue.Close(); err != nil {
+			h.logger.Error("Failed to close spill queue", zap.Error(err))
+			errs = append(errs, fmt.Errorf("failed to close spill queue: %w", err))
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	return nil
+// awaitSubscription waits for the broker subscription to return, discarding the messages from
+// other hubs it still queues so it isn't left blocked on the broadcast queue, for up to
+// subscriptionCloseTimeout.
+func (h *MessageHandler) awaitSubscription() error {
+	timeout := time.NewTimer(subscriptionCloseTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-h.subscribed:
+			return nil
+		case <-h.broadcastCh:
+		case <-timeout.C:
+			h.logger.Warn("Broker subscription did not end after the broker was closed")
+			return errors.New("broker subscription did not end after the broker was closed")
+		}
+	}
+}
+
+// closeReason builds the reconnect hint sent to clients when the hub closes their connection.
+// The retry delay is jittered up to twice the configured value so clients don't reconnect in lockstep.
+func (h *MessageHandler) closeReason(reason string) message.CloseReason {
+	retryAfter := h.retryAfter
+	if retryAfter > 0 {
+		retryAfter += rand.N(retryAfter)
+	}
+
+	return message.CloseReason{
+		Reason:       reason,
+		RetryAfterMs: retryAfter.Milliseconds(),
+		AltHub:       h.alternateHub,
+	}
 }
 
 // closeAndRemoveAllConnections closes all the WebSocket connections.
 func (h *MessageHandler) closeAndRemoveAllConnections() {
 	h.mu.Lock()
-	defer h.mu.Unlock()
-	for connID, conn := range h.connections {
-		err := conn.Close()
+	connections := h.connections
+	h.connections = nil
+	h.mu.Unlock()
+
+	for connID, conn := range connections {
+		h.ipFilter.Release(conn.remoteIP)
+		h.unregisterSession(conn.identity, connID)
+		h.removeRoute(connID)
+		err := conn.CloseWithReason(message.CloseShutdown, h.closeReason(message.ReasonShutdown))
 		if err != nil {
 			h.logger.Warn("Failed to close connection", zap.String("conn-id", connID))
 		}
 	}
-	h.connections = nil
 	h.logger.Info("All connections closed and map set to nil")
 }
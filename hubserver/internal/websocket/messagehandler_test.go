@@ -0,0 +1,356 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/hubtest"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// runHandler runs a message handler of the named hub over the broker until the test ends.
+func runHandler(t *testing.T, hubID string, broker Broker) *MessageHandler {
+	t.Helper()
+
+	cfg := testConfig()
+	cfg.HubName = hubID
+	h, err := NewMessageHandler(broker, nil, cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewMessageHandler: %v", err)
+	}
+	go h.Run()
+	t.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+// attach serves a fake connection subscribed to rooms on the handler.
+func attach(t *testing.T, h *MessageHandler, subprotocol string, rooms ...string) (*Connection, *hubtest.Conn) {
+	t.Helper()
+
+	ws := hubtest.NewConn(subprotocol)
+	conn, err := h.Attach(ws, auth.Identity{}, rooms)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	return conn, ws
+}
+
+// receiveFrame returns the next frame of the given type written to a framed fake connection.
+func receiveFrame(t *testing.T, ws *hubtest.Conn, frameType string) message.Frame {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		var frame message.Frame
+		if err := ws.ReceiveJSON(ctx, &frame); err != nil {
+			t.Fatalf("waiting for %s frame: %v", frameType, err)
+		}
+		if frame.Type == frameType {
+			return frame
+		}
+	}
+}
+
+func TestRoomMessagesReachRoomMembersOnly(t *testing.T) {
+	h := runHandler(t, "test-hub", hubtest.NewBroker("test-channel", "test-hub"))
+	_, member := attach(t, h, message.Subprotocol, "orders")
+	_, outsider := attach(t, h, message.Subprotocol)
+	_, publisher := attach(t, h, message.Subprotocol)
+
+	if err := publisher.SendJSON(message.Frame{Type: message.FrameMessage, Room: "orders", Payload: []byte(`{"id":1}`)}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	frame := receiveFrame(t, member, message.FrameMessage)
+	if frame.Room != "orders" || string(frame.Payload) != `{"id":1}` {
+		t.Fatalf("member received %+v", frame)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if data, err := outsider.Receive(ctx); err == nil {
+		t.Fatalf("connection outside the room received %s", data)
+	}
+}
+
+func TestMessagesAreExchangedBetweenHubs(t *testing.T) {
+	bus := hubtest.NewBus("test-channel")
+	first, second := bus.Broker("hub-1"), bus.Broker("hub-2")
+	h1 := runHandler(t, "hub-1", first)
+	h2 := runHandler(t, "hub-2", second)
+
+	_, sender := attach(t, h1, "")
+	_, remote := attach(t, h2, "")
+	if err := sender.Send([]byte(`"hello"`)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	data, err := remote.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if string(data) != `"hello"` {
+		t.Fatalf("remote connection received %s", data)
+	}
+
+	if published := first.Published(); len(published) != 1 || published[0].HubID != "hub-1" {
+		t.Fatalf("hub-1 published %+v", published)
+	}
+	if published := second.Published(); len(published) != 0 {
+		t.Fatalf("hub-2 re-published %d messages from the broker", len(published))
+	}
+}
+
+func TestHalfOpenConnectionIsClosed(t *testing.T) {
+	h := runHandler(t, "test-hub", hubtest.NewBroker("test-channel", "test-hub"))
+	_, ws := attach(t, h, "")
+	ws.SetAutoPong(false)
+
+	select {
+	case <-ws.Closed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection that stopped answering pings was not closed")
+	}
+	if ws.Pings() == 0 {
+		t.Fatal("connection was closed without being pinged")
+	}
+	waitFor(t, "connection removal", func() bool { return len(connections(h)) == 0 })
+}
+
+func TestClientCloseRemovesConnection(t *testing.T) {
+	h := runHandler(t, "test-hub", hubtest.NewBroker("test-channel", "test-hub"))
+	conn, ws := attach(t, h, message.Subprotocol)
+
+	ws.CloseFromClient(1000)
+	waitFor(t, "connection removal", func() bool { return len(connections(h)) == 0 })
+	select {
+	case <-conn.done:
+	default:
+		t.Fatal("connection not marked done after the client closed it")
+	}
+}
+
+// textCodec encodes JSON string payloads as their raw text.
+type textCodec struct{}
+
+func (textCodec) Encode(_ context.Context, payload []byte) ([]byte, error) {
+	var text string
+	err := json.Unmarshal(payload, &text)
+	return []byte(text), err
+}
+
+func (textCodec) Decode(_ context.Context, data []byte) ([]byte, error) {
+	return json.Marshal(string(data))
+}
+
+func TestChunkedMessagesAreDecodedByTheirCodec(t *testing.T) {
+	message.RegisterCodec("text/x-chunk-test", textCodec{})
+	h := runHandler(t, "test-hub", hubtest.NewBroker("test-channel", "test-hub"))
+	_, member := attach(t, h, message.Subprotocol, "docs")
+	_, publisher := attach(t, h, message.Subprotocol)
+
+	text := bytes.Repeat([]byte("chunked text "), 20)
+	for seq, total := 0, (len(text)+minChunkSize-1)/minChunkSize; seq < total; seq++ {
+		part := text[seq*minChunkSize : min((seq+1)*minChunkSize, len(text))]
+		chunk := message.Frame{Type: message.FrameChunk, ID: "doc-1", Room: "docs", ContentType: "text/x-chunk-test", Seq: seq, Total: total, Data: part}
+		if err := publisher.SendJSON(chunk); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	frame := receiveFrame(t, member, message.FrameMessage)
+	if want, _ := json.Marshal(string(text)); frame.ID != "doc-1" || string(frame.Payload) != string(want) {
+		t.Fatalf("member received %+v", frame)
+	}
+}
+
+// jsonFields decodes the fields of an encoded frame.
+func TestChunksMustRepeatTheMetadataOfTheFirstChunk(t *testing.T) {
+	h := runHandler(t, "test-hub", hubtest.NewBroker("test-channel", "test-hub"))
+	conn, _ := attach(t, h, message.Subprotocol)
+
+	first := message.Frame{Type: message.FrameChunk, ID: "doc-1", Room: "docs", Total: 2, Data: bytes.Repeat([]byte("a"), minChunkSize)}
+	last := first
+	last.Seq, last.Data = 1, []byte(`"`)
+	first.Data[0] = '"'
+	for name, moved := range map[string]func(message.Frame) message.Frame{
+		"room":    func(f message.Frame) message.Frame { f.Room = "admin"; return f },
+		"receipt": func(f message.Frame) message.Frame { f.Receipt = true; return f },
+		"key":     func(f message.Frame) message.Frame { f.Key = "doc"; return f },
+	} {
+		if _, complete, err := conn.assembleChunk(first, 1<<20); complete || err != nil {
+			t.Fatalf("first chunk: %v", err)
+		}
+		if _, _, err := conn.assembleChunk(moved(last), 1<<20); err == nil {
+			t.Fatalf("assembled a message whose last chunk changed the %s", name)
+		}
+	}
+
+	if _, _, err := conn.assembleChunk(first, 1<<20); err != nil {
+		t.Fatalf("first chunk: %v", err)
+	}
+	assembled, complete, err := conn.assembleChunk(last, 1<<20)
+	if err != nil || !complete || assembled.Type != message.FrameMessage || assembled.Room != "docs" || len(assembled.Payload) != minChunkSize+1 {
+		t.Fatalf("assembled %+v, %v, %v", assembled, complete, err)
+	}
+}
+
+func jsonFields(t *testing.T, data []byte) map[string]any {
+	t.Helper()
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("frame %s: %v", data, err)
+	}
+	return fields
+}
+
+// receiveMessageFields returns the fields of the next message frame written to a framed fake connection.
+func receiveMessageFields(t *testing.T, ws *hubtest.Conn) map[string]any {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		data, err := ws.Receive(ctx)
+		if err != nil {
+			t.Fatalf("waiting for message frame: %v", err)
+		}
+		if fields := jsonFields(t, data); fields["type"] == message.FrameMessage {
+			return fields
+		}
+	}
+}
+
+func TestSharedFramesMatchTheFramesOfEachCopy(t *testing.T) {
+	broker := hubtest.NewBroker("test-channel", "test-hub")
+	h := runHandler(t, "test-hub", broker)
+	_, first := attach(t, h, message.Subprotocol, "orders")
+	_, second := attach(t, h, message.Subprotocol, "orders")
+
+	// Room messages are numbered for each connection, messages to the whole hub are not
+	for _, tc := range []struct {
+		md      message.MessageDetails
+		roomSeq uint64
+	}{
+		{message.MessageDetails{ID: "order-1", OriginID: "client-1", Room: "orders", Message: []byte(`{"id":1}`), Key: "order-1", Sequence: 7, Cursor: "1-0", HLC: "0001", ContentType: "application/json", Annotations: map[string]string{"country": "IN", "name": "Ada"}}, 1},
+		{message.MessageDetails{ID: "order-2", OriginID: "client-1", Room: "orders", Message: []byte(`not json`), Receipt: true}, 2},
+		{message.MessageDetails{ID: "notice-1", OriginID: "client-2", Message: []byte(`{"notice":"maintenance"}`)}, 0},
+	} {
+		broker.Deliver(tc.md)
+		want := tc.md
+		want.RoomSeq = tc.roomSeq
+		frame := message.NewMessageFrame(&want)
+		data, err := frame.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON: %v", err)
+		}
+		wantFields := jsonFields(t, data)
+		for _, ws := range []*hubtest.Conn{first, second} {
+			if fields := receiveMessageFields(t, ws); !reflect.DeepEqual(fields, wantFields) {
+				t.Fatalf("connection received %v, want %v", fields, wantFields)
+			}
+		}
+	}
+}
+
+func TestAcksAreRelayedToThePublisherOfTheMessage(t *testing.T) {
+	cfg := testConfig()
+	cfg.DeliveryReceipts = true
+	h, err := NewMessageHandler(hubtest.NewBroker("test-channel", "test-hub"), nil, cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewMessageHandler: %v", err)
+	}
+	go h.Run()
+	t.Cleanup(func() { _ = h.Close() })
+
+	publisherConn, publisher := attach(t, h, message.Subprotocol)
+	bystanderConn, bystander := attach(t, h, message.Subprotocol)
+	_, member := attach(t, h, message.Subprotocol, "orders")
+
+	if err := publisher.SendJSON(message.Frame{Type: message.FrameMessage, ID: "order-1", Room: "orders", Payload: []byte(`{"id":1}`), Receipt: true}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if frame := receiveFrame(t, member, message.FrameMessage); frame.OriginID != publisherConn.id {
+		t.Fatalf("member received %+v", frame)
+	}
+
+	// The ack names the bystander, but the receipt goes to the publisher of the message
+	if err := member.SendJSON(message.Frame{Type: message.FrameAck, ID: "order-1", OriginID: bystanderConn.id}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	for {
+		receipt := receiveFrame(t, publisher, message.FrameReceipt)
+		if receipt.Status == message.ReceiptRead {
+			if receipt.ID != "order-1" {
+				t.Fatalf("publisher received %+v", receipt)
+			}
+			break
+		}
+	}
+
+	// Acks of messages the member was never written are dropped
+	if err := member.SendJSON(message.Frame{Type: message.FrameAck, ID: "order-2", OriginID: bystanderConn.id}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	for {
+		var frame message.Frame
+		if err := bystander.ReceiveJSON(ctx, &frame); err != nil {
+			break
+		}
+		if frame.Type == message.FrameReceipt {
+			t.Fatalf("bystander received %+v", frame)
+		}
+	}
+}
+
+func TestHTTPRequestsAreHeldToTheIPFilter(t *testing.T) {
+	cfg := testConfig()
+	cfg.IPDenylistFile = filepath.Join(t.TempDir(), "denylist")
+	if err := os.WriteFile(cfg.IPDenylistFile, []byte("192.0.2.0/24\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg.MaxConnectionsPerIP = 1
+	h, _ := startHubWith(t, cfg)
+
+	// The address at its limit holds a WebSocket connection
+	busy := netip.MustParseAddr("198.51.100.7")
+	if err := h.ipFilter.Acquire(busy); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	for name, serve := range map[string]func(http.ResponseWriter, *http.Request, string){
+		"publish": h.ServePublish,
+		"history": h.ServeRoomHistory,
+		"state":   h.ServeRoomState,
+	} {
+		for remoteAddr, want := range map[string]int{
+			"192.0.2.1:4000":    http.StatusForbidden,
+			"198.51.100.7:4000": http.StatusTooManyRequests,
+		} {
+			r := httptest.NewRequest(http.MethodPost, "/rooms/orders/messages", strings.NewReader(`{"payload":{}}`))
+			r.RemoteAddr = remoteAddr
+			w := httptest.NewRecorder()
+			serve(w, r, "orders")
+			if w.Code != want {
+				t.Errorf("%s request from %s answered %d, want %d", name, remoteAddr, w.Code, want)
+			}
+		}
+	}
+}
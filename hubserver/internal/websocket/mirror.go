@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"go.uber.org/zap"
+)
+
+// mirrorFlushTimeout bounds the time the messages still queued for the mirror get on Close.
+const mirrorFlushTimeout = 5 * time.Second
+
+// mirrorBroker publishes to the wrapped broker and, asynchronously, to the Redis of a secondary
+// region, where a passive hub cluster receives the messages as if they came from one of its own
+// hubs. Mirror publishes never hold up the broadcast path: they wait in their own queue, are
+// retried with backoff on failure, and are dropped once the queue is full.
+type mirrorBroker struct {
+	Broker
+	mirror Publisher
+	client *redis.Client
+	queue  chan message.MessageDetails
+
+	timeout     time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	stop   chan struct{}
+	done   chan struct{}
+	logger *zap.Logger
+}
+
+// newMirrorBroker wraps a broker to mirror its publishes to the configured secondary Redis, and
+// starts sending them.
+func newMirrorBroker(broker Broker, cfg *config.Config, logger *zap.Logger) *mirrorBroker {
+	channel := cfg.MirrorChannel
+	if channel == "" {
+		channel = cfg.PubSubChannelName
+	}
+	logger = logger.With(zap.String("mirror", cfg.MirrorRedisAddr))
+	client := redis.NewClient(cfg.MirrorRedisAddr, cfg.MirrorRedisUsername, cfg.MirrorRedisPassword, logger)
+	mirror := redis.NewPubSub(client, channel, cfg.HubName, cfg.RedisCompression, cfg.RedisCompressionThreshold, logger)
+	if cfg.RedisShards > 1 {
+		mirror.ShardRooms(cfg.RedisShards)
+	}
+
+	b := &mirrorBroker{
+		Broker:      broker,
+		mirror:      mirror,
+		client:      client,
+		queue:       make(chan message.MessageDetails, cfg.MirrorQueueSize),
+		timeout:     cfg.MirrorTimeout,
+		maxAttempts: cfg.MirrorMaxAttempts,
+		backoff:     cfg.MirrorBackoff,
+		maxBackoff:  cfg.MirrorMaxBackoff,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		logger:      logger,
+	}
+	go b.run()
+	return b
+}
+
+func (b *mirrorBroker) Publish(ctx context.Context, md *message.MessageDetails) error {
+	err := b.Broker.Publish(ctx, md)
+	b.enqueue(md)
+	return err
+}
+
+func (b *mirrorBroker) PublishCounted(ctx context.Context, md *message.MessageDetails) (int, error) {
+	hubs, err := publishCounted(ctx, b.Broker, md)
+	b.enqueue(md)
+	return hubs, err
+}
+
+// Close closes the wrapped broker, then gives the messages still queued up to mirrorFlushTimeout
+// to reach the mirror before closing its connection.
+func (b *mirrorBroker) Close() error {
+	err := b.Broker.Close()
+	close(b.stop)
+	<-b.done
+	if closeErr := b.client.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	return err
+}
+
+// enqueue queues a message for the mirror, whatever became of its publish to the wrapped broker.
+// Only durable room messages are mirrored: ephemeral messages, and the control, eviction, RPC and
+// heartbeat envelopes addressed to the hubs of this cluster, mean nothing to the passive cluster.
+// It never blocks: messages that do not fit in the queue are dropped.
+func (b *mirrorBroker) enqueue(md *message.MessageDetails) {
+	if md.Kind != message.KindMessage || md.Ephemeral {
+		return
+	}
+	select {
+	case b.queue <- *md:
+		metrics.MirrorQueueLength.Set(float64(len(b.queue)))
+	default:
+		metrics.MirroredMessages.WithLabelValues("dropped").Inc()
+		b.logger.Warn("Mirror queue is full, dropping message", zap.String("id", md.ID))
+	}
+}
+
+// run sends the queued messages to the mirror until the broker is closed, then flushes the queue.
+func (b *mirrorBroker) run() {
+	defer close(b.done)
+	for {
+		select {
+		case md := <-b.queue:
+			metrics.MirrorQueueLength.Set(float64(len(b.queue)))
+			b.send(md)
+		case <-b.stop:
+			b.flush()
+			return
+		}
+	}
+}
+
+// send publishes a message to the mirror, retrying with exponential backoff until it succeeds,
+// maxAttempts is reached or the broker is closed.
+func (b *mirrorBroker) send(md message.MessageDetails) {
+	backoff := b.backoff
+	for attempt := 1; ; attempt++ {
+		err := b.publish(context.Background(), &md)
+		if err == nil {
+			metrics.MirroredMessages.WithLabelValues("mirrored").Inc()
+			return
+		}
+		if attempt >= b.maxAttempts {
+			metrics.MirroredMessages.WithLabelValues("failed").Inc()
+			b.logger.Error("Giving up mirror publish", zap.String("id", md.ID), zap.Int("attempts", attempt), zap.Error(err))
+			return
+		}
+
+		metrics.MirrorRetries.Inc()
+		b.logger.Warn("Mirror publish failed, retrying", zap.String("id", md.ID), zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-b.stop:
+			metrics.MirroredMessages.WithLabelValues("failed").Inc()
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, b.maxBackoff)
+	}
+}
+
+// flush publishes the messages still queued once each, dropping those left when
+// mirrorFlushTimeout runs out.
+func (b *mirrorBroker) flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorFlushTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case md := <-b.queue:
+			result := "mirrored"
+			if ctx.Err() != nil {
+				result = "dropped"
+			} else if err := b.publish(ctx, &md); err != nil {
+				result = "failed"
+				b.logger.Error("Failed to mirror message on close", zap.String("id", md.ID), zap.Error(err))
+			}
+			metrics.MirroredMessages.WithLabelValues(result).Inc()
+		default:
+			metrics.MirrorQueueLength.Set(0)
+			return
+		}
+	}
+}
+
+// publish makes one attempt at publishing a message to the mirror.
+func (b *mirrorBroker) publish(ctx context.Context, md *message.MessageDetails) error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	return b.mirror.Publish(ctx, md)
+}
@@ -0,0 +1,31 @@
+package websocket
+
+import (
+	"crypto/x509"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+)
+
+// certificateUser returns the function naming the user of a client certificate by the configured
+// field: the subject common name or the first subject alternative name of the field's kind.
+func certificateUser(field string) func(*x509.Certificate) string {
+	return func(cert *x509.Certificate) string {
+		switch field {
+		case config.MTLSUserDNS:
+			if len(cert.DNSNames) > 0 {
+				return cert.DNSNames[0]
+			}
+		case config.MTLSUserURI:
+			if len(cert.URIs) > 0 {
+				return cert.URIs[0].String()
+			}
+		case config.MTLSUserEmail:
+			if len(cert.EmailAddresses) > 0 {
+				return cert.EmailAddresses[0]
+			}
+		default:
+			return cert.Subject.CommonName
+		}
+		return ""
+	}
+}
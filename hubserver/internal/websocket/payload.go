@@ -0,0 +1,184 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Reasons carried in error frames rejecting a published message.
+const (
+	rejectContentType = "content_type"
+	rejectTooLarge    = "payload_too_large"
+	rejectInvalidJSON = "invalid_json"
+	rejectTooDeep     = "payload_too_deep"
+	rejectLongString  = "string_too_long"
+	rejectInvalidUTF8 = "invalid_utf8"
+	rejectControl     = "control_characters"
+)
+
+// defaultContentType is the media type of payloads published without one.
+const defaultContentType = "application/json"
+
+// payloadGuard enforces the payload policies of rooms at ingest.
+type payloadGuard struct {
+	policies map[string]config.PayloadPolicy
+}
+
+// check returns the reason a payload published to a room with the content type violates the
+// room's policy, or an empty reason when it is accepted. Rooms without a policy of their own,
+// and messages to every connection, fall back to the policy of *.
+func (g *payloadGuard) check(room, contentType string, payload []byte) string {
+	policy, ok := g.policies[room]
+	if !ok {
+		if policy, ok = g.policies["*"]; !ok {
+			return ""
+		}
+	}
+
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	if !strings.EqualFold(contentType, policy.ContentType) {
+		return rejectContentType
+	}
+	if policy.MaxSize > 0 && len(payload) > policy.MaxSize {
+		return rejectTooLarge
+	}
+	switch {
+	case isJSONMediaType(policy.ContentType):
+		// The JSON decoder accepts invalid UTF-8, which browsers decoding the frame choke on
+		if !utf8.Valid(payload) {
+			return rejectInvalidUTF8
+		}
+		if !json.Valid(payload) {
+			return rejectInvalidJSON
+		}
+		shape := inspectJSON(payload)
+		if policy.MaxDepth > 0 && shape.depth > policy.MaxDepth {
+			return rejectTooDeep
+		}
+		if policy.MaxString > 0 && shape.longestString > policy.MaxString {
+			return rejectLongString
+		}
+		if shape.controls {
+			return rejectControl
+		}
+	case isTextMediaType(policy.ContentType):
+		if !utf8.Valid(payload) {
+			return rejectInvalidUTF8
+		}
+		if hasControlCharacters(string(payload)) {
+			return rejectControl
+		}
+	}
+	return ""
+}
+
+// isJSONMediaType reports whether a media type is JSON, such as application/json or a +json type.
+func isJSONMediaType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+// isTextMediaType reports whether a media type is text, such as text/plain.
+func isTextMediaType(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(contentType), "text/")
+}
+
+// jsonShape describes the parts of a JSON document that can overwhelm the clients parsing or
+// rendering it.
+type jsonShape struct {
+	// depth is the deepest nesting of objects and arrays and longestString the length in bytes of
+	// the longest string, as encoded
+	depth         int
+	longestString int
+	// controls is set when a string holds a control character other than tab, newline and
+	// carriage return, escaped or not
+	controls bool
+}
+
+// inspectJSON returns the shape of a valid JSON document in a single pass over it.
+func inspectJSON(data []byte) jsonShape {
+	var shape jsonShape
+	depth, start := 0, 0
+	inString, escaped := false, false
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case escaped:
+			escaped = false
+			if b == 'u' && i+4 < len(data) {
+				if r, err := strconv.ParseUint(string(data[i+1:i+5]), 16, 16); err == nil && isControl(rune(r)) {
+					shape.controls = true
+				}
+			}
+		case inString:
+			switch b {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+				shape.longestString = max(shape.longestString, i-start)
+			default:
+				// The JSON grammar rules out raw C0 control characters but not DEL, nor C1 control
+				// characters, which are two bytes in UTF-8 starting with 0xC2
+				if b == 0x7F || b == 0xC2 && i+1 < len(data) && isControl(rune(data[i+1])) {
+					shape.controls = true
+				}
+			}
+		case b == '"':
+			inString = true
+			start = i + 1
+		case b == '{' || b == '[':
+			depth++
+			shape.depth = max(shape.depth, depth)
+		case b == '}' || b == ']':
+			depth--
+		}
+	}
+	return shape
+}
+
+// hasControlCharacters reports whether text holds a control character other than tab, newline and
+// carriage return.
+func hasControlCharacters(text string) bool {
+	return strings.ContainsFunc(text, isControl)
+}
+
+// isControl reports whether r is a C0 or C1 control character other than tab, newline and carriage
+// return, which terminals and text renderers interpret rather than display.
+func isControl(r rune) bool {
+	return unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r'
+}
+
+// acceptPayload checks a payload published by the connection against the room's payload policy.
+// Rejected messages are dropped, and framed clients are told why with an error frame.
+func (h *MessageHandler) acceptPayload(conn *Connection, id, room, contentType string, payload []byte) bool {
+	if h.payloads == nil {
+		return true
+	}
+
+	reason := h.payloads.check(room, contentType, payload)
+	if reason == "" {
+		return true
+	}
+
+	metrics.MessagesDropped.WithLabelValues(reason).Inc()
+	h.logger.Warn("Rejecting payload violating the room's policy", zap.String("conn-id", conn.id), zap.String("id", id),
+		zap.String("room", room), zap.String("reason", reason))
+	if conn.framed {
+		frame := message.Frame{Type: message.FrameError, ID: id, Room: room, Reason: reason}
+		if data, err := frame.ToJSON(); err == nil {
+			h.writeControl(conn.id, data)
+		}
+	}
+	return false
+}
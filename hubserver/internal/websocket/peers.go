@@ -0,0 +1,179 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// peerStaleAfter is the number of heartbeat intervals of a peer after which it is reported stale
+	// when none of its heartbeats arrived
+	peerStaleAfter = 3
+	// peerForgetAfter is the number of heartbeat intervals of a peer after which it is forgotten,
+	// along with its metrics, so hubs scaled in don't linger
+	peerForgetAfter = 60
+)
+
+// heartbeat is the payload of the heartbeat envelopes hubs send each other. Numbers restart with
+// every run of a hub, told apart by its boot id.
+type heartbeat struct {
+	Boot     string `json:"boot"`
+	Seq      uint64 `json:"seq"`
+	SentAt   int64  `json:"sent_at"`
+	Interval int64  `json:"interval_ms"`
+}
+
+// PeerInfo describes another hub heard from through the broker: when its last heartbeat arrived
+// and how long it took, and the heartbeats received from it and lost since this hub started.
+type PeerInfo struct {
+	HubID    string    `json:"hub_id"`
+	Zone     string    `json:"zone,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+	DelayMS  float64   `json:"delay_ms"`
+	Received uint64    `json:"received"`
+	Lost     uint64    `json:"lost"`
+	Healthy  bool      `json:"healthy"`
+}
+
+// peerState is what the hub knows of a peer: its info and the last heartbeat received from it.
+type peerState struct {
+	info     PeerInfo
+	boot     string
+	seq      uint64
+	interval time.Duration
+}
+
+// peerHealth tracks the other hubs by the heartbeats they send through the broker.
+type peerHealth struct {
+	mu    sync.Mutex
+	peers map[string]*peerState
+}
+
+// observe records a heartbeat of another hub received at now. Heartbeats missing between two
+// received from the same run of a peer are counted lost; those arriving late or twice are ignored.
+func (p *peerHealth) observe(md message.MessageDetails, now time.Time) error {
+	var hb heartbeat
+	if err := json.Unmarshal(md.Message, &hb); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer, ok := p.peers[md.HubID]
+	if !ok {
+		peer = &peerState{info: PeerInfo{HubID: md.HubID}}
+		p.peers[md.HubID] = peer
+	}
+	switch {
+	case peer.boot != hb.Boot:
+		peer.boot = hb.Boot
+	case hb.Seq <= peer.seq:
+		return nil
+	case hb.Seq > peer.seq+1:
+		lost := hb.Seq - peer.seq - 1
+		peer.info.Lost += lost
+		metrics.PeerHeartbeatsLost.WithLabelValues(md.HubID).Add(float64(lost))
+	}
+	peer.seq = hb.Seq
+	peer.interval = time.Duration(hb.Interval) * time.Millisecond
+
+	delay := max(now.Sub(time.Unix(0, hb.SentAt)), 0)
+	peer.info.Zone = md.Zone
+	peer.info.LastSeen = now
+	peer.info.DelayMS = float64(delay) / float64(time.Millisecond)
+	peer.info.Received++
+	metrics.PeerPropagationSeconds.WithLabelValues(md.HubID).Observe(delay.Seconds())
+	return nil
+}
+
+// list returns the peers by hub id, forgetting those not heard from for peerForgetAfter of their
+// intervals, and reports the count of healthy and stale ones.
+func (p *peerHealth) list(now time.Time) []PeerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers := make([]PeerInfo, 0, len(p.peers))
+	healthy, stale := 0, 0
+	for hubID, peer := range p.peers {
+		silence := now.Sub(peer.info.LastSeen)
+		if silence > peerForgetAfter*peer.interval {
+			delete(p.peers, hubID)
+			metrics.PeerPropagationSeconds.DeleteLabelValues(hubID)
+			metrics.PeerHeartbeatsLost.DeleteLabelValues(hubID)
+			continue
+		}
+		info := peer.info
+		info.Healthy = silence <= peerStaleAfter*peer.interval
+		if info.Healthy {
+			healthy++
+		} else {
+			stale++
+		}
+		peers = append(peers, info)
+	}
+	metrics.PeerHubs.WithLabelValues("healthy").Set(float64(healthy))
+	metrics.PeerHubs.WithLabelValues("stale").Set(float64(stale))
+
+	slices.SortFunc(peers, func(a, b PeerInfo) int {
+		return strings.Compare(a.HubID, b.HubID)
+	})
+	return peers
+}
+
+// Peers returns the other hubs the hub received heartbeats from through the broker, by hub id.
+// Peers are healthy while their heartbeats keep arriving.
+func (h *MessageHandler) Peers() []PeerInfo {
+	if h.peers == nil {
+		return []PeerInfo{}
+	}
+	return h.peers.list(h.clock.Now())
+}
+
+// sendHeartbeats publishes a heartbeat to the other hubs every interval until ctx is done, and
+// refreshes the health of the peers heard from.
+func (h *MessageHandler) sendHeartbeats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	hb := heartbeat{Boot: uuid.NewString(), Interval: interval.Milliseconds()}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		hb.Seq++
+		hb.SentAt = h.clock.Now().UnixNano()
+		data, err := json.Marshal(hb)
+		if err != nil {
+			continue
+		}
+		md := message.NewHeartbeatMessageDetails(h.hubID, data)
+		md.Zone = h.zone
+		if err := h.broker.Publish(ctx, &md); err != nil && ctx.Err() == nil {
+			h.logger.Warn("Failed to publish heartbeat to broker", zap.Uint64("seq", hb.Seq), zap.Error(err))
+		}
+		h.peers.list(h.clock.Now())
+	}
+}
+
+// receiveHeartbeat records a heartbeat of another hub in the health of its peers.
+func (h *MessageHandler) receiveHeartbeat(md message.MessageDetails) {
+	if h.peers == nil {
+		return
+	}
+	if err := h.peers.observe(md, h.clock.Now()); err != nil {
+		h.logger.Warn("Dropping malformed heartbeat", zap.String("hub-id", md.HubID), zap.Error(err))
+	}
+}
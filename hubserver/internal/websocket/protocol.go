@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// protocolVersionParam is the query parameter of the upgrade request announcing the version of the
+// hub.v1 protocol the client speaks.
+const protocolVersionParam = "protocol_version"
+
+// Response headers announcing the protocol versions the hub supports on upgrades it rejects for an
+// unsupported version.
+const (
+	protocolVersionHeader    = "Hub-Protocol-Version"
+	minProtocolVersionHeader = "Hub-Min-Protocol-Version"
+)
+
+var errUnsupportedProtocol = errors.New("unsupported protocol version")
+
+// protocolPolicy decides which protocol versions hub.v1 clients may connect with. Raw clients
+// don't speak the protocol and are always accepted.
+type protocolPolicy struct {
+	// min is the lowest version accepted, and versions below deprecateBelow are warned about until
+	// cutoff, from which they are rejected too
+	min            int
+	deprecateBelow int
+	cutoff         time.Time
+}
+
+// minVersion returns the lowest version accepted at now.
+func (p protocolPolicy) minVersion(now time.Time) int {
+	if !p.cutoff.IsZero() && !now.Before(p.cutoff) {
+		return max(p.min, p.deprecateBelow)
+	}
+	return p.min
+}
+
+// requestedProtocol returns the protocol version an upgrade request announced, 0 when it announced
+// none or an invalid one, and whether the request asked for the hub.v1 subprotocol.
+func requestedProtocol(r *http.Request) (int, bool) {
+	framed := slices.Contains(websocket.Subprotocols(r), message.Subprotocol)
+	version, err := strconv.Atoi(r.URL.Query().Get(protocolVersionParam))
+	if err != nil || version < 0 {
+		return 0, framed
+	}
+	return version, framed
+}
+
+// checkProtocol rejects upgrade requests of hub.v1 clients speaking a protocol version the hub no
+// longer accepts, announcing the versions it supports in the response headers.
+func (h *MessageHandler) checkProtocol(w http.ResponseWriter, r *http.Request) error {
+	version, framed := requestedProtocol(r)
+	if !framed {
+		return nil
+	}
+	label := strconv.Itoa(version)
+	if version > message.ProtocolVersion {
+		// Versions are announced by clients, so unknown ones share a label
+		label = "unknown"
+	}
+	metrics.ProtocolVersions.WithLabelValues(label).Inc()
+
+	minVersion := h.protocol.minVersion(time.Now())
+	if version >= minVersion {
+		return nil
+	}
+	w.Header().Set(protocolVersionHeader, strconv.Itoa(message.ProtocolVersion))
+	w.Header().Set(minProtocolVersionHeader, strconv.Itoa(minVersion))
+	return errUnsupportedProtocol
+}
+
+// warnDeprecatedProtocol sends a deprecation frame to a connection whose protocol version the hub
+// will stop accepting, with the cut-off from which it does.
+func (h *MessageHandler) warnDeprecatedProtocol(conn *Connection) {
+	if conn.protocolVersion >= h.protocol.deprecateBelow {
+		return
+	}
+
+	frame := message.Frame{
+		Type:               message.FrameDeprecation,
+		HubID:              h.hubID,
+		ProtocolVersion:    conn.protocolVersion,
+		MinProtocolVersion: h.protocol.deprecateBelow,
+	}
+	if !h.protocol.cutoff.IsZero() {
+		frame.Cutoff = &h.protocol.cutoff
+	}
+	data, err := frame.ToJSON()
+	if err != nil {
+		conn.log().Error("Failed to marshal deprecation frame", zap.Error(err))
+		return
+	}
+	h.writeControl(conn.id, data)
+}
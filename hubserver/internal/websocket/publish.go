@@ -0,0 +1,187 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// httpPublisherID is the sender and origin of messages published over HTTP.
+	httpPublisherID = "http"
+	// maxPublishBodySize is the largest request body accepted by the HTTP publish endpoint.
+	maxPublishBodySize = 1 << 20
+	// publishResultTimeout bounds the wait for a message published over HTTP to be fanned out.
+	publishResultTimeout = 5 * time.Second
+)
+
+// PublishResult reports the fan-out of a message published over HTTP, or the reason it was rejected.
+type PublishResult struct {
+	ID string `json:"id"`
+	// LocalRecipients is the number of connections of this hub the message was queued for.
+	LocalRecipients int `json:"local_recipients"`
+	// Forwarded is set when the message was published to the other hubs, and Hubs is the number of
+	// hubs it reached, -1 when the broker cannot tell.
+	Forwarded bool `json:"forwarded"`
+	Hubs      int  `json:"hubs"`
+	// Rejected is the reason the message was not published: forbidden, replay_rejected,
+	// user_rate_limited or a payload policy violation.
+	Rejected string `json:"rejected,omitempty"`
+}
+
+// publishRequest is the body of a message published over HTTP.
+type publishRequest struct {
+	ID          string          `json:"id"`
+	Payload     json.RawMessage `json:"payload"`
+	ContentType string          `json:"content_type"`
+	Key         string          `json:"key"`
+	RoutingKey  string          `json:"routing_key"`
+}
+
+// ServePublish serves POST /rooms/:room/messages, publishing the payload of the JSON body to the
+// room, or the room its routing key is routed to, as the requesting user. Publishes are held to the
+// room's access control, payload policy and the user message rate; the response reports the
+// message's fan-out once the hub has broadcast it.
+func (h *MessageHandler) ServePublish(w http.ResponseWriter, r *http.Request, room string) {
+	remoteIP, ok := h.admitRequest(w, r)
+	if !ok {
+		return
+	}
+	defer h.ipFilter.Release(remoteIP)
+
+	identity, err := h.authenticator.Authenticate(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var req publishRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPublishBodySize)).Decode(&req); err != nil {
+		http.Error(w, "invalid publish request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Payload) == 0 {
+		http.Error(w, "payload is required", http.StatusBadRequest)
+		return
+	}
+
+	result := PublishResult{ID: req.ID}
+	if result.ID == "" {
+		result.ID = uuid.New().String()
+	}
+	// The room of the path is the fallback of routing keys without a rule, and the publisher must be
+	// allowed to publish to it as well as to the room it is routed to
+	named := room
+	room, _ = h.resolveRoute(req.RoutingKey, room)
+
+	switch {
+	case !h.authorizeRoom(r.Context(), identity, "", named, config.RoomPublish) ||
+		named != room && !h.authorizeRoom(r.Context(), identity, "", room, config.RoomPublish):
+		result.Rejected = "forbidden"
+		h.writePublishResult(w, http.StatusForbidden, result)
+		return
+	case h.replay != nil && (h.replay.protects(room) || h.replay.protects(named)):
+		// HTTP publishers have no way of signing their publishes
+		metrics.MessagesDropped.WithLabelValues("replay_rejected").Inc()
+		result.Rejected = "replay_rejected"
+		h.writePublishResult(w, http.StatusForbidden, result)
+		return
+	case !h.allowUserMessage(r.Context(), httpPublisherID, rateLimitKey(identity, remoteIP)):
+		result.Rejected = "user_rate_limited"
+		h.writePublishResult(w, http.StatusTooManyRequests, result)
+		return
+	}
+	if h.payloads != nil {
+		if reason := h.payloads.check(room, req.ContentType, req.Payload); reason != "" {
+			metrics.MessagesDropped.WithLabelValues(reason).Inc()
+			result.Rejected = reason
+			h.writePublishResult(w, http.StatusUnprocessableEntity, result)
+			return
+		}
+	}
+
+	md := message.NewMessageDetails(httpPublisherID, h.hubID, httpPublisherID, req.Payload)
+	md.ID = result.ID
+	md.Room = room
+	md.ContentType = req.ContentType
+	md.Key = req.Key
+	h.annotate(r.Context(), Sender{Identity: identity, RemoteIP: remoteIP, Header: r.Header, Room: room}, &md)
+	if result, err = h.publishAndWait(r.Context(), md); err != nil {
+		h.logger.Warn("Failed to publish message received over HTTP", zap.String("id", md.ID), zap.String("room", room), zap.Error(err))
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	h.writePublishResult(w, http.StatusOK, result)
+}
+
+// writePublishResult writes the result of a message published over HTTP.
+func (h *MessageHandler) writePublishResult(w http.ResponseWriter, status int, result PublishResult) {
+	// Publishers authenticate with a bearer token rather than cookies, as history readers do.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.logger.Warn("Failed to write publish result", zap.String("id", result.ID), zap.Error(err))
+	}
+}
+
+// PublishAndWait publishes a message from inside the hub's process like Publish, and waits until the
+// broadcast workers have fanned it out, for publishers that only let go of a message once it is.
+func (h *MessageHandler) PublishAndWait(ctx context.Context, md message.MessageDetails) (PublishResult, error) {
+	return h.publishAndWait(ctx, md)
+}
+
+// publishAndWait publishes a message and waits until the broadcast workers have fanned it out.
+func (h *MessageHandler) publishAndWait(ctx context.Context, md message.MessageDetails) (PublishResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, publishResultTimeout)
+	defer cancel()
+
+	result := make(chan PublishResult, 1)
+	h.publishResultsMu.Lock()
+	h.publishResults[md.ID] = result
+	h.publishResultsMu.Unlock()
+	defer func() {
+		h.publishResultsMu.Lock()
+		delete(h.publishResults, md.ID)
+		h.publishResultsMu.Unlock()
+	}()
+
+	if err := h.Publish(ctx, md); err != nil {
+		return PublishResult{}, fmt.Errorf("failed to publish message: %w", err)
+	}
+	select {
+	case r := <-result:
+		return r, nil
+	case <-ctx.Done():
+		return PublishResult{}, fmt.Errorf("message was not broadcast in time: %w", ctx.Err())
+	}
+}
+
+// reportPublish hands the fan-out of a broadcast message to the HTTP publisher waiting for it, if any.
+func (h *MessageHandler) reportPublish(md message.MessageDetails, delivered int, forwarded bool, hubs int) {
+	if md.IsFromPubSub(h.pubSubChannel) {
+		return
+	}
+
+	h.publishResultsMu.Lock()
+	result, ok := h.publishResults[md.ID]
+	delete(h.publishResults, md.ID)
+	h.publishResultsMu.Unlock()
+	if !ok {
+		return
+	}
+	result <- PublishResult{ID: md.ID, LocalRecipients: delivered, Forwarded: forwarded, Hubs: hubs}
+}
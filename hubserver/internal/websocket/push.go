@@ -0,0 +1,18 @@
+package websocket
+
+import "github.com/soumya-codes/realtime-hub/hubserver/internal/push"
+
+// AddPushSubscription registers an HTTP callback receiving the messages published to a room.
+func (h *MessageHandler) AddPushSubscription(room, callbackURL, secret string) (push.Subscription, error) {
+	return h.push.Subscribe(room, callbackURL, secret)
+}
+
+// RemovePushSubscription removes a push subscription and reports whether it existed.
+func (h *MessageHandler) RemovePushSubscription(id string) bool {
+	return h.push.Unsubscribe(id)
+}
+
+// PushSubscriptions returns the registered push subscriptions.
+func (h *MessageHandler) PushSubscriptions() []push.Subscription {
+	return h.push.Subscriptions()
+}
@@ -0,0 +1,168 @@
+package websocket
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync/atomic"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// queuedOverhead approximates the bytes a queued message takes besides its payload: the message
+// details and their strings.
+const queuedOverhead = 512
+
+// queueMemory accounts for the bytes of the messages queued on the write channels of all the
+// connections of the hub, and caps them at limit: once they exceed it, the connections with the
+// largest queues are closed as slow consumers, so many clients stalling at once cannot exhaust the
+// heap. Their clients reconnect and catch up from the rooms' history.
+//
+// The copies of a broadcast queued for many connections share its payload, so a payload is
+// accounted once while any copy of it is queued, as counted by the broadcast's shared frame, and
+// each copy adds queuedOverhead.
+type queueMemory struct {
+	limit int64
+	used  atomic.Int64
+
+	// over wakes the loop enforcing the limit
+	over chan struct{}
+}
+
+// newQueueMemory returns the accounting of the bytes queued for connections capped at limit, or
+// nil when there is no cap to enforce and the bytes are not accounted for.
+func newQueueMemory(limit int64) *queueMemory {
+	if limit <= 0 {
+		return nil
+	}
+	return &queueMemory{limit: limit, over: make(chan struct{}, 1)}
+}
+
+// usedBytes returns the bytes queued for all connections, or 0 when they are not accounted for.
+func (m *queueMemory) usedBytes() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.used.Load()
+}
+
+// queuedSize returns the bytes a queued copy of a message references: its payload and overhead.
+// They are what a connection's queue is charged for when choosing the connections to close.
+func queuedSize(md *message.MessageDetails) int64 {
+	return int64(len(md.Message)) + queuedOverhead
+}
+
+// add accounts for a message queued for the connection, which holds its queueMu.
+func (m *queueMemory) add(c *Connection, md *message.MessageDetails) {
+	if m == nil {
+		return
+	}
+	c.queuedBytes += queuedSize(md)
+	size := queuedSize(md)
+	if md.Frame != nil && !md.Frame.Queue() {
+		size = queuedOverhead
+	}
+	if m.used.Add(size) > m.limit {
+		select {
+		case m.over <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// remove accounts for a message taken from the connection's queue, which holds its queueMu.
+func (m *queueMemory) remove(c *Connection, md *message.MessageDetails) {
+	if m == nil {
+		return
+	}
+	c.queuedBytes -= queuedSize(md)
+	size := queuedSize(md)
+	if md.Frame != nil && !md.Frame.Dequeue() {
+		size = queuedOverhead
+	}
+	m.used.Add(-size)
+}
+
+// releaseQueue drops the messages queued for a connection removed from the hub, which are not
+// delivered, and stops the connection from queueing more, so the payloads they shared with the
+// queues of other connections are no longer accounted for it.
+func (c *Connection) releaseQueue() {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if c.queueReleased {
+		return
+	}
+	c.queueReleased = true
+	for {
+		select {
+		case md := <-c.writeCh:
+			c.memory.remove(c, &md)
+		default:
+			return
+		}
+	}
+}
+
+// queueBytes returns the bytes of the messages queued for the connection.
+func (c *Connection) queueBytes() int64 {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	return c.queuedBytes
+}
+
+// enforceQueueMemory closes the connections with the largest queues whenever the bytes queued for
+// all connections exceed their cap, until the context is done.
+func (h *MessageHandler) enforceQueueMemory(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.queueMemory.over:
+			h.shedQueueMemory()
+		}
+	}
+}
+
+// shedQueueMemory closes connections, largest queue first, until the bytes queued for the
+// remaining ones are within the cap.
+func (h *MessageHandler) shedQueueMemory() {
+	m := h.queueMemory
+	if m.used.Load() <= m.limit {
+		return
+	}
+
+	type queue struct {
+		conn  *Connection
+		bytes int64
+	}
+	h.mu.RLock()
+	queues := make([]queue, 0, len(h.connections))
+	for _, conn := range h.connections {
+		if bytes := conn.queueBytes(); bytes > 0 {
+			queues = append(queues, queue{conn, bytes})
+		}
+	}
+	h.mu.RUnlock()
+	slices.SortFunc(queues, func(a, b queue) int {
+		return cmp.Compare(b.bytes, a.bytes)
+	})
+
+	for _, q := range queues {
+		if m.used.Load() <= m.limit {
+			return
+		}
+		if _, ok := h.detach(q.conn.id); !ok {
+			continue
+		}
+		metrics.QueueMemoryEvictions.Inc()
+		h.logger.Warn("Queued messages exceed the memory cap, closing the connection with the largest queue",
+			zap.String("conn-id", q.conn.id), zap.String("user-id", q.conn.identity.UserID), zap.Int64("queued-bytes", q.bytes),
+			zap.Int64("max-queued-bytes", m.limit))
+		if err := q.conn.CloseWithReason(message.CloseEvicted, h.closeReason(message.ReasonSlowConsumer)); err != nil {
+			h.logger.Warn("Failed to close slow consumer", zap.String("conn-id", q.conn.id), zap.Error(err))
+		}
+	}
+}
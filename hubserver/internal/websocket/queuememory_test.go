@@ -0,0 +1,55 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+func TestQueueMemoryCountsSharedPayloadsOnce(t *testing.T) {
+	memory := newQueueMemory(1 << 20)
+	queue := func() *Connection {
+		return &Connection{writeCh: make(chan message.MessageDetails, 4), queueSize: 2, memory: memory}
+	}
+	first, second := queue(), queue()
+	md := message.MessageDetails{Message: make([]byte, 1000), Frame: &message.SharedFrame{}}
+
+	first.offer(md)
+	second.offer(md)
+	if used, want := memory.used.Load(), int64(1000+2*queuedOverhead); used != want {
+		t.Fatalf("two copies of a broadcast use %d bytes, want %d", used, want)
+	}
+	if first.queuedBytes != 1000+queuedOverhead || second.queuedBytes != 1000+queuedOverhead {
+		t.Fatalf("connections charged %d and %d bytes", first.queuedBytes, second.queuedBytes)
+	}
+
+	first.dequeued(<-first.writeCh)
+	if used, want := memory.used.Load(), int64(1000+queuedOverhead); used != want {
+		t.Fatalf("the copy left uses %d bytes, want %d", used, want)
+	}
+	second.releaseQueue()
+	if used := memory.used.Load(); used != 0 {
+		t.Fatalf("released queues use %d bytes", used)
+	}
+	if second.offer(md) {
+		t.Fatal("released connection queued a message")
+	}
+
+	// Messages queued for a single connection carry no shared frame
+	first.offer(message.MessageDetails{Message: make([]byte, 1000)})
+	first.offer(message.MessageDetails{Message: make([]byte, 1000)})
+	if used, want := memory.used.Load(), int64(2*(1000+queuedOverhead)); used != want {
+		t.Fatalf("two unshared messages use %d bytes, want %d", used, want)
+	}
+}
+
+func TestQueueMemoryIsNotAccountedWithoutACap(t *testing.T) {
+	memory := newQueueMemory(0)
+	conn := &Connection{writeCh: make(chan message.MessageDetails, 4), queueSize: 2, memory: memory}
+	if !conn.offer(message.MessageDetails{Message: make([]byte, 1000), Frame: &message.SharedFrame{}}) {
+		t.Fatal("message not queued")
+	}
+	if memory.usedBytes() != 0 || conn.queuedBytes != 0 {
+		t.Fatalf("uncapped queues accounted %d bytes, %d for the connection", memory.usedBytes(), conn.queuedBytes)
+	}
+}
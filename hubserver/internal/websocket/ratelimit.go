@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// keyLimiter takes a token from the bucket of a key and reports whether one was left.
+type keyLimiter interface {
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+// rateLimitKey returns the key whose message rate a sender counts towards: its user when it is
+// authenticated, otherwise its client IP.
+func rateLimitKey(identity auth.Identity, remoteIP netip.Addr) string {
+	if !identity.IsAnonymous() {
+		return "user:" + identity.UserID
+	}
+	return "ip:" + remoteIP.String()
+}
+
+// allowUserMessage reports whether the sender with the rate limit key may send another message
+// under the user message rate. Messages are allowed when the shared limiter cannot be reached,
+// leaving the per-connection quotas in place.
+func (h *MessageHandler) allowUserMessage(ctx context.Context, connID, key string) bool {
+	if h.userLimiter == nil {
+		return true
+	}
+
+	allowed, err := h.userLimiter.Allow(ctx, key)
+	if err != nil {
+		h.logger.Warn("Failed to check the user message rate, allowing message", zap.String("conn-id", connID), zap.Error(err))
+		return true
+	}
+	if !allowed {
+		metrics.MessagesDropped.WithLabelValues("user_rate_limited").Inc()
+		h.logger.Warn("User exceeded its message rate, dropping message", zap.String("conn-id", connID), zap.String("key", key))
+	}
+	return allowed
+}
+
+// memoryLimiter keeps a rate limiter per key in process memory, for hubs running without Redis.
+type memoryLimiter struct {
+	rate  rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	limiters  map[string]*rate.Limiter
+	lastPrune time.Time
+}
+
+func newMemoryLimiter(messagesPerSecond float64, burst int) *memoryLimiter {
+	return &memoryLimiter{
+		rate:     rate.Limit(messagesPerSecond),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+func (m *memoryLimiter) Allow(_ context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Limiters whose bucket refilled completely behave like new ones and can be dropped
+	now := time.Now()
+	refill := time.Duration(float64(m.burst) / float64(m.rate) * float64(time.Second))
+	if now.Sub(m.lastPrune) > refill {
+		for k, limiter := range m.limiters {
+			if limiter.TokensAt(now) >= float64(m.burst) {
+				delete(m.limiters, k)
+			}
+		}
+		m.lastPrune = now
+	}
+
+	limiter, ok := m.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(m.rate, m.burst)
+		m.limiters[key] = limiter
+	}
+	return limiter.AllowN(now, 1), nil
+}
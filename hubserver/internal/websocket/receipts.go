@@ -0,0 +1,168 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// sendDeliveryReceipt notifies the originating connection of how many connections on this hub the message was queued for.
+func (h *MessageHandler) sendDeliveryReceipt(ctx context.Context, md message.MessageDetails, delivered int) {
+	if !md.Receipt {
+		return
+	}
+
+	h.sendControl(ctx, md.OriginID, message.Frame{
+		Type:   message.FrameReceipt,
+		ID:     md.ID,
+		HubID:  h.hubID,
+		Status: message.ReceiptDelivered,
+		Count:  delivered,
+	})
+}
+
+// sendNack notifies the originating connection of a message that asked for it that the message
+// reached no connection: none of this hub's took it, shed by those whose queues were full, and no
+// other hub received it. Messages that reached other hubs are never nacked, as their connections
+// are unknown here.
+func (h *MessageHandler) sendNack(ctx context.Context, md message.MessageDetails, delivered, shed int, forwarded bool, hubs int) {
+	if !md.Nack || delivered > 0 || (forwarded && hubs != 0) || md.IsFromPubSub(h.pubSubChannel) {
+		return
+	}
+
+	reason := message.NackNoRecipients
+	if shed > 0 {
+		reason = message.NackQueuesFull
+	}
+	metrics.Nacks.WithLabelValues(reason).Inc()
+	h.sendControl(ctx, md.OriginID, message.Frame{
+		Type:   message.FrameNack,
+		ID:     md.ID,
+		HubID:  h.hubID,
+		Room:   md.Room,
+		Reason: reason,
+	})
+}
+
+// maxReceiptOrigins is the number of the last messages asking for receipts written to a connection
+// it can acknowledge.
+const maxReceiptOrigins = 1024
+
+// receiptOrigins remembers the originating connections of the last maxReceiptOrigins messages asking
+// for receipts written to a connection, by message id, so its acks are relayed to the publisher of
+// the message they acknowledge rather than to a connection the client names.
+type receiptOrigins struct {
+	mu      sync.Mutex
+	origins map[string]string
+	// ids holds the ids of origins in the order they were written, as a ring starting at next
+	ids  []string
+	next int
+}
+
+// record remembers the origin of a message written to the client, when it asks for receipts.
+func (r *receiptOrigins) record(md *message.MessageDetails) {
+	if !md.Receipt || md.ID == "" || md.OriginID == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.origins[md.ID]; ok {
+		r.origins[md.ID] = md.OriginID
+		return
+	}
+	if r.origins == nil {
+		r.origins = make(map[string]string)
+	}
+	if len(r.ids) < maxReceiptOrigins {
+		r.ids = append(r.ids, md.ID)
+	} else {
+		delete(r.origins, r.ids[r.next])
+		r.ids[r.next] = md.ID
+		r.next = (r.next + 1) % maxReceiptOrigins
+	}
+	r.origins[md.ID] = md.OriginID
+}
+
+// origin returns the originating connection of a message written to the client.
+func (r *receiptOrigins) origin(id string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	origin, ok := r.origins[id]
+	return origin, ok
+}
+
+// relayAck forwards a recipient's acknowledgment of a message to the message's originating connection
+// as a read receipt. The origin is the one of the message written to the recipient: acks of messages
+// the connection was not written, or no longer remembers, are dropped.
+func (h *MessageHandler) relayAck(ctx context.Context, conn *Connection, ack message.Frame) {
+	if !h.deliveryReceipts || !conn.capabilities.has(capAcks) {
+		return
+	}
+
+	if ack.ID == "" {
+		h.logger.Warn("Ack frame missing message id", zap.String("conn-id", conn.id))
+		return
+	}
+	origin, ok := conn.receipts.origin(ack.ID)
+	if !ok {
+		h.logger.Debug("Dropping ack of a message not written to the connection", zap.String("conn-id", conn.id), zap.String("id", ack.ID))
+		return
+	}
+
+	h.sendControl(ctx, origin, message.Frame{
+		Type:        message.FrameReceipt,
+		ID:          ack.ID,
+		HubID:       h.hubID,
+		Status:      message.ReceiptRead,
+		RecipientID: conn.id,
+	})
+}
+
+// sendControl delivers a control frame to the target connection, publishing it to the
+// other hubs through the broker when the connection is not registered on this hub.
+func (h *MessageHandler) sendControl(ctx context.Context, targetID string, frame message.Frame) {
+	data, err := frame.ToJSON()
+	if err != nil {
+		h.logger.Error("Failed to encode control frame", zap.String("type", frame.Type), zap.Error(err))
+		return
+	}
+
+	if h.writeControl(targetID, data) {
+		return
+	}
+
+	md := message.NewControlMessageDetails(h.hubID, targetID, data)
+	if err := h.broker.Publish(ctx, &md); err != nil {
+		h.logger.Error("Failed to publish control frame to broker", zap.String("target-id", targetID), zap.Error(err))
+	}
+}
+
+// writeControl queues an encoded control frame on a local connection. It reports whether the
+// connection is registered on this hub, even if it had to be closed as a slow consumer because its
+// control channel was full.
+func (h *MessageHandler) writeControl(connID string, data []byte) bool {
+	h.mu.RLock()
+	conn, ok := h.connections[connID]
+	h.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	if !conn.framed {
+		return true
+	}
+
+	select {
+	case conn.controlCh <- data:
+	default:
+		// Callers may hold locks that closing the connection needs
+		go h.shedSlowConsumer(conn)
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// allRooms in the replay protected rooms protects every room, including messages published without one.
+const allRooms = "*"
+
+var (
+	errUnsignedPublish = errors.New("publish to a replay protected room must be signed by an authenticated user")
+	errBadSignature    = errors.New("publish signature does not match")
+	errStaleNonce      = errors.New("publish timestamp is outside the replay window")
+	errReplayedNonce   = errors.New("publish nonce was already used")
+)
+
+// nonceClaimer records nonces and reports whether they were unused.
+type nonceClaimer interface {
+	Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// replayGuard verifies signed publishes to replay protected rooms. Publishers sign the room, the
+// routing key, the message id, a nonce, a millisecond timestamp and the payload with a key derived from the
+// signing secret and their user id; the hub rejects bad signatures, timestamps outside the
+// replay window and nonces it has already seen within it.
+type replayGuard struct {
+	secret []byte
+	window time.Duration
+	rooms  []string
+	nonces nonceClaimer
+}
+
+// protects reports whether publishes to the room must be signed.
+func (g *replayGuard) protects(room string) bool {
+	return slices.Contains(g.rooms, allRooms) || (room != "" && slices.Contains(g.rooms, room))
+}
+
+// verify checks the signature and nonce of a publish to a protected room.
+func (g *replayGuard) verify(ctx context.Context, identity auth.Identity, frame message.Frame, payload []byte) error {
+	if identity.IsAnonymous() || frame.Signature == "" || frame.Nonce == "" {
+		return errUnsignedPublish
+	}
+
+	signature, err := hex.DecodeString(frame.Signature)
+	if err != nil || !hmac.Equal(signature, g.sign(identity.UserID, frame, payload)) {
+		return errBadSignature
+	}
+
+	age := time.Since(time.UnixMilli(frame.Timestamp))
+	if age > g.window || age < -g.window {
+		return errStaleNonce
+	}
+
+	// Nonces only need to be remembered for as long as their timestamp is accepted.
+	claimed, err := g.nonces.Claim(ctx, identity.UserID+":"+frame.Nonce, 2*g.window)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return errReplayedNonce
+	}
+	return nil
+}
+
+// sign returns the signature of a publish for the user.
+func (g *replayGuard) sign(userID string, frame message.Frame, payload []byte) []byte {
+	key := hmac.New(sha256.New, g.secret)
+	key.Write([]byte(userID))
+
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%d\n", frame.Room, frame.RoutingKey, frame.ID, frame.Nonce, frame.Timestamp)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// memoryNonces remembers nonces in process memory, for hubs running without Redis.
+type memoryNonces struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newMemoryNonces() *memoryNonces {
+	return &memoryNonces{seen: make(map[string]time.Time)}
+}
+
+func (m *memoryNonces) Claim(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastPrune) > ttl {
+		for seen, expires := range m.seen {
+			if now.After(expires) {
+				delete(m.seen, seen)
+			}
+		}
+		m.lastPrune = now
+	}
+
+	if expires, ok := m.seen[nonce]; ok && now.Before(expires) {
+		return false, nil
+	}
+	m.seen[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+// verifyPublish reports whether a message frame routed to its room may be published, dropping
+// publishes to replay protected rooms that fail verification. A routed publish is protected when
+// either the room it named or the one it was routed to is, and is verified against the room it
+// named, which the publisher signed.
+func (h *MessageHandler) verifyPublish(ctx context.Context, conn *Connection, frame message.Frame, named string, payload []byte) bool {
+	if h.replay == nil || !h.replay.protects(frame.Room) && !h.replay.protects(named) {
+		return true
+	}
+
+	signed := frame
+	signed.Room = named
+	if err := h.replay.verify(ctx, conn.identity, signed, payload); err != nil {
+		metrics.MessagesDropped.WithLabelValues("replay_rejected").Inc()
+		h.logger.Warn("Dropping publish that failed replay verification", zap.String("conn-id", conn.id), zap.String("id", frame.ID), zap.String("room", frame.Room), zap.Error(err))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/hubtest"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+// replayConfig returns the config of a hub protecting the orders-eu room, to which the orders.eu
+// routing keys are routed.
+func replayConfig() *config.Config {
+	cfg := testConfig()
+	cfg.PublishSigningSecret = "publish-signing-secret"
+	cfg.ReplayProtectedRooms = []string{"orders-eu"}
+	cfg.RoutingRule = []string{"orders.eu.*=orders-eu"}
+	return cfg
+}
+
+// signed returns the message frame signed by the user with a fresh nonce.
+func signed(h *MessageHandler, userID string, frame message.Frame) message.Frame {
+	frame.Nonce = uuid.New().String()
+	return signedAt(h.replay, userID, frame, time.Now())
+}
+
+// signedAt returns the message frame signed by the user at the time.
+func signedAt(g *replayGuard, userID string, frame message.Frame, at time.Time) message.Frame {
+	frame.Type = message.FrameMessage
+	frame.Timestamp = at.UnixMilli()
+	frame.Signature = hex.EncodeToString(g.sign(userID, frame, frame.Payload))
+	return frame
+}
+
+func TestReplayGuardVerifiesPublishes(t *testing.T) {
+	g := &replayGuard{secret: []byte("publish-signing-secret"), window: time.Minute, rooms: []string{"orders"}, nonces: newMemoryNonces()}
+	alice := auth.Identity{UserID: "alice"}
+	now := time.Now()
+	order := message.Frame{ID: "order-1", Room: "orders", Nonce: "nonce-1", Payload: []byte(`{"qty":1}`)}
+	withNonce := func(nonce string) message.Frame { f := order; f.Nonce = nonce; return f }
+
+	for _, tc := range []struct {
+		name     string
+		identity auth.Identity
+		frame    message.Frame
+		err      error
+	}{
+		{"valid publish", alice, signedAt(g, "alice", order, now), nil},
+		{"reused nonce", alice, signedAt(g, "alice", order, now.Add(time.Second)), errReplayedNonce},
+		{"nonce of another user", auth.Identity{UserID: "bob"}, signedAt(g, "bob", order, now), nil},
+		{"timestamp before the window", alice, signedAt(g, "alice", withNonce("nonce-2"), now.Add(-2*time.Minute)), errStaleNonce},
+		{"timestamp after the window", alice, signedAt(g, "alice", withNonce("nonce-3"), now.Add(2*time.Minute)), errStaleNonce},
+		{"timestamp within the window", alice, signedAt(g, "alice", withNonce("nonce-4"), now.Add(-30*time.Second)), nil},
+		{"key of another user", alice, signedAt(g, "bob", withNonce("nonce-5"), now), errBadSignature},
+		{"tampered payload", alice, func() message.Frame {
+			f := signedAt(g, "alice", withNonce("nonce-6"), now)
+			f.Payload = []byte(`{"qty":100}`)
+			return f
+		}(), errBadSignature},
+		{"tampered timestamp", alice, func() message.Frame {
+			f := signedAt(g, "alice", withNonce("nonce-7"), now)
+			f.Timestamp++
+			return f
+		}(), errBadSignature},
+		{"anonymous publisher", auth.Identity{}, signedAt(g, "", withNonce("nonce-8"), now), errUnsignedPublish},
+		{"missing nonce", alice, signedAt(g, "alice", withNonce(""), now), errUnsignedPublish},
+		{"missing signature", alice, withNonce("nonce-9"), errUnsignedPublish},
+	} {
+		if err := g.verify(context.Background(), tc.identity, tc.frame, tc.frame.Payload); !errors.Is(err, tc.err) {
+			t.Errorf("%s: verify = %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+// publisher attaches a connection publishing as the user.
+func publisher(t *testing.T, h *MessageHandler, identity auth.Identity) *hubtest.Conn {
+	t.Helper()
+
+	ws := hubtest.NewConn(message.Subprotocol)
+	if _, err := h.Attach(ws, identity, nil); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	return ws
+}
+
+func TestRoutedPublishesAreVerifiedAgainstTheRoomTheyName(t *testing.T) {
+	cfg := replayConfig()
+	cfg.RoomACLs = []string{"orders:publish=trader"}
+	h, _ := startHubWith(t, cfg)
+	_, member := attach(t, h, message.Subprotocol, "orders-eu")
+	alice := publisher(t, h, auth.Identity{UserID: "alice", Roles: []string{"trader"}})
+	bob := publisher(t, h, auth.Identity{UserID: "bob"})
+
+	order := func(id string) message.Frame {
+		return message.Frame{ID: id, Room: "orders", RoutingKey: "orders.eu.1", Payload: []byte(`{"qty":1}`)}
+	}
+	rerouted := signed(h, "alice", order("order-1"))
+	rerouted.RoutingKey = "orders.eu.2"
+	for ws, frame := range map[*hubtest.Conn]message.Frame{
+		alice: rerouted,
+		// bob may not publish to the room he names
+		bob: signed(h, "bob", order("order-2")),
+	} {
+		if err := ws.SendJSON(frame); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if err := alice.SendJSON(signed(h, "alice", order("order-3"))); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if frame := receiveFrame(t, member, message.FrameMessage); frame.ID != "order-3" || frame.Room != "orders-eu" {
+		t.Fatalf("member received %+v, want order-3 routed to orders-eu", frame)
+	}
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+)
+
+// otherRooms labels the per-room metrics of rooms that are not tracked individually.
+const otherRooms = "_other"
+
+// roomMetrics chooses the label of each room in the per-room metrics, bounding their cardinality.
+// Listed rooms are labelled by name, as are the first limit rooms seen when every room is tracked;
+// the remaining rooms share the otherRooms label. A nil roomMetrics records nothing.
+type roomMetrics struct {
+	rooms []string
+	all   bool
+	limit int
+
+	mu      sync.Mutex
+	tracked map[string]struct{}
+}
+
+// newRoomMetrics creates the per-room metrics for the listed rooms, or nil when none is listed.
+// The room * tracks every room, up to limit rooms.
+func newRoomMetrics(rooms []string, limit int) *roomMetrics {
+	if len(rooms) == 0 {
+		return nil
+	}
+	return &roomMetrics{
+		rooms:   rooms,
+		all:     slices.Contains(rooms, "*"),
+		limit:   limit,
+		tracked: make(map[string]struct{}),
+	}
+}
+
+// label returns the label of a room, or an empty string for messages published without a room.
+func (m *roomMetrics) label(room string) string {
+	if room == "" {
+		return ""
+	}
+	if slices.Contains(m.rooms, room) {
+		return room
+	}
+	if !m.all {
+		return otherRooms
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tracked[room]; ok {
+		return room
+	}
+	if len(m.tracked) >= m.limit {
+		return otherRooms
+	}
+	m.tracked[room] = struct{}{}
+	return room
+}
+
+// broadcast records a message broadcast to its room and queued for delivered subscribers.
+func (m *roomMetrics) broadcast(room string, delivered int) {
+	if m == nil {
+		return
+	}
+	if label := m.label(room); label != "" {
+		metrics.RoomMessages.WithLabelValues(label).Inc()
+		metrics.RoomDeliveries.WithLabelValues(label).Add(float64(delivered))
+	}
+}
+
+// dropped records a message of the room dropped for a subscriber.
+func (m *roomMetrics) dropped(room string) {
+	if m == nil {
+		return
+	}
+	if label := m.label(room); label != "" {
+		metrics.RoomDrops.WithLabelValues(label).Inc()
+	}
+}
+
+// reportSubscribers replaces the subscriber gauges with the number of connections subscribed to each room.
+func (m *roomMetrics) reportSubscribers(subscribers map[string]int) {
+	if m == nil {
+		return
+	}
+
+	counts := make(map[string]int, len(subscribers))
+	for room, n := range subscribers {
+		counts[m.label(room)] += n
+	}
+	metrics.RoomSubscribers.Reset()
+	for label, n := range counts {
+		metrics.RoomSubscribers.WithLabelValues(label).Set(float64(n))
+	}
+}
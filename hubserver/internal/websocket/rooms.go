@@ -0,0 +1,245 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+var (
+	errRoomLimit     = errors.New("connection is subscribed to the maximum number of rooms")
+	errUserRoomLimit = errors.New("user is subscribed to the maximum number of rooms")
+)
+
+// userRooms counts the connections of each user on the hub subscribed to each room, holding the
+// user's connections together to at most max distinct rooms.
+type userRooms struct {
+	max int
+
+	mu sync.Mutex
+	// rooms holds the number of the user's connections subscribed to each room, by user id
+	rooms map[string]map[string]int
+}
+
+// newUserRooms returns the counts of the rooms of the users, or nil when they are not limited.
+func newUserRooms(max int) *userRooms {
+	if max <= 0 {
+		return nil
+	}
+	return &userRooms{max: max, rooms: make(map[string]map[string]int)}
+}
+
+// acquire counts a connection of the user subscribed to the room, unless the user's connections
+// are subscribed to the maximum number of other rooms. Anonymous connections are not counted.
+func (u *userRooms) acquire(userID, room string) error {
+	if u == nil || userID == "" {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	rooms := u.rooms[userID]
+	if rooms[room] == 0 && len(rooms) >= u.max {
+		return errUserRoomLimit
+	}
+	if rooms == nil {
+		rooms = make(map[string]int)
+		u.rooms[userID] = rooms
+	}
+	rooms[room]++
+	return nil
+}
+
+// release uncounts a connection of the user subscribed to the room.
+func (u *userRooms) release(userID, room string) {
+	if u == nil || userID == "" {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	rooms := u.rooms[userID]
+	if rooms[room] > 1 {
+		rooms[room]--
+		return
+	}
+	delete(rooms, room)
+	if len(rooms) == 0 {
+		delete(u.rooms, userID)
+	}
+}
+
+// join subscribes the connection to a room.
+func (c *Connection) join(room string) error {
+	return c.joinHeld(room, false)
+}
+
+// joinHeld subscribes the connection to a room, holding back the room's messages until release
+// when hold is set and the connection was not subscribed yet.
+func (c *Connection) joinHeld(room string, hold bool) error {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	if _, ok := c.rooms[room]; ok {
+		return nil
+	}
+	if c.maxRooms > 0 && len(c.rooms) >= c.maxRooms {
+		return errRoomLimit
+	}
+	if !c.roomsReleased {
+		if err := c.userRooms.acquire(c.identity.UserID, room); err != nil {
+			return err
+		}
+		c.lifecycle.joined(room)
+	}
+	c.rooms[room] = 0
+	if hold {
+		c.held[room] = []message.MessageDetails{}
+	}
+	return nil
+}
+
+// leave unsubscribes the connection from a room.
+func (c *Connection) leave(room string) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	if _, ok := c.rooms[room]; ok && !c.roomsReleased {
+		c.userRooms.release(c.identity.UserID, room)
+		c.lifecycle.left(room)
+	}
+	delete(c.rooms, room)
+	delete(c.cursors, room)
+	delete(c.held, room)
+	delete(c.filters, room)
+	c.patches.forget(room)
+}
+
+// releaseRooms uncounts the connection's rooms from its user's and the hub's once it is removed
+// from the hub. The rooms stay subscribed, so the ones of a draining connection can still be
+// handed off.
+func (c *Connection) releaseRooms() {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	if c.roomsReleased {
+		return
+	}
+	c.roomsReleased = true
+	for room := range c.rooms {
+		c.userRooms.release(c.identity.UserID, room)
+		c.lifecycle.left(room)
+	}
+}
+
+// rejectJoin counts a join refused for exceeding a subscription limit and tells framed clients
+// with an error frame.
+func (h *MessageHandler) rejectJoin(conn *Connection, room string, err error) {
+	limit, reason := "connection", "room_limit"
+	if errors.Is(err, errUserRoomLimit) {
+		limit, reason = "user", "user_room_limit"
+	}
+	metrics.RoomLimitExceeded.WithLabelValues(limit).Inc()
+	if conn.framed {
+		frame := message.Frame{Type: message.FrameError, Room: room, Reason: reason}
+		if data, err := frame.ToJSON(); err == nil {
+			h.writeControl(conn.id, data)
+		}
+	}
+}
+
+// roomNames returns the rooms the connection is subscribed to, by name.
+func (c *Connection) roomNames() []string {
+	c.roomsMu.RLock()
+	defer c.roomsMu.RUnlock()
+
+	names := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		names = append(names, room)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// subscribed reports whether the connection receives messages published to the room. Every
+// connection receives messages published without a room.
+func (c *Connection) subscribed(room string) bool {
+	ok, _ := c.subscription(room)
+	return ok
+}
+
+// enqueue queues a message on the write channel without blocking and reports whether it fit.
+// Regular messages published to a room are numbered in the room's sequence before they are
+// queued, so a message dropped because the channel is full leaves a gap the client can detect.
+func (c *Connection) enqueue(md message.MessageDetails) bool {
+	if md.Room == "" || md.Ephemeral {
+		return c.offer(md)
+	}
+
+	// The lock keeps the queue in sequence order when broadcast workers deliver to the room concurrently.
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	if held, ok := c.held[md.Room]; ok {
+		if len(held) >= c.queueSize {
+			metrics.MessagesShed.WithLabelValues(shedNormal).Inc()
+			return false
+		}
+		c.held[md.Room] = append(held, md)
+		return true
+	}
+	return c.enqueueLocked(md)
+}
+
+// enqueueLocked numbers a room message in the room's sequence and queues it. The caller holds roomsMu.
+func (c *Connection) enqueueLocked(md message.MessageDetails) bool {
+	seq, ok := c.rooms[md.Room]
+	if !ok {
+		return false
+	}
+	seq++
+	c.rooms[md.Room] = seq
+	md.RoomSeq = seq
+	return c.offer(md)
+}
+
+// handleRoomFrame applies a join or leave frame received from the connection. Joins are subject to room access control.
+func (h *MessageHandler) handleRoomFrame(ctx context.Context, conn *Connection, frame message.Frame) {
+	if frame.Room == "" {
+		h.logger.Warn("Room frame without a room", zap.String("conn-id", conn.id), zap.String("type", frame.Type))
+		return
+	}
+
+	if frame.Type == message.FrameLeave {
+		conn.leave(frame.Room)
+		return
+	}
+	// Echoes go to their sender alone, so the echo room has no members
+	if frame.Room == message.EchoRoom {
+		return
+	}
+	if !h.authorizeRoom(ctx, conn.identity, conn.id, frame.Room, config.RoomSubscribe) {
+		return
+	}
+	f, ok := h.compileFilter(conn, frame)
+	if !ok {
+		return
+	}
+
+	if err := conn.join(frame.Room); err != nil {
+		h.logger.Warn("Failed to join room", zap.String("conn-id", conn.id), zap.String("room", frame.Room), zap.Error(err))
+		h.rejectJoin(conn, frame.Room, err)
+		return
+	}
+	conn.setFilter(frame.Room, f)
+	h.advertiseRoom(frame.Room)
+	h.sendRoomState(ctx, conn, frame.Room)
+}
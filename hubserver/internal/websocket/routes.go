@@ -0,0 +1,52 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// addRoute records in the routing table that the hub holds the connection.
+func (h *MessageHandler) addRoute(connID string) {
+	if h.routes == nil {
+		return
+	}
+	if err := h.routes.Add(context.Background(), connID); err != nil {
+		h.logger.Warn("Failed to add connection route", zap.String("conn-id", connID), zap.Error(err))
+	}
+}
+
+// removeRoute removes the connection from the routing table.
+func (h *MessageHandler) removeRoute(connID string) {
+	if h.routes == nil {
+		return
+	}
+	if err := h.routes.Remove(context.Background(), connID); err != nil {
+		h.logger.Warn("Failed to remove connection route", zap.String("conn-id", connID), zap.Error(err))
+	}
+}
+
+// refreshRoutes periodically extends the routes of the hub's connections until ctx is done.
+func (h *MessageHandler) refreshRoutes(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.mu.RLock()
+			connIDs := make([]string, 0, len(h.connections))
+			for connID := range h.connections {
+				connIDs = append(connIDs, connID)
+			}
+			h.mu.RUnlock()
+
+			if err := h.routes.Refresh(ctx, connIDs); err != nil {
+				h.logger.Warn("Failed to refresh connection routes", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// resolveRoute returns the room a message published with the routing key to the room is delivered
+// to: the room of the rule with the exact key or, failing that, of the longest matching key prefix.
+// Messages without a routing key or matching rule keep their room; it reports false for those
+// naming no room either, which have nowhere to go.
+func (h *MessageHandler) resolveRoute(key, room string) (string, bool) {
+	if key == "" {
+		return room, true
+	}
+
+	var match config.RoutingRule
+	for _, rule := range *h.routing.Load() {
+		if rule.Key == key {
+			match = rule
+			break
+		}
+		prefix, ok := strings.CutSuffix(rule.Key, "*")
+		if ok && strings.HasPrefix(key, prefix) && len(rule.Key) > len(match.Key) {
+			match = rule
+		}
+	}
+
+	switch {
+	case match.Room != "":
+		metrics.RoutedMessages.WithLabelValues("routed").Inc()
+		return match.Room, true
+	case room != "":
+		metrics.RoutedMessages.WithLabelValues("fallback").Inc()
+		return room, true
+	default:
+		metrics.RoutedMessages.WithLabelValues("unrouted").Inc()
+		metrics.MessagesDropped.WithLabelValues("unrouted").Inc()
+		return "", false
+	}
+}
+
+// routeFrame resolves the room of a message frame published with a routing key, reporting false
+// when the message was dropped for matching no rule and naming no room.
+func (h *MessageHandler) routeFrame(conn *Connection, frame *message.Frame) bool {
+	room, ok := h.resolveRoute(frame.RoutingKey, frame.Room)
+	if !ok {
+		conn.log().Warn("Dropping message with unrouted routing key", zap.String("id", frame.ID), zap.String("routing-key", frame.RoutingKey))
+		return false
+	}
+	frame.Room = room
+	return true
+}
+
+// RoutingRules returns the routing rules as key=room specs, in the order they were set.
+func (h *MessageHandler) RoutingRules() []string {
+	rules := *h.routing.Load()
+	specs := make([]string, len(rules))
+	for i, rule := range rules {
+		specs[i] = rule.String()
+	}
+	return specs
+}
+
+// SetRoutingRules replaces the routing rules with the given routing-rules specs. Messages published
+// from then on are routed by the new rules, so publishers keep their routing keys while rooms are
+// split or merged.
+func (h *MessageHandler) SetRoutingRules(specs []string) error {
+	rules, err := config.ParseRoutingRules(specs)
+	if err != nil {
+		return fmt.Errorf("invalid routing rules: %w", err)
+	}
+
+	h.routing.Store(&rules)
+	h.logger.Info("Routing rules updated", zap.Strings("rules", h.RoutingRules()))
+	return nil
+}
@@ -0,0 +1,274 @@
+package websocket
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// rpcKey identifies a pending request by its requesting connection and correlation id.
+type rpcKey struct {
+	connID, correlationID string
+}
+
+// pendingCall is a request awaiting its reply on the requester's hub.
+type pendingCall struct {
+	service string
+	timer   *time.Timer
+}
+
+// rpcCalls tracks the requests sent by connections of this hub until they are replied to or time
+// out, and the claims by which hubs agree on the single service connection handling a request.
+type rpcCalls struct {
+	timeout time.Duration
+	// maxPending caps the pending requests of each connection, unless 0
+	maxPending int
+	claims     nonceClaimer
+
+	mu      sync.Mutex
+	pending map[rpcKey]*pendingCall
+	perConn map[string]int
+}
+
+func newRPCCalls(timeout time.Duration, maxPending int, claims nonceClaimer) *rpcCalls {
+	return &rpcCalls{
+		timeout:    timeout,
+		maxPending: maxPending,
+		claims:     claims,
+		pending:    make(map[rpcKey]*pendingCall),
+		perConn:    make(map[string]int),
+	}
+}
+
+// await registers a pending request, calling expire if no reply settles it within timeout. It
+// returns the reason the request is rejected instead: duplicate_request when the connection already
+// has a pending request with the same correlation id, too_many_requests when it has maxPending.
+func (c *rpcCalls) await(key rpcKey, service string, timeout time.Duration, expire func()) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.pending[key]; ok {
+		return "duplicate_request"
+	}
+	if c.maxPending > 0 && c.perConn[key.connID] >= c.maxPending {
+		return "too_many_requests"
+	}
+	c.perConn[key.connID]++
+	c.pending[key] = &pendingCall{
+		service: service,
+		timer: time.AfterFunc(timeout, func() {
+			if c.settle(key, service) {
+				expire()
+			}
+		}),
+	}
+	return ""
+}
+
+// settle removes a pending request answered by the service and reports whether it was pending.
+func (c *rpcCalls) settle(key rpcKey, service string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call, ok := c.pending[key]
+	if !ok || call.service != service {
+		return false
+	}
+	call.timer.Stop()
+	delete(c.pending, key)
+	if c.perConn[key.connID]--; c.perConn[key.connID] <= 0 {
+		delete(c.perConn, key.connID)
+	}
+	return true
+}
+
+// handleRequestFrame sends a request to one connection of the service it names, on this hub when
+// one is connected here or on whichever hub claims it first otherwise. The requester receives an
+// error frame with reason timeout if no reply arrives within the request's ttl or the rpc timeout.
+func (h *MessageHandler) handleRequestFrame(ctx context.Context, conn *Connection, frame message.Frame) {
+	if frame.Service == "" || frame.CorrelationID == "" {
+		h.rejectRPC(conn.id, frame.CorrelationID, "invalid_request")
+		return
+	}
+
+	timeout := h.rpc.timeout
+	if ttl := time.Duration(frame.TTL) * time.Millisecond; ttl > 0 && ttl < timeout {
+		timeout = ttl
+	}
+	key := rpcKey{conn.id, frame.CorrelationID}
+	if reason := h.rpc.await(key, frame.Service, timeout, func() {
+		metrics.MessagesDropped.WithLabelValues("rpc_timeout").Inc()
+		h.rejectRPC(conn.id, frame.CorrelationID, "timeout")
+	}); reason != "" {
+		h.rejectRPC(conn.id, frame.CorrelationID, reason)
+		return
+	}
+
+	request := message.Frame{
+		Type:          message.FrameRequest,
+		OriginID:      conn.id,
+		HubID:         h.hubID,
+		Service:       frame.Service,
+		CorrelationID: frame.CorrelationID,
+		Payload:       frame.Payload,
+		ContentType:   frame.ContentType,
+	}
+	data, err := request.ToJSON()
+	if err != nil {
+		h.logger.Error("Failed to encode request frame", zap.String("conn-id", conn.id), zap.Error(err))
+		return
+	}
+
+	if h.deliverRequest(frame.Service, data) {
+		return
+	}
+
+	md := message.NewRequestMessageDetails(h.hubID, data)
+	md.ExpiresAt = time.Now().Add(timeout).UnixMilli()
+	if err := h.broker.Publish(ctx, &md); err != nil {
+		h.logger.Error("Failed to publish request to broker", zap.String("conn-id", conn.id), zap.String("service", frame.Service), zap.Error(err))
+		if h.rpc.settle(key, frame.Service) {
+			h.rejectRPC(conn.id, frame.CorrelationID, "service_unavailable")
+		}
+	}
+}
+
+// claimRequest delivers a request published by another hub to a local connection of its service,
+// once this hub won the claim over the other hubs holding connections of the service.
+func (h *MessageHandler) claimRequest(md message.MessageDetails) {
+	if md.Expired(time.Now()) {
+		return
+	}
+
+	var request message.Frame
+	if err := request.FromJSON(md.Message); err != nil {
+		h.logger.Warn("Failed to decode request frame", zap.String("hub-id", md.HubID), zap.Error(err))
+		return
+	}
+	if h.serviceConnection(request.Service) == nil {
+		return
+	}
+
+	ttl := time.Until(time.UnixMilli(md.ExpiresAt))
+	claimed, err := h.rpc.claims.Claim(h.ctx, "rpc:"+request.HubID+":"+request.OriginID+":"+request.CorrelationID, ttl)
+	if err != nil {
+		h.logger.Error("Failed to claim request", zap.String("service", request.Service), zap.Error(err))
+		return
+	}
+	if claimed {
+		h.deliverRequest(request.Service, md.Message)
+	}
+}
+
+// deliverRequest queues an encoded request frame on a random local connection of the service and
+// reports whether one was connected.
+func (h *MessageHandler) deliverRequest(service string, data []byte) bool {
+	conn := h.serviceConnection(service)
+	if conn == nil {
+		return false
+	}
+	return h.writeControl(conn.id, data)
+}
+
+// serviceConnection returns a random authenticated connection of this hub serving as the service,
+// or nil if there is none. Services are the framed connections whose identity holds the service's
+// role, granted by the token, signed URL or client certificate they authenticated with.
+func (h *MessageHandler) serviceConnection(service string) *Connection {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var candidates []*Connection
+	for _, conn := range h.connections {
+		if conn.framed && !conn.unauthenticated.Load() && conn.identity.Serves(service) {
+			candidates = append(candidates, conn)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[rand.IntN(len(candidates))]
+}
+
+// handleReplyFrame routes a service's reply back to the requesting connection named by its
+// origin id, through the broker when the requester is connected to another hub. The reply names the
+// service it answers for, which the connection must serve.
+func (h *MessageHandler) handleReplyFrame(ctx context.Context, conn *Connection, frame message.Frame) {
+	if frame.OriginID == "" || frame.CorrelationID == "" || frame.Service == "" {
+		h.rejectRPC(conn.id, frame.CorrelationID, "invalid_request")
+		return
+	}
+	if conn.unauthenticated.Load() || !conn.identity.Serves(frame.Service) {
+		h.rejectRPC(conn.id, frame.CorrelationID, "forbidden")
+		return
+	}
+
+	reply := message.Frame{
+		Type:          message.FrameReply,
+		OriginID:      conn.id,
+		HubID:         h.hubID,
+		Service:       frame.Service,
+		CorrelationID: frame.CorrelationID,
+		Payload:       frame.Payload,
+		ContentType:   frame.ContentType,
+	}
+	data, err := reply.ToJSON()
+	if err != nil {
+		h.logger.Error("Failed to encode reply frame", zap.String("conn-id", conn.id), zap.Error(err))
+		return
+	}
+
+	if h.deliverReply(frame.OriginID, reply, data) {
+		return
+	}
+
+	md := message.NewReplyMessageDetails(h.hubID, frame.OriginID, data)
+	if err := h.broker.Publish(ctx, &md); err != nil {
+		h.logger.Error("Failed to publish reply to broker", zap.String("conn-id", conn.id), zap.String("target-id", frame.OriginID), zap.Error(err))
+	}
+}
+
+// routeReply delivers a reply published by another hub if the requester is connected here.
+func (h *MessageHandler) routeReply(md message.MessageDetails) {
+	var reply message.Frame
+	if err := reply.FromJSON(md.Message); err != nil {
+		h.logger.Warn("Failed to decode reply frame", zap.String("hub-id", md.HubID), zap.Error(err))
+		return
+	}
+	h.deliverReply(md.TargetID, reply, md.Message)
+}
+
+// deliverReply queues an encoded reply on the requesting connection if it is registered on this
+// hub, and reports whether it is. Replies to requests that timed out, were already answered or
+// were not sent to the replying service are dropped.
+func (h *MessageHandler) deliverReply(targetID string, reply message.Frame, data []byte) bool {
+	h.mu.RLock()
+	_, ok := h.connections[targetID]
+	h.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	if !h.rpc.settle(rpcKey{targetID, reply.CorrelationID}, reply.Service) {
+		metrics.MessagesDropped.WithLabelValues("rpc_unexpected_reply").Inc()
+		h.logger.Warn("Dropping reply to a request that is not pending", zap.String("conn-id", targetID), zap.String("correlation-id", reply.CorrelationID))
+		return true
+	}
+	h.writeControl(targetID, data)
+	return true
+}
+
+// rejectRPC sends the connection an error frame for the request with the correlation id.
+func (h *MessageHandler) rejectRPC(connID, correlationID, reason string) {
+	frame := message.Frame{Type: message.FrameError, CorrelationID: correlationID, Reason: reason}
+	data, err := frame.ToJSON()
+	if err != nil {
+		h.logger.Error("Failed to encode error frame", zap.String("conn-id", connID), zap.Error(err))
+		return
+	}
+	h.writeControl(connID, data)
+}
@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/hubtest"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+func TestRequestsAreServedByConnectionsHoldingTheServiceRole(t *testing.T) {
+	cfg := testConfig()
+	cfg.RPCTimeout = time.Minute
+	h, _ := startHubWith(t, cfg)
+	requester := publisher(t, h, auth.Identity{UserID: "alice"})
+	// a user named after the service does not serve it without the role
+	impostor := publisher(t, h, auth.Identity{UserID: "pricing"})
+	service := publisher(t, h, auth.Identity{UserID: "pricing-1", Roles: []string{auth.ServiceRolePrefix + "pricing"}})
+
+	if err := requester.SendJSON(message.Frame{Type: message.FrameRequest, Service: "pricing", CorrelationID: "c1", Payload: []byte(`{"symbol":"AAPL"}`)}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	request := receiveFrame(t, service, message.FrameRequest)
+	if request.CorrelationID != "c1" || string(request.Payload) != `{"symbol":"AAPL"}` {
+		t.Fatalf("service received %+v", request)
+	}
+
+	reply := message.Frame{Type: message.FrameReply, Service: "pricing", CorrelationID: "c1", OriginID: request.OriginID, Payload: json.RawMessage(`{"price":1}`)}
+	if err := impostor.SendJSON(reply); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if frame := receiveFrame(t, impostor, message.FrameError); frame.Reason != "forbidden" || frame.CorrelationID != "c1" {
+		t.Fatalf("impostor's reply answered with %+v, want a forbidden error", frame)
+	}
+	if err := service.SendJSON(reply); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if frame := receiveFrame(t, requester, message.FrameReply); frame.Service != "pricing" || string(frame.Payload) != `{"price":1}` {
+		t.Fatalf("requester received %+v, want the service's reply", frame)
+	}
+}
+
+func TestPendingRequestsAreCappedPerConnection(t *testing.T) {
+	cfg := testConfig()
+	cfg.RPCTimeout = time.Minute
+	cfg.RPCMaxPending = 2
+	h, _ := startHubWith(t, cfg)
+	ws := hubtest.NewConn(message.Subprotocol)
+	conn, err := h.Attach(ws, auth.Identity{UserID: "alice"}, nil)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	request := func(ids ...string) {
+		t.Helper()
+		for _, id := range ids {
+			if err := ws.SendJSON(message.Frame{Type: message.FrameRequest, Service: "pricing", CorrelationID: id}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+		}
+	}
+
+	// no connection serves the requests, so they stay pending until they time out
+	request("c1", "c2", "c3")
+	if frame := receiveFrame(t, ws, message.FrameError); frame.Reason != "too_many_requests" || frame.CorrelationID != "c3" {
+		t.Fatalf("request beyond the cap answered with %+v", frame)
+	}
+	if reason := h.rpc.await(rpcKey{"other-conn", "c1"}, "pricing", time.Minute, func() {}); reason != "" {
+		t.Fatalf("request of another connection rejected as %s", reason)
+	}
+
+	// a settled request makes room for another one
+	if !h.rpc.settle(rpcKey{conn.id, "c1"}, "pricing") {
+		t.Fatal("request c1 was not pending")
+	}
+	request("c4", "c5")
+	if frame := receiveFrame(t, ws, message.FrameError); frame.Reason != "too_many_requests" || frame.CorrelationID != "c5" {
+		t.Fatalf("request beyond the cap answered with %+v, want c5 rejected", frame)
+	}
+}
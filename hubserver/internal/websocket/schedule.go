@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// submit queues a message received from a client for broadcasting, or holds it in the scheduler
+// when the frame asks for delivery at a later time.
+func (h *MessageHandler) submit(ctx context.Context, md message.MessageDetails, deliverAt *time.Time) {
+	if deliverAt == nil || !deliverAt.After(time.Now()) {
+		h.ingest(md)
+		return
+	}
+
+	if h.scheduler == nil {
+		h.logger.Warn("Dropping scheduled message, scheduled delivery is disabled", zap.String("senderID", md.SenderID), zap.String("id", md.ID))
+		return
+	}
+
+	if md.Local || md.Ephemeral {
+		h.scheduleLocally(md, *deliverAt)
+		return
+	}
+	if err := h.scheduler.Schedule(ctx, &md, *deliverAt); err != nil {
+		h.logger.Error("Failed to schedule message", zap.String("senderID", md.SenderID), zap.String("id", md.ID), zap.Error(err))
+	}
+}
+
+// scheduleLocally holds a local or ephemeral message in a timer of the hub rather than in the
+// shared schedule, which whichever hub claims a due message delivers from and which keeps the
+// messages it holds in Redis; the message is lost if the hub stops before it falls due.
+func (h *MessageHandler) scheduleLocally(md message.MessageDetails, deliverAt time.Time) {
+	time.AfterFunc(time.Until(deliverAt), func() {
+		if h.ctx.Err() == nil {
+			h.ingest(md)
+		}
+	})
+}
+
+// runScheduler injects scheduled messages into the broadcast pipeline as they fall due. The
+// claiming hub broadcasts them to its connections and forwards them to the other hubs.
+func (h *MessageHandler) runScheduler() {
+	h.scheduler.Run(h.ctx, h.scheduleInterval, func(md message.MessageDetails) {
+		md.HubID = h.hubID
+		h.ingest(md)
+	})
+}
@@ -0,0 +1,43 @@
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// echoParam is the query parameter clients choose the echo policy of the messages they publish
+// with: others, the default, all or none.
+const echoParam = "echo"
+
+// requestedEcho returns the echo policy an upgrade request chose for the messages published on
+// the connection. Unknown policies fall back to the default.
+func (h *MessageHandler) requestedEcho(r *http.Request) string {
+	echo := r.URL.Query().Get(echoParam)
+	if !message.ValidEcho(echo) {
+		h.logger.Warn("Unknown echo policy, using the default", zap.String("echo", echo))
+		return ""
+	}
+	if echo == message.EchoOthers {
+		return ""
+	}
+	return echo
+}
+
+// setEcho sets the echo policy of a message published on the connection: the one its frame asked
+// for, when known, otherwise the connection's. Messages echoed to none of the user's connections
+// carry the user so every hub can skip them; those of anonymous connections skip only the
+// publishing connection.
+func (c *Connection) setEcho(md *message.MessageDetails, requested string) {
+	md.Echo = c.echo
+	if requested != "" && message.ValidEcho(requested) {
+		md.Echo = requested
+	}
+	if md.Echo == message.EchoOthers {
+		md.Echo = ""
+	}
+	if md.Echo == message.EchoNone {
+		md.UserID = c.identity.UserID
+	}
+}
@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"go.uber.org/zap"
+)
+
+// roomSequencer issues the sequence numbers of the messages of sequenced rooms, from Redis for
+// rooms ordered across hubs and from counters of the hub for the others.
+type roomSequencer struct {
+	issuers map[string]string
+	redis   *redis.RoomSequences
+
+	mu    sync.Mutex
+	local map[string]uint64
+}
+
+// issuer returns the issuer of the room's sequence numbers, or "" when the room isn't sequenced.
+func (s *roomSequencer) issuer(room string) string {
+	if issuer, ok := s.issuers[room]; ok {
+		return issuer
+	}
+	return s.issuers[allRooms]
+}
+
+// nextLocal issues the next sequence number of a room sequenced by the hub.
+func (s *roomSequencer) nextLocal(room string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.local[room]++
+	return s.local[room]
+}
+
+// stampSequences stamps the messages of a batch published to sequenced rooms on this hub with
+// their room's next sequence number, in the order of the batch, before they are recorded and
+// delivered. Messages from other hubs keep the number their hub stamped. Local messages of rooms
+// sequenced across hubs are left unnumbered, since the other hubs never see them, as are
+// ephemeral messages, which carry no ordering promise.
+func (h *MessageHandler) stampSequences(ctx context.Context, batch []message.MessageDetails) {
+	if h.sequences == nil {
+		return
+	}
+
+	var shared []int
+	for i := range batch {
+		md := &batch[i]
+		if md.Kind != message.KindMessage || md.Room == "" || md.Ephemeral || md.Sequence != 0 || md.IsFromPubSub(h.pubSubChannel) {
+			continue
+		}
+		switch h.sequences.issuer(md.Room) {
+		case config.SequenceLocal:
+			md.Sequence = h.sequences.nextLocal(md.Room)
+		case config.SequenceRedis:
+			if !md.Local && h.sequences.redis != nil {
+				shared = append(shared, i)
+			}
+		}
+	}
+	if len(shared) == 0 {
+		return
+	}
+
+	rooms := make([]string, len(shared))
+	for j, i := range shared {
+		rooms[j] = batch[i].Room
+	}
+	seqs, err := h.sequences.redis.Next(ctx, rooms)
+	if err != nil {
+		h.logger.Error("Failed to issue room sequence numbers, delivering messages unnumbered", zap.Int("messages", len(shared)), zap.Error(err))
+		return
+	}
+	for j, i := range shared {
+		batch[i].Sequence = seqs[j]
+	}
+}
@@ -0,0 +1,31 @@
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+)
+
+// sessionParam is the query parameter of the upgrade request carrying the session id of the
+// client's previous connections, which the new connection continues.
+const sessionParam = "session"
+
+// sessionHeader is the upgrade response header carrying the session id of the connection.
+const sessionHeader = "Hub-Session"
+
+// requestedSession returns the session id an upgrade request continues, or "" when it names none
+// or one that is not a UUID. Session ids only correlate a client's connections across reconnects
+// in logs and the admin API; they grant nothing, so clients choose them freely.
+func requestedSession(r *http.Request) string {
+	session := r.URL.Query().Get(sessionParam)
+	if session == "" {
+		return ""
+	}
+	id, err := uuid.Parse(session)
+	if err != nil {
+		return ""
+	}
+	metrics.SessionsResumed.Inc()
+	return id.String()
+}
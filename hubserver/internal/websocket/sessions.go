@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"go.uber.org/zap"
+)
+
+var (
+	errDuplicateSession = errors.New("user already holds the maximum number of connections")
+	errSessionRegistry  = errors.New("session registry unavailable")
+)
+
+// registerSession records the connection in the fleet-wide session registry and returns the
+// sessions to evict under the kick-oldest policy. Anonymous connections are not tracked.
+func (h *MessageHandler) registerSession(ctx context.Context, identity auth.Identity, connID string) ([]redis.Session, error) {
+	if h.sessions == nil || identity.IsAnonymous() {
+		return nil, nil
+	}
+
+	accepted, evicted, err := h.sessions.Register(ctx, identity.UserID, connID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errSessionRegistry, err)
+	}
+	if !accepted {
+		return nil, errDuplicateSession
+	}
+	return evicted, nil
+}
+
+// unregisterSession removes the connection from the session registry.
+func (h *MessageHandler) unregisterSession(identity auth.Identity, connID string) {
+	if h.sessions == nil || identity.IsAnonymous() {
+		return
+	}
+
+	if err := h.sessions.Unregister(context.Background(), identity.UserID, connID); err != nil {
+		h.logger.Warn("Failed to unregister session", zap.String("conn-id", connID), zap.Error(err))
+	}
+}
+
+// evictSessions closes the given sessions, asking the other hubs through the broker to close the ones they hold.
+func (h *MessageHandler) evictSessions(ctx context.Context, sessions []redis.Session) {
+	for _, session := range sessions {
+		if session.HubID == h.hubID {
+			h.evictConnection(session.ConnID)
+			continue
+		}
+
+		md := message.NewEvictMessageDetails(h.hubID, session.ConnID)
+		if err := h.broker.Publish(ctx, &md); err != nil {
+			h.logger.Error("Failed to publish eviction to broker", zap.String("conn-id", session.ConnID), zap.Error(err))
+		}
+	}
+}
+
+// evictConnection closes a local connection with the evicted close code so the client does not reconnect
+// blindly, and reports whether the hub held the connection.
+func (h *MessageHandler) evictConnection(connID string) bool {
+	conn, ok := h.detach(connID)
+	if !ok {
+		return false
+	}
+
+	if err := conn.CloseWithReason(message.CloseEvicted, h.closeReason(message.ReasonEvicted)); err != nil {
+		h.logger.Warn("Failed to close evicted connection", zap.String("conn-id", connID), zap.Error(err))
+		return true
+	}
+	h.logger.Info("Connection evicted", zap.String("conn-id", connID), zap.String("user-id", conn.identity.UserID))
+	return true
+}
@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Shedding classes of the messages queued for a connection, from the first to be shed to the
+// never shed.
+const (
+	shedEphemeral = "ephemeral"
+	shedNormal    = "normal"
+	shedControl   = "control"
+)
+
+// offer queues a message on the connection's write channel, shedding by class when the queue is
+// full: the last quarter of the queue is reserved for regular messages, so ephemeral messages are
+// shed first, and a regular message arriving at a full queue displaces the oldest queued ephemeral
+// message, being shed itself only when there is none. It reports whether the message was queued.
+func (c *Connection) offer(md message.MessageDetails) bool {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if c.queueReleased {
+		return false
+	}
+	depth := len(c.writeCh) - c.ephemeralShed
+	if md.Ephemeral {
+		if depth >= c.queueSize*3/4 || !c.push(md) {
+			metrics.MessagesShed.WithLabelValues(shedEphemeral).Inc()
+			return false
+		}
+		c.ephemeralQueued++
+		return true
+	}
+
+	if depth >= c.queueSize {
+		if c.ephemeralQueued <= c.ephemeralShed {
+			metrics.MessagesShed.WithLabelValues(shedNormal).Inc()
+			return false
+		}
+		// The write pump skips the oldest queued ephemeral message in its place
+		c.ephemeralShed++
+		metrics.MessagesShed.WithLabelValues(shedEphemeral).Inc()
+	}
+	if !c.push(md) {
+		metrics.MessagesShed.WithLabelValues(shedNormal).Inc()
+		return false
+	}
+	return true
+}
+
+// push queues a message on the write channel unless it is full. The channel holds twice the queue
+// size so the ephemeral messages shed while queued fit alongside the messages that displaced them.
+func (c *Connection) push(md message.MessageDetails) bool {
+	select {
+	case c.writeCh <- md:
+		c.memory.add(c, &md)
+		return true
+	default:
+		return false
+	}
+}
+
+// dequeued accounts for a message the write pump took from the write channel and reports whether it
+// was shed while queued, in which case it must be skipped.
+func (c *Connection) dequeued(md message.MessageDetails) bool {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	c.memory.remove(c, &md)
+	if !md.Ephemeral {
+		return false
+	}
+	c.ephemeralQueued--
+	if c.ephemeralShed > 0 {
+		c.ephemeralShed--
+		return true
+	}
+	return false
+}
+
+// queueDepth returns the number of messages queued for the connection, not counting the ones shed.
+func (c *Connection) queueDepth() int {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	return max(len(c.writeCh)-c.ephemeralShed, 0)
+}
+
+// shedSlowConsumer closes a connection whose control channel is full. Control frames such as acks,
+// receipts and replies are never shed, so a client that falls this far behind is disconnected and
+// recovers on reconnect instead of silently missing them.
+func (h *MessageHandler) shedSlowConsumer(conn *Connection) {
+	if _, ok := h.detach(conn.id); !ok {
+		return
+	}
+
+	metrics.MessagesShed.WithLabelValues(shedControl).Inc()
+	if err := conn.CloseWithReason(message.CloseEvicted, h.closeReason(message.ReasonSlowConsumer)); err != nil {
+		h.logger.Warn("Failed to close slow consumer", zap.String("conn-id", conn.id), zap.Error(err))
+		return
+	}
+	h.logger.Warn("Control channel is full, closing slow consumer", zap.String("conn-id", conn.id), zap.String("user-id", conn.identity.UserID))
+}
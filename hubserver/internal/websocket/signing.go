@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"context"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"go.uber.org/zap"
+)
+
+// signingBroker signs envelopes published to the wrapped broker and drops received envelopes that
+// are unsigned or whose signature does not match, so a party with access to the broker but not to
+// the secrets cannot spoof senders, hubs or evictions. The first secret signs; every secret is
+// accepted, which allows rotating them without downtime.
+type signingBroker struct {
+	Broker
+	secrets [][]byte
+	// deadLetters records the dropped envelopes when dead letters are enabled
+	deadLetters deadLetterer
+	logger      *zap.Logger
+}
+
+// deadLetterer records the envelopes a hub received but could not deliver.
+type deadLetterer interface {
+	Add(ctx context.Context, channel, reason string, cause error, envelope []byte) error
+}
+
+func newSigningBroker(broker Broker, secrets []string, deadLetters *redis.DeadLetters, logger *zap.Logger) *signingBroker {
+	b := &signingBroker{Broker: broker, logger: logger}
+	if deadLetters != nil {
+		b.deadLetters = deadLetters
+	}
+	for _, secret := range secrets {
+		b.secrets = append(b.secrets, []byte(secret))
+	}
+	return b
+}
+
+func (b *signingBroker) Publish(ctx context.Context, md *message.MessageDetails) error {
+	envelope := *md
+	envelope.Sign(b.secrets[0])
+	return b.Broker.Publish(ctx, &envelope)
+}
+
+func (b *signingBroker) PublishCounted(ctx context.Context, md *message.MessageDetails) (int, error) {
+	envelope := *md
+	envelope.Sign(b.secrets[0])
+	return publishCounted(ctx, b.Broker, &envelope)
+}
+
+func (b *signingBroker) Subscribe(ctx context.Context, broadcastCh chan<- message.MessageDetails) {
+	received := make(chan message.MessageDetails)
+	go func() {
+		defer close(received)
+		b.Broker.Subscribe(ctx, received)
+	}()
+
+	for md := range received {
+		if err := md.Verify(b.secrets); err != nil {
+			metrics.MessagesDropped.WithLabelValues("bad_envelope_signature").Inc()
+			b.logger.Warn("Dropping envelope from broker", zap.String("id", md.ID), zap.String("hub-id", md.HubID), zap.Error(err))
+			b.deadLetter(ctx, md, err)
+			continue
+		}
+		md.Sealed = nil
+		broadcastCh <- md
+	}
+}
+
+// deadLetter records an envelope whose signature did not match, received on the channel the Redis
+// broker names as its sender, so it can be replayed once the hubs agree on the secrets again. An
+// envelope the broker decrypted is recorded as it was received, so its payload stays encrypted in
+// the dead letters.
+func (b *signingBroker) deadLetter(ctx context.Context, md message.MessageDetails, cause error) {
+	if b.deadLetters == nil {
+		return
+	}
+	data := md.Sealed
+	var err error
+	if data == nil {
+		data, err = md.ToJSON()
+	}
+	if err == nil {
+		err = b.deadLetters.Add(ctx, md.SenderID, redis.DeadLetterSignature, cause, data)
+	}
+	if err != nil {
+		b.logger.Error("Failed to dead-letter envelope", zap.String("id", md.ID), zap.Error(err))
+		return
+	}
+	metrics.DeadLetters.WithLabelValues(redis.DeadLetterSignature).Inc()
+}
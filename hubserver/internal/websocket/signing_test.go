@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"go.uber.org/zap"
+)
+
+// envelopeBroker is a broker delivering the envelopes it holds to its subscriber.
+type envelopeBroker struct {
+	Broker
+	envelopes []message.MessageDetails
+}
+
+func (b *envelopeBroker) Subscribe(_ context.Context, broadcastCh chan<- message.MessageDetails) {
+	for _, md := range b.envelopes {
+		broadcastCh <- md
+	}
+}
+
+// deadLetterLog records the ids of the envelopes dead-lettered, by reason.
+type deadLetterLog map[string][]string
+
+func (l deadLetterLog) Add(_ context.Context, _, reason string, _ error, envelope []byte) error {
+	var md message.MessageDetails
+	if err := md.FromJSON(envelope); err != nil {
+		return err
+	}
+	l[reason] = append(l[reason], md.ID)
+	return nil
+}
+
+// receiveSigned returns the ids of the envelopes a hub holding the secrets delivers and those it
+// dead-letters.
+func receiveSigned(t *testing.T, secrets []string, envelopes ...message.MessageDetails) (delivered []string, deadLetters deadLetterLog) {
+	t.Helper()
+
+	b := newSigningBroker(&envelopeBroker{envelopes: envelopes}, secrets, nil, zap.NewNop())
+	deadLetters = deadLetterLog{}
+	b.deadLetters = deadLetters
+	broadcastCh := make(chan message.MessageDetails, len(envelopes))
+	b.Subscribe(context.Background(), broadcastCh)
+	close(broadcastCh)
+	for md := range broadcastCh {
+		delivered = append(delivered, md.ID)
+	}
+	return delivered, deadLetters
+}
+
+// signedEnvelope returns an envelope of the id signed with the secret.
+func signedEnvelope(id, secret string) message.MessageDetails {
+	md := message.NewMessageDetails("client-1", "hub-1", "hub-1", []byte(`{"qty":1}`))
+	md.ID, md.Room, md.UserID = id, "orders", "alice"
+	md.Annotations = map[string]string{"tenant": "acme"}
+	md.Sign([]byte(secret))
+	return md
+}
+
+func TestSigningBrokerDropsTamperedEnvelopes(t *testing.T) {
+	for _, tc := range []struct {
+		field     string
+		change    func(*message.MessageDetails)
+		delivered bool
+	}{
+		{"id", func(md *message.MessageDetails) { md.ID += "-forged" }, false},
+		{"kind", func(md *message.MessageDetails) { md.Kind = message.KindEvict }, false},
+		{"origin", func(md *message.MessageDetails) { md.OriginID = "client-2" }, false},
+		{"hub", func(md *message.MessageDetails) { md.HubID = "hub-2" }, false},
+		{"target", func(md *message.MessageDetails) { md.TargetID = "client-3" }, false},
+		{"room", func(md *message.MessageDetails) { md.Room = "admin" }, false},
+		{"user", func(md *message.MessageDetails) { md.UserID = "mallory" }, false},
+		{"payload", func(md *message.MessageDetails) { md.Message = []byte(`{"qty":100}`) }, false},
+		{"annotation", func(md *message.MessageDetails) { md.Annotations["tenant"] = "other" }, false},
+		{"expiry", func(md *message.MessageDetails) { md.ExpiresAt++ }, false},
+		{"sequence", func(md *message.MessageDetails) { md.Sequence++ }, false},
+		{"ephemeral", func(md *message.MessageDetails) { md.Ephemeral = true }, false},
+		{"signature", func(md *message.MessageDetails) { md.Signature = "not-hex" }, false},
+
+		// Hubs rewrite and extend these, and sign envelopes before encoding, compressing and encrypting them
+		{"sender", func(md *message.MessageDetails) { md.SenderID = "test-channel" }, true},
+		{"hops", func(md *message.MessageDetails) { md.Hops = append(md.Hops, "hub-3") }, true},
+		{"encoded", func(md *message.MessageDetails) { md.Encoded = true }, true},
+		{"content encoding", func(md *message.MessageDetails) { md.ContentEncoding = "gzip" }, true},
+		{"key id", func(md *message.MessageDetails) { md.KeyID = "2024-02" }, true},
+	} {
+		md := signedEnvelope("order-1", "secret")
+		tc.change(&md)
+		delivered, deadLetters := receiveSigned(t, []string{"secret"}, md)
+		if got := len(delivered) == 1; got != tc.delivered {
+			t.Errorf("envelope with a changed %s delivered: %v, want %v", tc.field, got, tc.delivered)
+		}
+		if !tc.delivered && len(deadLetters[redis.DeadLetterSignature]) != 1 {
+			t.Errorf("envelope with a changed %s dead-lettered as %v", tc.field, deadLetters)
+		}
+	}
+}
+
+func TestSigningBrokerAcceptsEverySecretDuringARotation(t *testing.T) {
+	unsigned := signedEnvelope("order-4", "secret")
+	unsigned.Signature = ""
+	delivered, deadLetters := receiveSigned(t, []string{"new-secret", "old-secret"},
+		signedEnvelope("order-1", "new-secret"),
+		signedEnvelope("order-2", "old-secret"),
+		signedEnvelope("order-3", "retired-secret"),
+		unsigned,
+	)
+	if want := []string{"order-1", "order-2"}; !slices.Equal(delivered, want) {
+		t.Fatalf("delivered %v, want %v", delivered, want)
+	}
+	if want := []string{"order-3", "order-4"}; !slices.Equal(deadLetters[redis.DeadLetterSignature], want) {
+		t.Fatalf("dead-lettered %v, want %v", deadLetters, want)
+	}
+}
@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/spill"
+	"go.uber.org/zap"
+)
+
+// brokerInboundBuffer is the capacity of the queue between the broker and the broadcast queue
+// when messages from other hubs may be spilled.
+const brokerInboundBuffer = 64
+
+// spillover moves regular messages that overflow the broadcast queue to a disk-backed queue and
+// feeds them back, in order, as the broadcast queue drains.
+type spillover struct {
+	queue *spill.Queue
+	// draining is set while a message taken from the queue waits for room in the broadcast queue
+	draining atomic.Bool
+	// wake signals the drain loop that messages were spilled
+	wake chan struct{}
+}
+
+func newSpillover(dir string, segmentSize, maxSize int64) (*spillover, error) {
+	queue, err := spill.Open(dir, segmentSize, maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill queue: %w", err)
+	}
+	return &spillover{queue: queue, wake: make(chan struct{}, 1)}, nil
+}
+
+// pending reports whether spilled messages are still waiting to be broadcast.
+func (s *spillover) pending() bool {
+	return s.draining.Load() || s.queue.Len() > 0
+}
+
+// queueBroadcast queues a regular message for broadcasting. Without a spill queue it waits for
+// room in the broadcast queue; with one, messages that find the broadcast queue full, or spilled
+// messages ahead of them, are spilled to disk instead. Once the spill queue is full or failing,
+// it falls back to waiting.
+func (h *MessageHandler) queueBroadcast(md message.MessageDetails) {
+	if h.spill == nil {
+		h.waitBroadcast(md)
+		return
+	}
+
+	if !h.spill.pending() {
+		select {
+		case h.broadcastCh <- md:
+			return
+		default:
+		}
+	}
+
+	data, err := json.Marshal(md)
+	if err == nil {
+		err = h.spill.queue.Push(data)
+	}
+	if err != nil {
+		if !errors.Is(err, spill.ErrFull) {
+			h.logger.Error("Failed to spill message", zap.String("id", md.ID), zap.Error(err))
+		}
+		h.waitBroadcast(md)
+		return
+	}
+
+	metrics.SpilledMessages.Inc()
+	select {
+	case h.spill.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drainSpill feeds spilled messages back into the broadcast queue, waiting for room for each one,
+// until ctx is done.
+func (h *MessageHandler) drainSpill(ctx context.Context) {
+	for {
+		h.spill.draining.Store(true)
+		data, ok, err := h.spill.queue.Pop()
+		if err != nil {
+			h.logger.Error("Failed to read spilled messages", zap.Error(err))
+		}
+		if !ok {
+			h.spill.draining.Store(false)
+			// Messages spilled between the failed pop and clearing draining signalled wake
+			select {
+			case <-ctx.Done():
+				return
+			case <-h.spill.wake:
+			}
+			continue
+		}
+
+		var md message.MessageDetails
+		if err := json.Unmarshal(data, &md); err != nil {
+			h.logger.Error("Dropping undecodable spilled message", zap.Error(err))
+			continue
+		}
+
+		select {
+		case h.broadcastCh <- md:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// forwardInbound queues the messages received from other hubs for broadcasting, spilling the
+// regular ones that overflow the broadcast queue.
+func (h *MessageHandler) forwardInbound(inbound <-chan message.MessageDetails) {
+	for md := range inbound {
+		if md.Ephemeral {
+			h.waitBroadcast(md)
+			continue
+		}
+		h.queueBroadcast(md)
+	}
+}
@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// stateResponse is the body returned by the room state API.
+type stateResponse struct {
+	Messages []message.Frame `json:"messages"`
+}
+
+// recordState records keyed messages published to state rooms as the latest value of their key
+// before they are delivered. Like history, only the hub that received a message records it.
+func (h *MessageHandler) recordState(ctx context.Context, md *message.MessageDetails) {
+	if h.state == nil || md.Key == "" || md.Ephemeral || md.IsFromPubSub(h.pubSubChannel) || !h.state.Enabled(md.Room) {
+		return
+	}
+
+	if err := h.state.Set(ctx, md); err != nil {
+		h.logger.Error("Failed to record room state", zap.String("room", md.Room), zap.String("key", md.Key), zap.Error(err))
+		return
+	}
+	h.touchRoom(md.Room)
+}
+
+// sendRoomState queues the latest message of every key of a state room for a connection that just
+// joined it, so it starts from the room's current state. An update published while the state is
+// read may reach the connection before the older value of its key.
+func (h *MessageHandler) sendRoomState(ctx context.Context, conn *Connection, room string) {
+	if h.state == nil || !h.state.Enabled(room) {
+		return
+	}
+
+	snapshot, err := h.state.Snapshot(ctx, room)
+	if err != nil {
+		h.logger.Error("Failed to read room state", zap.String("conn-id", conn.id), zap.String("room", room), zap.Error(err))
+		return
+	}
+	for _, md := range snapshot {
+		if !conn.enqueue(md) {
+			h.roomMetrics.dropped(room)
+			h.logger.Warn("Write channel is full, dropping room state", zap.String("conn-id", conn.id), zap.String("room", room), zap.String("key", md.Key))
+		}
+	}
+}
+
+// ServeRoomState serves GET /rooms/:room/state, returning the latest message of every key of a
+// state room, ordered by key.
+func (h *MessageHandler) ServeRoomState(w http.ResponseWriter, r *http.Request, room string) {
+	remoteIP, ok := h.admitRequest(w, r)
+	if !ok {
+		return
+	}
+	defer h.ipFilter.Release(remoteIP)
+
+	identity, err := h.authenticator.Authenticate(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if !h.authorizeRoom(r.Context(), identity, "", room, config.RoomSubscribe) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if h.state == nil || !h.state.Enabled(room) {
+		http.Error(w, "room is not a state room", http.StatusNotFound)
+		return
+	}
+
+	snapshot, err := h.state.Snapshot(r.Context(), room)
+	if err != nil {
+		h.logger.Error("Failed to read room state", zap.String("room", room), zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := h.stateResponse(reader(r, identity), snapshot)
+	// Clients authenticate with a bearer token rather than cookies, so web apps on any origin may read the state.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Warn("Failed to write room state", zap.String("room", room), zap.Error(err))
+	}
+}
+
+// stateResponse returns the room's state as the subscriber receives it over its connections.
+func (h *MessageHandler) stateResponse(sub Subscriber, snapshot []message.MessageDetails) stateResponse {
+	response := stateResponse{Messages: make([]message.Frame, 0, len(snapshot))}
+	for i := range snapshot {
+		if h.transformRead(sub, &snapshot[i]) {
+			response.Messages = append(response.Messages, message.NewMessageFrame(&snapshot[i]))
+		}
+	}
+	return response
+}
@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+)
+
+// statePatchesParam is the query parameter hub.v1 clients ask for state patches with.
+const statePatchesParam = "state_patches"
+
+// statePatches holds the payload of the last message of each key of the state rooms written to a
+// client that applies JSON Patches, which the next message of the key is sent as a patch against.
+// The client keeps the same payloads from the frames it receives, so both sides agree on what a
+// patch applies to as long as they forget a room's payloads when it is left.
+type statePatches struct {
+	stateRoom func(room string) bool
+
+	mu sync.Mutex
+	// last holds the payloads by room and key
+	last map[string]map[string]json.RawMessage
+}
+
+// newStatePatches returns the state patches of a connection requested with r, or nil when the hub
+// doesn't send patches or the client didn't ask for them.
+func (h *MessageHandler) newStatePatches(r *http.Request, framed bool) *statePatches {
+	if !h.statePatches || h.state == nil || !framed {
+		return nil
+	}
+	if asked, _ := strconv.ParseBool(r.URL.Query().Get(statePatchesParam)); !asked {
+		return nil
+	}
+	return &statePatches{stateRoom: h.state.Enabled, last: make(map[string]map[string]json.RawMessage)}
+}
+
+// tracks reports whether patch records the payloads of the room and key of a message, which must
+// then go through it.
+func (p *statePatches) tracks(md *message.MessageDetails) bool {
+	return p != nil && md.Key != "" && md.Room != "" && p.stateRoom(md.Room)
+}
+
+// patch records the payload of a message frame of a state room as the last one of its key and
+// replaces it with the JSON Patch from the previous one when the patch is smaller and, for
+// messages over a positive chunk size, fits in a chunk. It reports whether the payload was replaced.
+func (p *statePatches) patch(frame *message.Frame, chunkSize int) bool {
+	if p == nil || frame.Key == "" || frame.Room == "" || !p.stateRoom(frame.Room) {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := p.last[frame.Room]
+	previous, ok := keys[frame.Key]
+	if bytes.Equal(bytes.TrimSpace(frame.Payload), []byte("null")) {
+		// Tombstones remove the key, on the client too
+		delete(keys, frame.Key)
+		return false
+	}
+	if keys == nil {
+		keys = make(map[string]json.RawMessage)
+		p.last[frame.Room] = keys
+	}
+	keys[frame.Key] = frame.Payload
+	if !ok {
+		return false
+	}
+
+	patch, ok := message.Diff(previous, frame.Payload)
+	if !ok || len(patch) >= len(frame.Payload) || (chunkSize > 0 && len(patch) > chunkSize) {
+		metrics.StatePatches.WithLabelValues("full").Inc()
+		return false
+	}
+	metrics.StatePatches.WithLabelValues("patched").Inc()
+	metrics.StatePatchBytesSaved.Add(float64(len(frame.Payload) - len(patch)))
+	frame.Payload = nil
+	frame.Patch = patch
+	return true
+}
+
+// forget drops the payloads of a room the client left, which the client drops too.
+func (p *statePatches) forget(room string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.last, room)
+}
@@ -0,0 +1,29 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+func TestStatePatchesSendTheKeyAfterATombstoneInFull(t *testing.T) {
+	p := &statePatches{stateRoom: func(string) bool { return true }, last: make(map[string]map[string]json.RawMessage)}
+	frame := func(payload string) *message.Frame {
+		return &message.Frame{Type: message.FrameMessage, Room: "orders", Key: "order-1", Payload: []byte(payload)}
+	}
+	order := `{"id":1,"side":"buy","qty":10,"status":"open","notes":"deliver to the loading dock"}`
+
+	if p.patch(frame(order), 0) {
+		t.Fatal("first payload of the key patched")
+	}
+	if f := frame(`{"id":1,"side":"buy","qty":10,"status":"filled","notes":"deliver to the loading dock"}`); !p.patch(f, 0) {
+		t.Fatal("second payload of the key sent in full")
+	}
+	if f := frame(` null `); p.patch(f, 0) || string(f.Payload) != ` null ` {
+		t.Fatalf("tombstone patched to %s", f.Patch)
+	}
+	if f := frame(order); p.patch(f, 0) {
+		t.Fatalf("payload after the tombstone patched with %s", f.Patch)
+	}
+}
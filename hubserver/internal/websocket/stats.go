@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+)
+
+// Stats is a point-in-time snapshot of the handler's load.
+type Stats struct {
+	Connections         int    `json:"connections"`
+	MessagesProcessed   uint64 `json:"messages_processed"`
+	BroadcastQueueDepth int    `json:"broadcast_queue_depth"`
+	WriteQueueDepth     int    `json:"write_queue_depth"`
+	WriteQueueBytes     int64  `json:"write_queue_bytes"`
+	PausedConnections   int    `json:"paused_connections"`
+	Draining            bool   `json:"draining"`
+	// AwaitingReconnection is the number of clients the hub handed off before it restarted that are
+	// yet to reconnect
+	AwaitingReconnection int `json:"awaiting_reconnection"`
+}
+
+// Stats returns the current connection count, the number of messages processed since start, the queue depths and bytes,
+// the number of connections whose delivery is paused waiting for credit, whether the hub is draining and
+// how many clients it handed off before restarting are yet to reconnect.
+func (h *MessageHandler) Stats() Stats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	stats := Stats{
+		Connections:          len(h.connections),
+		MessagesProcessed:    h.messagesProcessed.Load(),
+		BroadcastQueueDepth:  len(h.broadcastCh) + h.fair.len(),
+		Draining:             h.draining.Load(),
+		AwaitingReconnection: int(h.awaitingReconnection.Load()),
+		WriteQueueBytes:      h.queueMemory.usedBytes(),
+	}
+	for _, conn := range h.connections {
+		stats.WriteQueueDepth += conn.queueDepth()
+		if conn.deliveries() == nil {
+			stats.PausedConnections++
+		}
+	}
+	return stats
+}
+
+// bufferMetricsInterval is how often buffer occupancy is sampled into the buffer gauges.
+const bufferMetricsInterval = 5 * time.Second
+
+// bufferUsage aggregates the occupancy of one kind of buffer.
+type bufferUsage struct {
+	length        int
+	capacity      int
+	maxSaturation float64
+}
+
+func (u *bufferUsage) add(length, capacity int) {
+	u.length += length
+	u.capacity += capacity
+	if capacity > 0 {
+		u.maxSaturation = max(u.maxSaturation, float64(length)/float64(capacity))
+	}
+}
+
+// reportBufferMetrics samples the occupancy of the handler's channels into the buffer gauges until ctx is done.
+func (h *MessageHandler) reportBufferMetrics(ctx context.Context) {
+	ticker := time.NewTicker(bufferMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for name, usage := range h.bufferUsage() {
+				metrics.BufferLength.WithLabelValues(name).Set(float64(usage.length))
+				metrics.BufferCapacity.WithLabelValues(name).Set(float64(usage.capacity))
+				metrics.BufferMaxSaturation.WithLabelValues(name).Set(usage.maxSaturation)
+			}
+			metrics.WriteQueueBytes.Set(float64(h.queueMemory.usedBytes()))
+			if h.spill != nil {
+				metrics.SpillLength.Set(float64(h.spill.queue.Len()))
+				metrics.SpillBytes.Set(float64(h.spill.queue.Size()))
+			}
+			if h.roomMetrics != nil {
+				h.roomMetrics.reportSubscribers(h.roomSubscribers())
+			}
+		}
+	}
+}
+
+// bufferUsage returns the occupancy of the broadcast and remove queues and, summed over
+// connections, of the connections' read and write queues.
+func (h *MessageHandler) bufferUsage() map[string]*bufferUsage {
+	usage := map[string]*bufferUsage{
+		"broadcast": {},
+		"remove":    {},
+		"read":      {},
+		"write":     {},
+	}
+	usage["broadcast"].add(len(h.broadcastCh), cap(h.broadcastCh))
+	if h.fair != nil {
+		usage["broadcast"].add(h.fair.len(), h.fair.capacity)
+	}
+	usage["remove"].add(len(h.remove), cap(h.remove))
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, conn := range h.connections {
+		usage["read"].add(len(conn.readCh), cap(conn.readCh))
+		usage["write"].add(conn.queueDepth(), conn.queueSize)
+	}
+	return usage
+}
+
+// roomSubscribers returns the number of connections subscribed to each room.
+func (h *MessageHandler) roomSubscribers() map[string]int {
+	subscribers := make(map[string]int)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, conn := range h.connections {
+		conn.roomsMu.RLock()
+		for room := range conn.rooms {
+			subscribers[room]++
+		}
+		conn.roomsMu.RUnlock()
+	}
+	return subscribers
+}
@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// unlimitedRestarts keeps restarting a goroutine for the lifetime of the process.
+	unlimitedRestarts = -1
+	// pumpRestarts bounds restarts of per-connection goroutines; past it the connection is closed.
+	pumpRestarts = 3
+	// restartDelay is the pause before restarting a goroutine that panicked.
+	restartDelay = 100 * time.Millisecond
+)
+
+// supervise runs fn and restarts it whenever it panics, until it returns normally or has been
+// restarted maxRestarts times. A negative maxRestarts restarts it indefinitely.
+func supervise(name string, maxRestarts int, logger *zap.Logger, fn func()) {
+	for restarts := 0; ; restarts++ {
+		if !runRecovered(name, logger, fn) {
+			return
+		}
+
+		if maxRestarts >= 0 && restarts >= maxRestarts {
+			logger.Error("Goroutine exceeded its restart budget", zap.String("goroutine", name), zap.Int("restarts", restarts))
+			return
+		}
+
+		time.Sleep(restartDelay)
+		logger.Warn("Restarting goroutine after panic", zap.String("goroutine", name))
+	}
+}
+
+// runRecovered runs fn and reports whether it panicked. The panic is logged with its stack and counted.
+func runRecovered(name string, logger *zap.Logger, fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			metrics.GoroutinePanics.WithLabelValues(name).Inc()
+			logger.Error("Recovered panic", zap.String("goroutine", name), zap.Any("panic", r), zap.Stack("stack"))
+		}
+	}()
+
+	fn()
+	return false
+}
@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// tapBuffer is the number of tapped messages queued for a tap; messages that do not fit are dropped
+// rather than slowing down the broadcast.
+const tapBuffer = 256
+
+// tapUpgrader upgrades tap requests, which are served on the admin address only.
+var tapUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// TapMessage is a copy of a broadcast message written to a tap.
+type TapMessage struct {
+	ID          string          `json:"id,omitempty"`
+	Room        string          `json:"room,omitempty"`
+	OriginID    string          `json:"origin_id"`
+	HubID       string          `json:"hub_id"`
+	Zone        string          `json:"zone,omitempty"`
+	ContentType string          `json:"content_type,omitempty"`
+	Ephemeral   bool            `json:"ephemeral,omitempty"`
+	Size        int             `json:"size"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	TappedAt    time.Time       `json:"tapped_at"`
+}
+
+// tapFilter selects and redacts the messages mirrored to a tap.
+type tapFilter struct {
+	room     string
+	originID string
+	hubID    string
+	sample   float64
+	// redact lists the top-level payload fields removed; omitPayload drops the payload altogether
+	redact      []string
+	omitPayload bool
+}
+
+// parseTapFilter reads a tap filter from the query parameters room, origin, hub, sample and redact.
+func parseTapFilter(query url.Values) (tapFilter, error) {
+	filter := tapFilter{
+		room:     query.Get("room"),
+		originID: query.Get("origin"),
+		hubID:    query.Get("hub"),
+		sample:   1,
+	}
+	if value := query.Get("sample"); value != "" {
+		sample, err := strconv.ParseFloat(value, 64)
+		if err != nil || sample <= 0 || sample > 1 {
+			return tapFilter{}, fmt.Errorf("sample must be a fraction in (0, 1], got %q", value)
+		}
+		filter.sample = sample
+	}
+	for _, field := range strings.Split(query.Get("redact"), ",") {
+		switch field = strings.TrimSpace(field); field {
+		case "":
+		case "*":
+			filter.omitPayload = true
+		default:
+			filter.redact = append(filter.redact, field)
+		}
+	}
+	return filter, nil
+}
+
+// matches reports whether a message passes the filter and is sampled.
+func (f tapFilter) matches(md *message.MessageDetails) bool {
+	if f.originID != "" && md.OriginID != f.originID {
+		return false
+	}
+	if f.hubID != "" && md.HubID != f.hubID {
+		return false
+	}
+	return f.sample >= 1 || rand.Float64() < f.sample
+}
+
+// payload returns the message payload with the filter's redactions applied.
+func (f tapFilter) payload(md *message.MessageDetails) json.RawMessage {
+	if f.omitPayload {
+		return nil
+	}
+	if len(f.redact) == 0 {
+		return md.Message
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(md.Message, &fields); err != nil {
+		// Payloads that are not objects can't be redacted field by field, so they are withheld.
+		return nil
+	}
+	for _, field := range f.redact {
+		delete(fields, field)
+	}
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// ServeTap upgrades an admin request to a WebSocket connection receiving a copy of the messages
+// broadcast by the hub, filtered by the query parameters room, origin and hub, sampled with sample
+// and stripped of the payload fields listed in redact (* omits payloads). The tap only listens:
+// anything the client sends is discarded, and messages it reads too slowly for are dropped.
+func (h *MessageHandler) ServeTap(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTapFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ws, err := tapUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.Warn("Failed to upgrade tap", zap.Error(err))
+		return
+	}
+	defer ws.Close()
+
+	messages, unsubscribe := h.Subscribe(filter.room, tapBuffer)
+	defer unsubscribe()
+	h.logger.Info("Tap attached", zap.String("remote-addr", r.RemoteAddr), zap.String("room", filter.room),
+		zap.String("origin", filter.originID), zap.String("hub", filter.hubID), zap.Float64("sample", filter.sample))
+
+	// Read until the client goes away so control frames are answered and the tap ends with it.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			h.logger.Info("Tap detached", zap.String("remote-addr", r.RemoteAddr))
+			return
+		case <-h.ctx.Done():
+			_ = ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "hub shutting down"),
+				time.Now().Add(h.writeTimeout))
+			return
+		case md, ok := <-messages:
+			if !ok {
+				return
+			}
+			if !filter.matches(&md) {
+				continue
+			}
+
+			tapped := TapMessage{
+				ID:          md.ID,
+				Room:        md.Room,
+				OriginID:    md.OriginID,
+				HubID:       md.HubID,
+				Zone:        md.Zone,
+				ContentType: md.ContentType,
+				Ephemeral:   md.Ephemeral,
+				Size:        len(md.Message),
+				Payload:     filter.payload(&md),
+				TappedAt:    time.Now(),
+			}
+			_ = ws.SetWriteDeadline(time.Now().Add(h.writeTimeout))
+			if err := ws.WriteJSON(tapped); err != nil {
+				h.logger.Info("Tap write failed, detaching", zap.String("remote-addr", r.RemoteAddr), zap.Error(err))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/clock"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// SetClock replaces the clock messages are stamped with, such as with a fake clock in tests. It
+// must be called before the handler runs.
+func (h *MessageHandler) SetClock(c clock.Clock) {
+	h.clock = c
+	if h.hlc != nil {
+		h.hlc = clock.NewHLC(c, h.hlcMaxDrift)
+	}
+}
+
+// stampIngest stamps a message taken in by this hub with the time it was ingested and, with hybrid
+// logical timestamps, its HLC reading. Messages from other hubs keep their stamps and advance the
+// hybrid logical clock past theirs instead, so the messages this hub stamps next order after them.
+func (h *MessageHandler) stampIngest(md *message.MessageDetails) {
+	if md.Kind != message.KindMessage {
+		return
+	}
+
+	if md.IsFromPubSub(h.pubSubChannel) {
+		if h.hlc == nil || md.HLC == "" {
+			return
+		}
+		ts, err := clock.ParseTimestamp(md.HLC)
+		if err != nil {
+			h.logger.Warn("Ignoring malformed hybrid logical timestamp", zap.String("id", md.ID), zap.String("hub-id", md.HubID), zap.String("hlc", md.HLC))
+			return
+		}
+		if !h.hlc.Update(ts) {
+			metrics.HLCDriftRejected.Inc()
+			h.logger.Warn("Ignoring hybrid logical timestamp too far ahead of the hub's clock", zap.String("id", md.ID), zap.String("hub-id", md.HubID), zap.String("hlc", md.HLC))
+		}
+		return
+	}
+
+	if md.IngestedAt == 0 {
+		md.IngestedAt = h.clock.Now().UnixMilli()
+	}
+	if h.hlc != nil && md.HLC == "" {
+		md.HLC = h.hlc.Now().String()
+	}
+}
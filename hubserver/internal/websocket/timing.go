@@ -0,0 +1,79 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Time spent by messages in the stages of the broadcast pipeline, from reading them off their
+// publisher's socket to writing them to the sockets of their recipients.
+var (
+	// stageRead is the read channel wait and frame handling, such as rate limits and room access
+	// checks, until the message is queued for broadcasting
+	stageRead = metrics.PipelineStageSeconds.WithLabelValues("read")
+	// stageIngestWait is the wait in the broadcast channel for a broadcast worker
+	stageIngestWait = metrics.PipelineStageSeconds.WithLabelValues("ingest_wait")
+	// stageRecord is the recording of a batch's history and room state in Redis, and its aggregation
+	stageRecord = metrics.PipelineStageSeconds.WithLabelValues("record")
+	// stageFanOut is the queuing of a batch on the connections, holding the registry lock
+	stageFanOut = metrics.PipelineStageSeconds.WithLabelValues("fan_out")
+	// stageWriteWait is the wait in a connection's write queue, counted from the fan-out of the
+	// message's batch
+	stageWriteWait = metrics.PipelineStageSeconds.WithLabelValues("write_wait")
+	// stageWrite is the write of the message's frames to the socket
+	stageWrite = metrics.PipelineStageSeconds.WithLabelValues("write")
+	// stagePublish is the publish to the other hubs through the broker
+	stagePublish = metrics.PipelineStageSeconds.WithLabelValues("publish")
+)
+
+// inbound is a message read from a client, with the time it was read in Unix nanoseconds.
+type inbound struct {
+	data   []byte
+	readAt int64
+}
+
+// observeStage observes the time between two Unix nanosecond times in a stage's histogram, unless
+// the message skipped the stage and from is zero.
+func observeStage(stage interface{ Observe(float64) }, from, to int64) {
+	if from > 0 && to >= from {
+		stage.Observe(time.Duration(to - from).Seconds())
+	}
+}
+
+// traceWrite logs the time a message written to the client spent in each stage of the pipeline,
+// when the hub traces it. Stages the message skipped are logged as zero.
+func (c *Connection) traceWrite(md *message.MessageDetails, dequeued int64, write time.Duration) {
+	if !c.tracePipeline {
+		return
+	}
+
+	t := md.Timing
+	span := func(from, to int64) time.Duration {
+		if from == 0 || to < from {
+			return 0
+		}
+		return time.Duration(to - from)
+	}
+	c.log().Info("Message pipeline timing",
+		zap.String("id", md.ID),
+		zap.String("room", md.Room),
+		zap.Duration("read", span(t.Read, t.Queued)),
+		zap.Duration("ingest-wait", span(t.Queued, t.Batched)),
+		zap.Duration("record", span(t.Batched, t.FannedOut)),
+		zap.Duration("write-wait", span(t.FannedOut, dequeued)),
+		zap.Duration("write", write),
+		zap.Duration("total", span(nonZero(t.Read, t.Queued, t.Batched, t.FannedOut), dequeued)+write))
+}
+
+// nonZero returns the first of the times that is not zero, or zero.
+func nonZero(times ...int64) int64 {
+	for _, t := range times {
+		if t != 0 {
+			return t
+		}
+	}
+	return 0
+}
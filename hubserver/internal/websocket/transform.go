@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// Subscriber describes the connection a message is about to be written to.
+type Subscriber struct {
+	ConnID   string
+	Identity auth.Identity
+	// Language is the client's preferred language from the upgrade request's Accept-Language header.
+	Language string
+}
+
+// Transform rewrites a message payload for a single subscriber just before it is written, so
+// subscribers of the same broadcast can receive tailored views. Returning an error drops the
+// message for that subscriber.
+type Transform func(sub Subscriber, payload []byte) ([]byte, error)
+
+// AddTransform registers a transform applied to every message written to connections created
+// afterwards. Transforms run in the order they were added.
+func (h *MessageHandler) AddTransform(t Transform) {
+	h.transforms = append(h.transforms, t)
+}
+
+// RedactFields returns a transform that removes top-level JSON object fields from the payload
+// unless the subscriber holds one of the roles listed for the field. Payloads that are not JSON
+// objects are passed through unchanged.
+func RedactFields(rules map[string][]string) Transform {
+	return func(sub Subscriber, payload []byte) ([]byte, error) {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(payload, &fields); err != nil {
+			return payload, nil
+		}
+
+		redacted := false
+		for field, roles := range rules {
+			if _, ok := fields[field]; !ok || hasAnyRole(sub.Identity, roles) {
+				continue
+			}
+			delete(fields, field)
+			redacted = true
+		}
+		if !redacted {
+			return payload, nil
+		}
+		return json.Marshal(fields)
+	}
+}
+
+// ParseRedactionRules parses rules of the form field=role1|role2 into the map used by RedactFields.
+func ParseRedactionRules(specs []string) (map[string][]string, error) {
+	rules := make(map[string][]string, len(specs))
+	for _, spec := range specs {
+		field, roles, ok := strings.Cut(spec, "=")
+		if !ok || field == "" || roles == "" {
+			return nil, fmt.Errorf("invalid redaction rule %q, expected field=role[|role]", spec)
+		}
+		rules[field] = append(rules[field], strings.Split(roles, "|")...)
+	}
+	return rules, nil
+}
+
+func hasAnyRole(identity auth.Identity, roles []string) bool {
+	for _, role := range roles {
+		if slices.Contains(identity.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// transform applies the connection's transforms to the message payload. It reports false when
+// a transform failed and the message must not be written.
+func (c *Connection) transform(md *message.MessageDetails) bool {
+	if len(c.transforms) == 0 {
+		return true
+	}
+
+	payload, err := applyTransforms(c.transforms, Subscriber{ConnID: c.id, Identity: c.identity, Language: c.language}, md.Message)
+	if err != nil {
+		c.log().Warn("Dropping message rejected by transform", zap.String("message-id", md.ID), zap.Error(err))
+		return false
+	}
+	md.Message = payload
+	return true
+}
+
+// transformRead applies the handler's transforms to the payload of a message read over HTTP, as
+// they are applied to the messages written to the reader's connections. It reports false when a
+// transform failed and the message must be left out of the response.
+func (h *MessageHandler) transformRead(sub Subscriber, md *message.MessageDetails) bool {
+	payload, err := applyTransforms(h.transforms, sub, md.Message)
+	if err != nil {
+		h.logger.Warn("Leaving out message rejected by transform", zap.String("message-id", md.ID), zap.String("room", md.Room), zap.Error(err))
+		return false
+	}
+	md.Message = payload
+	return true
+}
+
+// reader returns the subscriber reading messages with an HTTP request.
+func reader(r *http.Request, identity auth.Identity) Subscriber {
+	return Subscriber{Identity: identity, Language: preferredLanguage(r.Header.Get("Accept-Language"))}
+}
+
+func applyTransforms(transforms []Transform, sub Subscriber, payload []byte) ([]byte, error) {
+	for _, t := range transforms {
+		var err error
+		if payload, err = t(sub, payload); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// preferredLanguage returns the first language tag of an Accept-Language header.
+func preferredLanguage(header string) string {
+	tag, _, _ := strings.Cut(header, ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	return strings.TrimSpace(tag)
+}
@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/hubtest"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+)
+
+// redactingHandler returns a handler redacting the card field of payloads for readers without the
+// billing role, and dropping payloads with a secret field for everyone.
+func redactingHandler(t *testing.T) *MessageHandler {
+	t.Helper()
+
+	h := runHandler(t, "hub-1", hubtest.NewBroker("test-channel", "hub-1"))
+	h.AddTransform(RedactFields(map[string][]string{"card": {"billing"}}))
+	h.AddTransform(func(_ Subscriber, payload []byte) ([]byte, error) {
+		if _, ok := jsonFields(t, payload)["secret"]; ok {
+			return nil, errors.New("secret payload")
+		}
+		return payload, nil
+	})
+	return h
+}
+
+// readers are the readers of redacted payloads, with the payload each one reads.
+var readers = []struct {
+	identity auth.Identity
+	want     string
+}{
+	{auth.Identity{UserID: "alice"}, `{"id":1}`},
+	{auth.Identity{UserID: "bob", Roles: []string{"billing"}}, `{"id":1,"card":"4111"}`},
+}
+
+func storedMessage(id, payload string) message.MessageDetails {
+	md := message.NewMessageDetails("client-1", "hub-1", "client-1", []byte(payload))
+	md.ID, md.Room = id, "orders"
+	return md
+}
+
+func TestHistoryResponsesAreTransformedForTheReader(t *testing.T) {
+	h := redactingHandler(t)
+	for _, r := range readers {
+		entries := []redis.HistoryEntry{
+			{Cursor: "1-0", Message: storedMessage("order-1", `{"id":1,"card":"4111"}`)},
+			{Cursor: "2-0", Message: storedMessage("order-2", `{"id":2,"secret":true}`)},
+		}
+		response := h.historyResponse(Subscriber{Identity: r.identity}, entries)
+		if len(response.Messages) != 1 || string(response.Messages[0].Payload) != r.want {
+			t.Fatalf("%s read the history %+v, want the single payload %s", r.identity.UserID, response.Messages, r.want)
+		}
+		// The page resumes after the message left out
+		if response.Next != "2-0" {
+			t.Fatalf("%s reads the next page after %s, want 2-0", r.identity.UserID, response.Next)
+		}
+	}
+}
+
+func TestStateResponsesAreTransformedForTheReader(t *testing.T) {
+	h := redactingHandler(t)
+	for _, r := range readers {
+		snapshot := []message.MessageDetails{
+			storedMessage("order-1", `{"id":1,"card":"4111"}`),
+			storedMessage("order-2", `{"id":2,"secret":true}`),
+		}
+		response := h.stateResponse(Subscriber{Identity: r.identity}, snapshot)
+		if len(response.Messages) != 1 || string(response.Messages[0].Payload) != r.want {
+			t.Fatalf("%s read the state %+v, want the single payload %s", r.identity.UserID, response.Messages, r.want)
+		}
+	}
+}
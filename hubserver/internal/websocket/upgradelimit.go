@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	// errUpgradeRateLimited is returned for upgrade attempts above the hub's upgrade rate.
+	errUpgradeRateLimited = errors.New("hub upgrade rate exceeded")
+	// errIPUpgradeRateLimited is returned for upgrade attempts above the upgrade rate of a client IP.
+	errIPUpgradeRateLimited = errors.New("client IP upgrade rate exceeded")
+)
+
+// upgradeLimiter holds the token buckets of the upgrade attempts the hub accepts in total and from
+// each client IP, so a reconnect storm after a network blip is admitted at a pace the hub sustains.
+type upgradeLimiter struct {
+	global *rate.Limiter
+	perIP  *memoryLimiter
+}
+
+// newUpgradeLimiter returns the limiter of the upgrade rates, or nil when both are disabled.
+func newUpgradeLimiter(perSecond float64, burst int, perIPPerSecond float64, perIPBurst int) *upgradeLimiter {
+	if perSecond <= 0 && perIPPerSecond <= 0 {
+		return nil
+	}
+
+	l := &upgradeLimiter{}
+	if perSecond > 0 {
+		l.global = rate.NewLimiter(rate.Limit(perSecond), burst)
+	}
+	if perIPPerSecond > 0 {
+		l.perIP = newMemoryLimiter(perIPPerSecond, perIPBurst)
+	}
+	return l
+}
+
+// allow takes a token for an upgrade attempt from the client IP's bucket, then from the hub's.
+// Attempts refused by the IP's bucket leave the hub's untouched for the other clients.
+func (l *upgradeLimiter) allow(remoteIP netip.Addr) error {
+	if l == nil {
+		return nil
+	}
+	if l.perIP != nil {
+		if allowed, _ := l.perIP.Allow(context.Background(), remoteIP.String()); !allowed {
+			return errIPUpgradeRateLimited
+		}
+	}
+	if l.global != nil && !l.global.Allow() {
+		return errUpgradeRateLimited
+	}
+	return nil
+}
+
+// setRetryAfter tells a client whose upgrade attempt was rate limited when to retry, jittered like
+// the reconnect delay of close reasons so rejected clients don't come back in lockstep.
+func (h *MessageHandler) setRetryAfter(w http.ResponseWriter) {
+	retryAfter := max(h.retryAfter, time.Second)
+	retryAfter += rand.N(retryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+}
@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/webtransport-go"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// webTransportStreamTimeout is how long a WebTransport session may take to open the stream its
+// connection is carried over.
+const webTransportStreamTimeout = 10 * time.Second
+
+// ServeWebTransport handles the WebTransport (HTTP/3) session requests of the server, serving each
+// as a connection of the hub, admitted, authenticated and authorized like WebSocket upgrades. The
+// session's client opens one bidirectional stream carrying the hub.v1 frames of the connection,
+// each prefixed with its WebSocket opcode and length, as WebTransport has no message framing of
+// its own; the stream reaches the hub once the client writes to it. WebTransport connections do
+// not support the compression capability.
+func (h *MessageHandler) ServeWebTransport(server *webtransport.Server, w http.ResponseWriter, r *http.Request) {
+	// Sessions always speak hub.v1, which WebTransport does not negotiate
+	r.Header.Set("Sec-WebSocket-Protocol", message.Subprotocol)
+	h.serve(w, r, func(w http.ResponseWriter, r *http.Request, h *MessageHandler, id string, remoteIP netip.Addr, identity auth.Identity, quota Quota, keepaliveClass string, header http.Header) (*Connection, error) {
+		return upgradeWebTransport(server, w, r, h, id, remoteIP, identity, quota, keepaliveClass, header)
+	})
+}
+
+// upgradeWebTransport accepts a WebTransport session request and the stream its client opens, as
+// Upgrade does for WebSocket connections.
+func upgradeWebTransport(server *webtransport.Server, w http.ResponseWriter, r *http.Request, h *MessageHandler, id string, remoteIP netip.Addr, identity auth.Identity, quota Quota, keepaliveClass string, header http.Header) (*Connection, error) {
+	enabled := requestedCapabilities(r) & h.hubCapabilities() &^ capCompression
+	session := cmp.Or(requestedSession(r), id)
+	for name, values := range h.upgradeResponseHeader(header) {
+		w.Header()[name] = values
+	}
+	w.Header().Set(capabilitiesHeader, enabled.String())
+	w.Header().Set(sessionHeader, session)
+
+	wt, err := server.Upgrade(w, r)
+	if err != nil {
+		h.logger.Error("Failed to upgrade to WebTransport session", zap.Error(err))
+		return nil, fmt.Errorf("failed to upgrade to WebTransport session: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(wt.Context(), webTransportStreamTimeout)
+	defer cancel()
+	stream, err := wt.AcceptStream(ctx)
+	if err != nil {
+		_ = wt.CloseWithError(0, "no stream opened")
+		h.logger.Error("Failed to accept WebTransport stream", zap.Error(err))
+		return nil, fmt.Errorf("failed to accept WebTransport stream: %w", err)
+	}
+
+	ws := newWebTransportConn(wt, stream)
+	conn := newUpgradedConnection(h, r, id, ws, remoteIP, identity, quota, keepaliveClass, enabled, session)
+	conn.start(h)
+	return conn, nil
+}
+
+// webTransportConn is a Conn over a WebTransport stream. Each message is sent as its WebSocket
+// opcode, its length as a big-endian uint32 and its data. Pings are answered with pongs as
+// *websocket.Conn does.
+type webTransportConn struct {
+	session *webtransport.Session
+	stream  webtransport.Stream
+	reader  *bufio.Reader
+
+	// readLimit and pong are only used by the connection's read pump
+	readLimit int64
+	pong      func(appData string) error
+
+	// writeMu serializes writes, which WriteControl may make concurrently with WriteMessage
+	writeMu       sync.Mutex
+	writeDeadline time.Time
+	buf           []byte
+}
+
+func newWebTransportConn(session *webtransport.Session, stream webtransport.Stream) *webTransportConn {
+	return &webTransportConn{
+		session: session,
+		stream:  stream,
+		reader:  bufio.NewReader(stream),
+		pong:    func(string) error { return nil },
+	}
+}
+
+func (c *webTransportConn) Subprotocol() string {
+	return message.Subprotocol
+}
+
+func (c *webTransportConn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+func (c *webTransportConn) SetReadDeadline(t time.Time) error {
+	return c.stream.SetReadDeadline(t)
+}
+
+func (c *webTransportConn) SetPongHandler(h func(appData string) error) {
+	if h == nil {
+		h = func(string) error { return nil }
+	}
+	c.pong = h
+}
+
+// ReadMessage returns the next text or binary message of the stream, handling the control
+// messages before it. A close message is returned as a *websocket.CloseError.
+func (c *webTransportConn) ReadMessage() (int, []byte, error) {
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return 0, nil, err
+		}
+		messageType, size := int(header[0]), int64(binary.BigEndian.Uint32(header[1:]))
+		if c.readLimit > 0 && size > c.readLimit {
+			_ = c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(time.Second))
+			return 0, nil, websocket.ErrReadLimit
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return 0, nil, err
+		}
+
+		switch messageType {
+		case websocket.TextMessage, websocket.BinaryMessage:
+			return messageType, data, nil
+		case websocket.PingMessage:
+			if err := c.WriteControl(websocket.PongMessage, data, time.Now().Add(time.Second)); err != nil {
+				return 0, nil, err
+			}
+		case websocket.PongMessage:
+			if err := c.pong(string(data)); err != nil {
+				return 0, nil, err
+			}
+		case websocket.CloseMessage:
+			closeErr := &websocket.CloseError{Code: websocket.CloseNoStatusReceived}
+			if len(data) >= 2 {
+				closeErr.Code, closeErr.Text = int(binary.BigEndian.Uint16(data)), string(data[2:])
+			}
+			return 0, nil, closeErr
+		default:
+			return 0, nil, fmt.Errorf("unknown WebTransport message type %d", messageType)
+		}
+	}
+}
+
+func (c *webTransportConn) SetWriteDeadline(t time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
+
+func (c *webTransportConn) WriteMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeLocked(messageType, data, c.writeDeadline)
+}
+
+func (c *webTransportConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeLocked(messageType, data, deadline)
+}
+
+// writeLocked writes a message by the deadline. c.writeMu must be held.
+func (c *webTransportConn) writeLocked(messageType int, data []byte, deadline time.Time) error {
+	if uint64(len(data)) > 1<<32-1 {
+		return errors.New("message too large for a WebTransport stream")
+	}
+	if err := c.stream.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	c.buf = append(c.buf[:0], byte(messageType))
+	c.buf = binary.BigEndian.AppendUint32(c.buf, uint32(len(data)))
+	c.buf = append(c.buf, data...)
+	_, err := c.stream.Write(c.buf)
+	return err
+}
+
+// Close closes the WebTransport session, and with it the stream.
+func (c *webTransportConn) Close() error {
+	return c.session.CloseWithError(0, "")
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/hubtest"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// selfSignedCertificate returns a certificate for 127.0.0.1 signed by its own key.
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// dialWebTransport serves WebTransport sessions of the handler on a loopback UDP port and returns
+// the connection of a session opened to it.
+func dialWebTransport(t *testing.T, h *MessageHandler) *webTransportConn {
+	t.Helper()
+
+	server := &webtransport.Server{
+		H3:          http3.Server{TLSConfig: &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate(t)}}},
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	server.H3.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeWebTransport(server, w, r)
+	})
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	go func() { _ = server.Serve(udp) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	dialer := &webtransport.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, NextProtos: []string{http3.NextProtoH3}}}
+	t.Cleanup(func() { _ = dialer.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, session, err := dialer.Dial(ctx, "https://"+udp.LocalAddr().String()+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	return newWebTransportConn(session, stream)
+}
+
+func TestWebTransportSessionsAreServedAsConnections(t *testing.T) {
+	cfg := testConfig()
+	cfg.DeliveryReceipts = true
+	h, err := NewMessageHandler(hubtest.NewBroker("test-channel", "test-hub"), nil, cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewMessageHandler: %v", err)
+	}
+	go h.Run()
+	t.Cleanup(func() { _ = h.Close() })
+	_, member := attach(t, h, message.Subprotocol, "orders")
+	client := dialWebTransport(t, h)
+
+	publish, _ := json.Marshal(message.Frame{Type: message.FrameMessage, ID: "order-1", Room: "orders", Payload: []byte(`{"id":1}`), Receipt: true})
+	if err := client.WriteMessage(websocket.TextMessage, publish); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	frame := receiveFrame(t, member, message.FrameMessage)
+	if frame.ID != "order-1" || string(frame.Payload) != `{"id":1}` {
+		t.Fatalf("member received %+v", frame)
+	}
+
+	if err := client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline: %v", err)
+	}
+	for {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		var frame message.Frame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("WebTransport client received %s: %v", data, err)
+		}
+		if frame.Type == message.FrameReceipt {
+			if frame.ID != "order-1" || frame.Count != 1 {
+				t.Fatalf("WebTransport client received the receipt %+v", frame)
+			}
+			break
+		}
+	}
+}
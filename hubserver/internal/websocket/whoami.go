@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"cmp"
+	"encoding/json"
+	"time"
+
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"go.uber.org/zap"
+)
+
+// whoamiPayload is the state of a connection as the hub perceives it, sent in answer to the
+// client's whoami frames for debugging SDKs and attaching to support tickets.
+type whoamiPayload struct {
+	ConnectionID    string   `json:"connection_id"`
+	SessionID       string   `json:"session_id"`
+	HubID           string   `json:"hub_id"`
+	UserID          string   `json:"user_id,omitempty"`
+	Roles           []string `json:"roles,omitempty"`
+	RemoteIP        string   `json:"remote_ip,omitempty"`
+	ProtocolVersion int      `json:"protocol_version"`
+	KeepaliveClass  string   `json:"keepalive_class"`
+	Capabilities    []string `json:"capabilities"`
+	Echo            string   `json:"echo"`
+	Rooms           []string `json:"rooms"`
+	// MaxRooms is the number of rooms the connection may be subscribed to, zero when unlimited
+	MaxRooms    int              `json:"max_rooms,omitempty"`
+	Groups      []string         `json:"groups,omitempty"`
+	RateLimit   *rateLimitBudget `json:"rate_limit,omitempty"`
+	Throttled   bool             `json:"throttled,omitempty"`
+	ConnectedAt time.Time        `json:"connected_at"`
+}
+
+// rateLimitBudget is the message rate a connection is held to, with the messages it may still send
+// in a burst.
+type rateLimitBudget struct {
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	Burst             int     `json:"burst"`
+	Remaining         int     `json:"remaining"`
+}
+
+// whoami answers a whoami frame with the state of the connection, echoing the frame's correlation
+// id. Only the connection itself is described, so the answer never leaves the hub.
+func (h *MessageHandler) whoami(conn *Connection, frame message.Frame) {
+	payload := whoamiPayload{
+		ConnectionID:    conn.id,
+		SessionID:       conn.session,
+		HubID:           h.hubID,
+		UserID:          conn.identity.UserID,
+		Roles:           conn.identity.Roles,
+		ProtocolVersion: conn.protocolVersion,
+		KeepaliveClass:  conn.keepaliveClass,
+		Capabilities:    conn.capabilities.names(),
+		Echo:            cmp.Or(conn.echo, message.EchoOthers),
+		Rooms:           conn.roomNames(),
+		MaxRooms:        conn.maxRooms,
+		Groups:          conn.groupNames(),
+		Throttled:       conn.throttle.Load() != nil,
+		ConnectedAt:     conn.connectedAt,
+	}
+	if conn.remoteIP.IsValid() {
+		payload.RemoteIP = conn.remoteIP.String()
+	}
+	// The ingest goroutine answering the frame owns the limiter
+	limiter := conn.limiter
+	if override := conn.rateOverride.Load(); override != nil {
+		limiter = override
+	}
+	if limiter != nil {
+		payload.RateLimit = &rateLimitBudget{
+			MessagesPerSecond: float64(limiter.Limit()),
+			Burst:             limiter.Burst(),
+			Remaining:         max(int(limiter.Tokens()), 0),
+		}
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		conn.log().Warn("Failed to encode whoami", zap.Error(err))
+		return
+	}
+	reply := message.Frame{Type: message.FrameWhoami, CorrelationID: frame.CorrelationID, HubID: h.hubID, Payload: encoded}
+	data, err := reply.ToJSON()
+	if err != nil {
+		conn.log().Warn("Failed to encode whoami", zap.Error(err))
+		return
+	}
+	h.writeControl(conn.id, data)
+}
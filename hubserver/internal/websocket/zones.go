@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// advertiseRooms records every refresh interval that the hub's zone has members of the rooms its
+// connections are subscribed to, until ctx is done.
+func (h *MessageHandler) advertiseRooms(ctx context.Context) {
+	ticker := time.NewTicker(h.zones.Refresh())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			subscribers := h.roomSubscribers()
+			rooms := make([]string, 0, len(subscribers))
+			for room := range subscribers {
+				rooms = append(rooms, room)
+			}
+			if err := h.zones.Advertise(ctx, rooms); err != nil {
+				h.logger.Warn("Failed to advertise the zone's rooms", zap.Int("rooms", len(rooms)), zap.Error(err))
+			}
+		}
+	}
+}
+
+// advertiseRoom records in the background that the hub's zone has members of a room a connection
+// just joined, so hubs of other zones route its messages here without waiting for the next refresh.
+func (h *MessageHandler) advertiseRoom(room string) {
+	if h.zones == nil {
+		return
+	}
+	go func() {
+		if err := h.zones.Advertise(h.ctx, []string{room}); err != nil {
+			h.logger.Warn("Failed to advertise room", zap.String("room", room), zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,598 @@
+// Package client connects Go programs to a hub as a hub.v1 client: joining rooms, sending messages
+// and receiving the frames the hub delivers, like the JavaScript client does in browsers.
+//
+//	c, err := client.Dial(ctx, "hub.example.com:8080", client.Options{Token: token})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	_ = c.Join("orders", "")
+//	for frame := range c.Frames() {
+//		fmt.Println(frame.Room, string(frame.Payload))
+//	}
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+// Frame is a frame exchanged with the hub. Message frames delivered in chunks are reassembled
+// before they are received.
+type Frame = message.Frame
+
+// Frame types received from the hub.
+const (
+	FrameMessage     = message.FrameMessage
+	FrameReceipt     = message.FrameReceipt
+	FrameNack        = message.FrameNack
+	FrameError       = message.FrameError
+	FrameDeprecation = message.FrameDeprecation
+	FrameConfig      = message.FrameConfig
+)
+
+// Echo policies of the messages sent, naming which connections of the client's user receive them.
+const (
+	EchoOthers = message.EchoOthers
+	EchoAll    = message.EchoAll
+	EchoNone   = message.EchoNone
+)
+
+const (
+	// maxFrameSize is the largest frame the hub accepts from clients; larger messages are uploaded
+	// in chunks of uploadChunkSize bytes of payload
+	maxFrameSize    = 512
+	uploadChunkSize = 256
+	// capabilities are the features the client announces: receipts and batched deliveries
+	capabilities = "acks,batching"
+	// writeWait bounds the writes of frames to the hub
+	writeWait = 10 * time.Second
+	// defaultReconnectDelay and defaultMaxReconnectDelay are the first and longest delays between
+	// reconnect attempts
+	defaultReconnectDelay    = 500 * time.Millisecond
+	defaultMaxReconnectDelay = 30 * time.Second
+)
+
+// Overflow policies of the offline queue, naming the message dropped once it is full.
+const (
+	OverflowOldest = "oldest"
+	OverflowNewest = "newest"
+)
+
+// Reasons messages are dropped from the offline queue for.
+const (
+	// DropOverflow reports a message dropped as the queue was full
+	DropOverflow = "overflow"
+	// DropClosed reports a message dropped as the client was closed or will not reconnect
+	DropClosed = "closed"
+)
+
+var (
+	// ErrClosed is returned by the operations of a Client whose connection closed.
+	ErrClosed = errors.New("connection to the hub is closed")
+	// ErrDisconnected is returned by the operations of a Client that is reconnecting and cannot
+	// hold what they send.
+	ErrDisconnected = errors.New("disconnected from the hub")
+	// ErrOfflineQueueFull is returned by Send when the offline queue is full and its overflow
+	// policy drops the message being sent.
+	ErrOfflineQueueFull = errors.New("offline queue is full")
+)
+
+// Options configures the connection to the hub.
+type Options struct {
+	// Token is the access token the hub authenticates the connection with, if it requires one
+	Token string
+	// TLSConfig configures the TLS of wss addresses
+	TLSConfig *tls.Config
+	// Header holds extra headers of the upgrade request
+	Header http.Header
+	// HandshakeTimeout bounds the upgrade, 10 seconds when zero
+	HandshakeTimeout time.Duration
+	// Buffer is the number of received frames held until Frames is read, 256 when zero
+	Buffer int
+	// Echo is the echo policy of the messages sent; empty leaves the hub's default, EchoOthers
+	Echo string
+
+	// Reconnect redials the hub when the connection is lost, until Close is called, rejoining the
+	// rooms joined. Connections the hub closed as unauthorized are not reopened, as the token
+	// would be refused again.
+	Reconnect bool
+	// ReconnectDelay is the delay before the first reconnect attempt, doubled after each failed
+	// attempt up to MaxReconnectDelay; 500 milliseconds and 30 seconds when zero. A retry delay
+	// the hub sent in its close reason replaces the first delay.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+	// OfflineQueue is the number of messages sent while reconnecting that are held and sent in
+	// order once the client reconnects; 0 disables the queue, so Send returns ErrDisconnected.
+	OfflineQueue int
+	// OfflineOverflow is the overflow policy of a full offline queue: OverflowOldest, the
+	// default, drops the oldest message to make room and OverflowNewest the one being sent.
+	OfflineOverflow string
+	// OnDropped is called with every message dropped from the offline queue and the reason,
+	// DropOverflow or DropClosed, from the goroutine of Send or of the connection. It is called
+	// without the Client's lock held, so it may use the Client.
+	OnDropped func(frame Frame, reason string)
+}
+
+// Client is a connection to a hub, reopened as the options ask when it is lost. Its methods are
+// safe for concurrent use.
+type Client struct {
+	opts   Options
+	dialer *websocket.Dialer
+	// target is the URL of the hub, replaced by the alternate hub a closing hub names
+	target string
+
+	// mu guards the connection and the state it is resumed from, and serializes writes, so the
+	// messages queued while reconnecting are sent before those sent once reconnected
+	mu      sync.Mutex
+	ws      *websocket.Conn
+	joined  map[string]string
+	offline []Frame
+	closing bool
+	done    chan struct{}
+
+	frames chan Frame
+	chunks map[string][][]byte
+
+	err atomic.Pointer[error]
+}
+
+// Dial connects to the hub at addr: a ws:// or wss:// URL, or a host:port whose /ws endpoint is
+// dialled over plain WebSocket. The connection lasts until Close is called, the context of the
+// dial has no bearing on it. The first connection must succeed; only later ones are retried.
+func Dial(ctx context.Context, addr string, opts Options) (*Client, error) {
+	target, err := dialURL(addr, opts.Token, opts.Echo)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: opts.HandshakeTimeout,
+		TLSClientConfig:  opts.TLSConfig,
+		Subprotocols:     []string{message.Subprotocol},
+	}
+	if dialer.HandshakeTimeout == 0 {
+		dialer.HandshakeTimeout = 10 * time.Second
+	}
+	buffer := opts.Buffer
+	if buffer <= 0 {
+		buffer = 256
+	}
+	c := &Client{
+		opts:   opts,
+		dialer: dialer,
+		target: target,
+		joined: make(map[string]string),
+		done:   make(chan struct{}),
+		frames: make(chan Frame, buffer),
+		chunks: make(map[string][][]byte),
+	}
+
+	ws, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.ws = ws
+	go c.run(ws)
+	return c, nil
+}
+
+// dial opens a connection to the hub.
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	ws, resp, err := c.dialer.DialContext(ctx, c.target, c.opts.Header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w (status %s)", c.target, err, resp.Status)
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", c.target, err)
+	}
+	if ws.Subprotocol() != message.Subprotocol {
+		_ = ws.Close()
+		return nil, fmt.Errorf("hub at %s did not negotiate the %s subprotocol", c.target, message.Subprotocol)
+	}
+	return ws, nil
+}
+
+// dialURL returns the URL of the hub's WebSocket endpoint at addr, announcing the client's protocol
+// version and capabilities and carrying the token and echo policy.
+func dialURL(addr, token, echo string) (string, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "ws://" + addr + "/ws"
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid hub address %q: %w", addr, err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return "", fmt.Errorf("invalid hub address %q: scheme must be ws or wss", addr)
+	}
+
+	query := u.Query()
+	query.Set("protocol_version", strconv.Itoa(message.ProtocolVersion))
+	query.Set("capabilities", capabilities)
+	if token != "" {
+		query.Set("access_token", token)
+	}
+	if echo != "" {
+		query.Set("echo", echo)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Frames returns the channel of the frames received from the hub, closed once the connection
+// closes, after which Err returns why.
+func (c *Client) Frames() <-chan Frame {
+	return c.frames
+}
+
+// Err returns why the connection closed: ErrClosed once Close was called or the hub closed it
+// normally, nil while it is open.
+func (c *Client) Err() error {
+	if err := c.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Join subscribes to a room, receiving only the messages whose payload passes the filter
+// expression when it is not empty. Rejected joins are received as error frames. The rooms joined
+// are rejoined when the client reconnects, and joins made while reconnecting wait for it.
+func (c *Client) Join(room, filter string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err() != nil {
+		return ErrClosed
+	}
+	c.joined[room] = filter
+	if c.ws == nil {
+		return nil
+	}
+	return c.sendLocked(Frame{Type: message.FrameJoin, Room: room, Filter: filter})
+}
+
+// Leave unsubscribes from a room.
+func (c *Client) Leave(room string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err() != nil {
+		return ErrClosed
+	}
+	delete(c.joined, room)
+	if c.ws == nil {
+		return nil
+	}
+	return c.sendLocked(Frame{Type: message.FrameLeave, Room: room})
+}
+
+// Send publishes a JSON payload to the room, or to every connection when room is empty, and
+// returns the id of the message. Payloads that are not valid JSON are sent as JSON strings.
+// Messages sent while the client reconnects are held in the offline queue, keeping their id.
+func (c *Client) Send(room string, payload []byte) (string, error) {
+	if !json.Valid(payload) {
+		quoted, err := json.Marshal(string(payload))
+		if err != nil {
+			return "", err
+		}
+		payload = quoted
+	}
+
+	frame := Frame{Type: message.FrameMessage, ID: uuid.New().String(), Room: room, Payload: payload}
+	c.mu.Lock()
+	if c.Err() != nil {
+		c.mu.Unlock()
+		return "", ErrClosed
+	}
+	if c.ws != nil {
+		defer c.mu.Unlock()
+		return frame.ID, c.sendMessageLocked(frame)
+	}
+	dropped, err := c.enqueueLocked(frame)
+	c.mu.Unlock()
+	c.dropped(dropped, DropOverflow)
+	return frame.ID, err
+}
+
+// enqueueLocked holds a message sent while reconnecting in the offline queue and returns the
+// message its overflow policy dropped, if any. c.mu must be held.
+func (c *Client) enqueueLocked(frame Frame) ([]Frame, error) {
+	if c.opts.OfflineQueue <= 0 {
+		return nil, ErrDisconnected
+	}
+	if len(c.offline) < c.opts.OfflineQueue {
+		c.offline = append(c.offline, frame)
+		return nil, nil
+	}
+	if c.opts.OfflineOverflow == OverflowNewest {
+		return []Frame{frame}, ErrOfflineQueueFull
+	}
+	dropped := c.offline[0]
+	c.offline = append(c.offline[1:], frame)
+	return []Frame{dropped}, nil
+}
+
+// dropped reports the messages dropped from the offline queue for the reason.
+func (c *Client) dropped(frames []Frame, reason string) {
+	if c.opts.OnDropped == nil {
+		return
+	}
+	for _, frame := range frames {
+		c.opts.OnDropped(frame, reason)
+	}
+}
+
+// sendMessageLocked writes a message frame to the hub, in chunks when it is too large for one
+// frame. c.mu must be held.
+func (c *Client) sendMessageLocked(frame Frame) error {
+	data, err := frame.ToJSON()
+	if err != nil {
+		return err
+	}
+	if len(data) <= maxFrameSize {
+		return c.writeLocked(data)
+	}
+
+	payload := frame.Payload
+	total := (len(payload) + uploadChunkSize - 1) / uploadChunkSize
+	for seq := 0; seq < total; seq++ {
+		part := payload[seq*uploadChunkSize : min((seq+1)*uploadChunkSize, len(payload))]
+		// Every chunk repeats the metadata of the message, which the hub checks
+		chunk := frame
+		chunk.Type, chunk.Payload, chunk.Seq, chunk.Total, chunk.Data = message.FrameChunk, nil, seq, total, part
+		if err := c.sendLocked(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection with a normal closure, dropping the messages of the offline queue.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closing = true
+	close(c.done)
+	c.closed(ErrClosed)
+	ws := c.ws
+	if ws != nil {
+		_ = ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+	}
+	c.mu.Unlock()
+
+	if ws == nil {
+		return nil
+	}
+	return ws.Close()
+}
+
+// send encodes a frame and writes it to the hub.
+func (c *Client) send(frame Frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err() != nil {
+		return ErrClosed
+	}
+	if c.ws == nil {
+		return ErrDisconnected
+	}
+	return c.sendLocked(frame)
+}
+
+// sendLocked encodes a frame and writes it to the hub. c.mu must be held and c.ws set.
+func (c *Client) sendLocked(frame Frame) error {
+	data, err := frame.ToJSON()
+	if err != nil {
+		return err
+	}
+	return c.writeLocked(data)
+}
+
+func (c *Client) writeLocked(data []byte) error {
+	if err := c.ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+	return c.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// run receives the frames of the hub over its connections until the client closes, reconnecting
+// when the options ask for it.
+func (c *Client) run(ws *websocket.Conn) {
+	defer close(c.frames)
+	for {
+		err := c.readLoop(ws)
+
+		c.mu.Lock()
+		c.ws = nil
+		closing := c.closing
+		c.mu.Unlock()
+		if closing || !c.opts.Reconnect || websocket.IsCloseError(err, message.CloseUnauthorized) {
+			c.finish(err)
+			return
+		}
+		if ws = c.reconnect(err); ws == nil {
+			c.finish(ErrClosed)
+			return
+		}
+	}
+}
+
+// finish records why the client closed and drops the messages of the offline queue.
+func (c *Client) finish(err error) {
+	c.mu.Lock()
+	c.closed(err)
+	dropped := c.offline
+	c.offline = nil
+	c.mu.Unlock()
+	c.dropped(dropped, DropClosed)
+}
+
+// reconnect redials the hub, waiting longer after each failed attempt, and resumes the client on
+// the new connection. It returns nil once Close is called.
+func (c *Client) reconnect(closeErr error) *websocket.Conn {
+	delay := cmp.Or(c.opts.ReconnectDelay, defaultReconnectDelay)
+	maxDelay := cmp.Or(c.opts.MaxReconnectDelay, defaultMaxReconnectDelay)
+	var hint message.CloseReason
+	var ce *websocket.CloseError
+	if errors.As(closeErr, &ce) && json.Unmarshal([]byte(ce.Text), &hint) == nil {
+		if hint.RetryAfterMs > 0 {
+			delay = time.Duration(hint.RetryAfterMs) * time.Millisecond
+		}
+		if hint.AltHub != "" {
+			if target, err := dialURL(hint.AltHub, c.opts.Token, c.opts.Echo); err == nil {
+				c.target = target
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return nil
+		}
+		delay = min(2*delay, maxDelay)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-c.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		ws, err := c.dial(ctx)
+		cancel()
+		if err != nil {
+			continue
+		}
+		if c.resume(ws) {
+			return ws
+		}
+	}
+}
+
+// resume rejoins the rooms joined and sends the messages of the offline queue, in order, on a new
+// connection, which the client then uses. Messages are only removed from the queue once written.
+func (c *Client) resume(ws *websocket.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		_ = ws.Close()
+		return false
+	}
+
+	c.ws = ws
+	for room, filter := range c.joined {
+		if err := c.sendLocked(Frame{Type: message.FrameJoin, Room: room, Filter: filter}); err != nil {
+			c.ws = nil
+			_ = ws.Close()
+			return false
+		}
+	}
+	for len(c.offline) > 0 {
+		if err := c.sendMessageLocked(c.offline[0]); err != nil {
+			c.ws = nil
+			_ = ws.Close()
+			return false
+		}
+		c.offline = c.offline[1:]
+	}
+	return true
+}
+
+// readLoop receives the frames of the hub over a connection until it closes, acknowledging the
+// messages sent with a receipt, and returns why it closed.
+func (c *Client) readLoop(ws *websocket.Conn) error {
+	clear(c.chunks)
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) || errors.Is(err, net.ErrClosed) {
+				return ErrClosed
+			}
+			return err
+		}
+
+		frames, err := decodeFrames(data)
+		if err != nil {
+			continue
+		}
+		for _, frame := range frames {
+			if frame.Type == message.FrameChunk {
+				var ok bool
+				if frame, ok = c.assemble(frame); !ok {
+					continue
+				}
+			}
+			if frame.Type == message.FrameMessage && frame.Receipt {
+				_ = c.send(Frame{Type: message.FrameAck, ID: frame.ID, OriginID: frame.OriginID})
+			}
+			c.frames <- frame
+		}
+	}
+}
+
+// closed records why the connection closed, unless it already was.
+func (c *Client) closed(err error) {
+	c.err.CompareAndSwap(nil, &err)
+}
+
+// decodeFrames decodes a frame, or the array of frames of a batch.
+func decodeFrames(data []byte) ([]Frame, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var frames []Frame
+		err := json.Unmarshal(data, &frames)
+		return frames, err
+	}
+	var frame Frame
+	err := frame.FromJSON(data)
+	return []Frame{frame}, err
+}
+
+// assemble collects the chunks of a message delivered in chunks and returns the message frame once
+// every chunk arrived. Chunks carry the payload's bytes, base64 encoded in data.
+func (c *Client) assemble(chunk Frame) (Frame, bool) {
+	if chunk.Total <= 0 || chunk.Seq < 0 || chunk.Seq >= chunk.Total {
+		return Frame{}, false
+	}
+	parts, ok := c.chunks[chunk.ID]
+	if !ok || len(parts) != chunk.Total {
+		parts = make([][]byte, chunk.Total)
+		c.chunks[chunk.ID] = parts
+	}
+	parts[chunk.Seq] = chunk.Data
+	for _, part := range parts {
+		if part == nil {
+			return Frame{}, false
+		}
+	}
+	delete(c.chunks, chunk.ID)
+
+	frame := chunk
+	frame.Type = message.FrameMessage
+	frame.Payload = bytes.Join(parts, nil)
+	frame.Seq, frame.Total, frame.Data = 0, 0, nil
+	return frame, true
+}
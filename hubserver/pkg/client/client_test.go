@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+)
+
+// fakeHub accepts hub.v1 connections and hands each to the test.
+func fakeHub(t *testing.T) (string, <-chan *websocket.Conn) {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 4)
+	upgrader := websocket.Upgrader{Subprotocols: []string{message.Subprotocol}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- ws
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://"), conns
+}
+
+// nextConn returns the next connection the client opened to the fake hub.
+func nextConn(t *testing.T, conns <-chan *websocket.Conn) *websocket.Conn {
+	t.Helper()
+
+	select {
+	case ws := <-conns:
+		t.Cleanup(func() { _ = ws.Close() })
+		return ws
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// readFrame returns the next frame the client wrote to a fake hub connection.
+func readFrame(t *testing.T, ws *websocket.Conn) Frame {
+	t.Helper()
+
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var frame Frame
+	if err := frame.FromJSON(data); err != nil {
+		t.Fatalf("client sent %s: %v", data, err)
+	}
+	return frame
+}
+
+func TestOfflineQueueIsFlushedInOrderOnReconnect(t *testing.T) {
+	addr, conns := fakeHub(t)
+	var mu sync.Mutex
+	var dropped []string
+	c, err := Dial(context.Background(), addr, Options{
+		Reconnect:      true,
+		ReconnectDelay: 200 * time.Millisecond,
+		OfflineQueue:   2,
+		OnDropped: func(frame Frame, reason string) {
+			mu.Lock()
+			defer mu.Unlock()
+			dropped = append(dropped, string(frame.Payload)+":"+reason)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	first := nextConn(t, conns)
+	if err := c.Join("orders", ""); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	readFrame(t, first)
+	_ = first.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(message.CloseShutdown, ""), time.Now().Add(time.Second))
+	_ = first.Close()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		c.mu.Lock()
+		disconnected := c.ws == nil
+		c.mu.Unlock()
+		if disconnected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client did not notice the closed connection")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, payload := range []string{"1", "2", "3"} {
+		if _, err := c.Send("orders", []byte(payload)); err != nil {
+			t.Fatalf("Send(%s) while reconnecting: %v", payload, err)
+		}
+	}
+
+	second := nextConn(t, conns)
+	if frame := readFrame(t, second); frame.Type != message.FrameJoin || frame.Room != "orders" {
+		t.Fatalf("client resumed with %+v, want the join of orders", frame)
+	}
+	for _, want := range []string{"2", "3"} {
+		if frame := readFrame(t, second); frame.Type != message.FrameMessage || string(frame.Payload) != want {
+			t.Fatalf("client flushed %+v, want message %s", frame, want)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dropped) != 1 || dropped[0] != "1:"+DropOverflow {
+		t.Fatalf("dropped %v, want the oldest message for overflow", dropped)
+	}
+}
+
+func TestOfflineQueueOverflowNewestRefusesTheMessageSent(t *testing.T) {
+	c := &Client{opts: Options{OfflineQueue: 1, OfflineOverflow: OverflowNewest}}
+	if dropped, err := c.enqueueLocked(Frame{ID: "1"}); err != nil || len(dropped) != 0 {
+		t.Fatalf("enqueue into an empty queue dropped %v: %v", dropped, err)
+	}
+	dropped, err := c.enqueueLocked(Frame{ID: "2"})
+	if err != ErrOfflineQueueFull || len(dropped) != 1 || dropped[0].ID != "2" {
+		t.Fatalf("enqueue into a full queue dropped %v: %v", dropped, err)
+	}
+	if len(c.offline) != 1 || c.offline[0].ID != "1" {
+		t.Fatalf("queue holds %v", c.offline)
+	}
+}
+
+func TestCloseDropsTheOfflineQueue(t *testing.T) {
+	addr, conns := fakeHub(t)
+	dropped := make(chan string, 4)
+	c, err := Dial(context.Background(), addr, Options{
+		Reconnect:      true,
+		ReconnectDelay: time.Hour,
+		OfflineQueue:   4,
+		OnDropped:      func(frame Frame, reason string) { dropped <- reason },
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	_ = nextConn(t, conns).Close()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, err := c.Send("orders", []byte("1")); err == nil {
+			c.mu.Lock()
+			queued := len(c.offline)
+			c.mu.Unlock()
+			if queued > 0 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client did not queue messages while reconnecting")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = c.Close()
+
+	select {
+	case reason := <-dropped:
+		if reason != DropClosed {
+			t.Fatalf("dropped for %s, want %s", reason, DropClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued message not dropped on close")
+	}
+	if _, ok := <-c.Frames(); ok {
+		t.Fatal("Frames not closed after Close")
+	}
+}
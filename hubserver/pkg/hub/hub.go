@@ -0,0 +1,514 @@
+// Package hub embeds the realtime hub in a Go service. A Hub serves WebSocket connections as an
+// http.Handler and lets the service publish to and subscribe to rooms in-process, alone or
+// together with other hubs sharing a Redis channel.
+//
+//	h, err := hub.New(hub.WithName("orders"), hub.WithRedis("redis:6379", "", ""))
+//	if err != nil {
+//		return err
+//	}
+//	defer h.Close()
+//
+//	http.Handle("/ws", h)
+//	messages, unsubscribe := h.Subscribe("orders")
+//	defer unsubscribe()
+//	_, err = h.Publish(ctx, "orders", []byte(`{"status":"shipped"}`))
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/auth"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/clock"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/config"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/message"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/redis"
+	"github.com/soumya-codes/realtime-hub/hubserver/internal/websocket"
+	"go.uber.org/zap"
+)
+
+// Transform rewrites a message payload for a single subscriber just before it is written.
+type Transform = websocket.Transform
+
+// Subscriber describes the connection a transformed message is about to be written to.
+type Subscriber = websocket.Subscriber
+
+// Enricher annotates a message published to the hub by a connection or over HTTP before it is fanned out.
+type Enricher = websocket.Enricher
+
+// Sender describes the publisher of a message being enriched.
+type Sender = websocket.Sender
+
+// Reducer combines the payloads published to an aggregation room over a window into one.
+type Reducer = websocket.Reducer
+
+// Codec converts payloads of a content type between JSON and the content type's encoding.
+type Codec = message.Codec
+
+// Stats is a point-in-time snapshot of the hub's load.
+type Stats = websocket.Stats
+
+// Clock tells the time messages are stamped with.
+type Clock = clock.Clock
+
+// Credentials authenticate connections to Redis.
+type Credentials = redis.Credentials
+
+// CredentialsProvider issues the credentials connections to Redis authenticate with.
+type CredentialsProvider = redis.CredentialsProvider
+
+// EncryptionKey is an AES key envelope payloads are encrypted with, named by the id envelopes record.
+type EncryptionKey = redis.EncryptionKey
+
+// KeyProvider issues the keys envelope payloads are encrypted with; the first encrypts and every key decrypts.
+type KeyProvider = redis.KeyProvider
+
+// RoomEvent reports a room created by its first subscriber on the hub or destroyed once it was
+// left without subscribers for the grace period.
+type RoomEvent = websocket.RoomEvent
+
+// Identity is the authenticated identity of a connection request.
+type Identity = auth.Identity
+
+// Authorizer decides during the handshake whether an authenticated client may connect and what it is granted.
+type Authorizer = websocket.Authorizer
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc = websocket.AuthorizerFunc
+
+// Authorization is an Authorizer's decision, including the headers and cookies of the upgrade response.
+type Authorization = websocket.Authorization
+
+// Quota limits what a single connection may send.
+type Quota = websocket.Quota
+
+// ConfigTarget selects the connections a client configuration is pushed to; an empty target selects them all.
+type ConfigTarget = websocket.ConfigTarget
+
+// publisherID is the origin of messages published through Publish.
+const publisherID = "embedded"
+
+// Message is a message broadcast to a room.
+type Message struct {
+	ID          string
+	Room        string
+	OriginID    string
+	HubID       string
+	ContentType string
+	Payload     []byte
+	// IngestedAt is when the hub the message was published to took it in, and HLC its hybrid
+	// logical timestamp, set by hubs created WithHLC
+	IngestedAt time.Time
+	HLC        string
+	// Annotations holds the server-side fields the enrichers of the hub the message was published
+	// to added
+	Annotations map[string]string
+}
+
+// Option configures a Hub.
+type Option func(*options)
+
+type options struct {
+	cfg        *config.Config
+	logger     *zap.Logger
+	transforms []Transform
+	enrichers  []Enricher
+	roomHooks  []func(RoomEvent)
+	authorizer Authorizer
+	codecs     map[string]Codec
+	buffer     int
+	clock      Clock
+	// credentials authenticate the Redis connections instead of the password of WithRedis
+	credentials  CredentialsProvider
+	aggregations []aggregation
+	// keys encrypt the payloads of the envelopes published to Redis
+	keys KeyProvider
+}
+
+type aggregation struct {
+	room   string
+	window time.Duration
+	reduce Reducer
+}
+
+// WithName sets the name identifying the hub among the hubs sharing a channel. It defaults to the host name.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.cfg.HubName = name
+	}
+}
+
+// WithLogger sets the logger of the hub. Nothing is logged by default.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithRedis exchanges messages with the other hubs through the Redis server at addr. Without it the
+// hub runs alone.
+func WithRedis(addr, username, password string) Option {
+	return func(o *options) {
+		o.cfg.Broker = config.BrokerRedis
+		o.cfg.PubSubHostName = addr
+		o.cfg.RedisUsername = username
+		o.cfg.RedisPassword = password
+	}
+}
+
+// WithRedisCredentials exchanges messages with the other hubs through the Redis server at addr,
+// authenticating with the credentials of the provider, such as short-lived IAM auth tokens,
+// refreshed every refresh.
+func WithRedisCredentials(addr string, provider CredentialsProvider, refresh time.Duration) Option {
+	return func(o *options) {
+		o.cfg.Broker = config.BrokerRedis
+		o.cfg.PubSubHostName = addr
+		o.cfg.RedisCredentialsRefresh = refresh
+		o.credentials = provider
+	}
+}
+
+// WithEnvelopeKeys encrypts the payloads of the envelopes the hub publishes to Redis with the keys
+// of the provider, such as data keys unwrapped by a KMS, refreshed every refresh. The other hubs
+// must hold the keys to decrypt them.
+func WithEnvelopeKeys(provider KeyProvider, refresh time.Duration) Option {
+	return func(o *options) {
+		o.cfg.EnvelopeKeysRefresh = refresh
+		o.keys = provider
+	}
+}
+
+// WithPlaintextEnvelopes accepts envelopes received unencrypted by a hub created WithEnvelopeKeys,
+// from hubs that don't hold the keys yet, while encryption rolls out to them.
+func WithPlaintextEnvelopes() Option {
+	return func(o *options) {
+		o.cfg.EnvelopeAcceptPlaintext = true
+	}
+}
+
+// WithChannel sets the Redis pub/sub channel shared by the hubs.
+func WithChannel(channel string) Option {
+	return func(o *options) {
+		o.cfg.PubSubChannelName = channel
+	}
+}
+
+// WithAuth verifies HS256 JWT access tokens of connecting clients with the secret, rejecting
+// clients without a valid token when required is set.
+func WithAuth(jwtSecret string, required bool) Option {
+	return func(o *options) {
+		o.cfg.AuthJWTSecret = jwtSecret
+		o.cfg.AuthRequired = required
+	}
+}
+
+// WithSignedURLs lets clients connect with URLs signed with the secret by SignURL, expiring at
+// most maxTTL after they were minted.
+func WithSignedURLs(secret string, maxTTL time.Duration) Option {
+	return func(o *options) {
+		o.cfg.AuthURLSigningSecret = secret
+		o.cfg.AuthURLMaxTTL = maxTTL
+	}
+}
+
+// SignURL returns a connect URL for the hub that is valid for ttl, letting a visitor without an
+// access token connect to a hub created WithSignedURLs with the same secret. Query parameters of
+// rawURL are covered by the signature; sub and roles name the visitor and their comma-separated roles.
+func SignURL(secret, rawURL string, ttl time.Duration) (string, error) {
+	return auth.SignURL([]byte(secret), rawURL, time.Now().Add(ttl))
+}
+
+// WithAutoJoinRooms makes every connection join the rooms named by the templates on connect.
+// Placeholders {language}, {locale}, {claim:name} and {header:name} are filled in from the
+// connection's preferred language, token claims and upgrade request headers.
+func WithAutoJoinRooms(templates ...string) Option {
+	return func(o *options) {
+		o.cfg.AutoJoinRoom = append(o.cfg.AutoJoinRoom, templates...)
+	}
+}
+
+// WithAuthorizer sets the Authorizer consulted for every connection, which can also set headers
+// and cookies on its upgrade response.
+func WithAuthorizer(a Authorizer) Option {
+	return func(o *options) {
+		o.authorizer = a
+	}
+}
+
+// WithUpgradeHeader adds a header to the upgrade response of every connection, such as one naming
+// the service instance that served it.
+func WithUpgradeHeader(name, value string) Option {
+	return func(o *options) {
+		o.cfg.UpgradeHeader = append(o.cfg.UpgradeHeader, name+": "+value)
+	}
+}
+
+// WithTransform adds a transform applied to every message written to a connection.
+func WithTransform(t Transform) Option {
+	return func(o *options) {
+		o.transforms = append(o.transforms, t)
+	}
+}
+
+// WithEnricher adds an enricher run, after the ones added before it, on every message published
+// to the hub by a connection or over HTTP.
+func WithEnricher(e Enricher) Option {
+	return func(o *options) {
+		o.enrichers = append(o.enrichers, e)
+	}
+}
+
+// WithRoomHook adds a hook called with the rooms created and destroyed on the hub, in order, from a
+// goroutine of the hub. Rooms are destroyed once they were left without subscribers for
+// WithRoomGracePeriod.
+func WithRoomHook(hook func(RoomEvent)) Option {
+	return func(o *options) {
+		o.roomHooks = append(o.roomHooks, hook)
+	}
+}
+
+// WithRoomGracePeriod sets how long a room left without subscribers is kept before it is
+// destroyed, 30 seconds by default; zero destroys rooms as soon as their last subscriber leaves.
+func WithRoomGracePeriod(grace time.Duration) Option {
+	return func(o *options) {
+		o.cfg.RoomGracePeriod = grace
+	}
+}
+
+// WithAggregation delivers the messages published to the room combined by reduce into one message
+// per window, such as ConcatReducer for telemetry fanned in from many producers.
+func WithAggregation(room string, window time.Duration, reduce Reducer) Option {
+	return func(o *options) {
+		o.aggregations = append(o.aggregations, aggregation{room: room, window: window, reduce: reduce})
+	}
+}
+
+// CountReducer combines the messages of a window into {"count": n}.
+func CountReducer(room string, payloads []json.RawMessage) (json.RawMessage, error) {
+	return websocket.CountReducer(room, payloads)
+}
+
+// ConcatReducer combines the messages of a window into a JSON array of their payloads.
+func ConcatReducer(room string, payloads []json.RawMessage) (json.RawMessage, error) {
+	return websocket.ConcatReducer(room, payloads)
+}
+
+// WithCodec registers the codec of a content type. Codecs are registered for the whole process.
+func WithCodec(contentType string, codec Codec) Option {
+	return func(o *options) {
+		o.codecs[contentType] = codec
+	}
+}
+
+// WithHLC stamps messages with hybrid logical timestamps, ordering them across hubs whose clocks
+// disagree. Timestamps from other hubs more than maxDrift ahead of the hub's clock are ignored.
+func WithHLC(maxDrift time.Duration) Option {
+	return func(o *options) {
+		o.cfg.HLCTimestamps = true
+		o.cfg.HLCMaxDrift = maxDrift
+	}
+}
+
+// WithClock sets the clock messages are stamped with, such as a fake clock in tests. It defaults
+// to the system clock.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithSubscriptionBuffer sets the number of messages buffered for each subscription; messages that
+// do not fit are dropped. It defaults to the connections' write buffer size.
+func WithSubscriptionBuffer(size int) Option {
+	return func(o *options) {
+		o.buffer = size
+	}
+}
+
+// Hub is a realtime hub running inside the process. It serves WebSocket upgrades on any path it is
+// mounted at.
+type Hub struct {
+	handler     *websocket.MessageHandler
+	redisClient *redis.Client
+	hubID       string
+	buffer      int
+}
+
+// New creates and starts a Hub.
+func New(opts ...Option) (*Hub, error) {
+	o := &options{
+		cfg:    config.Default(),
+		logger: zap.NewNop(),
+		codecs: make(map[string]Codec),
+	}
+	o.cfg.Broker = config.BrokerNone
+	o.cfg.AdminAddr = ""
+	if hostname, err := os.Hostname(); err == nil {
+		o.cfg.HubName = hostname
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.buffer <= 0 {
+		o.buffer = o.cfg.WriteBufferSize
+	}
+
+	cfg := o.cfg
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid hub configuration: %w", err)
+	}
+	for contentType, codec := range o.codecs {
+		message.RegisterCodec(contentType, codec)
+	}
+
+	var redisClient *redis.Client
+	broker := websocket.NewStandaloneBroker()
+	if cfg.UsesRedis() {
+		if o.credentials != nil {
+			client, err := redis.NewClientWithCredentials(context.Background(), cfg.PubSubHostName, o.credentials, cfg.RedisCredentialsRefresh, o.logger)
+			if err != nil {
+				return nil, err
+			}
+			redisClient = client
+		} else {
+			redisClient = redis.NewClient(cfg.PubSubHostName, cfg.RedisUsername, cfg.RedisPassword, o.logger)
+		}
+		if err := redisClient.Ping(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		broker = redis.NewPubSub(redisClient, cfg.PubSubChannelName, cfg.HubName, cfg.RedisCompression, cfg.RedisCompressionThreshold, o.logger)
+	}
+
+	handler, err := websocket.NewMessageHandler(broker, redisClient, cfg, o.logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create message handler: %w", err)
+	}
+	for _, t := range o.transforms {
+		handler.AddTransform(t)
+	}
+	for _, e := range o.enrichers {
+		handler.AddEnricher(e)
+	}
+	if o.authorizer != nil {
+		handler.SetAuthorizer(o.authorizer)
+	}
+	for _, hook := range o.roomHooks {
+		handler.OnRoomEvent(hook)
+	}
+	for _, a := range o.aggregations {
+		handler.AggregateRoom(a.room, a.window, a.reduce)
+	}
+	if o.clock != nil {
+		handler.SetClock(o.clock)
+	}
+	if o.keys != nil {
+		if err := handler.EncryptEnvelopes(o.keys, cfg.EnvelopeKeysRefresh, cfg.EnvelopeAcceptPlaintext); err != nil {
+			_ = handler.Close()
+			return nil, err
+		}
+	}
+	go handler.Run()
+
+	return &Hub{
+		handler:     handler,
+		redisClient: redisClient,
+		hubID:       cfg.HubName,
+		buffer:      o.buffer,
+	}, nil
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection to the hub.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+// Publish broadcasts a payload to the room, or to every connection when room is empty, and returns
+// the message id.
+func (h *Hub) Publish(ctx context.Context, room string, payload []byte) (string, error) {
+	md := message.NewMessageDetails(publisherID, h.hubID, publisherID, payload)
+	md.ID = uuid.New().String()
+	md.Room = room
+	if err := h.handler.Publish(ctx, md); err != nil {
+		return "", fmt.Errorf("failed to publish message: %w", err)
+	}
+	return md.ID, nil
+}
+
+// Subscribe returns a channel receiving the messages broadcast to the room, or to every room when
+// room is empty, including those published by other hubs, and a function ending the subscription.
+// The channel is closed once the subscription ends.
+func (h *Hub) Subscribe(room string) (<-chan Message, func()) {
+	received, unsubscribe := h.handler.Subscribe(room, h.buffer)
+
+	messages := make(chan Message)
+	done := make(chan struct{})
+	go func() {
+		defer close(messages)
+		for md := range received {
+			m := Message{
+				ID:          md.ID,
+				Room:        md.Room,
+				OriginID:    md.OriginID,
+				HubID:       md.HubID,
+				ContentType: md.ContentType,
+				Payload:     md.Message,
+				HLC:         md.HLC,
+				Annotations: md.Annotations,
+			}
+			if md.IngestedAt > 0 {
+				m.IngestedAt = time.UnixMilli(md.IngestedAt)
+			}
+			select {
+			case messages <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return messages, func() {
+		once.Do(func() {
+			unsubscribe()
+			close(done)
+		})
+	}
+}
+
+// SetClientConfig sets the JSON object sent to every connection of the hub in a config frame, now
+// and when it connects, or stops sending one to new connections when config is nil.
+func (h *Hub) SetClientConfig(config json.RawMessage) error {
+	_, err := h.handler.SetClientConfig(config)
+	return err
+}
+
+// PushClientConfig sends a client configuration to the connections of the hub the target selects,
+// without setting it for new connections, and returns the number of connections it was sent to.
+func (h *Hub) PushClientConfig(config json.RawMessage, target ConfigTarget) (int, error) {
+	return h.handler.PushClientConfig(config, target)
+}
+
+// Stats returns the current load of the hub.
+func (h *Hub) Stats() Stats {
+	return h.handler.Stats()
+}
+
+// Close closes every connection, broadcasts the messages still queued and stops exchanging messages
+// with the other hubs. It returns every error met on the way, joined; calling it again returns the
+// same errors.
+func (h *Hub) Close() error {
+	err := h.handler.Close()
+	if h.redisClient != nil {
+		if closeErr := h.redisClient.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}
+	return err
+}